@@ -9,6 +9,8 @@ replace github.com/sahara/iavl => github.com/SaharaLabsAI/iavl202-temperory v0.0
 require (
 	github.com/SaharaLabsAI/iavl/v2 v2.2.0-beta.5 // v3
 	github.com/gogo/protobuf v1.3.2
+	github.com/klauspost/compress v1.18.0
+	github.com/prometheus/client_golang v1.22.0
 	github.com/sahara/iavl v0.0.0-00010101000000-000000000000 // v2
 	github.com/spf13/cobra v1.9.1
 	github.com/stretchr/testify v1.10.0
@@ -32,7 +34,6 @@ require (
 	github.com/google/go-cmp v0.7.0 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/klauspost/compress v1.18.0 // indirect
 	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 	github.com/kocubinski/costor-api v1.1.2 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
@@ -40,7 +41,6 @@ require (
 	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
 	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
-	github.com/prometheus/client_golang v1.22.0 // indirect
 	github.com/prometheus/client_model v0.6.2 // indirect
 	github.com/prometheus/common v0.65.0 // indirect
 	github.com/prometheus/procfs v0.16.1 // indirect
@@ -9,15 +9,20 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Version is set by the Makefile's -ldflags and recorded in migration manifests.
+var Version string
+
 func main() {
+	v2.Version = Version
 	root := cobra.Command{
 		Use:   "migrate",
 		Short: "migrate application.db to IAVL v2",
 	}
 	root.AddCommand(v2.Command())
 
+	// See v2.ExitCode for the exit codes.
 	if err := root.Execute(); err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
-		os.Exit(1)
+		os.Exit(v2.ExitCode(err))
 	}
 }
@@ -0,0 +1,362 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Options configures Migrate and MigrateStore for callers embedding the migration,
+// e.g. a chain upgrade handler. The start command is a wrapper around Migrate, and
+// each field matches one of its flags.
+type Options struct {
+	// Context cancels the migration between stores, shards and row batches; nil never cancels.
+	Context context.Context
+	// IAVL2Path is the v2 iavl2/ directory holding one sub-directory per store.
+	// Migrate moves it to IAVL2Path+".bak" and rebuilds it in place.
+	IAVL2Path string
+	// NewIAVL2Path is where MigrateStore writes the store, as NewIAVL2Path/<store>.
+	// Migrate ignores it.
+	NewIAVL2Path string
+	// StoreKeys are the store names or globs to migrate; empty means all stores.
+	StoreKeys []string
+	// StoreRegexes selects additional stores by regular expression.
+	StoreRegexes []string
+	// IgnoreMissing skips StoreKeys that don't exist instead of failing.
+	IgnoreMissing bool
+	// AppConfig is the node's genesis.json. Set, Migrate migrates the stores of its app_state
+	// modules that exist on disk, and StoreKeys and StoreRegexes must be empty.
+	AppConfig string
+	// StoreWorkers is how many stores Migrate migrates at once; 0 or 1 migrates them one
+	// after another. Set, it takes precedence over Concurrent and Workers.
+	StoreWorkers int
+	// ShardWorkers is how many tree shards are copied at once, shared by all stores migrating
+	// concurrently; 0 or 1 copies each store's shards in order. Each worker holds its own
+	// SQLite connections and a staging database for its shard.
+	ShardWorkers int
+	// Concurrent migrates stores in parallel, at most Workers at a time.
+	//
+	// Deprecated: use StoreWorkers.
+	Concurrent bool
+	// Workers caps concurrent store migrations; 0 means runtime.NumCPU().
+	//
+	// Deprecated: use StoreWorkers.
+	Workers int
+	// HashAlgorithm is the changelog key_hash algorithm; empty means blake3.
+	HashAlgorithm string
+	// SkipCorrupt skips and reports unreadable rows instead of failing the store.
+	SkipCorrupt bool
+	// LowMemory copies tree shards and orphan tables in bounded batches, dropping duplicate
+	// orphan rows.
+	LowMemory bool
+	// CopyStrategy is "attach" or "stream", the latter being LowMemory; empty follows LowMemory.
+	CopyStrategy string
+	// BatchSize is the rows per batch of the LowMemory copies; 0 means 10000.
+	BatchSize int
+	// CheckpointInterval commits each changelog's leaf copy every so many rows instead of in
+	// one transaction. The destination still only appears under its final name once complete;
+	// a run killed in between leaves a partly committed temporary file. 0 never checkpoints.
+	CheckpointInterval int64
+	// SourceReadonly opens the v2 databases read-only and immutable. The start command
+	// defaults it to true.
+	SourceReadonly bool
+	// VerifySource runs SQLite's quick_check on each source database, checks that each tree has
+	// exactly one root per version in its range, and fails a store whose source is corrupt with
+	// ErrCorruptSource.
+	VerifySource bool
+	// VerifyEachShard compares each tree shard's row count with its source version range right
+	// after the shard is written, failing the store with ErrVerificationFailed on the first
+	// difference instead of after the whole store. Shards are not checked with SkipCorrupt.
+	VerifyEachShard bool
+	// SkipEmptyShards leaves out tree_N tables whose version range has no source rows instead
+	// of creating them empty. iavl v3 reads and prunes across such a gap, but its rollback
+	// fails on it until fix-missing-shard recreates the tables.
+	SkipEmptyShards bool
+	// ParallelPhases migrates each store's tree and changelog at once. With concurrent
+	// stores, at most as many phases as store workers run at once.
+	ParallelPhases bool
+	// Optimize runs ANALYZE on each migrated database; Vacuum also runs VACUUM and implies
+	// Optimize. A failure of either is logged without failing the store.
+	Optimize bool
+	Vacuum   bool
+	// MaxRetries retries a store failing with a transient SQLite error.
+	MaxRetries int
+	// StoreTimeout cancels a store whose migration runs longer, removing its partial output;
+	// the other stores carry on. 0 never times out.
+	StoreTimeout time.Duration
+	// DirMode is the permission of the directories created for the destination; 0 means 0o750.
+	DirMode os.FileMode
+	// BusyTimeout is how long SQLite waits on a locked database before failing with
+	// SQLITE_BUSY; 0 fails at once. The start command defaults it to 5s.
+	BusyTimeout time.Duration
+	// Overwrite replaces existing destination files instead of failing.
+	Overwrite bool
+	// ConfirmIn, if set, is read for the operator's confirmation after the files Migrate would
+	// delete, such as destinations replaced by Overwrite, are listed on stdout; a declined
+	// prompt fails the run before anything is touched. nil deletes without asking, like the
+	// start command's --assume-yes.
+	ConfirmIn io.Reader
+	// MaxShards is the most shard tables a store may need before it's refused as
+	// corrupt; 0 means 10000. Force migrates such stores anyway.
+	MaxShards int64
+	Force     bool
+	// ContinueOnError migrates every store even after one fails and returns all
+	// failures joined with errors.Join.
+	ContinueOnError bool
+	// CopyUnknownTables copies source tables the migration doesn't know about verbatim.
+	CopyUnknownTables bool
+	// GroupedLogs prints each store's log output in one block when the store finishes.
+	GroupedLogs bool
+	// ProgressBar has Migrate redraw a progress line per store on stdout, if it is a terminal,
+	// holding back log output bound for stderr until the run ends.
+	ProgressBar bool
+	// RebuildOrphans reconstructs a missing leaf_orphan table from the changelog, best-effort.
+	RebuildOrphans bool
+	// MetricsAddr, if set, serves Prometheus metrics on this address under /metrics
+	// until the migration returns.
+	MetricsAddr string
+	// OnlyTree and OnlyChangelog migrate just one of each store's databases, keeping the
+	// other's existing destination. With either set, Migrate re-runs from an existing
+	// IAVL2Path+".bak" instead of failing.
+	OnlyTree      bool
+	OnlyChangelog bool
+	// ValidateRoot checks that each store's latest migrated root decodes as a v3 node.
+	// The start command defaults it to true.
+	ValidateRoot bool
+	// Stamp records the tool version, source format, shard size and hash algorithm in a
+	// migration_meta table of each destination database, where check-hash and verify-sample
+	// read them. The start command defaults it to true.
+	Stamp bool
+	// ShardSizeFromSource reads sources already split into tree_N tables instead of refusing them.
+	ShardSizeFromSource bool
+	// Shards limits the tree_N shards created and filled to these IDs, for debugging or partial
+	// recovery; each must lie in every migrated store's shard range. nil migrates every shard.
+	Shards []int64
+	// KeyPrefix keeps only the changelog leaves whose raw key starts with it, for analytics or
+	// partial state; the tree is still migrated in full. Such a changelog is not a full state
+	// and can't back a node. nil keeps every leaf.
+	KeyPrefix []byte
+	// KeepRawKey also stores each leaf's original key in an extra key column of the destination
+	// leaf table. iavl v3 ignores the column, and verify-schema reports it as drift.
+	KeepRawKey bool
+	// TrimOrphans drops branch and leaf orphan rows with at below this version, capped at each
+	// store's earliest version that still has a root; 0 keeps them all.
+	TrimOrphans int64
+	// CombinedSource names a file in each store directory holding both the tree and the
+	// changelog tables. Stores with neither tree.sqlite nor changelog.sqlite are read from
+	// an application.db without it.
+	CombinedSource string
+	// TreeFilename and ChangelogFilename name the tree and changelog databases in each store
+	// directory, of both the source and the destination, for chains that don't use the
+	// default tree.sqlite and changelog.sqlite.
+	TreeFilename      string
+	ChangelogFilename string
+	// SkipSpaceCheck starts even if the destination has less free space than estimated.
+	SkipSpaceCheck bool
+	// MaxOpenFiles is how many files the migration may keep open, in place of the process's
+	// RLIMIT_NOFILE when 0. Fewer store workers run if StoreWorkers would need more, and a
+	// run for which even one is too many fails with ErrOpenFilesLimit.
+	MaxOpenFiles int64
+	// Append adds the source versions newer than an existing destination's latest version to
+	// it instead of failing on it; see MigrateStore.
+	Append bool
+	// Manifest, if set, is where Migrate writes a JSON record of the run: tool version, paths,
+	// timings and each store's result, latest version and root hash. MigrateStore ignores it.
+	Manifest string
+	// ReportFile, if set, is where Migrate writes every selected store's final status, error,
+	// row counts and duration: JSON if it ends in .json, CSV otherwise. It is written even when
+	// the run fails. MigrateStore ignores it.
+	ReportFile string
+	// Quiet suppresses log output and the migration summary.
+	Quiet bool
+	// Verbose also logs every SQL statement executed on the destination, with the rows it affected.
+	Verbose bool
+	// LogFormat is "text" (the default) or "json".
+	LogFormat string
+}
+
+func (o Options) migrateOptions() (migrateOptions, error) {
+	logger, err := newMigrationLogger(o.LogFormat)
+	if err != nil {
+		return migrateOptions{}, err
+	}
+	logger.quiet = o.Quiet
+	logger.verbose = o.Verbose
+	if err := checkStoreFilenames(o.TreeFilename, o.ChangelogFilename); err != nil {
+		return migrateOptions{}, err
+	}
+	lowMemory, err := resolveCopyStrategy(o.CopyStrategy, o.LowMemory)
+	if err != nil {
+		return migrateOptions{}, err
+	}
+	// the manifest's root hashes are loaded through iavl v3, which only opens the default names
+	customNames := (o.TreeFilename != "" && o.TreeFilename != defaultTreeFilename) ||
+		(o.ChangelogFilename != "" && o.ChangelogFilename != defaultChangelogFilename)
+	if o.DirMode&^os.ModePerm != 0 {
+		return migrateOptions{}, fmt.Errorf("DirMode must only hold permission bits, got %v", o.DirMode)
+	}
+	if o.Manifest != "" && customNames {
+		return migrateOptions{}, fmt.Errorf("--manifest reads root hashes through iavl v3, which only opens %s and %s; it can't be combined with custom filenames",
+			defaultTreeFilename, defaultChangelogFilename)
+	}
+	return migrateOptions{
+		ctx:                 o.Context,
+		hashAlgorithm:       o.HashAlgorithm,
+		skipCorrupt:         o.SkipCorrupt,
+		storeRegexes:        o.StoreRegexes,
+		ignoreMissing:       o.IgnoreMissing,
+		appConfig:           o.AppConfig,
+		lowMemory:           lowMemory,
+		batchSize:           o.BatchSize,
+		checkpointInterval:  o.CheckpointInterval,
+		workers:             o.storeWorkers(),
+		shardWorkers:        o.ShardWorkers,
+		sourceReadonly:      o.SourceReadonly,
+		verifySource:        o.VerifySource,
+		verifyEachShard:     o.VerifyEachShard,
+		skipEmptyShards:     o.SkipEmptyShards,
+		parallelPhases:      o.ParallelPhases,
+		optimize:            o.Optimize,
+		vacuum:              o.Vacuum,
+		manifest:            o.Manifest,
+		reportFile:          o.ReportFile,
+		maxRetries:          o.MaxRetries,
+		storeTimeout:        o.StoreTimeout,
+		busyTimeout:         o.BusyTimeout,
+		overwrite:           o.Overwrite,
+		confirmIn:           confirmReader(o.ConfirmIn),
+		maxShards:           o.MaxShards,
+		force:               o.Force,
+		continueOnError:     o.ContinueOnError,
+		copyUnknownTables:   o.CopyUnknownTables,
+		groupedLogs:         o.GroupedLogs,
+		progressBar:         o.ProgressBar,
+		rebuildOrphans:      o.RebuildOrphans,
+		onlyTree:            o.OnlyTree,
+		onlyChangelog:       o.OnlyChangelog,
+		validateRoot:        o.ValidateRoot,
+		stamp:               o.Stamp,
+		shardSizeFromSource: o.ShardSizeFromSource,
+		shards:              o.Shards,
+		keyPrefix:           o.KeyPrefix,
+		keepRawKey:          o.KeepRawKey,
+		trimOrphans:         o.TrimOrphans,
+		combinedSource:      o.CombinedSource,
+		treeFile:            o.TreeFilename,
+		changelogFile:       o.ChangelogFilename,
+		skipSpaceCheck:      o.SkipSpaceCheck,
+		maxOpenFiles:        o.MaxOpenFiles,
+		quiet:               o.Quiet,
+		appendMode:          o.Append,
+		dirMode:             o.DirMode,
+		logger:              logger,
+	}, nil
+}
+
+// concurrent reports whether Migrate runs stores in parallel.
+func (o Options) concurrent() bool {
+	if o.StoreWorkers != 0 {
+		return o.StoreWorkers > 1
+	}
+	return o.Concurrent
+}
+
+// storeWorkers returns the store worker limit, from StoreWorkers or else the deprecated Workers.
+func (o Options) storeWorkers() int {
+	if o.StoreWorkers != 0 {
+		return o.StoreWorkers
+	}
+	return o.Workers
+}
+
+// Migrate migrates the stores selected by opts under opts.IAVL2Path to the v3 layout,
+// keeping the original directory as opts.IAVL2Path+".bak".
+func Migrate(opts Options) error {
+	mo, err := opts.migrateOptions()
+	if err != nil {
+		return err
+	}
+	stop, err := opts.serveMetrics(&mo)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	return migrate(opts.IAVL2Path, opts.StoreKeys, opts.concurrent(), mo)
+}
+
+// serveMetrics starts the metrics server for opts.MetricsAddr and points mo at its counters.
+// Without an address it does nothing.
+func (o Options) serveMetrics(mo *migrateOptions) (func(), error) {
+	if o.MetricsAddr == "" {
+		return func() {}, nil
+	}
+	mo.metrics = newMigrationMetrics()
+	return serveMetrics(o.MetricsAddr, mo.metrics, mo.logger)
+}
+
+// MigrateStore migrates the single store opts.IAVL2Path/<store> into opts.NewIAVL2Path/<store>,
+// leaving the source in place. Store selection and store concurrency options are ignored. With
+// opts.Append an already migrated destination store is brought up to the source's latest version.
+func MigrateStore(store string, opts Options) error {
+	if opts.NewIAVL2Path == "" {
+		return errors.New("NewIAVL2Path is required")
+	}
+	oldAbs, err := filepath.Abs(opts.IAVL2Path)
+	if err != nil {
+		return err
+	}
+	newAbs, err := filepath.Abs(opts.NewIAVL2Path)
+	if err != nil {
+		return err
+	}
+	if oldAbs == newAbs {
+		return fmt.Errorf("NewIAVL2Path must differ from IAVL2Path %s", opts.IAVL2Path)
+	}
+
+	mo, err := opts.migrateOptions()
+	if err != nil {
+		return err
+	}
+	if _, err := keyHashPool(mo.hashAlgorithm); err != nil {
+		return err
+	}
+	if mo.maxRetries < 0 {
+		return fmt.Errorf("max-retries must not be negative, got %d", mo.maxRetries)
+	}
+	if mo.batchSize < 0 {
+		return fmt.Errorf("BatchSize must be positive, got %d", mo.batchSize)
+	}
+	if mo.checkpointInterval < 0 {
+		return fmt.Errorf("CheckpointInterval must not be negative, got %d", mo.checkpointInterval)
+	}
+	if mo.storeTimeout < 0 {
+		return fmt.Errorf("StoreTimeout must not be negative, got %s", mo.storeTimeout)
+	}
+	if mo.maxOpenFiles < 0 {
+		return fmt.Errorf("MaxOpenFiles must not be negative, got %d", mo.maxOpenFiles)
+	}
+	if mo.shardWorkers < 0 {
+		return fmt.Errorf("shard-workers must be positive, got %d", mo.shardWorkers)
+	}
+	if mo.onlyTree && mo.onlyChangelog {
+		return errors.New("OnlyTree and OnlyChangelog are mutually exclusive")
+	}
+	stop, err := opts.serveMetrics(&mo)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	if err := checkDiskSpace(filepath.Join(opts.NewIAVL2Path, store), storeSourcePaths(opts.IAVL2Path, store, mo), mo); err != nil {
+		return err
+	}
+	if _, err := checkOpenFiles(1, mo); err != nil {
+		return err
+	}
+	mo.metrics.addStores(1)
+	_, err = migrateStoreWithRetry(store, opts.IAVL2Path, opts.NewIAVL2Path, mo)
+	return err
+}
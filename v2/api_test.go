@@ -0,0 +1,39 @@
+package v2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateAPI(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2)
+	createV2Store(t, filepath.Join(iavl2Path, "evm"), 1)
+
+	require.NoError(t, Migrate(Options{IAVL2Path: iavl2Path, StoreKeys: []string{"bank"}, Concurrent: true}))
+	require.DirExists(t, iavl2Path+".bak")
+	require.FileExists(t, filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+	require.FileExists(t, filepath.Join(iavl2Path, "bank", "changelog.sqlite"))
+	require.NoDirExists(t, filepath.Join(iavl2Path, "evm"))
+}
+
+func TestMigrateStoreAPI(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	newPath := filepath.Join(t.TempDir(), "iavl3")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2)
+
+	require.ErrorContains(t, MigrateStore("bank", Options{IAVL2Path: iavl2Path}), "NewIAVL2Path is required")
+	require.ErrorContains(t, MigrateStore("bank", Options{IAVL2Path: iavl2Path, NewIAVL2Path: iavl2Path}), "must differ")
+	require.ErrorContains(t, MigrateStore("bank", Options{IAVL2Path: iavl2Path, NewIAVL2Path: newPath, LogFormat: "xml"}), "unsupported log format")
+	require.ErrorIs(t, MigrateStore("evm", Options{IAVL2Path: iavl2Path, NewIAVL2Path: newPath}), ErrSourceNotFound)
+
+	require.NoError(t, MigrateStore("bank", Options{IAVL2Path: iavl2Path, NewIAVL2Path: newPath, SourceReadonly: true}))
+	require.FileExists(t, filepath.Join(newPath, "bank", "tree.sqlite"))
+	require.FileExists(t, filepath.Join(newPath, "bank", "changelog.sqlite"))
+	// the source is left in place
+	_, err := os.Stat(filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+	require.NoError(t, err)
+}
@@ -0,0 +1,67 @@
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+)
+
+// readAppConfigStoreKeys reads the store keys registered with the chain from the node's
+// genesis.json at path: the modules of its app_state, which name their stores after themselves.
+func readAppConfigStoreKeys(path string) ([]string, error) {
+	bz, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read app config: %w", err)
+	}
+	var genesis struct {
+		AppState map[string]json.RawMessage `json:"app_state"`
+	}
+	if err := json.Unmarshal(bz, &genesis); err != nil {
+		return nil, fmt.Errorf("parse app config %s: %w", path, err)
+	}
+	if len(genesis.AppState) == 0 {
+		return nil, fmt.Errorf("app config %s has no app_state modules; pass the node's genesis.json", path)
+	}
+	return slices.Sorted(maps.Keys(genesis.AppState)), nil
+}
+
+// appConfigStores selects the store directories under dir whose name is a store key of the app
+// config at path, logging the directories it leaves out as not registered and the registered
+// keys without a directory. The latter only warn: modules such as genutil have no store.
+func appConfigStores(dir, path string, lg *migrationLogger) ([]string, error) {
+	keys, err := readAppConfigStoreKeys(path)
+	if err != nil {
+		return nil, err
+	}
+	onDisk, _, err := getStoreKeys(dir, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var stores, unregistered []string
+	for _, store := range onDisk {
+		if slices.Contains(keys, store) {
+			stores = append(stores, store)
+		} else {
+			unregistered = append(unregistered, store)
+		}
+	}
+	var missing []string
+	for _, key := range keys {
+		if !slices.Contains(onDisk, key) {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(unregistered) > 0 {
+		lg.Event("unregistered_stores", logFields{"stores": unregistered},
+			"skipping directories under %s that %s registers no store for: %v", dir, path, unregistered)
+	}
+	if len(missing) > 0 {
+		lg.Event("missing_store_keys", logFields{"stores": missing},
+			"WARNING: store keys registered in %s have no directory under %s: %v", path, dir, missing)
+	}
+	return stores, nil
+}
@@ -0,0 +1,37 @@
+package v2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateAppConfig(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+	createV2Store(t, filepath.Join(iavl2Path, "evm"), 1)
+	require.NoError(t, os.MkdirAll(filepath.Join(iavl2Path, "lost+found"), 0o755))
+	genesis := filepath.Join(t.TempDir(), "genesis.json")
+	require.NoError(t, os.WriteFile(genesis, []byte(`{"chain_id": "sahara", "app_state": {"evm": {}, "bank": {"balances": []}, "genutil": {}}}`), 0o644))
+
+	keys, err := readAppConfigStoreKeys(genesis)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bank", "evm", "genutil"}, keys)
+
+	require.ErrorContains(t, migrate(iavl2Path, []string{"bank"}, false, migrateOptions{appConfig: genesis}), "can't be combined")
+
+	buf := captureLog(t)
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{appConfig: genesis}))
+	require.FileExists(t, filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+	require.FileExists(t, filepath.Join(iavl2Path, "evm", "changelog.sqlite"))
+	require.NoDirExists(t, filepath.Join(iavl2Path, "lost+found"))
+	require.Contains(t, buf.String(), "registers no store for: [lost+found]")
+	require.Contains(t, buf.String(), "WARNING: store keys registered in "+genesis+" have no directory under "+iavl2Path+": [genutil]")
+
+	config := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(config, []byte(`{"chain_id": "sahara"}`), 0o644))
+	_, err = readAppConfigStoreKeys(config)
+	require.ErrorContains(t, err, "has no app_state modules")
+}
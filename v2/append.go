@@ -0,0 +1,274 @@
+package v2
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// appendSuffix names the database the versions to append are migrated into before they are
+// merged into the destination.
+const appendSuffix = ".append"
+
+// appendTree migrates the source versions newer than the latest root of the existing
+// destination tree at newPath and adds them to it. The source must continue the destination:
+// it must hold the destination's latest version with the same root, and its next version must
+// directly follow it. The new rows are migrated into a separate database first and merged in
+// one transaction, so a failure leaves the destination as it was.
+func appendTree(oldPath, newPath string, opts migrateOptions) (TreeMigrationResult, error) {
+	lg := opts.logger
+	destDB, err := openDest(newPath, opts)
+	if err != nil {
+		return TreeMigrationResult{}, fmt.Errorf("open destination %s: %w", newPath, err)
+	}
+	defer destDB.Close()
+
+	var latest sql.NullInt64
+	var latestRoot []byte
+	err = destDB.QueryRow("SELECT version, bytes FROM root ORDER BY version DESC LIMIT 1").Scan(&latest, &latestRoot)
+	if err == sql.ErrNoRows {
+		return TreeMigrationResult{}, fmt.Errorf("%w: destination %s has no roots to append to", ErrAppendConflict, newPath)
+	}
+	if err != nil {
+		return TreeMigrationResult{}, fmt.Errorf("read latest root of %s: %w", newPath, err)
+	}
+	after := latest.Int64
+	if err := checkNoRowsAfter(destDB, newPath, after); err != nil {
+		return TreeMigrationResult{}, err
+	}
+
+	srcPath, cleanup, err := decompressSource(oldPath, filepath.Dir(newPath), opts.dirPerm(), lg)
+	if err != nil {
+		return TreeMigrationResult{}, err
+	}
+	defer cleanup()
+	srcDB, err := openSource(srcPath, opts.sourceReadonly, opts.busyTimeout)
+	if err != nil {
+		return TreeMigrationResult{}, fmt.Errorf("open old db %s: %w", srcPath, err)
+	}
+	defer srcDB.Close()
+
+	var srcRoot []byte
+	err = srcDB.QueryRow("SELECT bytes FROM root WHERE version = ?", after).Scan(&srcRoot)
+	if err == sql.ErrNoRows {
+		return TreeMigrationResult{}, fmt.Errorf("%w: source %s has no root for the destination's latest version %d", ErrAppendConflict, oldPath, after)
+	}
+	if err != nil {
+		return TreeMigrationResult{}, fmt.Errorf("read root %d of %s: %w", after, oldPath, err)
+	}
+	if !bytes.Equal(srcRoot, latestRoot) {
+		return TreeMigrationResult{}, fmt.Errorf("%w: root of version %d differs between source %s and destination %s", ErrAppendConflict, after, oldPath, newPath)
+	}
+	var next sql.NullInt64
+	if err := srcDB.QueryRow("SELECT MIN(version) FROM root WHERE version > ?", after).Scan(&next); err != nil {
+		return TreeMigrationResult{}, fmt.Errorf("read next version of %s: %w", oldPath, err)
+	}
+	if !next.Valid {
+		lg.Event("append_up_to_date", logFields{"version": after}, "%s is already at the source's latest version %d, nothing to append", newPath, after)
+		return TreeMigrationResult{}, nil
+	}
+	if next.Int64 != after+1 {
+		return TreeMigrationResult{}, fmt.Errorf("%w: source %s skips from version %d to %d", ErrAppendConflict, oldPath, after, next.Int64)
+	}
+	srcDB.Close()
+
+	lg.Event("append", logFields{"dest": newPath, "after": after}, "appending versions after %d from %s to %s", after, oldPath, newPath)
+	deltaPath := newPath + appendSuffix
+	removeSQLiteFiles(deltaPath)
+	defer removeSQLiteFiles(deltaPath)
+	result, err := copyTree(srcPath, newPath, deltaPath, appendOptions(opts, after))
+	if err != nil {
+		return TreeMigrationResult{}, err
+	}
+	if err := mergeAppended(newPath, deltaPath, opts); err != nil {
+		return TreeMigrationResult{}, err
+	}
+	if err := checkpointDestination(newPath, lg); err != nil {
+		return TreeMigrationResult{}, err
+	}
+	return result, nil
+}
+
+// appendChangelog is appendTree for a changelog. Versions without leaf writes are normal, so
+// only an overlap is checked: the source must hold as many leaves at the destination's latest
+// leaf version as the destination does.
+func appendChangelog(oldPath, newPath string, opts migrateOptions) (int64, error) {
+	lg := opts.logger
+	destDB, err := openDest(newPath, opts)
+	if err != nil {
+		return 0, fmt.Errorf("open destination %s: %w", newPath, err)
+	}
+	defer destDB.Close()
+
+	var latest sql.NullInt64
+	if err := destDB.QueryRow("SELECT MAX(version) FROM leaf").Scan(&latest); err != nil {
+		return 0, fmt.Errorf("read latest leaf version of %s: %w", newPath, err)
+	}
+	// the appended leaves are merged column by column, so both must agree on the raw key
+	hasRawKey, err := columnExists(destDB, "leaf", "key")
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case hasRawKey && !opts.keepRawKey:
+		return 0, fmt.Errorf("%w: the leaf table of %s has a raw key column; append with --keep-raw-key", ErrAppendConflict, newPath)
+	case !hasRawKey && opts.keepRawKey:
+		return 0, fmt.Errorf("%w: the leaf table of %s has no raw key column to append to; drop --keep-raw-key", ErrAppendConflict, newPath)
+	}
+	// key hashes of another algorithm would never be found next to the existing ones
+	if meta, ok, err := readMigrationMeta(newPath); err != nil {
+		return 0, err
+	} else if ok && meta.hashAlgorithm != hashAlgorithmName(opts.hashAlgorithm) {
+		return 0, fmt.Errorf("%w: %s was migrated with --hash-algorithm %s; append with the same algorithm",
+			ErrAppendConflict, newPath, meta.hashAlgorithm)
+	}
+	after := latest.Int64
+
+	trimCutoff, err := changelogTrimCutoff(oldPath, opts)
+	if err != nil {
+		return 0, err
+	}
+	srcPath, cleanup, err := decompressSource(oldPath, filepath.Dir(newPath), opts.dirPerm(), lg)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+
+	if after > 0 {
+		srcDB, err := openSource(srcPath, opts.sourceReadonly, opts.busyTimeout)
+		if err != nil {
+			return 0, fmt.Errorf("open old changelog db %s: %w", srcPath, err)
+		}
+		var srcLeaves, destLeaves int64
+		err = srcDB.QueryRow("SELECT COUNT(*) FROM leaf WHERE version = ?", after).Scan(&srcLeaves)
+		srcDB.Close()
+		if err != nil {
+			return 0, fmt.Errorf("count leaves at version %d in %s: %w", after, oldPath, err)
+		}
+		if err := destDB.QueryRow("SELECT COUNT(*) FROM leaf WHERE version = ?", after).Scan(&destLeaves); err != nil {
+			return 0, fmt.Errorf("count leaves at version %d in %s: %w", after, newPath, err)
+		}
+		if srcLeaves != destLeaves {
+			return 0, fmt.Errorf("%w: source %s has %d leaves at the destination's latest leaf version %d, destination %s has %d",
+				ErrAppendConflict, oldPath, srcLeaves, after, newPath, destLeaves)
+		}
+	}
+
+	lg.Event("append", logFields{"dest": newPath, "after": after}, "appending leaves after version %d from %s to %s", after, oldPath, newPath)
+	deltaPath := newPath + appendSuffix
+	removeSQLiteFiles(deltaPath)
+	defer removeSQLiteFiles(deltaPath)
+	rows, err := copyChangelog(srcPath, newPath, deltaPath, trimCutoff, appendOptions(opts, after))
+	if err != nil {
+		return 0, err
+	}
+	if err := mergeAppended(newPath, deltaPath, opts); err != nil {
+		return 0, err
+	}
+	if err := checkpointDestination(newPath, lg); err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
+
+// appendOptions returns the options migrating the versions after after into the database
+// that is merged into the destination. Unknown tables were handled by the first migration.
+func appendOptions(opts migrateOptions, after int64) migrateOptions {
+	opts.appendAfter = after
+	opts.copyUnknownTables = false
+	return opts
+}
+
+// checkNoRowsAfter fails with ErrAppendConflict if any shard of the destination tree holds
+// nodes newer than its latest root, which an interrupted or foreign write would leave behind.
+func checkNoRowsAfter(destDB *sql.DB, destPath string, after int64) error {
+	shardIDs, err := listShardIDs(destDB)
+	if err != nil {
+		return err
+	}
+	for _, id := range shardIDs {
+		var found bool
+		if err := destDB.QueryRow(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM tree_%d WHERE version > ?)", id), after).Scan(&found); err != nil {
+			return fmt.Errorf("check tree_%d of %s: %w", id, destPath, err)
+		}
+		if found {
+			return fmt.Errorf("%w: tree_%d of %s holds nodes newer than its latest root %d", ErrAppendConflict, id, destPath, after)
+		}
+	}
+	return nil
+}
+
+// mergeAppended copies every table of the database at deltaPath into the one at destPath in a
+// single transaction, creating tables the destination lacks (new shards) from the delta's schema.
+// Orphan rows already present are skipped: a leaf orphaned by a delete-only version can be
+// in both. Any other duplicate row aborts the merge.
+func mergeAppended(destPath, deltaPath string, opts migrateOptions) error {
+	db, err := openDest(destPath, opts)
+	if err != nil {
+		return fmt.Errorf("open destination %s: %w", destPath, err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("open destination %s: %w", destPath, err)
+	}
+	defer conn.Close()
+
+	lg := opts.logger
+	if _, err := execSQL(ctx, conn, lg, fmt.Sprintf(`ATTACH DATABASE '%s' AS delta;`, deltaPath)); err != nil {
+		return fmt.Errorf("attach %s: %w", deltaPath, err)
+	}
+	defer execSQL(ctx, conn, lg, `DETACH DATABASE delta;`)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT name, sql FROM delta.sqlite_master WHERE type = 'table' ORDER BY name`)
+	if err != nil {
+		return fmt.Errorf("list tables of %s: %w", deltaPath, err)
+	}
+	var tables, schemas []string
+	for rows.Next() {
+		var name, schema string
+		if err := rows.Scan(&name, &schema); err != nil {
+			rows.Close()
+			return err
+		}
+		tables, schemas = append(tables, name), append(schemas, schema)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, table := range tables {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM main.sqlite_master WHERE type = 'table' AND name = ?)", table).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			if _, err := execSQL(ctx, tx, lg, schemas[i]); err != nil {
+				return fmt.Errorf("create %s in %s: %w", table, destPath, err)
+			}
+		}
+		insert := "INSERT INTO"
+		switch {
+		case strings.HasSuffix(table, "orphan"):
+			insert = "INSERT OR IGNORE INTO"
+		case table == migrationMetaTable:
+			// the appending run's stamp replaces the destination's
+			insert = "INSERT OR REPLACE INTO"
+		}
+		if _, err := execSQL(ctx, tx, lg, fmt.Sprintf("%s main.%s SELECT * FROM delta.%s", insert, table, table)); err != nil {
+			return fmt.Errorf("merge %s into %s: %w", table, destPath, classifySQLiteError(err))
+		}
+	}
+	return tx.Commit()
+}
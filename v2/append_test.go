@@ -0,0 +1,133 @@
+package v2
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// addV2Versions writes the rows createV2Store writes for versions into an existing v2 store.
+func addV2Versions(t *testing.T, dir string, versions ...int64) {
+	for _, version := range versions {
+		execV2(t, dir, "tree.sqlite", "INSERT INTO tree_1 VALUES (?, 1, x'01', false)", version)
+		execV2(t, dir, "tree.sqlite", "INSERT INTO root VALUES (?, ?, 1, x'000201aa000101')", version, version)
+		execV2(t, dir, "changelog.sqlite", "INSERT INTO leaf VALUES (?, 1, x'aa', x'01', false)", version)
+	}
+}
+
+func execV2(t *testing.T, dir, name, stmt string, args ...any) {
+	db, err := sql.Open("sqlite", filepath.Join(dir, name))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(stmt, args...)
+	require.NoError(t, err)
+}
+
+// migrateV2Store migrates both databases of the v2 store oldDir into newDir.
+func migrateV2Store(t *testing.T, oldDir, newDir string, opts migrateOptions) error {
+	t.Helper()
+	if _, err := migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite"), opts); err != nil {
+		return err
+	}
+	_, err := migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(newDir, "changelog.sqlite"), opts)
+	return err
+}
+
+func TestMigrateAppend(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	newDir := filepath.Join(t.TempDir(), "bank")
+	// the last versions of shard 1, so the appended version needs a new shard
+	createV2Store(t, oldDir, 499999, 500000)
+	execV2(t, oldDir, "tree.sqlite", "INSERT INTO orphan VALUES (499999, 1, 500000)")
+	require.NoError(t, migrateV2Store(t, oldDir, newDir, migrateOptions{}))
+
+	addV2Versions(t, oldDir, 500001)
+	execV2(t, oldDir, "tree.sqlite", "INSERT INTO orphan VALUES (500000, 1, 500001)")
+	execV2(t, oldDir, "changelog.sqlite", "INSERT INTO leaf_orphan VALUES (500000, 1, 500001)")
+
+	// without --append an existing destination is refused
+	require.ErrorContains(t, migrateV2Store(t, oldDir, newDir, migrateOptions{}), "already exists")
+
+	opts := migrateOptions{appendMode: true, validateRoot: true}
+	tree, err := migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite"), opts)
+	require.NoError(t, err)
+	require.Equal(t, []int64{2}, tree.Shards)
+	require.Equal(t, int64(1), tree.RootRows)
+	rows, err := migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(newDir, "changelog.sqlite"), opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), rows)
+
+	treePath := filepath.Join(newDir, "tree.sqlite")
+	changelogPath := filepath.Join(newDir, "changelog.sqlite")
+	check := func() {
+		requireTableNames(t, treePath, "branch_orphan", "root", "tree_1", "tree_2")
+		require.Equal(t, int64(2), countTableRows(t, treePath, "tree_1"))
+		require.Equal(t, int64(1), countTableRows(t, treePath, "tree_2"))
+		require.Equal(t, int64(3), countTableRows(t, treePath, "root"))
+		require.Equal(t, int64(2), countTableRows(t, treePath, "branch_orphan"))
+		require.Equal(t, int64(3), countTableRows(t, changelogPath, "leaf"))
+		require.Equal(t, int64(1), countTableRows(t, changelogPath, "leaf_orphan"))
+		require.NoFileExists(t, treePath+appendSuffix)
+		require.NoFileExists(t, changelogPath+appendSuffix)
+	}
+	check()
+
+	// appending again finds nothing new
+	require.NoError(t, migrateV2Store(t, oldDir, newDir, opts))
+	check()
+}
+
+func TestMigrateAppendConflicts(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(t *testing.T, oldDir string)
+		want   string
+	}{
+		{
+			name:   "gap",
+			modify: func(t *testing.T, oldDir string) { addV2Versions(t, oldDir, 4) },
+			want:   "skips from version 2 to 4",
+		},
+		{
+			name: "different root",
+			modify: func(t *testing.T, oldDir string) {
+				execV2(t, oldDir, "tree.sqlite", "UPDATE root SET bytes = x'000201bb000101' WHERE version = 2")
+				addV2Versions(t, oldDir, 3)
+			},
+			want: "root of version 2 differs",
+		},
+		{
+			name: "missing latest version",
+			modify: func(t *testing.T, oldDir string) {
+				execV2(t, oldDir, "tree.sqlite", "DELETE FROM root WHERE version = 2")
+				addV2Versions(t, oldDir, 3)
+			},
+			want: "has no root for the destination's latest version 2",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			oldDir := filepath.Join(t.TempDir(), "bank")
+			newDir := filepath.Join(t.TempDir(), "bank")
+			createV2Store(t, oldDir, 1, 2)
+			require.NoError(t, migrateV2Store(t, oldDir, newDir, migrateOptions{}))
+			tc.modify(t, oldDir)
+
+			_, err := migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite"), migrateOptions{appendMode: true})
+			require.ErrorIs(t, err, ErrAppendConflict)
+			require.ErrorContains(t, err, tc.want)
+			require.Equal(t, int64(2), countTableRows(t, filepath.Join(newDir, "tree.sqlite"), "root"))
+		})
+	}
+
+	// a changelog whose leaves at the latest version differ in number overlaps wrongly
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	newDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 1, 2)
+	require.NoError(t, migrateV2Store(t, oldDir, newDir, migrateOptions{}))
+	execV2(t, oldDir, "changelog.sqlite", "INSERT INTO leaf VALUES (2, 2, x'bb', x'02', false)")
+	_, err := migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(newDir, "changelog.sqlite"), migrateOptions{appendMode: true})
+	require.ErrorIs(t, err, ErrAppendConflict)
+	require.Equal(t, int64(2), countTableRows(t, filepath.Join(newDir, "changelog.sqlite"), "leaf"))
+}
@@ -0,0 +1,183 @@
+package v2
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+func BenchCommand() *cobra.Command {
+	var (
+		cfg           benchConfig
+		dir           string
+		hashAlgorithm string
+		lowMemory     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "measure migration throughput on a generated v2 store",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if dir == "" {
+				tmp, err := os.MkdirTemp("", "iavl-migration-bench")
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(tmp)
+				dir = tmp
+			}
+			opts := migrateOptions{ctx: cmd.Context(), hashAlgorithm: hashAlgorithm, lowMemory: lowMemory}
+			return bench(os.Stdout, dir, cfg, opts)
+		},
+	}
+
+	cmd.Flags().Int64Var(&cfg.versions, "versions", 1000, "Number of versions to generate")
+	cmd.Flags().Int64Var(&cfg.rowsPerVersion, "rows-per-version", 100, "Tree node rows and leaf rows generated per version")
+	cmd.Flags().IntVar(&cfg.bytesPerRow, "bytes-per-row", 64, "Size of each generated node/leaf value in bytes")
+	cmd.Flags().StringVar(&dir, "dir", "", "Directory for the generated and migrated databases; must be empty (default: a temporary directory, removed afterwards)")
+	cmd.Flags().StringVar(&hashAlgorithm, "hash-algorithm", hashAlgorithmBlake3, "Hash algorithm used to compute changelog key_hash (blake3, sha256)")
+	cmd.Flags().BoolVar(&lowMemory, "low-memory", false, "Benchmark the bounded-batch shard copy instead of the window-function INSERT")
+
+	return cmd
+}
+
+// benchConfig is the shape of the generated source store.
+type benchConfig struct {
+	versions       int64
+	rowsPerVersion int64
+	bytesPerRow    int
+}
+
+// bench generates a v2 store of the requested size under dir, migrates it with
+// migrateTree and migrateChangelog and writes the timings to w.
+func bench(w io.Writer, dir string, cfg benchConfig, opts migrateOptions) error {
+	if cfg.versions <= 0 || cfg.rowsPerVersion <= 0 || cfg.bytesPerRow <= 0 {
+		return fmt.Errorf("versions, rows-per-version and bytes-per-row must be positive")
+	}
+	oldDir := filepath.Join(dir, "v2")
+	newDir := filepath.Join(dir, "v3")
+	if err := os.MkdirAll(oldDir, defaultDirMode); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if err := generateBenchStore(oldDir, cfg); err != nil {
+		return err
+	}
+	rows := cfg.versions * cfg.rowsPerVersion
+	fmt.Fprintf(w, "generated %d versions, %d tree rows and %d leaf rows of %d bytes in %s\n",
+		cfg.versions, rows, rows, cfg.bytesPerRow, time.Since(start).Round(time.Millisecond))
+
+	treeStart := time.Now()
+	tree, err := migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite"), opts)
+	if err != nil {
+		return fmt.Errorf("migrate tree: %w", err)
+	}
+	treeDuration := time.Since(treeStart)
+
+	changelogStart := time.Now()
+	leafRows, err := migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(newDir, "changelog.sqlite"), opts)
+	if err != nil {
+		return fmt.Errorf("migrate changelog: %w", err)
+	}
+	changelogDuration := time.Since(changelogStart)
+
+	fmt.Fprintf(w, "tree:      %d rows in %d shards, %s (%s)\n", tree.Rows(), len(tree.Shards), treeDuration.Round(time.Millisecond), rowsPerSecond(tree.Rows(), treeDuration))
+	fmt.Fprintf(w, "changelog: %d rows, %s (%s)\n", leafRows, changelogDuration.Round(time.Millisecond), rowsPerSecond(leafRows, changelogDuration))
+	total := treeDuration + changelogDuration
+	fmt.Fprintf(w, "total:     %d rows, %s (%s)\n", tree.Rows()+leafRows, total.Round(time.Millisecond), rowsPerSecond(tree.Rows()+leafRows, total))
+	return nil
+}
+
+func rowsPerSecond(rows int64, d time.Duration) string {
+	if d <= 0 {
+		return "- rows/s"
+	}
+	return fmt.Sprintf("%.0f rows/s", float64(rows)/d.Seconds())
+}
+
+// generateBenchStore writes a tree.sqlite and changelog.sqlite in the v2 layout with random
+// node bytes and leaf keys, one root per version.
+func generateBenchStore(dir string, cfg benchConfig) error {
+	treeDB, err := sql.Open("sqlite", filepath.Join(dir, "tree.sqlite"))
+	if err != nil {
+		return err
+	}
+	defer treeDB.Close()
+	if _, err := treeDB.Exec(`
+		CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool);
+		CREATE TABLE orphan (version int, sequence int, at int);
+		CREATE TABLE root (version int, node_version int, node_sequence int, bytes blob, PRIMARY KEY (version));
+	`); err != nil {
+		return fmt.Errorf("create tree tables: %w", err)
+	}
+
+	changelogDB, err := sql.Open("sqlite", filepath.Join(dir, "changelog.sqlite"))
+	if err != nil {
+		return err
+	}
+	defer changelogDB.Close()
+	if _, err := changelogDB.Exec(`
+		CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+		CREATE TABLE leaf_orphan (version int, sequence int, at int);
+	`); err != nil {
+		return fmt.Errorf("create changelog tables: %w", err)
+	}
+
+	treeTx, err := treeDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer treeTx.Rollback()
+	changelogTx, err := changelogDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer changelogTx.Rollback()
+
+	nodeStmt, err := treeTx.Prepare(`INSERT INTO tree_1 VALUES (?, ?, ?, false)`)
+	if err != nil {
+		return err
+	}
+	defer nodeStmt.Close()
+	rootStmt, err := treeTx.Prepare(`INSERT INTO root VALUES (?, ?, 1, ?)`)
+	if err != nil {
+		return err
+	}
+	defer rootStmt.Close()
+	leafStmt, err := changelogTx.Prepare(`INSERT INTO leaf VALUES (?, ?, ?, ?, false)`)
+	if err != nil {
+		return err
+	}
+	defer leafStmt.Close()
+
+	value := make([]byte, cfg.bytesPerRow)
+	key := make([]byte, 32)
+	for version := int64(1); version <= cfg.versions; version++ {
+		for sequence := int64(1); sequence <= cfg.rowsPerVersion; sequence++ {
+			rand.Read(value)
+			rand.Read(key)
+			if _, err := nodeStmt.Exec(version, sequence, value); err != nil {
+				return fmt.Errorf("insert tree_1 row: %w", err)
+			}
+			if _, err := leafStmt.Exec(version, sequence, key, value); err != nil {
+				return fmt.Errorf("insert leaf row: %w", err)
+			}
+		}
+		if _, err := rootStmt.Exec(version, version, value); err != nil {
+			return fmt.Errorf("insert root row: %w", err)
+		}
+	}
+
+	if err := treeTx.Commit(); err != nil {
+		return err
+	}
+	return changelogTx.Commit()
+}
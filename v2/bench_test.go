@@ -0,0 +1,25 @@
+package v2
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBench(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, bench(&buf, t.TempDir(), benchConfig{versions: 20, rowsPerVersion: 5, bytesPerRow: 16}, migrateOptions{}))
+	out := buf.String()
+	require.Contains(t, out, "generated 20 versions, 100 tree rows and 100 leaf rows of 16 bytes")
+	require.Regexp(t, `tree:\s+100 rows in 1 shards, .* rows/s`, out)
+	require.Regexp(t, `changelog: 100 rows, .* rows/s`, out)
+	require.Regexp(t, `total:\s+200 rows`, out)
+
+	require.ErrorContains(t, bench(&buf, t.TempDir(), benchConfig{versions: 1}, migrateOptions{}), "must be positive")
+}
+
+func TestBenchCommand(t *testing.T) {
+	out := runV2Command(t, "bench", "--versions", "3", "--rows-per-version", "2", "--dir", t.TempDir())
+	require.Contains(t, out, "generated 3 versions, 6 tree rows")
+}
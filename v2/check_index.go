@@ -0,0 +1,168 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+func CheckIndexCommand() *cobra.Command {
+	var (
+		dbPath   string
+		storeKey string
+		rebuild  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "check-index",
+		Short: "check that every migrated changelog has an intact unique leaf_idx, optionally rebuilding broken ones",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return checkIndex(os.Stdout, dbPath, storeKey, rebuild)
+		},
+	}
+
+	cmd.Flags().StringVar(&dbPath, "db-path", "", "Path to the migrated iavl2/ directory")
+	if err := cmd.MarkFlagRequired("db-path"); err != nil {
+		panic(err)
+	}
+	cmd.Flags().StringVar(&storeKey, "store-key", "", "Only check this store (default: every store under --db-path)")
+	cmd.Flags().BoolVar(&rebuild, "rebuild", false, "Drop and recreate leaf_idx from the leaf table in stores where it is missing, malformed or corrupt")
+
+	return cmd
+}
+
+// checkIndex checks leaf_idx in the changelog.sqlite of every store under dbPath, or only of
+// storeKey, and with rebuild recreates it where it has problems. Stores whose problems remain
+// fail the check with ErrVerificationFailed.
+func checkIndex(w io.Writer, dbPath, storeKey string, rebuild bool) error {
+	stores := []string{storeKey}
+	if storeKey == "" {
+		var err error
+		if stores, _, err = getStoreKeys(dbPath, nil, nil); err != nil {
+			return err
+		}
+	}
+
+	var broken []string
+	for _, store := range stores {
+		path := filepath.Join(dbPath, store, "changelog.sqlite")
+		if _, err := os.Stat(path); err != nil {
+			fmt.Fprintf(w, "%s: changelog.sqlite missing\n", store)
+			broken = append(broken, store)
+			continue
+		}
+		ok, err := checkStoreIndex(w, store, path, rebuild)
+		if err != nil {
+			return fmt.Errorf("check %s: %w", path, err)
+		}
+		if !ok {
+			broken = append(broken, store)
+		}
+	}
+
+	if len(broken) > 0 {
+		return fmt.Errorf("%w: leaf_idx problems in %d stores: %v", ErrVerificationFailed, len(broken), broken)
+	}
+	return nil
+}
+
+// checkStoreIndex reports the leaf_idx problems of the changelog at path and, with rebuild,
+// recreates the index and checks it again. It returns whether the index ends up intact.
+func checkStoreIndex(w io.Writer, store, path string, rebuild bool) (bool, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return false, fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+
+	ok, err := tableExists(db, "leaf")
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		fmt.Fprintf(w, "%s: table leaf is missing\n", store)
+		return false, nil
+	}
+
+	problems, err := leafIndexProblems(db)
+	if err != nil {
+		return false, err
+	}
+	if len(problems) == 0 {
+		fmt.Fprintf(w, "%s: OK\n", store)
+		return true, nil
+	}
+	fmt.Fprintf(w, "%s: leaf_idx problems\n", store)
+	for _, p := range problems {
+		fmt.Fprintf(w, "  - %s\n", p)
+	}
+	if !rebuild {
+		return false, nil
+	}
+
+	if err := rebuildLeafIndex(db); err != nil {
+		fmt.Fprintf(w, "%s: rebuild failed: %v\n", store, err)
+		return false, nil
+	}
+	if problems, err = leafIndexProblems(db); err != nil {
+		return false, err
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(w, "%s: leaf_idx rebuilt, problems remain\n", store)
+		for _, p := range problems {
+			fmt.Fprintf(w, "  - %s\n", p)
+		}
+		return false, nil
+	}
+	fmt.Fprintf(w, "%s: leaf_idx rebuilt, OK\n", store)
+	return true, nil
+}
+
+// leafIndexProblems combines verifyLeafIndex's schema checks with an integrity check of the
+// leaf table and its indexes, which finds index entries that don't match the table's rows.
+func leafIndexProblems(db *sql.DB) ([]string, error) {
+	problems, err := verifyLeafIndex(db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("PRAGMA integrity_check(leaf)")
+	if err != nil {
+		return nil, fmt.Errorf("integrity_check leaf: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, fmt.Errorf("integrity_check leaf: %w", err)
+		}
+		if msg != "ok" {
+			problems = append(problems, "integrity_check: "+msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("integrity_check leaf: %w", err)
+	}
+	return problems, nil
+}
+
+// rebuildLeafIndex drops leaf_idx, whatever it currently indexes, and builds it again from the
+// leaf table in one transaction, so a failed build keeps the old index.
+func rebuildLeafIndex(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DROP INDEX IF EXISTS leaf_idx"); err != nil {
+		return fmt.Errorf("drop leaf_idx: %w", err)
+	}
+	if err := createLeafIndex(tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
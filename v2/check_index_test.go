@@ -0,0 +1,66 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckIndex(t *testing.T) {
+	dbPath := t.TempDir()
+	createMigratedStore(t, dbPath, "bank")
+	createMigratedStore(t, dbPath, "staking")
+
+	var buf bytes.Buffer
+	require.NoError(t, checkIndex(&buf, dbPath, "", false))
+	require.Equal(t, "bank: OK\nstaking: OK\n", buf.String())
+
+	db, err := sql.Open("sqlite", filepath.Join(dbPath, "bank", "changelog.sqlite"))
+	require.NoError(t, err)
+	_, err = db.Exec("DROP INDEX leaf_idx")
+	require.NoError(t, err)
+	db.Close()
+	db, err = sql.Open("sqlite", filepath.Join(dbPath, "staking", "changelog.sqlite"))
+	require.NoError(t, err)
+	_, err = db.Exec("DROP INDEX leaf_idx; CREATE INDEX leaf_idx ON leaf (version)")
+	require.NoError(t, err)
+	db.Close()
+
+	buf.Reset()
+	err = checkIndex(&buf, dbPath, "", false)
+	require.ErrorIs(t, err, ErrVerificationFailed)
+	require.ErrorContains(t, err, "2 stores")
+	require.Contains(t, buf.String(), "bank: leaf_idx problems\n  - index leaf_idx is missing\n")
+	require.Contains(t, buf.String(), "  - index leaf_idx is not UNIQUE\n  - index leaf_idx covers (version), want (version, sequence)\n")
+
+	// --store-key limits the check and the rebuild to one store
+	buf.Reset()
+	require.NoError(t, checkIndex(&buf, dbPath, "bank", true))
+	require.Contains(t, buf.String(), "bank: leaf_idx rebuilt, OK\n")
+	require.NotContains(t, buf.String(), "staking")
+
+	buf.Reset()
+	require.NoError(t, checkIndex(&buf, dbPath, "", true))
+	require.Contains(t, buf.String(), "bank: OK\n")
+	require.Contains(t, buf.String(), "staking: leaf_idx rebuilt, OK\n")
+	buf.Reset()
+	require.NoError(t, checkIndex(&buf, dbPath, "", false))
+	require.Equal(t, "bank: OK\nstaking: OK\n", buf.String())
+}
+
+func TestCheckIndexRebuildDuplicates(t *testing.T) {
+	dbPath := t.TempDir()
+	createMigratedStore(t, dbPath, "bank")
+	db, err := sql.Open("sqlite", filepath.Join(dbPath, "bank", "changelog.sqlite"))
+	require.NoError(t, err)
+	_, err = db.Exec("DROP INDEX leaf_idx; INSERT INTO leaf SELECT version, sequence, x'bb', bytes, orphaned FROM leaf")
+	require.NoError(t, err)
+	db.Close()
+
+	var buf bytes.Buffer
+	require.ErrorIs(t, checkIndex(&buf, dbPath, "", true), ErrVerificationFailed)
+	require.Contains(t, buf.String(), "bank: rebuild failed: create leaf_idx: constraint violation: duplicate leaf (version 1, sequence 1)")
+}
@@ -1,26 +1,42 @@
 package v2
 
 import (
+	"bufio"
 	"database/sql"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
+	nodepool3 "github.com/SaharaLabsAI/iavl/v2/common/pool/node"
+	inode3 "github.com/SaharaLabsAI/iavl/v2/node"
 	"github.com/spf13/cobra"
 	_ "modernc.org/sqlite"
 )
 
 func CheckShardsCommand() *cobra.Command {
 	var (
-		dbPath string
+		dbPath          string
+		summary         bool
+		pruneUnexpected bool
+		assumeYes       bool
+		treeFilename    string
 	)
 
 	cmd := &cobra.Command{
 		Use:   "check-shards",
 		Short: "check shard tables in database",
 		Run: func(cmd *cobra.Command, args []string) {
-			checkShards(dbPath)
+			// one reader for every store's prompt, so answers piped in for later stores aren't lost
+			var in *bufio.Reader
+			if !assumeYes {
+				in = confirmReader(os.Stdin)
+			}
+			checkShards(dbPath, treeFilename, summary, pruneUnexpected, in)
 		},
 	}
 
@@ -28,12 +44,46 @@ func CheckShardsCommand() *cobra.Command {
 	if err := cmd.MarkFlagRequired("db-path"); err != nil {
 		panic(err)
 	}
+	cmd.Flags().BoolVar(&summary, "summary", false, "Print one table row per store instead of the detailed report")
+	cmd.Flags().BoolVar(&pruneUnexpected, "prune-unexpected", false, "After confirmation, drop shard tables above the latest root's shard that hold no in-range rows")
+	cmd.Flags().BoolVarP(&assumeYes, "assume-yes", "y", false, "Prune without asking for confirmation")
+	cmd.Flags().StringVar(&treeFilename, "tree-filename", defaultTreeFilename, "Name of the tree databases to check")
 
 	return cmd
 }
 
-func checkShards(dbPath string) {
-	// Walk through all tree.sqlite files in the database directory
+// shardReport is what check-shards finds in one tree.sqlite.
+type shardReport struct {
+	path     string
+	existing []string
+	// minVersion and maxVersion are NULL when the root table is empty.
+	minVersion, maxVersion sql.NullInt64
+	expected               []int64
+	missing                []string
+	// gaps lists expected shard tables that don't exist but that no root points into and no
+	// branch node in a later shard has a child in, such as empty shards left out by
+	// --skip-empty-shards; they are not reported missing.
+	gaps []string
+	// unexpected lists shard tables outside the expected range; only set when the range is known.
+	unexpected []string
+	rowCounts  map[string]int64
+	countErrs  map[string]error
+}
+
+func (r *shardReport) totalRows() int64 {
+	var total int64
+	for _, count := range r.rowCounts {
+		total += count
+	}
+	return total
+}
+
+// checkShards reports the shard tables of every tree database named treeFilename under dbPath.
+// With pruneUnexpected it drops unexpected ones after asking on in; a nil in drops them without asking.
+func checkShards(dbPath, treeFilename string, summary, pruneUnexpected bool, in *bufio.Reader) {
+	var reports []*shardReport
+
+	// Walk through all tree databases in the database directory
 	var walkDir func(dir string) error
 	walkDir = func(dir string) error {
 		entries, err := os.ReadDir(dir)
@@ -52,16 +102,29 @@ func checkShards(dbPath string) {
 				continue
 			}
 
-			// Only process tree.sqlite files
-			if entry.Name() != "tree.sqlite" {
+			// Only process tree databases
+			if entry.Name() != treeFilename {
 				continue
 			}
 
-			fmt.Printf("\n=== Checking tree.sqlite: %s ===\n", path)
-			if err := checkShardsInFile(path); err != nil {
+			if !summary {
+				fmt.Printf("\n=== Checking %s: %s ===\n", treeFilename, path)
+			}
+			report, err := inspectShards(path)
+			if err != nil {
 				log.Printf("Error checking %s: %v", path, err)
 				continue
 			}
+			if summary {
+				reports = append(reports, report)
+			} else {
+				printShardReport(os.Stdout, report)
+			}
+			if pruneUnexpected && len(report.unexpected) > 0 {
+				if err := pruneUnexpectedShards(in, os.Stdout, report); err != nil {
+					log.Printf("Error pruning %s: %v", path, err)
+				}
+			}
 		}
 		return nil
 	}
@@ -69,90 +132,298 @@ func checkShards(dbPath string) {
 	if err := walkDir(dbPath); err != nil {
 		log.Fatal(err)
 	}
+	if summary {
+		printShardSummary(os.Stdout, dbPath, reports)
+	}
 }
 
-func checkShardsInFile(dbPath string) error {
+// inspectShards collects the existing, expected and missing shard tables of a tree.sqlite
+// along with per-shard row counts.
+func inspectShards(dbPath string) (*shardReport, error) {
 	// Open the database
 	db, err := sql.Open("sqlite", dbPath)
 	if err != nil {
-		return fmt.Errorf("open db %s: %w", dbPath, err)
+		return nil, fmt.Errorf("open db %s: %w", dbPath, err)
 	}
 	defer db.Close()
 
 	// Check what shard tables exist
 	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' AND name LIKE 'tree_%' ORDER BY name")
 	if err != nil {
-		return fmt.Errorf("failed to query shard tables: %w", err)
+		return nil, fmt.Errorf("failed to query shard tables: %w", err)
 	}
 	defer rows.Close()
 
-	var existingShards []string
+	report := &shardReport{
+		path:      dbPath,
+		rowCounts: make(map[string]int64),
+		countErrs: make(map[string]error),
+	}
 	for rows.Next() {
 		var tableName string
 		if err := rows.Scan(&tableName); err != nil {
-			return fmt.Errorf("failed to scan table name: %w", err)
+			return nil, fmt.Errorf("failed to scan table name: %w", err)
 		}
-		existingShards = append(existingShards, tableName)
+		report.existing = append(report.existing, tableName)
 	}
 
 	if err := rows.Err(); err != nil {
-		return fmt.Errorf("error iterating shard tables: %w", err)
+		return nil, fmt.Errorf("error iterating shard tables: %w", err)
 	}
 
-	fmt.Printf("Database: %s\n", dbPath)
-	fmt.Printf("Existing shard tables: %v\n", existingShards)
-
-	// Analyze version range to understand data distribution
-	fmt.Printf("Analyzing version range...\n")
-
 	// Get min and max versions from the root table
-	var minVersion, maxVersion int64
-	err = db.QueryRow("SELECT MIN(version), MAX(version) FROM root").Scan(&minVersion, &maxVersion)
+	err = db.QueryRow("SELECT MIN(version), MAX(version) FROM root").Scan(&report.minVersion, &report.maxVersion)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			fmt.Printf("No data found in root table\n")
-			return nil
-		}
-		return fmt.Errorf("failed to query version range: %w", err)
+		return nil, fmt.Errorf("failed to query version range: %w", err)
+	}
+	if !report.minVersion.Valid || !report.maxVersion.Valid {
+		return report, nil
 	}
-
-	fmt.Printf("Version range: %d to %d\n", minVersion, maxVersion)
 
 	// Calculate expected shard range
-	expectedShards := calculateShardRange(minVersion, maxVersion)
-	fmt.Printf("Expected shards based on version range: %v\n", expectedShards)
+	report.expected = calculateShardRange(report.minVersion.Int64, report.maxVersion.Int64)
 
 	// Check for missing shards
 	existingShardMap := make(map[string]bool)
-	for _, shard := range existingShards {
+	for _, shard := range report.existing {
 		existingShardMap[shard] = true
 	}
 
-	var missingShards []string
-	for _, shardID := range expectedShards {
+	shardIDs, err := listShardIDs(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// an absent shard no root points into may still hold the children of nodes in later shards
+	expectedShardMap := make(map[string]bool)
+	rootReferenced := make(map[int64]bool)
+	var unreferenced []int64
+	for _, shardID := range report.expected {
+		tableName := fmt.Sprintf("tree_%d", shardID)
+		expectedShardMap[tableName] = true
+		if existingShardMap[tableName] {
+			continue
+		}
+		startVersion, endVersion := shardVersionRange(shardID)
+		var referenced bool
+		err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM root WHERE node_version >= ? AND node_version <= ?)", startVersion, endVersion).Scan(&referenced)
+		if err != nil {
+			return nil, fmt.Errorf("check roots in %s: %w", tableName, err)
+		}
+		if referenced {
+			rootReferenced[shardID] = true
+		} else {
+			unreferenced = append(unreferenced, shardID)
+		}
+	}
+	nodeReferenced, err := shardsReferencedByNodes(db, shardIDs, unreferenced)
+	if err != nil {
+		return nil, err
+	}
+	for _, shardID := range report.expected {
 		tableName := fmt.Sprintf("tree_%d", shardID)
-		if !existingShardMap[tableName] {
-			missingShards = append(missingShards, tableName)
+		switch {
+		case existingShardMap[tableName]:
+		case rootReferenced[shardID] || nodeReferenced[shardID]:
+			report.missing = append(report.missing, tableName)
+		default:
+			report.gaps = append(report.gaps, tableName)
+		}
+	}
+
+	for _, shardID := range shardIDs {
+		if tableName := fmt.Sprintf("tree_%d", shardID); !expectedShardMap[tableName] {
+			report.unexpected = append(report.unexpected, tableName)
+		}
+	}
+
+	// Count rows per shard
+	for _, shard := range report.existing {
+		var count int64
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", shard)).Scan(&count); err != nil {
+			report.countErrs[shard] = err
+			continue
 		}
+		report.rowCounts[shard] = count
 	}
 
-	if len(missingShards) > 0 {
-		fmt.Printf("Missing shard tables: %v\n", missingShards)
+	return report, nil
+}
+
+// shardsReferencedByNodes returns which of the absent shards in candidates, in ascending order,
+// hold a child of a branch node in one of the shard tables shardIDs of db. A child is never
+// newer than its parent, so only the shards above the lowest candidate are read. Rows that
+// don't decode as a v3 node are skipped.
+func shardsReferencedByNodes(db *sql.DB, shardIDs, candidates []int64) (map[int64]bool, error) {
+	referenced := make(map[int64]bool)
+	if len(candidates) == 0 {
+		return referenced, nil
+	}
+	isCandidate := make(map[int64]bool)
+	for _, shardID := range candidates {
+		isCandidate[shardID] = true
+	}
+	for _, shardID := range shardIDs {
+		if shardID <= candidates[0] || len(referenced) == len(candidates) {
+			continue
+		}
+		if err := addChildShards(db, shardID, isCandidate, referenced); err != nil {
+			return nil, err
+		}
+	}
+	return referenced, nil
+}
+
+// addChildShards decodes every row of tree_<shardID> and adds the shards in isCandidate that a
+// branch's left or right child lives in to referenced.
+func addChildShards(db *sql.DB, shardID int64, isCandidate, referenced map[int64]bool) error {
+	tableName := fmt.Sprintf("tree_%d", shardID)
+	rows, err := db.Query(fmt.Sprintf("SELECT version, sequence, bytes FROM %s", tableName))
+	if err != nil {
+		return fmt.Errorf("read nodes of %s: %w", tableName, err)
+	}
+	defer rows.Close()
+	pool := nodepool3.NewNodePool()
+	for rows.Next() {
+		var (
+			version, sequence int64
+			bz                []byte
+		)
+		if err := rows.Scan(&version, &sequence, &bz); err != nil {
+			return fmt.Errorf("read nodes of %s: %w", tableName, err)
+		}
+		node, err := inode3.Decode(pool, inode3.NewNodeKey(version, uint32(sequence)), bz)
+		if err != nil || node.IsLeaf() {
+			continue
+		}
+		for _, child := range []inode3.NodeKey{node.LeftNodeKey(), node.RightNodeKey()} {
+			if childShard := ToShardID(child.Version()); isCandidate[childShard] {
+				referenced[childShard] = true
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read nodes of %s: %w", tableName, err)
+	}
+	return nil
+}
+
+func printShardReport(w io.Writer, report *shardReport) {
+	fmt.Fprintf(w, "Database: %s\n", report.path)
+	fmt.Fprintf(w, "Existing shard tables: %v\n", report.existing)
+
+	// Analyze version range to understand data distribution
+	fmt.Fprintf(w, "Analyzing version range...\n")
+	if !report.minVersion.Valid || !report.maxVersion.Valid {
+		fmt.Fprintf(w, "No data found in root table\n")
+		return
+	}
+
+	fmt.Fprintf(w, "Version range: %d to %d\n", report.minVersion.Int64, report.maxVersion.Int64)
+	fmt.Fprintf(w, "Expected shards based on version range: %v\n", report.expected)
+
+	if len(report.missing) > 0 {
+		fmt.Fprintf(w, "Missing shard tables: %v\n", report.missing)
 	} else {
-		fmt.Printf("All expected shard tables exist\n")
+		fmt.Fprintf(w, "All expected shard tables exist\n")
+	}
+	if len(report.gaps) > 0 {
+		fmt.Fprintf(w, "Empty shards without a table (no root or node points into them): %v\n", report.gaps)
+	}
+	if len(report.unexpected) > 0 {
+		fmt.Fprintf(w, "Unexpected shard tables: %v\n", report.unexpected)
 	}
 
 	// Show data distribution across shards
-	fmt.Printf("\nData distribution across shards:\n")
-	for _, shard := range existingShards {
-		var count int64
-		err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", shard)).Scan(&count)
-		if err != nil {
-			fmt.Printf("  %s: error counting rows: %v\n", shard, err)
+	fmt.Fprintf(w, "\nData distribution across shards:\n")
+	for _, shard := range report.existing {
+		if err, ok := report.countErrs[shard]; ok {
+			fmt.Fprintf(w, "  %s: error counting rows: %v\n", shard, err)
 		} else {
-			fmt.Printf("  %s: %d rows\n", shard, count)
+			fmt.Fprintf(w, "  %s: %d rows\n", shard, report.rowCounts[shard])
 		}
 	}
+}
+
+// printShardSummary prints one aligned row per store, named by its directory relative to dbPath.
+func printShardSummary(w io.Writer, dbPath string, reports []*shardReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tVERSIONS\tEXPECTED\tEXISTING\tMISSING\tUNEXPECTED\tROWS")
+	for _, report := range reports {
+		store, err := filepath.Rel(dbPath, filepath.Dir(report.path))
+		if err != nil {
+			store = filepath.Dir(report.path)
+		}
 
+		versions := "-"
+		if report.minVersion.Valid && report.maxVersion.Valid {
+			versions = fmt.Sprintf("%d-%d", report.minVersion.Int64, report.maxVersion.Int64)
+		}
+		missing := "-"
+		if len(report.missing) > 0 {
+			missing = strings.Join(report.missing, ",")
+		}
+		unexpected := "-"
+		if len(report.unexpected) > 0 {
+			unexpected = strings.Join(report.unexpected, ",")
+		}
+		rows := fmt.Sprint(report.totalRows())
+		if len(report.countErrs) > 0 {
+			rows += " (count errors)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%s\n", store, versions, len(report.expected), len(report.existing), missing, unexpected, rows)
+	}
+	tw.Flush()
+}
+
+// pruneUnexpectedShards drops the report's unexpected shard tables above the latest root's
+// shard once the operator confirms on in; a nil in doesn't ask. Tables below it are always
+// kept: a pruned store's roots still reach nodes written long before its first root through
+// unchanged subtrees, and those nodes live in the lower shards. A table above it is also kept
+// if it holds any row inside the root version range, since that data would be lost.
+func pruneUnexpectedShards(in *bufio.Reader, out io.Writer, report *shardReport) error {
+	db, err := sql.Open("sqlite", report.path)
+	if err != nil {
+		return fmt.Errorf("open db %s: %w", report.path, err)
+	}
+	defer db.Close()
+
+	latestShard := ToShardID(report.maxVersion.Int64)
+	var droppable []string
+	for _, tableName := range report.unexpected {
+		shardID, err := strconv.ParseInt(strings.TrimPrefix(tableName, "tree_"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse shard table name %s: %w", tableName, err)
+		}
+		if shardID <= latestShard {
+			fmt.Fprintf(out, "Keeping %s: retained roots up to version %d may still reach its nodes\n", tableName, report.maxVersion.Int64)
+			continue
+		}
+		var inRange int64
+		err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE version >= ? AND version <= ?", tableName),
+			report.minVersion.Int64, report.maxVersion.Int64).Scan(&inRange)
+		if err != nil {
+			return fmt.Errorf("count in-range rows of %s: %w", tableName, err)
+		}
+		if inRange > 0 {
+			fmt.Fprintf(out, "Keeping %s: it holds %d rows inside versions %d-%d\n", tableName, inRange, report.minVersion.Int64, report.maxVersion.Int64)
+			continue
+		}
+		droppable = append(droppable, tableName)
+	}
+	if len(droppable) == 0 {
+		return nil
+	}
+
+	if in != nil && !confirm(in, out, fmt.Sprintf("Drop unexpected shard tables %v from %s?", droppable, report.path)) {
+		fmt.Fprintf(out, "Skipping %s\n", report.path)
+		return nil
+	}
+	for _, tableName := range droppable {
+		if _, err := db.Exec(fmt.Sprintf("DROP TABLE %s", tableName)); err != nil {
+			return fmt.Errorf("drop %s: %w", tableName, err)
+		}
+		fmt.Fprintf(out, "Dropped %s from %s\n", tableName, report.path)
+	}
 	return nil
 }
@@ -0,0 +1,155 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	inode3 "github.com/SaharaLabsAI/iavl/v2/node"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func TestCheckShardsSummary(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 500001, 500002)
+	createV2Store(t, filepath.Join(iavl2Path, "staking"), 1)
+	runV2Command(t, "start", "--iavl2-path", iavl2Path)
+
+	db, err := sql.Open("sqlite", filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("DROP TABLE tree_2")
+	require.NoError(t, err)
+
+	out := runV2Command(t, "check-shards", "--db-path", iavl2Path, "--summary")
+	require.Equal(t, []string{
+		"STORE    VERSIONS  EXPECTED  EXISTING  MISSING  UNEXPECTED  ROWS",
+		"bank     1-500002  2         1         tree_2   -           1",
+		"staking  1-1       1         1         -        -           1",
+	}, strings.Split(strings.TrimSpace(out), "\n"))
+}
+
+func TestCheckShardsUnexpected(t *testing.T) {
+	dbPath := t.TempDir()
+	createMigratedStore(t, dbPath, "bank")
+	treePath := filepath.Join(dbPath, "bank", "tree.sqlite")
+
+	db, err := sql.Open("sqlite", treePath)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE tree_12 (version int, sequence int, bytes blob, orphaned bool, PRIMARY KEY (version, sequence)) WITHOUT ROWID;
+		CREATE TABLE tree_13 (version int, sequence int, bytes blob, orphaned bool, PRIMARY KEY (version, sequence)) WITHOUT ROWID;
+		INSERT INTO tree_12 VALUES (5600000, 1, x'01', false);
+		INSERT INTO tree_13 VALUES (1, 2, x'01', false);
+	`)
+	require.NoError(t, err)
+
+	report, err := inspectShards(treePath)
+	require.NoError(t, err)
+	require.Empty(t, report.missing)
+	require.Equal(t, []string{"tree_12", "tree_13"}, report.unexpected)
+
+	var buf bytes.Buffer
+	printShardReport(&buf, report)
+	require.Contains(t, buf.String(), "Unexpected shard tables: [tree_12 tree_13]")
+
+	// declining leaves everything in place
+	var out bytes.Buffer
+	require.NoError(t, pruneUnexpectedShards(confirmReader(strings.NewReader("n\n")), &out, report))
+	require.Equal(t, []int64{1, 12, 13}, shardIDsOf(t, db))
+
+	out.Reset()
+	require.NoError(t, pruneUnexpectedShards(confirmReader(strings.NewReader("y\n")), &out, report))
+	require.Contains(t, out.String(), "Dropped tree_12")
+	require.Contains(t, out.String(), "Keeping tree_13: it holds 1 rows inside versions 1-1")
+	require.Equal(t, []int64{1, 13}, shardIDsOf(t, db))
+}
+
+func TestPruneUnexpectedShardsKeepsLowerShards(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 500001, 1000001)
+	runV2Command(t, "start", "--iavl2-path", iavl2Path)
+	treePath := filepath.Join(iavl2Path, "bank", "tree.sqlite")
+
+	// pruned up to version 500001: its root can still reach the version 1 node in tree_1
+	db, err := sql.Open("sqlite", treePath)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`DELETE FROM root WHERE version < 500001;
+		CREATE TABLE tree_9 (version int, sequence int, bytes blob, orphaned bool, PRIMARY KEY (version, sequence)) WITHOUT ROWID;`)
+	require.NoError(t, err)
+
+	report, err := inspectShards(treePath)
+	require.NoError(t, err)
+	require.Equal(t, []string{"tree_1", "tree_9"}, report.unexpected)
+
+	var out bytes.Buffer
+	require.NoError(t, pruneUnexpectedShards(nil, &out, report))
+	require.Contains(t, out.String(), "Keeping tree_1: retained roots up to version 1000001 may still reach its nodes")
+	require.Contains(t, out.String(), "Dropped tree_9")
+	require.Equal(t, []int64{1, 2, 3}, shardIDsOf(t, db))
+}
+
+func shardIDsOf(t *testing.T, db *sql.DB) []int64 {
+	shardIDs, err := listShardIDs(db)
+	require.NoError(t, err)
+	return shardIDs
+}
+
+func TestCheckShardsPruneReadsEveryAnswer(t *testing.T) {
+	dbPath := t.TempDir()
+	for _, store := range []string{"bank", "staking"} {
+		createMigratedStore(t, dbPath, store)
+		db, err := sql.Open("sqlite", filepath.Join(dbPath, store, "tree.sqlite"))
+		require.NoError(t, err)
+		_, err = db.Exec(`CREATE TABLE tree_9 (version int, sequence int, bytes blob, orphaned bool, PRIMARY KEY (version, sequence)) WITHOUT ROWID`)
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+	}
+
+	// both answers arrive at once, as when piped
+	out := captureStdout(t, func() {
+		checkShards(dbPath, defaultTreeFilename, false, true, confirmReader(strings.NewReader("y\ny\n")))
+	})
+	require.Equal(t, 2, strings.Count(out, "Dropped tree_9"), out)
+}
+
+// branchNodeBytes encodes a height 1 branch node the way the v3 codec does.
+func branchNodeBytes(left, right inode3.NodeKey) []byte {
+	bz := binary.AppendVarint(nil, 1)
+	bz = binary.AppendVarint(bz, 2)
+	bz = append(binary.AppendUvarint(bz, 1), 0xaa)
+	bz = append(binary.AppendUvarint(bz, 32), make([]byte, 32)...)
+	bz = append(binary.AppendUvarint(bz, 12), left[:]...)
+	return append(binary.AppendUvarint(bz, 12), right[:]...)
+}
+
+func TestInspectShardsNodeReferencedShardIsMissing(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, dir, 1, 1000001)
+	newPath := filepath.Join(t.TempDir(), "tree.sqlite")
+	_, err := migrateTree(filepath.Join(dir, "tree.sqlite"), newPath, migrateOptions{skipEmptyShards: true})
+	require.NoError(t, err)
+
+	report, err := inspectShards(newPath)
+	require.NoError(t, err)
+	require.Equal(t, []string{"tree_2"}, report.gaps)
+
+	// no root points into shard 2, but a branch of version 1000001 keeps a child written at 600000
+	db, err := sql.Open("sqlite", newPath)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("INSERT INTO tree_3 VALUES (1000001, 2, ?, false)",
+		branchNodeBytes(inode3.NewNodeKey(600000, 1), inode3.NewNodeKey(1000001, 1)))
+	require.NoError(t, err)
+
+	report, err = inspectShards(newPath)
+	require.NoError(t, err)
+	require.Equal(t, []string{"tree_2"}, report.missing)
+	require.Empty(t, report.gaps)
+}
@@ -0,0 +1,44 @@
+package v2
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckVersionRequiresRoots(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2, 3)
+	runV2Command(t, "start", "--iavl2-path", iavl2Path)
+
+	require.ErrorContains(t, checkVersion(iavl2Path+".bak", iavl2Path, "bank", 0, iavlLoadOptions{}), "--version must be positive")
+
+	err := checkVersion(iavl2Path+".bak", iavl2Path, "bank", 4, iavlLoadOptions{})
+	require.ErrorIs(t, err, ErrSourceNotFound)
+	require.ErrorContains(t, err, "root of version 4 not found in "+filepath.Join(iavl2Path+".bak", "bank", "tree.sqlite"))
+
+	// a version the destination lost is reported against it
+	db, err := sql.Open("sqlite", filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("DELETE FROM root WHERE version = 2")
+	require.NoError(t, err)
+	err = checkVersion(iavl2Path+".bak", iavl2Path, "bank", 2, iavlLoadOptions{})
+	require.ErrorIs(t, err, ErrSourceNotFound)
+	require.ErrorContains(t, err, "root of version 2 not found in "+filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+}
+
+func TestReadRootRowAt(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, dir, 1, 2, 3)
+
+	row, err := readRootRowAt(filepath.Join(dir, "tree.sqlite"), 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), row.version)
+
+	row, err = readLatestRootRow(filepath.Join(dir, "tree.sqlite"))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), row.version)
+}
@@ -0,0 +1,185 @@
+package v2
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultManifestName = "checksums.sha256"
+
+func ChecksumCommand() *cobra.Command {
+	var (
+		dbPath   string
+		manifest string
+		verify   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "checksum",
+		Short: "write or verify a SHA-256 manifest of the migrated tree.sqlite/changelog.sqlite files",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if manifest == "" {
+				manifest = filepath.Join(dbPath, defaultManifestName)
+			}
+			if verify {
+				return verifyChecksumManifest(dbPath, manifest)
+			}
+			return writeChecksumManifest(dbPath, manifest)
+		},
+	}
+
+	cmd.Flags().StringVar(&dbPath, "db-path", "", "Path to the migrated iavl2/ directory")
+	if err := cmd.MarkFlagRequired("db-path"); err != nil {
+		panic(err)
+	}
+	cmd.Flags().StringVar(&manifest, "manifest", "", "Manifest file (default <db-path>/"+defaultManifestName+")")
+	cmd.Flags().BoolVar(&verify, "verify", false, "Re-hash the files and compare them against an existing manifest")
+
+	return cmd
+}
+
+// checksumFiles hashes every tree.sqlite and changelog.sqlite under dbPath, keyed by
+// slash-separated path relative to dbPath.
+func checksumFiles(dbPath string) (map[string]string, []string, error) {
+	sums := make(map[string]string)
+	var paths []string
+	err := filepath.WalkDir(dbPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || (d.Name() != "tree.sqlite" && d.Name() != "changelog.sqlite") {
+			return nil
+		}
+		rel, err := filepath.Rel(dbPath, path)
+		if err != nil {
+			return err
+		}
+		sum, err := sha256File(path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		sums[rel] = sum
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return sums, paths, nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksumManifest writes the manifest in sha256sum format, so it can also be
+// checked with `sha256sum -c` from inside dbPath.
+func writeChecksumManifest(dbPath, manifest string) error {
+	sums, paths, err := checksumFiles(dbPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(manifest)
+	if err != nil {
+		return fmt.Errorf("create manifest %s: %w", manifest, err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, path := range paths {
+		fmt.Fprintf(w, "%s  %s\n", sums[path], path)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write manifest %s: %w", manifest, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write manifest %s: %w", manifest, err)
+	}
+
+	fmt.Printf("wrote checksums for %d files to %s\n", len(paths), manifest)
+	return nil
+}
+
+func readChecksumManifest(manifest string) (map[string]string, []string, error) {
+	f, err := os.Open(manifest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open manifest %s: %w", manifest, err)
+	}
+	defer f.Close()
+
+	sums := make(map[string]string)
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		sum, path, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			return nil, nil, fmt.Errorf("manifest %s line %d: malformed entry %q", manifest, line, scanner.Text())
+		}
+		sums[path] = sum
+		paths = append(paths, path)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("read manifest %s: %w", manifest, err)
+	}
+	return sums, paths, nil
+}
+
+func verifyChecksumManifest(dbPath, manifest string) error {
+	want, wantPaths, err := readChecksumManifest(manifest)
+	if err != nil {
+		return err
+	}
+	got, gotPaths, err := checksumFiles(dbPath)
+	if err != nil {
+		return err
+	}
+
+	var problems int
+	for _, path := range wantPaths {
+		sum, ok := got[path]
+		switch {
+		case !ok:
+			fmt.Printf("MISSING  %s\n", path)
+			problems++
+		case sum != want[path]:
+			fmt.Printf("CHANGED  %s (manifest %s, now %s)\n", path, want[path], sum)
+			problems++
+		}
+	}
+	for _, path := range gotPaths {
+		if _, ok := want[path]; !ok {
+			fmt.Printf("UNTRACKED  %s\n", path)
+			problems++
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%w: %d files do not match manifest %s", ErrVerificationFailed, problems, manifest)
+	}
+	fmt.Printf("all %d files match %s\n", len(wantPaths), manifest)
+	return nil
+}
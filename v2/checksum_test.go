@@ -0,0 +1,45 @@
+package v2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumManifest(t *testing.T) {
+	dbPath := t.TempDir()
+	createMigratedStore(t, dbPath, "bank")
+	createMigratedStore(t, dbPath, "staking")
+	manifest := filepath.Join(t.TempDir(), "manifest.sha256")
+
+	require.NoError(t, writeChecksumManifest(dbPath, manifest))
+	sums, paths, err := readChecksumManifest(manifest)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"bank/changelog.sqlite",
+		"bank/tree.sqlite",
+		"staking/changelog.sqlite",
+		"staking/tree.sqlite",
+	}, paths)
+	require.Len(t, sums["bank/tree.sqlite"], 64)
+
+	require.NoError(t, verifyChecksumManifest(dbPath, manifest))
+
+	// flip a byte in one file and drop another
+	treePath := filepath.Join(dbPath, "bank", "tree.sqlite")
+	bz, err := os.ReadFile(treePath)
+	require.NoError(t, err)
+	bz[len(bz)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(treePath, bz, 0o644))
+	require.NoError(t, os.Remove(filepath.Join(dbPath, "staking", "changelog.sqlite")))
+
+	var verifyErr error
+	out := captureStdout(t, func() {
+		verifyErr = verifyChecksumManifest(dbPath, manifest)
+	})
+	require.ErrorContains(t, verifyErr, "2 files do not match manifest")
+	require.Contains(t, out, "CHANGED  bank/tree.sqlite")
+	require.Contains(t, out, "MISSING  staking/changelog.sqlite")
+}
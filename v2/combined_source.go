@@ -0,0 +1,48 @@
+package v2
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// defaultCombinedSource is the single-file layout looked for in a store directory that has
+// neither a tree.sqlite nor a changelog.sqlite: one SQLite database holding both the tree
+// tables (tree_1, root, orphan) and the changelog tables (leaf, leaf_orphan).
+const defaultCombinedSource = "application.db"
+
+// storeSources returns the tree and changelog source paths of the store directory dir. With
+// opts.combinedSource both name that file; otherwise they are opts' tree and changelog filenames
+// (or a compressed variant), unless neither exists and dir holds a defaultCombinedSource.
+// combined reports whether both halves are read from one file.
+func storeSources(dir string, opts migrateOptions) (treePath, changelogPath string, combined bool) {
+	if opts.combinedSource != "" {
+		path := resolveSource(filepath.Join(dir, opts.combinedSource))
+		return path, path, true
+	}
+	treePath = resolveSource(filepath.Join(dir, opts.treeFilename()))
+	changelogPath = resolveSource(filepath.Join(dir, opts.changelogFilename()))
+	if fileExists(treePath) || fileExists(changelogPath) {
+		return treePath, changelogPath, false
+	}
+	if path := resolveSource(filepath.Join(dir, defaultCombinedSource)); fileExists(path) {
+		return path, path, true
+	}
+	return treePath, changelogPath, false
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// knownSourceTables returns the source tables a phase migrates itself, given the phase's own
+// tables. A combined source also holds the other phase's tables, which aren't unknown there.
+func knownSourceTables(own []string, opts migrateOptions) []string {
+	known := slices.Clone(own)
+	if opts.combined {
+		known = append(known, knownTreeTables...)
+		known = append(known, knownChangelogTables...)
+	}
+	return known
+}
@@ -0,0 +1,106 @@
+package v2
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// createCombinedV2Store writes a store whose tree and changelog tables share the one file name in dir.
+func createCombinedV2Store(t *testing.T, dir, name string, versions ...int64) {
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	db, err := sql.Open("sqlite", filepath.Join(dir, name))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool);
+		CREATE TABLE orphan (version int, sequence int, at int);
+		CREATE TABLE root (version int, node_version int, node_sequence int, bytes blob, PRIMARY KEY (version));
+		CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+		CREATE TABLE leaf_orphan (version int, sequence int, at int);
+	`)
+	require.NoError(t, err)
+	for _, version := range versions {
+		_, err = db.Exec("INSERT INTO tree_1 VALUES (?, 1, x'01', false)", version)
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT INTO root VALUES (?, ?, 1, x'000201aa000101')", version, version)
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT INTO leaf VALUES (?, 1, x'aa', x'01', false)", version)
+		require.NoError(t, err)
+	}
+}
+
+// requireTableNames checks that the database at path has exactly the given tables.
+func requireTableNames(t *testing.T, path string, want ...string) {
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+	require.NoError(t, err)
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		got = append(got, name)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, want, got)
+}
+
+func countTableRows(t *testing.T, path, table string) int64 {
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+	var rows int64
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM "+table).Scan(&rows))
+	return rows
+}
+
+func TestMigrateCombinedSource(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	// detected without any option: neither tree.sqlite nor changelog.sqlite exists
+	createCombinedV2Store(t, filepath.Join(iavl2Path, "bank"), defaultCombinedSource, 1, 2)
+	createV2Store(t, filepath.Join(iavl2Path, "staking"), 1)
+
+	// the other half's tables aren't unknown, so even --copy-unknown-tables leaves them alone
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{copyUnknownTables: true}))
+
+	treePath := filepath.Join(iavl2Path, "bank", "tree.sqlite")
+	changelogPath := filepath.Join(iavl2Path, "bank", "changelog.sqlite")
+	requireTableNames(t, treePath, "branch_orphan", "root", "tree_1")
+	requireTableNames(t, changelogPath, "leaf", "leaf_orphan")
+	require.Equal(t, int64(2), countTableRows(t, treePath, "tree_1"))
+	require.Equal(t, int64(2), countTableRows(t, treePath, "root"))
+	require.Equal(t, int64(2), countTableRows(t, changelogPath, "leaf"))
+	require.Equal(t, int64(1), countTableRows(t, filepath.Join(iavl2Path, "staking", "changelog.sqlite"), "leaf"))
+}
+
+func TestMigrateCombinedSourceNamed(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createCombinedV2Store(t, filepath.Join(iavl2Path, "bank"), "state.db", 1)
+
+	// a name other than the default isn't detected
+	require.ErrorIs(t, migrate(iavl2Path, nil, false, migrateOptions{}), ErrSourceNotFound)
+	require.NoError(t, os.RemoveAll(iavl2Path))
+	require.NoError(t, os.Rename(iavl2Path+".bak", iavl2Path))
+
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{combinedSource: "state.db"}))
+	require.Equal(t, int64(1), countTableRows(t, filepath.Join(iavl2Path, "bank", "tree.sqlite"), "tree_1"))
+	require.Equal(t, int64(1), countTableRows(t, filepath.Join(iavl2Path, "bank", "changelog.sqlite"), "leaf"))
+}
+
+func TestMigrateFilesCombinedSource(t *testing.T) {
+	oldDir := t.TempDir()
+	createCombinedV2Store(t, oldDir, defaultCombinedSource, 1)
+	source := filepath.Join(oldDir, defaultCombinedSource)
+	newDir := t.TempDir()
+
+	require.NoError(t, migrateFiles(source, filepath.Join(newDir, "tree.sqlite"), source, filepath.Join(newDir, "changelog.sqlite"),
+		migrateOptions{copyUnknownTables: true}))
+	requireTableNames(t, filepath.Join(newDir, "tree.sqlite"), "branch_orphan", "root", "tree_1")
+	requireTableNames(t, filepath.Join(newDir, "changelog.sqlite"), "leaf", "leaf_orphan")
+}
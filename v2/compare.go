@@ -0,0 +1,186 @@
+package v2
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+func CompareCommand() *cobra.Command {
+	var (
+		paths      []string
+		hashShards bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "compare",
+		Short: "compare two migrated iavl2/ directories store by store",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(paths) != 2 {
+				return fmt.Errorf("compare needs exactly two --path flags, got %d", len(paths))
+			}
+			return compareDestinations(paths[0], paths[1], hashShards)
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&paths, "path", nil, "Migrated iavl2/ directory; pass twice")
+	cmd.Flags().BoolVar(&hashShards, "hash", false, "Also compare a SHA-256 of the sorted (version, sequence, bytes) rows of every shard")
+	if err := cmd.MarkFlagRequired("path"); err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+// treeSummary is what compare looks at in one destination tree.sqlite.
+type treeSummary struct {
+	latestVersion sql.NullInt64
+	shardIDs      []int64
+	shardRows     map[int64]int64
+	shardHashes   map[int64]string
+}
+
+func compareDestinations(pathA, pathB string, hashShards bool) error {
+	storesA, _, err := getStoreKeys(pathA, nil, nil)
+	if err != nil {
+		return err
+	}
+	storesB, _, err := getStoreKeys(pathB, nil, nil)
+	if err != nil {
+		return err
+	}
+	stores := append(slices.Clone(storesA), storesB...)
+	slices.Sort(stores)
+	stores = slices.Compact(stores)
+
+	var diverged []string
+	for _, store := range stores {
+		diff, err := compareStore(pathA, pathB, store, storesA, storesB, hashShards)
+		if err != nil {
+			return fmt.Errorf("compare store %s: %w", store, err)
+		}
+		if diff == "" {
+			fmt.Printf("%s: identical\n", store)
+			continue
+		}
+		diverged = append(diverged, store)
+		fmt.Printf("%s: DIFFERS: %s\n", store, diff)
+	}
+
+	if len(diverged) > 0 {
+		fmt.Printf("FAIL: %d of %d stores differ\n", len(diverged), len(stores))
+		return fmt.Errorf("%w: %d stores differ between %s and %s: %v", ErrVerificationFailed, len(diverged), pathA, pathB, diverged)
+	}
+	fmt.Printf("PASS: %d stores identical\n", len(stores))
+	return nil
+}
+
+// compareStore returns a description of the first difference found in store, or "" if none.
+func compareStore(pathA, pathB, store string, storesA, storesB []string, hashShards bool) (string, error) {
+	switch {
+	case !slices.Contains(storesA, store):
+		return fmt.Sprintf("missing from %s", pathA), nil
+	case !slices.Contains(storesB, store):
+		return fmt.Sprintf("missing from %s", pathB), nil
+	}
+
+	a, err := summarizeTree(filepath.Join(pathA, store, "tree.sqlite"), hashShards)
+	if err != nil {
+		return "", err
+	}
+	b, err := summarizeTree(filepath.Join(pathB, store, "tree.sqlite"), hashShards)
+	if err != nil {
+		return "", err
+	}
+
+	if a.latestVersion != b.latestVersion {
+		return fmt.Sprintf("latest root version %s vs %s", nullInt64String(a.latestVersion), nullInt64String(b.latestVersion)), nil
+	}
+	if !slices.Equal(a.shardIDs, b.shardIDs) {
+		return fmt.Sprintf("shards %v vs %v", a.shardIDs, b.shardIDs), nil
+	}
+	for _, shardID := range a.shardIDs {
+		if a.shardRows[shardID] != b.shardRows[shardID] {
+			return fmt.Sprintf("tree_%d has %d rows vs %d", shardID, a.shardRows[shardID], b.shardRows[shardID]), nil
+		}
+		if hashShards && a.shardHashes[shardID] != b.shardHashes[shardID] {
+			return fmt.Sprintf("tree_%d content hash %s vs %s", shardID, a.shardHashes[shardID], b.shardHashes[shardID]), nil
+		}
+	}
+	return "", nil
+}
+
+func summarizeTree(path string, hashShards bool) (*treeSummary, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+
+	summary := &treeSummary{
+		shardRows:   make(map[int64]int64),
+		shardHashes: make(map[int64]string),
+	}
+	if err := db.QueryRow("SELECT MAX(version) FROM root").Scan(&summary.latestVersion); err != nil {
+		return nil, fmt.Errorf("read latest version from %s: %w", path, err)
+	}
+
+	summary.shardIDs, err = listShardIDs(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, shardID := range summary.shardIDs {
+		var count int64
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM tree_%d", shardID)).Scan(&count); err != nil {
+			return nil, fmt.Errorf("count tree_%d in %s: %w", shardID, path, err)
+		}
+		summary.shardRows[shardID] = count
+
+		if hashShards {
+			sum, err := hashShard(db, shardID)
+			if err != nil {
+				return nil, fmt.Errorf("hash tree_%d in %s: %w", shardID, path, err)
+			}
+			summary.shardHashes[shardID] = sum
+		}
+	}
+	return summary, nil
+}
+
+// hashShard hashes every (version, sequence, bytes) row of tree_<shardID> in primary key order.
+// Each row is length-prefixed so that adjacent rows cannot run into each other.
+func hashShard(db *sql.DB, shardID int64) (string, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT version, sequence, bytes FROM tree_%d ORDER BY version, sequence", shardID))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	h := sha256.New()
+	var buf [8]byte
+	for rows.Next() {
+		var (
+			version, sequence int64
+			bz                []byte
+		)
+		if err := rows.Scan(&version, &sequence, &bz); err != nil {
+			return "", err
+		}
+		for _, v := range []uint64{uint64(version), uint64(sequence), uint64(len(bz))} {
+			binary.BigEndian.PutUint64(buf[:], v)
+			h.Write(buf[:])
+		}
+		h.Write(bz)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,66 @@
+package v2
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func TestCompareDestinations(t *testing.T) {
+	pathA, pathB := t.TempDir(), t.TempDir()
+	for _, path := range []string{pathA, pathB} {
+		createMigratedStore(t, path, "bank")
+		createMigratedStore(t, path, "staking")
+	}
+
+	out := captureStdout(t, func() {
+		require.NoError(t, compareDestinations(pathA, pathB, true))
+	})
+	require.Contains(t, out, "PASS: 2 stores identical")
+
+	// same row count, different node bytes: only the content hash notices
+	db, err := sql.Open("sqlite", filepath.Join(pathB, "staking", "tree.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("UPDATE tree_1 SET bytes = x'02'")
+	require.NoError(t, err)
+
+	captureStdout(t, func() {
+		require.NoError(t, compareDestinations(pathA, pathB, false))
+	})
+
+	var compareErr error
+	out = captureStdout(t, func() {
+		compareErr = compareDestinations(pathA, pathB, true)
+	})
+	require.ErrorContains(t, compareErr, "1 stores differ")
+	require.Contains(t, out, "bank: identical")
+	require.Contains(t, out, "staking: DIFFERS: tree_1 content hash")
+
+	// a newer root is reported before the shard contents
+	_, err = db.Exec("INSERT INTO root VALUES (2, 2, 1, x'01')")
+	require.NoError(t, err)
+	out = captureStdout(t, func() {
+		compareErr = compareDestinations(pathA, pathB, true)
+	})
+	require.Error(t, compareErr)
+	require.Contains(t, out, "staking: DIFFERS: latest root version 1 vs 2")
+	require.Contains(t, out, "FAIL: 1 of 2 stores differ")
+}
+
+func TestCompareDestinationsMissingStore(t *testing.T) {
+	pathA, pathB := t.TempDir(), t.TempDir()
+	createMigratedStore(t, pathA, "bank")
+	createMigratedStore(t, pathA, "staking")
+	createMigratedStore(t, pathB, "bank")
+
+	var err error
+	out := captureStdout(t, func() {
+		err = compareDestinations(pathA, pathB, false)
+	})
+	require.Error(t, err)
+	require.Contains(t, out, "staking: DIFFERS: missing from "+pathB)
+}
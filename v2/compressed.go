@@ -0,0 +1,83 @@
+package v2
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Suffixes of compressed source databases, e.g. tree.sqlite.zst, in the order they are looked for.
+const (
+	zstdSuffix = ".zst"
+	gzipSuffix = ".gz"
+)
+
+// resolveSource returns path if it exists, otherwise its .zst or .gz variant if one exists,
+// otherwise path unchanged.
+func resolveSource(path string) string {
+	for _, candidate := range []string{path, path + zstdSuffix, path + gzipSuffix} {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return path
+}
+
+// decompressSource returns a path SQLite can open for the source database at path. A .zst or
+// .gz file is decompressed into a temporary file in dir, created with dirPerm if missing, which
+// cleanup removes; other paths are returned as they are, with a no-op cleanup.
+func decompressSource(path, dir string, dirPerm os.FileMode, lg *migrationLogger) (string, func(), error) {
+	var open func(io.Reader) (io.ReadCloser, error)
+	switch {
+	case strings.HasSuffix(path, zstdSuffix):
+		open = func(r io.Reader) (io.ReadCloser, error) {
+			dec, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return dec.IOReadCloser(), nil
+		}
+	case strings.HasSuffix(path, gzipSuffix):
+		open = func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }
+	default:
+		return path, func() {}, nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("open compressed source: %w", err)
+	}
+	defer in.Close()
+	r, err := open(in)
+	if err != nil {
+		return "", nil, fmt.Errorf("read compressed source %s: %w", path, err)
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return "", nil, err
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), zstdSuffix), gzipSuffix)
+	out, err := os.CreateTemp(dir, base+".*.decompressed")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { removeSQLiteFiles(out.Name()) }
+
+	lg.Event("decompress", logFields{"source": path, "path": out.Name()}, "decompressing %s to %s", path, out.Name())
+	n, err := io.Copy(out, r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("decompress %s: %w", path, err)
+	}
+	lg.Event("decompressed", logFields{"source": path, "bytes": n}, "decompressed %s (%s)", path, formatBytes(n))
+	return out.Name(), cleanup, nil
+}
@@ -0,0 +1,80 @@
+package v2
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+// compressFile replaces path by path+suffix compressed with zstd or gzip.
+func compressFile(t *testing.T, path, suffix string) {
+	in, err := os.Open(path)
+	require.NoError(t, err)
+	defer in.Close()
+	out, err := os.Create(path + suffix)
+	require.NoError(t, err)
+	defer out.Close()
+
+	var w io.WriteCloser
+	switch suffix {
+	case zstdSuffix:
+		w, err = zstd.NewWriter(out)
+		require.NoError(t, err)
+	case gzipSuffix:
+		w = gzip.NewWriter(out)
+	}
+	_, err = io.Copy(w, in)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, os.Remove(path))
+}
+
+func TestMigrateCompressedSources(t *testing.T) {
+	for _, suffix := range []string{zstdSuffix, gzipSuffix} {
+		t.Run(suffix, func(t *testing.T) {
+			baseOld, baseNew := t.TempDir(), t.TempDir()
+			createV2Store(t, filepath.Join(baseOld, "bank"), 1, 2, 500001)
+			compressFile(t, filepath.Join(baseOld, "bank", "tree.sqlite"), suffix)
+			compressFile(t, filepath.Join(baseOld, "bank", "changelog.sqlite"), suffix)
+
+			res, err := migrateStore("bank", baseOld, baseNew, migrateOptions{sourceReadonly: true})
+			require.NoError(t, err)
+			require.Equal(t, int64(3), res.treeRows)
+			require.Equal(t, int64(3), res.changelogRows)
+
+			db, err := sql.Open("sqlite", filepath.Join(baseNew, "bank", "tree.sqlite"))
+			require.NoError(t, err)
+			defer db.Close()
+			var rows int
+			require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM tree_2").Scan(&rows))
+			require.Equal(t, 1, rows)
+
+			// the decompressed copies are gone
+			entries, err := os.ReadDir(filepath.Join(baseNew, "bank"))
+			require.NoError(t, err)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			require.ElementsMatch(t, []string{"tree.sqlite", "changelog.sqlite"}, names)
+		})
+	}
+}
+
+func TestDecompressSourceCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tree.sqlite.gz")
+	require.NoError(t, os.WriteFile(path, []byte("not gzip"), 0o644))
+
+	_, err := migrateTree(path, filepath.Join(dir, "new", "tree.sqlite"), migrateOptions{})
+	require.ErrorContains(t, err, "read compressed source")
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
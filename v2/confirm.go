@@ -0,0 +1,83 @@
+package v2
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// errNotConfirmed is returned when the operator declines a destructive step.
+var errNotConfirmed = errors.New("not confirmed; pass --assume-yes to skip the prompt")
+
+// confirmReader wraps in for confirm, or returns nil for a nil in. A command asking several
+// times must read every answer through the one reader it returns: a reader per prompt would
+// buffer the answers to later prompts from piped input and lose them.
+func confirmReader(in io.Reader) *bufio.Reader {
+	if in == nil {
+		return nil
+	}
+	if r, ok := in.(*bufio.Reader); ok {
+		return r
+	}
+	return bufio.NewReader(in)
+}
+
+// confirm asks a yes/no question on out and reads the answer line from in. Anything but y/yes is a no.
+func confirm(in *bufio.Reader, out io.Writer, prompt string) bool {
+	fmt.Fprintf(out, "%s [y/N]: ", prompt)
+	answer, err := in.ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// confirmDeletion lists the databases at paths with their sizes on out, including any WAL or
+// journal, and asks before they are deleted. A nil in, as with --assume-yes or the API, deletes
+// without asking; no paths asks nothing. A declined prompt fails with errNotConfirmed.
+func confirmDeletion(in *bufio.Reader, out io.Writer, paths []string) error {
+	if in == nil || len(paths) == 0 {
+		return nil
+	}
+	fmt.Fprintf(out, "The following %d files will be deleted:\n", len(paths))
+	var total int64
+	for _, path := range paths {
+		size := sqliteFilesSize(path)
+		total += size
+		fmt.Fprintf(out, "  %s (%s)\n", path, formatBytes(size))
+	}
+	if !confirm(in, out, fmt.Sprintf("Delete %s?", formatBytes(total))) {
+		return errNotConfirmed
+	}
+	return nil
+}
+
+// sqliteFilesSize returns the bytes held by a database file and its journal, WAL and
+// shared-memory files, the files removeSQLiteFiles deletes.
+func sqliteFilesSize(path string) int64 {
+	var size int64
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		if fi, err := os.Stat(path + suffix); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size
+}
+
+// existingFiles returns the paths of paths that exist.
+func existingFiles(paths ...string) []string {
+	var found []string
+	for _, path := range paths {
+		if path != "" && fileExists(path) {
+			found = append(found, path)
+		}
+	}
+	return found
+}
@@ -0,0 +1,228 @@
+package v2
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// corruptRowReport records the rows skipped under --skip-corrupt into a CSV
+// sidecar next to the destination database, so they can be inspected later.
+type corruptRowReport struct {
+	path  string
+	lg    *migrationLogger
+	file  *os.File
+	w     *csv.Writer
+	count int64
+}
+
+// skippedSuffix names the corruptRowReport of a destination database.
+const skippedSuffix = ".skipped"
+
+func newCorruptRowReport(newPath string, lg *migrationLogger) *corruptRowReport {
+	path := newPath + skippedSuffix
+	// drop a report left behind by a previous run, the file is only created once a row is skipped
+	os.Remove(path)
+	return &corruptRowReport{path: path, lg: lg}
+}
+
+func (r *corruptRowReport) add(table string, version, sequence sql.NullInt64, cause error) error {
+	r.lg.Event("corrupt_row", logFields{"table": table, "version": nullInt64String(version), "sequence": nullInt64String(sequence), "error": cause},
+		"skipping corrupt row in %s (version=%s, sequence=%s): %v", table, nullInt64String(version), nullInt64String(sequence), cause)
+
+	if r.file == nil {
+		f, err := os.Create(r.path)
+		if err != nil {
+			return fmt.Errorf("create skipped rows report %s: %w", r.path, err)
+		}
+		r.file = f
+		r.w = csv.NewWriter(f)
+		if err := r.w.Write([]string{"table", "version", "sequence", "error"}); err != nil {
+			return err
+		}
+	}
+	r.count++
+	return r.w.Write([]string{table, nullInt64String(version), nullInt64String(sequence), cause.Error()})
+}
+
+// close flushes and closes the report file, if a row was skipped at all. A nil report or a
+// second call does nothing, so callers can defer close for early returns and still check the
+// error of the close that completes the report.
+func (r *corruptRowReport) close() error {
+	if r == nil || r.file == nil {
+		return nil
+	}
+	f := r.file
+	r.file = nil
+	r.w.Flush()
+	if err := r.w.Error(); err != nil {
+		f.Close()
+		return fmt.Errorf("write skipped rows report %s: %w", r.path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close skipped rows report %s: %w", r.path, err)
+	}
+	r.lg.Event("corrupt_rows_skipped", logFields{"rows": r.count, "report": r.path},
+		"skipped %d corrupt rows, report written to %s", r.count, r.path)
+	return nil
+}
+
+func nullInt64String(v sql.NullInt64) string {
+	if !v.Valid {
+		return "NULL"
+	}
+	return strconv.FormatInt(v.Int64, 10)
+}
+
+func validInt64(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: true}
+}
+
+// rescanVersionSequence re-reads the leading (version, sequence) columns of a row that
+// failed to scan, so the report can still point at it.
+func rescanVersionSequence(rows *sql.Rows) (version, sequence sql.NullInt64) {
+	cols, err := rows.Columns()
+	if err != nil || len(cols) < 2 {
+		return
+	}
+	dest := make([]any, len(cols))
+	dest[0], dest[1] = &version, &sequence
+	for i := 2; i < len(dest); i++ {
+		dest[i] = new(any)
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return sql.NullInt64{}, sql.NullInt64{}
+	}
+	return version, sequence
+}
+
+// coerceNullLeafColumns fills in defaults for the NULL columns of a changelog leaf row:
+// a NULL sequence becomes 0 and a NULL key the empty key. A NULL value is copied as is.
+// It returns the names of the NULL columns so the caller can log the row.
+func coerceNullLeafColumns(sequence *sql.NullInt64, key *[]byte, value []byte) []string {
+	var coerced []string
+	if !sequence.Valid {
+		*sequence = validInt64(0)
+		coerced = append(coerced, "sequence")
+	}
+	if *key == nil {
+		*key = []byte{}
+		coerced = append(coerced, "key")
+	}
+	if value == nil {
+		coerced = append(coerced, "bytes")
+	}
+	return coerced
+}
+
+// reportNullVersionRows records the source tree rows without a version. They can't be placed
+// in any shard and are dropped by the version-ranged copy regardless of --skip-corrupt.
+func reportNullVersionRows(oldDB *sql.DB, src treeSource, report *corruptRowReport) error {
+	for _, table := range src.tables {
+		if err := reportNullVersionRowsOf(oldDB, table, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportNullVersionRowsOf records the rows without a version of one source table.
+func reportNullVersionRowsOf(oldDB *sql.DB, table string, report *corruptRowReport) error {
+	rows, err := oldDB.Query("SELECT sequence FROM " + table + " WHERE version IS NULL")
+	if err != nil {
+		return fmt.Errorf("query rows with NULL version in %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sequence sql.NullInt64
+		if err := rows.Scan(&sequence); err != nil {
+			sequence = sql.NullInt64{}
+		}
+		if err := report.add(table, sql.NullInt64{}, sequence, errors.New("NULL version")); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// copyShardRowsSkippingCorrupt copies one shard's version range from src row by row.
+// Like the window-function copy it keeps the first row (by rowid) of every
+// (version, sequence), but rows that can't be read or inserted are reported and skipped.
+// Each report names the source table the row was read from. It returns the number of rows copied.
+func copyShardRowsSkippingCorrupt(oldDB, newDB *sql.DB, src treeSource, tableName string, startVersion, endVersion int64, report *corruptRowReport) (int64, error) {
+	rows, err := oldDB.Query(`SELECT version, sequence, bytes, orphaned, rowid FROM `+src.from("")+`
+		WHERE version >= ? AND version <= ? ORDER BY version, sequence, rowid`, startVersion, endVersion)
+	if err != nil {
+		return 0, fmt.Errorf("read old %s: %w", src, err)
+	}
+	defer rows.Close()
+
+	tx, err := newDB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	insertStmt, err := tx.Prepare(fmt.Sprintf(`INSERT INTO %s(version, sequence, bytes, orphaned) VALUES (?, ?, ?, ?)`, tableName))
+	if err != nil {
+		return 0, err
+	}
+	defer insertStmt.Close()
+
+	var (
+		lastVersion, lastSequence int64
+		hasLast                   bool
+		copied                    int64
+	)
+	for rows.Next() {
+		var (
+			version, sequence, rowid sql.NullInt64
+			bz                       []byte
+			orphaned                 sql.NullBool
+		)
+		if err := rows.Scan(&version, &sequence, &bz, &orphaned, &rowid); err != nil {
+			// the scan stops at the bad column, so the rowid and with it the table may be unknown
+			if err := report.add(src.tableOf(rowid), version, sequence, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		table := src.tableOf(rowid)
+		if !sequence.Valid {
+			if err := report.add(table, version, sequence, errors.New("NULL sequence")); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if bz == nil {
+			if err := report.add(table, version, sequence, errors.New("NULL bytes")); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if hasLast && version.Int64 == lastVersion && sequence.Int64 == lastSequence {
+			continue
+		}
+
+		if _, err := insertStmt.Exec(version.Int64, sequence.Int64, bz, orphaned); err != nil {
+			if err := report.add(table, version, sequence, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		lastVersion, lastSequence, hasLast = version.Int64, sequence.Int64, true
+		copied++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("read old %s: %w", src, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return copied, nil
+}
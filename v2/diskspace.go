@@ -0,0 +1,133 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// compressedSizeFactor is how much larger than its .zst/.gz file a decompressed source is
+// assumed to be when estimating disk space.
+const compressedSizeFactor = 4
+
+// freeSpace returns the bytes available to unprivileged users on the filesystem holding dir.
+// Tests replace it.
+var freeSpace = filesystemFreeSpace
+
+// errFreeSpaceUnsupported is returned by filesystemFreeSpace where it can't be determined.
+var errFreeSpaceUnsupported = errors.New("free disk space can't be determined on this platform")
+
+// estimateDestinationSize estimates the bytes migrating the source files at paths writes next
+// to the destination: about the size of each source, plus for a compressed source its
+// temporary decompressed copy, with 10% headroom for indexes and SQLite page slack.
+func estimateDestinationSize(paths []string) (int64, error) {
+	var total int64
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			// reported by the migration itself
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		size := fi.Size()
+		if strings.HasSuffix(path, zstdSuffix) || strings.HasSuffix(path, gzipSuffix) {
+			size *= 2 * compressedSizeFactor
+		}
+		total += size
+	}
+	return total + total/10, nil
+}
+
+// storeSourcePaths returns the source files migrateStore reads for store under baseOld in
+// the phases opts selects, counting a combined source once.
+func storeSourcePaths(baseOld, store string, opts migrateOptions) []string {
+	treePath, changelogPath, combined := storeSources(filepath.Join(baseOld, store), opts)
+	switch {
+	case combined || opts.onlyTree:
+		return []string{treePath}
+	case opts.onlyChangelog:
+		return []string{changelogPath}
+	}
+	return []string{treePath, changelogPath}
+}
+
+// checkDiskSpace fails with ErrInsufficientSpace if the filesystem dest is (or will be) created
+// on has less free space than migrating sources needs. Without opts.skipSpaceCheck it runs before
+// any store is touched, so a full disk fails the run up front instead of hours in.
+func checkDiskSpace(dest string, sources []string, opts migrateOptions) error {
+	if opts.skipSpaceCheck {
+		return nil
+	}
+	need, err := estimateDestinationSize(sources)
+	if err != nil {
+		return fmt.Errorf("estimate destination size: %w", err)
+	}
+	dir := existingAncestor(dest)
+	avail, err := freeSpace(dir)
+	if errors.Is(err, errFreeSpaceUnsupported) {
+		opts.logger.Event("space_check_skipped", logFields{"path": dir}, "WARNING: %v, skipping the disk space check", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("check free space of %s: %w", dir, err)
+	}
+	opts.logger.Event("space_check", logFields{"path": dir, "needed": need, "available": avail},
+		"destination %s needs about %s, %s available", dir, formatBytes(need), formatBytes(avail))
+	if need > avail {
+		return fmt.Errorf("%w: migrating into %s needs about %s but only %s is free; free up space or pass --skip-space-check",
+			ErrInsufficientSpace, dir, formatBytes(need), formatBytes(avail))
+	}
+	return nil
+}
+
+// existingAncestor returns path or its closest parent that exists.
+func existingAncestor(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// sqliteFull is the SQLite result code of a write that found the disk full.
+const sqliteFull = 13
+
+// isDiskFull reports whether err comes from the destination filesystem running out of space,
+// as SQLITE_FULL or as the OS's ENOSPC. The messages are matched too, for errors that lost
+// their code on the way.
+func isDiskFull(err error) bool {
+	var coded interface{ Code() int }
+	if errors.As(err, &coded) && coded.Code()&0xff == sqliteFull {
+		return true
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "database or disk is full") || strings.Contains(msg, "no space left on device")
+}
+
+// diskFullError wraps err with ErrDiskFull, naming the destination newPath and the space free
+// on its filesystem, if the destination ran out of space; other errors are returned unchanged.
+// It is called once the partial output is removed, so the free space is what a rerun starts with.
+func diskFullError(err error, newPath string) error {
+	if err == nil || !isDiskFull(err) {
+		return err
+	}
+	free := "an unknown amount"
+	if avail, ferr := freeSpace(existingAncestor(filepath.Dir(newPath))); ferr == nil {
+		free = formatBytes(avail)
+	}
+	return fmt.Errorf("%w while writing %s; the partial output was removed and %s is free now, free up more space and rerun: %w",
+		ErrDiskFull, newPath, free, err)
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package v2
+
+func filesystemFreeSpace(dir string) (int64, error) {
+	return 0, errFreeSpaceUnsupported
+}
@@ -0,0 +1,98 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setFreeSpace makes freeSpace report avail bytes for the rest of the test.
+func setFreeSpace(t *testing.T, avail int64) {
+	orig := freeSpace
+	freeSpace = func(string) (int64, error) { return avail, nil }
+	t.Cleanup(func() { freeSpace = orig })
+}
+
+func TestEstimateDestinationSize(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tree.sqlite"), make([]byte, 1000), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "changelog.sqlite.zst"), make([]byte, 100), 0o644))
+
+	size, err := estimateDestinationSize([]string{
+		filepath.Join(dir, "tree.sqlite"),
+		filepath.Join(dir, "changelog.sqlite.zst"),
+		filepath.Join(dir, "missing.sqlite"),
+	})
+	require.NoError(t, err)
+	// 1000 + 100 decompressed twice (temporary copy and destination), plus 10%
+	require.Equal(t, int64((1000+800)*11/10), size)
+}
+
+func TestMigrateChecksDiskSpace(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2)
+
+	setFreeSpace(t, 1024)
+	err := migrate(iavl2Path, nil, false, migrateOptions{})
+	require.ErrorIs(t, err, ErrInsufficientSpace)
+	require.ErrorContains(t, err, "--skip-space-check")
+	// failed before the source was moved aside
+	require.DirExists(t, filepath.Join(iavl2Path, "bank"))
+	require.NoDirExists(t, iavl2Path+".bak")
+
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{skipSpaceCheck: true}))
+}
+
+func TestMigrateFilesChecksDiskSpace(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 1)
+	newDir := filepath.Join(t.TempDir(), "not", "yet", "created")
+
+	setFreeSpace(t, 1024)
+	err := migrateFiles(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite"), "", "", migrateOptions{})
+	require.ErrorIs(t, err, ErrInsufficientSpace)
+	require.NoFileExists(t, filepath.Join(newDir, "tree.sqlite"))
+
+	setFreeSpace(t, 1<<40)
+	require.NoError(t, migrateFiles(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite"), "", "", migrateOptions{}))
+}
+
+func TestExistingAncestor(t *testing.T) {
+	dir := t.TempDir()
+	require.Equal(t, dir, existingAncestor(filepath.Join(dir, "a", "b")))
+	require.Equal(t, dir, existingAncestor(dir))
+}
+
+func TestDiskFullError(t *testing.T) {
+	setFreeSpace(t, 1<<20)
+	newPath := filepath.Join(t.TempDir(), "bank", "tree.sqlite")
+
+	// a database capped at a few pages fails its writes with SQLITE_FULL like a full disk
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "full.sqlite")+"?_pragma=max_page_count(2)")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE a (x BLOB); CREATE TABLE b (x BLOB); INSERT INTO b VALUES (randomblob(100000))`)
+	require.Error(t, err)
+
+	for _, full := range []error{
+		fmt.Errorf("migrate shard tree_1: %w", err),
+		codedError{sqliteFull},
+		&os.PathError{Op: "write", Path: newPath, Err: syscall.ENOSPC},
+	} {
+		wrapped := diskFullError(full, newPath)
+		require.ErrorIs(t, wrapped, ErrDiskFull, "%v", full)
+		require.ErrorContains(t, wrapped, newPath)
+		require.ErrorContains(t, wrapped, "1.0 MiB is free now")
+		require.Equal(t, ExitInsufficientSpace, ExitCode(wrapped))
+		require.False(t, isTransientSQLiteError(wrapped))
+	}
+
+	require.NoError(t, diskFullError(nil, newPath))
+	other := codedError{sqliteIOErr}
+	require.Equal(t, error(other), diskFullError(other, newPath))
+}
@@ -0,0 +1,240 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+func DowngradeCommand() *cobra.Command {
+	var (
+		dbPath    string
+		output    string
+		overwrite bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "downgrade",
+		Short: "write the stores of a migrated iavl2/ directory back in the v2 layout, e.g. to roll back a failed upgrade",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return downgrade(os.Stdout, dbPath, output, overwrite)
+		},
+	}
+
+	cmd.Flags().StringVar(&dbPath, "db-path", "", "Path to the migrated iavl2/ directory")
+	cmd.Flags().StringVar(&output, "output", "", "Directory to write the v2-layout stores to, one directory per store")
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "Replace databases that already exist under --output")
+	for _, name := range []string{"db-path", "output"} {
+		if err := cmd.MarkFlagRequired(name); err != nil {
+			panic(err)
+		}
+	}
+
+	return cmd
+}
+
+// v2 tables the downgrade writes, in the layout the migration reads. Node and leaf bytes are
+// copied unchanged, as the migration copied them.
+var (
+	v2TreeSchema = []string{
+		`CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool);`,
+		`CREATE UNIQUE INDEX tree_idx ON tree_1 (version, sequence);`,
+		`CREATE TABLE orphan (version int, sequence int, at int);`,
+		`CREATE TABLE root (version int, node_version int, node_sequence int, bytes blob, PRIMARY KEY (version));`,
+	}
+	v2ChangelogSchema = []string{
+		`CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);`,
+		`CREATE UNIQUE INDEX leaf_idx ON leaf (version, sequence);`,
+		`CREATE TABLE leaf_orphan (version int, sequence int, at int);`,
+	}
+)
+
+// downgradeResult counts the rows downgradeStore wrote for one store.
+type downgradeResult struct {
+	treeRows, rootRows, orphanRows int64
+	leafRows, leafOrphanRows       int64
+}
+
+// downgrade writes every store under dbPath, a migrated iavl2/ directory, to output in the v2
+// layout: the tree_N shards are merged back into tree_1, branch_orphan becomes orphan, and the
+// changelog's leaf table gets its key column back. A key_hash can't be reversed, so each
+// changelog must have been migrated with --keep-raw-key; this is checked for every store
+// before anything is written. What the migration left out (--key-prefix, --trim-orphans,
+// --skip-corrupt, unknown tables) is not recovered.
+func downgrade(w io.Writer, dbPath, output string, overwrite bool) error {
+	if sameDir(dbPath, output) {
+		return fmt.Errorf("--output %s is the migrated directory itself", output)
+	}
+	stores, _, err := getStoreKeys(dbPath, nil, nil)
+	if err != nil {
+		return err
+	}
+	if len(stores) == 0 {
+		return fmt.Errorf("no stores found under %s", dbPath)
+	}
+	for _, store := range stores {
+		if err := checkDowngradable(filepath.Join(dbPath, store)); err != nil {
+			return err
+		}
+	}
+	if err := checkNoRunningNode(filepath.Dir(filepath.Clean(output))); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tTREE ROWS\tROOTS\tORPHANS\tLEAVES\tLEAF ORPHANS")
+	for _, store := range stores {
+		res, err := downgradeStore(filepath.Join(dbPath, store), filepath.Join(output, store), overwrite)
+		if err != nil {
+			tw.Flush()
+			return fmt.Errorf("downgrade store %s: %w", store, err)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", store, res.treeRows, res.rootRows, res.orphanRows, res.leafRows, res.leafOrphanRows)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "downgraded %d stores from %s to %s\n", len(stores), dbPath, output)
+	return nil
+}
+
+// checkDowngradable fails unless the migrated store directory dir holds a finished migration
+// whose changelog, if any, kept the raw leaf keys.
+func checkDowngradable(dir string) error {
+	if err := checkMergeableStore(dir); err != nil {
+		return err
+	}
+	changelogPath := filepath.Join(dir, "changelog.sqlite")
+	if !fileExists(changelogPath) {
+		return nil
+	}
+	db, err := sql.Open("sqlite", readonlyURI(changelogPath))
+	if err != nil {
+		return fmt.Errorf("open db %s: %w", changelogPath, err)
+	}
+	defer db.Close()
+	if err := requireTables(db, changelogPath, "leaf"); err != nil {
+		return err
+	}
+	ok, err := columnExists(db, "leaf", "key")
+	if err != nil {
+		return fmt.Errorf("%s: %w", changelogPath, err)
+	}
+	if !ok {
+		return fmt.Errorf("%s has no key column: it was migrated without --keep-raw-key and key_hash can't be turned back into the key", changelogPath)
+	}
+	return nil
+}
+
+// downgradeStore writes the tree.sqlite and changelog.sqlite of the migrated store directory
+// src, whichever exist, to dst. Each database is built under a temporary name and renamed.
+func downgradeStore(src, dst string, overwrite bool) (downgradeResult, error) {
+	var res downgradeResult
+	if treePath := filepath.Join(src, "tree.sqlite"); fileExists(treePath) {
+		shardIDs, err := listMigratedShards(treePath)
+		if err != nil {
+			return res, err
+		}
+		stmts := []string{
+			`INSERT INTO root(version, node_version, node_sequence, bytes)
+			  SELECT version, node_version, node_sequence, bytes FROM old.root;`,
+			`INSERT INTO orphan(version, sequence, at) SELECT version, sequence, at FROM old.branch_orphan;`,
+		}
+		for _, shardID := range shardIDs {
+			stmts = append(stmts, fmt.Sprintf(`INSERT INTO tree_1(version, sequence, bytes, orphaned)
+			  SELECT version, sequence, bytes, orphaned FROM old.tree_%d;`, shardID))
+		}
+		rows, err := writeAtomically(filepath.Join(dst, "tree.sqlite"), overwrite, defaultDirMode, func(tmpPath string) ([]int64, error) {
+			return downgradeDB(treePath, tmpPath, v2TreeSchema, stmts)
+		})
+		if err != nil {
+			return res, err
+		}
+		res.rootRows, res.orphanRows = rows[0], rows[1]
+		for _, n := range rows[2:] {
+			res.treeRows += n
+		}
+	}
+	if changelogPath := filepath.Join(src, "changelog.sqlite"); fileExists(changelogPath) {
+		stmts := []string{
+			`INSERT INTO leaf(version, sequence, key, bytes, orphaned) SELECT version, sequence, key, bytes, orphaned FROM old.leaf;`,
+			`INSERT INTO leaf_orphan(version, sequence, at) SELECT version, sequence, at FROM old.leaf_orphan;`,
+		}
+		rows, err := writeAtomically(filepath.Join(dst, "changelog.sqlite"), overwrite, defaultDirMode, func(tmpPath string) ([]int64, error) {
+			return downgradeDB(changelogPath, tmpPath, v2ChangelogSchema, stmts)
+		})
+		if err != nil {
+			return res, err
+		}
+		res.leafRows, res.leafOrphanRows = rows[0], rows[1]
+	}
+	return res, nil
+}
+
+// listMigratedShards lists the tree_N shards of the migrated tree database at path.
+func listMigratedShards(path string) ([]int64, error) {
+	db, err := sql.Open("sqlite", readonlyURI(path))
+	if err != nil {
+		return nil, fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+	shardIDs, err := listShardIDs(db)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return shardIDs, nil
+}
+
+// downgradeDB creates the schema in a new database at newPath and runs stmts, which read the
+// migrated database at oldPath attached read-only as "old", in one transaction. It returns the
+// rows each statement inserted.
+func downgradeDB(oldPath, newPath string, schema, stmts []string) ([]int64, error) {
+	db, err := sql.Open("sqlite", newPath)
+	if err != nil {
+		return nil, fmt.Errorf("open db %s: %w", newPath, err)
+	}
+	defer db.Close()
+
+	// ATTACH is per connection, so pin one
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open db %s: %w", newPath, err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, attachSourceStmt(oldPath, true)); err != nil {
+		return nil, fmt.Errorf("attach %s: %w", oldPath, err)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for _, stmt := range schema {
+		if _, err := tx.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("exec %s: %w", stmt, err)
+		}
+	}
+	rows := make([]int64, 0, len(stmts))
+	for _, stmt := range stmts {
+		res, err := tx.Exec(stmt)
+		if err != nil {
+			return nil, fmt.Errorf("exec [%s]: %w", stmt, classifySQLiteError(err))
+		}
+		n, _ := res.RowsAffected()
+		rows = append(rows, n)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `DETACH DATABASE old;`); err != nil {
+		return nil, fmt.Errorf("detach %s: %w", oldPath, err)
+	}
+	return rows, nil
+}
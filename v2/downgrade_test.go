@@ -0,0 +1,83 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+// dumpRows returns the rows query yields from the database at path, one formatted line each.
+func dumpRows(t *testing.T, path, query string) []string {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+	rows, err := db.Query(query)
+	require.NoError(t, err)
+	defer rows.Close()
+	cols, err := rows.Columns()
+	require.NoError(t, err)
+	var out []string
+	for rows.Next() {
+		values := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		require.NoError(t, rows.Scan(ptrs...))
+		out = append(out, fmt.Sprint(values...))
+	}
+	require.NoError(t, rows.Err())
+	return out
+}
+
+func TestDowngradeRoundTrip(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 1, 2, 500001)
+	execV2(t, oldDir, "tree.sqlite", "INSERT INTO orphan VALUES (1, 1, 2)")
+	execV2(t, oldDir, "changelog.sqlite", "INSERT INTO leaf_orphan VALUES (1, 1, 2)")
+	dbPath := t.TempDir()
+	require.NoError(t, migrateV2Store(t, oldDir, filepath.Join(dbPath, "bank"), migrateOptions{keepRawKey: true}))
+
+	output := filepath.Join(t.TempDir(), "iavl2")
+	var buf bytes.Buffer
+	require.NoError(t, downgrade(&buf, dbPath, output, false))
+	require.Contains(t, buf.String(), "downgraded 1 stores")
+
+	newDir := filepath.Join(output, "bank")
+	requireTableNames(t, filepath.Join(newDir, "tree.sqlite"), "orphan", "root", "tree_1")
+	for _, q := range []struct{ db, query string }{
+		{"tree.sqlite", "SELECT version, sequence, bytes FROM tree_1 ORDER BY version, sequence"},
+		{"tree.sqlite", "SELECT version, node_version, node_sequence, bytes FROM root ORDER BY version"},
+		{"tree.sqlite", "SELECT version, sequence, at FROM orphan ORDER BY version, sequence"},
+		{"changelog.sqlite", "SELECT version, sequence, key, bytes FROM leaf ORDER BY version, sequence"},
+		{"changelog.sqlite", "SELECT version, sequence, at FROM leaf_orphan ORDER BY version, sequence"},
+	} {
+		want := dumpRows(t, filepath.Join(oldDir, q.db), q.query)
+		require.NotEmpty(t, want, q.query)
+		require.Equal(t, want, dumpRows(t, filepath.Join(newDir, q.db), q.query), q.query)
+	}
+
+	// the downgraded store migrates again
+	require.NoError(t, migrateV2Store(t, newDir, filepath.Join(t.TempDir(), "bank"), migrateOptions{}))
+
+	// an existing output is refused unless overwritten
+	require.ErrorContains(t, downgrade(&buf, dbPath, output, false), "already exists")
+	require.NoError(t, downgrade(&buf, dbPath, output, true))
+}
+
+func TestDowngradeRequiresRawKeys(t *testing.T) {
+	dbPath := t.TempDir()
+	createMigratedStore(t, dbPath, "bank")
+
+	output := t.TempDir()
+	var buf bytes.Buffer
+	err := downgrade(&buf, dbPath, output, false)
+	require.ErrorContains(t, err, "migrated without --keep-raw-key")
+	require.NoDirExists(t, filepath.Join(output, "bank"))
+}
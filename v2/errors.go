@@ -0,0 +1,79 @@
+package v2
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors wrapped into the errors returned by the migration and check functions,
+// so callers can tell failure causes apart with errors.Is.
+var (
+	// ErrSourceNotFound means a v2 source database or store directory doesn't exist.
+	ErrSourceNotFound = errors.New("not found")
+	// ErrSchemaMismatch means a source database lacks a table the migration reads.
+	ErrSchemaMismatch = errors.New("schema mismatch")
+	// ErrSourceIsV3 means a source database is already laid out like iavl v3, e.g. because
+	// the directory was migrated before.
+	ErrSourceIsV3 = errors.New("source is not iavl v2")
+	// ErrCorruptSource means --verify-source found a source database failing SQLite's
+	// quick_check, or a source tree with missing or duplicate root versions.
+	ErrCorruptSource = errors.New("corrupt source")
+	// ErrConstraintViolation means the destination rejected a row, e.g. a duplicate
+	// (version, sequence) pair in the source.
+	ErrConstraintViolation = errors.New("constraint violation")
+	// ErrHashMismatch means a migrated tree's root hash or version differs from the expected one.
+	ErrHashMismatch = errors.New("hash mismatch")
+	// ErrIncompatibleRoot means a migrated root's bytes don't decode as a v3 node.
+	ErrIncompatibleRoot = errors.New("incompatible root encoding")
+	// ErrInsufficientSpace means the destination filesystem has less free space than the
+	// migration is estimated to need.
+	ErrInsufficientSpace = errors.New("insufficient disk space")
+	// ErrDiskFull means the destination filesystem filled up while a database was being
+	// written, e.g. because the space estimate was skipped or something else used the disk.
+	ErrDiskFull = errors.New("disk full")
+	// ErrOpenFilesLimit means the open file limit is too low for even one store to migrate
+	// with the shard workers asked for.
+	ErrOpenFilesLimit = errors.New("open file limit too low")
+	// ErrVerificationFailed means a verify, compare or checksum command, or
+	// --verify-after-each-shard, found the migrated data differing from what it was checked against.
+	ErrVerificationFailed = errors.New("verification failed")
+	// ErrAppendConflict means --append found a source that doesn't continue the destination:
+	// a different or missing latest version, a gap, or rows the destination already has.
+	ErrAppendConflict = errors.New("append conflict")
+	// ErrNodeRunning means a database next to the destination is locked, most likely by a
+	// node still running on the data directory being migrated into.
+	ErrNodeRunning = errors.New("node running")
+	// ErrStoreTimeout means a store was cancelled for running longer than --store-timeout.
+	ErrStoreTimeout = errors.New("store timed out")
+	// ErrPartialFailure means some stores migrated and others failed.
+	ErrPartialFailure = errors.New("partial failure")
+)
+
+// sqliteConstraint is the primary SQLite result code of UNIQUE, PRIMARY KEY, NOT NULL
+// and CHECK failures.
+const sqliteConstraint = 19
+
+// classifySQLiteError wraps err with ErrConstraintViolation if it carries SQLITE_CONSTRAINT,
+// and returns it unchanged otherwise.
+func classifySQLiteError(err error) error {
+	var coded interface{ Code() int }
+	if errors.As(err, &coded) && coded.Code()&0xff == sqliteConstraint {
+		return fmt.Errorf("%w: %w", ErrConstraintViolation, err)
+	}
+	return err
+}
+
+// requireTables fails with ErrSchemaMismatch if the source at path lacks any of tables.
+func requireTables(db *sql.DB, path string, tables ...string) error {
+	for _, table := range tables {
+		ok, err := tableExists(db, table)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: source %s has no %s table", ErrSchemaMismatch, path, table)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package v2
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifySQLiteError(t *testing.T) {
+	// SQLITE_CONSTRAINT_PRIMARYKEY
+	require.ErrorIs(t, classifySQLiteError(codedError{sqliteConstraint | 6<<8}), ErrConstraintViolation)
+	require.NotErrorIs(t, classifySQLiteError(codedError{sqliteBusy}), ErrConstraintViolation)
+	require.NotErrorIs(t, classifySQLiteError(errors.New("boom")), ErrConstraintViolation)
+}
+
+func TestMigrationErrorKinds(t *testing.T) {
+	tempDir := t.TempDir()
+
+	_, err := migrateStore("bank", tempDir, t.TempDir(), migrateOptions{})
+	require.ErrorIs(t, err, ErrSourceNotFound)
+	require.ErrorContains(t, err, "tree.sqlite not found")
+
+	_, err = migrateTree(filepath.Join(tempDir, "missing.sqlite"), filepath.Join(tempDir, "new_missing.sqlite"), migrateOptions{})
+	require.ErrorIs(t, err, ErrSourceNotFound)
+	require.NoFileExists(t, filepath.Join(tempDir, "missing.sqlite"))
+
+	// a tree without a root table
+	oldTreePath := filepath.Join(tempDir, "old_tree.sqlite")
+	oldTree, err := sql.Open("sqlite", oldTreePath)
+	require.NoError(t, err)
+	defer oldTree.Close()
+	_, err = oldTree.Exec(`CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool);`)
+	require.NoError(t, err)
+	_, err = migrateTree(oldTreePath, filepath.Join(tempDir, "new_tree.sqlite"), migrateOptions{})
+	require.ErrorIs(t, err, ErrSchemaMismatch)
+	require.ErrorContains(t, err, "has no root table")
+
+	// a changelog with a duplicate (version, sequence)
+	oldChangelogPath := filepath.Join(tempDir, "old_changelog.sqlite")
+	oldChangelog, err := sql.Open("sqlite", oldChangelogPath)
+	require.NoError(t, err)
+	defer oldChangelog.Close()
+	_, err = oldChangelog.Exec(`
+		CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+		INSERT INTO leaf VALUES (1, 1, x'aa', x'01', false);
+		INSERT INTO leaf VALUES (1, 1, x'bb', x'02', false);
+	`)
+	require.NoError(t, err)
+	_, err = migrateChangelog(oldChangelogPath, filepath.Join(tempDir, "new_changelog.sqlite"), migrateOptions{})
+	require.ErrorIs(t, err, ErrConstraintViolation)
+
+	require.ErrorIs(t, checkExpectedHash([]byte{0x01}, "02"), ErrHashMismatch)
+}
@@ -0,0 +1,188 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+// The per-row and per-table byte counts estimateStore projects a destination with. They are
+// rough averages for the integer widths of typical versions and sequences, not exact sizes.
+const (
+	// estimateTreeIndexRowBytes is a v2 tree_idx entry; v3 shards are WITHOUT ROWID tables
+	// clustered by (version, sequence) and need no separate index.
+	estimateTreeIndexRowBytes = 16
+	// estimateKeyHashBytes is the fixed key_hash blob every v3 leaf stores instead of its key.
+	estimateKeyHashBytes = 32
+	// estimateLeafPKRowBytes is an entry of the index backing the v3 leaf's (key_hash, version)
+	// primary key, which v2 has no equivalent of.
+	estimateLeafPKRowBytes = estimateKeyHashBytes + 12
+	// estimateShardTableBytes is the minimum a tree_N shard table occupies, even when empty.
+	estimateShardTableBytes = 2 * 4096
+	// estimateKeySample is how many leaves the average key length is taken over.
+	estimateKeySample = 100_000
+)
+
+func EstimateCommand() *cobra.Command {
+	var (
+		dbV2       string
+		keepRawKey bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "estimate",
+		Short: "estimate how large the v3 destination of each store in a v2 iavl2/ directory will be",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			estimates, err := estimateSource(dbV2, keepRawKey)
+			if err != nil {
+				return err
+			}
+			printEstimates(os.Stdout, estimates)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&dbV2, "iavl2-path", "", "Path to the v2 iavl2/ directory")
+	if err := cmd.MarkFlagRequired("iavl2-path"); err != nil {
+		panic(err)
+	}
+	cmd.Flags().BoolVar(&keepRawKey, "keep-raw-key", false, "Estimate for a migration with --keep-raw-key, whose leaves keep their key next to the key_hash")
+
+	return cmd
+}
+
+// storeEstimate is the projected destination size of one store and what it is based on.
+type storeEstimate struct {
+	store                string
+	sourceBytes          int64
+	treeRows, leaves     int64
+	avgKeyLen            float64
+	shards               int
+	treeBytes, leafBytes int64
+}
+
+// total is the estimated destination size of the store, with the 10% headroom for page slack
+// estimateDestinationSize adds too.
+func (e storeEstimate) total() int64 {
+	n := e.treeBytes + e.leafBytes
+	return n + n/10
+}
+
+// estimateSource projects the v3 destination size of every store under path without writing to it.
+func estimateSource(path string, keepRawKey bool) ([]storeEstimate, error) {
+	stores, _, err := getStoreKeys(path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	estimates := make([]storeEstimate, 0, len(stores))
+	for _, store := range stores {
+		e, err := estimateStore(filepath.Join(path, store), keepRawKey)
+		if err != nil {
+			return nil, err
+		}
+		e.store = store
+		estimates = append(estimates, e)
+	}
+	return estimates, nil
+}
+
+// estimateStore projects the destination of the v2 store directory dir from its file sizes and
+// row counts: the tree loses its tree_idx index but gains a table per shard, and each leaf swaps
+// its key for a 32 byte key_hash, unless keepRawKey keeps both, and gains a primary key index
+// entry. Orphans, roots and the leaf_idx index are carried over at their source size.
+func estimateStore(dir string, keepRawKey bool) (storeEstimate, error) {
+	var e storeEstimate
+
+	treePath := filepath.Join(dir, "tree.sqlite")
+	if fi, err := os.Stat(treePath); err == nil {
+		e.sourceBytes += fi.Size()
+		if err := estimateTree(treePath, &e); err != nil {
+			return e, err
+		}
+		e.treeBytes = max(fi.Size()-e.treeRows*estimateTreeIndexRowBytes, 0) + int64(e.shards)*estimateShardTableBytes
+	}
+
+	changelogPath := filepath.Join(dir, "changelog.sqlite")
+	if fi, err := os.Stat(changelogPath); err == nil {
+		e.sourceBytes += fi.Size()
+		if err := estimateChangelog(changelogPath, &e); err != nil {
+			return e, err
+		}
+		perLeaf := float64(estimateKeyHashBytes + estimateLeafPKRowBytes)
+		if !keepRawKey {
+			perLeaf -= e.avgKeyLen
+		}
+		e.leafBytes = max(fi.Size()+int64(math.Round(perLeaf*float64(e.leaves))), 0)
+	}
+	return e, nil
+}
+
+func estimateTree(path string, e *storeEstimate) error {
+	db, err := sql.Open("sqlite", readonlyURI(path))
+	if err != nil {
+		return fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+
+	var minVersion, maxVersion sql.NullInt64
+	if err := db.QueryRow("SELECT MIN(version), MAX(version) FROM root").Scan(&minVersion, &maxVersion); err != nil {
+		return fmt.Errorf("read version range from %s: %w", path, err)
+	}
+	if minVersion.Valid && maxVersion.Valid {
+		e.shards = len(calculateShardRange(minVersion.Int64, maxVersion.Int64))
+	}
+
+	shardIDs, err := listShardIDs(db)
+	if err != nil {
+		return fmt.Errorf("list shards of %s: %w", path, err)
+	}
+	for _, shardID := range shardIDs {
+		var rows int64
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM tree_%d", shardID)).Scan(&rows); err != nil {
+			return fmt.Errorf("count rows of tree_%d in %s: %w", shardID, path, err)
+		}
+		e.treeRows += rows
+	}
+	return nil
+}
+
+func estimateChangelog(path string, e *storeEstimate) error {
+	db, err := sql.Open("sqlite", readonlyURI(path))
+	if err != nil {
+		return fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM leaf").Scan(&e.leaves); err != nil {
+		return fmt.Errorf("count leaves in %s: %w", path, err)
+	}
+	var avg sql.NullFloat64
+	err = db.QueryRow("SELECT AVG(LENGTH(key)) FROM (SELECT key FROM leaf LIMIT ?)", estimateKeySample).Scan(&avg)
+	if err != nil {
+		return fmt.Errorf("read key lengths in %s: %w", path, err)
+	}
+	e.avgKeyLen = avg.Float64
+	return nil
+}
+
+func printEstimates(w io.Writer, estimates []storeEstimate) {
+	var totalSource, totalEstimate int64
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tSOURCE\tTREE ROWS\tLEAVES\tAVG KEY\tSHARDS\tESTIMATE")
+	for _, e := range estimates {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.1f B\t%d\t%s\n", e.store, formatBytes(e.sourceBytes), e.treeRows, e.leaves,
+			e.avgKeyLen, e.shards, formatBytes(e.total()))
+		totalSource += e.sourceBytes
+		totalEstimate += e.total()
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d stores, %s source, about %s destination\n", len(estimates), formatBytes(totalSource), formatBytes(totalEstimate))
+}
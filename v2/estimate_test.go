@@ -0,0 +1,43 @@
+package v2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateSource(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	bankDir := filepath.Join(iavl2Path, "bank")
+	createV2Store(t, bankDir, 1, 2, 500001)
+	execV2(t, bankDir, "changelog.sqlite", "UPDATE leaf SET key = x'aabbcc' WHERE version = 2")
+
+	estimates, err := estimateSource(iavl2Path, false)
+	require.NoError(t, err)
+	require.Len(t, estimates, 1)
+	e := estimates[0]
+	require.Equal(t, "bank", e.store)
+	require.Equal(t, int64(3), e.treeRows)
+	require.Equal(t, int64(3), e.leaves)
+	require.InDelta(t, 5.0/3, e.avgKeyLen, 0.001)
+	require.Equal(t, 2, e.shards)
+
+	treeInfo, err := os.Stat(filepath.Join(bankDir, "tree.sqlite"))
+	require.NoError(t, err)
+	changelogInfo, err := os.Stat(filepath.Join(bankDir, "changelog.sqlite"))
+	require.NoError(t, err)
+	require.Equal(t, treeInfo.Size()+changelogInfo.Size(), e.sourceBytes)
+	require.Equal(t, treeInfo.Size()-3*estimateTreeIndexRowBytes+2*estimateShardTableBytes, e.treeBytes)
+	require.Equal(t, changelogInfo.Size()+3*(estimateKeyHashBytes+estimateLeafPKRowBytes)-5, e.leafBytes)
+
+	// keeping the raw key keeps its bytes too
+	estimates, err = estimateSource(iavl2Path, true)
+	require.NoError(t, err)
+	require.Equal(t, e.leafBytes+5, estimates[0].leafBytes)
+
+	out := runV2Command(t, "estimate", "--iavl2-path", iavl2Path)
+	require.Contains(t, out, "STORE  SOURCE")
+	require.Contains(t, out, "1 stores, "+formatBytes(e.sourceBytes)+" source, about "+formatBytes(e.total())+" destination\n")
+}
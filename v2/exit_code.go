@@ -0,0 +1,62 @@
+package v2
+
+import (
+	"context"
+	"errors"
+)
+
+// Exit codes returned by the migrate binary, so wrappers can tell failures apart without
+// parsing output. When an error matches several, ExitCode picks by precedence rather than
+// by value: interrupted, verification failed, partial failure, insufficient space, node running,
+// timed out, transient, bad source.
+const (
+	ExitOK = 0
+	// ExitError is any failure not covered below, e.g. bad flags.
+	ExitError = 1
+	// ExitPartialFailure means some stores migrated and others failed.
+	ExitPartialFailure = 2
+	// ExitVerificationFailed means migrated data doesn't match its source or reference:
+	// a hash, schema, sample, checksum or root check failed. Retrying won't help.
+	ExitVerificationFailed = 3
+	// ExitTransient means a SQLite busy, locked or I/O error that may clear up on a retry.
+	ExitTransient = 4
+	// ExitBadSource means the source is missing, corrupt, already v3, has an unexpected schema, holds rows
+	// the destination rejects or doesn't continue the destination of --append.
+	ExitBadSource = 5
+	// ExitInsufficientSpace means the destination filesystem is too small for the migration,
+	// found upfront or by filling up mid-write.
+	ExitInsufficientSpace = 6
+	// ExitTimedOut means a store didn't finish within --store-timeout.
+	ExitTimedOut = 7
+	// ExitNodeRunning means a node still holds a database lock in the destination's data directory.
+	ExitNodeRunning = 8
+	// ExitInterrupted means the run was cancelled, e.g. by Ctrl-C or SIGTERM.
+	ExitInterrupted = 130
+)
+
+// ExitCode maps an error returned by a command to the exit code documented above; its cases
+// are in order of precedence.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	case errors.Is(err, ErrVerificationFailed), errors.Is(err, ErrHashMismatch), errors.Is(err, ErrIncompatibleRoot):
+		return ExitVerificationFailed
+	case errors.Is(err, ErrPartialFailure):
+		return ExitPartialFailure
+	case errors.Is(err, ErrInsufficientSpace), errors.Is(err, ErrDiskFull):
+		return ExitInsufficientSpace
+	case errors.Is(err, ErrNodeRunning):
+		return ExitNodeRunning
+	case errors.Is(err, ErrStoreTimeout):
+		return ExitTimedOut
+	case isTransientSQLiteError(err):
+		return ExitTransient
+	case errors.Is(err, ErrSourceNotFound), errors.Is(err, ErrSchemaMismatch), errors.Is(err, ErrConstraintViolation),
+		errors.Is(err, ErrAppendConflict), errors.Is(err, ErrCorruptSource), errors.Is(err, ErrSourceIsV3):
+		return ExitBadSource
+	}
+	return ExitError
+}
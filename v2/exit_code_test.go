@@ -0,0 +1,84 @@
+package v2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{nil, ExitOK},
+		{errors.New("bad flag"), ExitError},
+		{fmt.Errorf("migration interrupted: %w", context.Canceled), ExitInterrupted},
+		{fmt.Errorf("store bank: %w", ErrHashMismatch), ExitVerificationFailed},
+		{fmt.Errorf("%w: 2 stores differ", ErrVerificationFailed), ExitVerificationFailed},
+		{ErrIncompatibleRoot, ExitVerificationFailed},
+		{fmt.Errorf("%w (1 of 2 stores migrated): %w", ErrPartialFailure, ErrSourceNotFound), ExitPartialFailure},
+		{fmt.Errorf("migrate shard tree_1: %w", codedError{sqliteBusy}), ExitTransient},
+		{ErrInsufficientSpace, ExitInsufficientSpace},
+		{fmt.Errorf("%w: data/application.db/LOCK is locked by another process", ErrNodeRunning), ExitNodeRunning},
+		{fmt.Errorf("%w: store bank did not finish within 1h0m0s: %w", ErrStoreTimeout, context.DeadlineExceeded), ExitTimedOut},
+		{fmt.Errorf("tree.sqlite %w", ErrSourceNotFound), ExitBadSource},
+		{ErrSchemaMismatch, ExitBadSource},
+		{ErrConstraintViolation, ExitBadSource},
+		// a mismatch outranks everything but an interruption
+		{errors.Join(ErrSourceNotFound, ErrHashMismatch), ExitVerificationFailed},
+	} {
+		require.Equal(t, tc.want, ExitCode(tc.err), "%v", tc.err)
+	}
+}
+
+func TestMigratePartialFailure(t *testing.T) {
+	for _, continueOnError := range []bool{false, true} {
+		t.Run(fmt.Sprintf("continueOnError=%v", continueOnError), func(t *testing.T) {
+			iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+			createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+			// sorted after bank, so bank has migrated when it fails
+			require.NoError(t, os.MkdirAll(filepath.Join(iavl2Path, "evm"), 0o755))
+
+			err := migrate(iavl2Path, nil, false, migrateOptions{continueOnError: continueOnError})
+			require.ErrorIs(t, err, ErrPartialFailure)
+			require.ErrorIs(t, err, ErrSourceNotFound)
+			require.ErrorContains(t, err, "(1 of 2 stores migrated)")
+			require.Equal(t, ExitPartialFailure, ExitCode(err))
+		})
+	}
+
+	// nothing migrated is a plain failure
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	require.NoError(t, os.MkdirAll(filepath.Join(iavl2Path, "evm"), 0o755))
+	err := migrate(iavl2Path, nil, false, migrateOptions{continueOnError: true})
+	require.NotErrorIs(t, err, ErrPartialFailure)
+	require.Equal(t, ExitBadSource, ExitCode(err))
+}
+
+func TestQuiet(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+	buf := captureLog(t)
+
+	cmd := Command()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"start", "--quiet", "--iavl2-path", iavl2Path})
+	require.NoError(t, cmd.Execute())
+	require.Empty(t, buf.String())
+	require.FileExists(t, filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+
+	// the library option silences the migration's own logger
+	iavl2Path = filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+	buf = captureLog(t)
+	require.NoError(t, Migrate(Options{IAVL2Path: iavl2Path, Quiet: true}))
+	require.Empty(t, buf.String())
+}
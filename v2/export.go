@@ -0,0 +1,222 @@
+package v2
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	exportFormatNDJSON = "ndjson"
+	exportFormatCSV    = "csv"
+)
+
+func ExportCommand() *cobra.Command {
+	// e.g.: ./migrate v2 export --new-iavl2-path ~/.saharad/data/iavl2 --store-key bank --output bank.ndjson.gz
+	var (
+		dbv3   string
+		sk     string
+		output string
+		format string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "export the root and tree shard rows of a migrated store to NDJSON or CSV",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			treePath := filepath.Join(dbv3, sk, "tree.sqlite")
+			rows, err := exportTree(treePath, output, format)
+			if err != nil {
+				return err
+			}
+			log.Printf("exported %d rows from %s to %s", rows, treePath, output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&dbv3, "new-iavl2-path", "", "Path to the v3 root directory")
+	cmd.Flags().StringVar(&sk, "store-key", "", "The store which is going to be exported")
+	cmd.Flags().StringVar(&output, "output", "", "Output file, gzip compressed when it ends in .gz")
+	cmd.Flags().StringVar(&format, "format", exportFormatNDJSON, "Output format (ndjson, csv)")
+	for _, flag := range []string{"new-iavl2-path", "store-key", "output"} {
+		if err := cmd.MarkFlagRequired(flag); err != nil {
+			panic(err)
+		}
+	}
+
+	return cmd
+}
+
+// exportRecord is one exported row. Shard rows fill version/sequence, root rows
+// fill version/node_version/node_sequence; bytes is hex encoded.
+type exportRecord struct {
+	Table        string `json:"table"`
+	Version      int64  `json:"version"`
+	Sequence     *int64 `json:"sequence,omitempty"`
+	NodeVersion  *int64 `json:"node_version,omitempty"`
+	NodeSequence *int64 `json:"node_sequence,omitempty"`
+	Bytes        string `json:"bytes"`
+}
+
+type exportWriter interface {
+	write(rec exportRecord) error
+	flush() error
+}
+
+type ndjsonExportWriter struct {
+	enc *json.Encoder
+}
+
+func (w *ndjsonExportWriter) write(rec exportRecord) error { return w.enc.Encode(rec) }
+func (w *ndjsonExportWriter) flush() error                 { return nil }
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (w *csvExportWriter) write(rec exportRecord) error {
+	optional := func(v *int64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatInt(*v, 10)
+	}
+	return w.w.Write([]string{rec.Table, strconv.FormatInt(rec.Version, 10), optional(rec.Sequence),
+		optional(rec.NodeVersion), optional(rec.NodeSequence), rec.Bytes})
+}
+
+func (w *csvExportWriter) flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// exportTree streams the root table and every tree_N shard of a v3 tree database
+// to output and returns the number of rows written.
+func exportTree(treePath, output, format string) (int64, error) {
+	if _, err := os.Stat(treePath); err != nil {
+		return 0, fmt.Errorf("tree.sqlite not found: %w", err)
+	}
+	db, err := sql.Open("sqlite", treePath)
+	if err != nil {
+		return 0, fmt.Errorf("open db %s: %w", treePath, err)
+	}
+	defer db.Close()
+
+	f, err := os.Create(output)
+	if err != nil {
+		return 0, fmt.Errorf("create output %s: %w", output, err)
+	}
+	defer f.Close()
+
+	buf := bufio.NewWriter(f)
+	var out io.Writer = buf
+	var gz *gzip.Writer
+	if strings.HasSuffix(output, ".gz") {
+		gz = gzip.NewWriter(buf)
+		out = gz
+	}
+
+	var w exportWriter
+	switch format {
+	case exportFormatNDJSON:
+		w = &ndjsonExportWriter{enc: json.NewEncoder(out)}
+	case exportFormatCSV:
+		cw := csv.NewWriter(out)
+		if err := cw.Write([]string{"table", "version", "sequence", "node_version", "node_sequence", "bytes"}); err != nil {
+			return 0, err
+		}
+		w = &csvExportWriter{w: cw}
+	default:
+		return 0, fmt.Errorf("unsupported export format %q (supported: %s, %s)", format, exportFormatNDJSON, exportFormatCSV)
+	}
+
+	var exported int64
+
+	rows, err := db.Query("SELECT version, node_version, node_sequence, bytes FROM root ORDER BY version")
+	if err != nil {
+		return 0, fmt.Errorf("read root: %w", err)
+	}
+	for rows.Next() {
+		var (
+			version, nodeVersion, nodeSequence int64
+			bz                                 []byte
+		)
+		if err := rows.Scan(&version, &nodeVersion, &nodeSequence, &bz); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan root: %w", err)
+		}
+		rec := exportRecord{Table: "root", Version: version, NodeVersion: &nodeVersion, NodeSequence: &nodeSequence, Bytes: hex.EncodeToString(bz)}
+		if err := w.write(rec); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		exported++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("read root: %w", err)
+	}
+
+	shardIDs, err := listShardIDs(db)
+	if err != nil {
+		return 0, err
+	}
+	for _, shardID := range shardIDs {
+		tableName := fmt.Sprintf("tree_%d", shardID)
+		n, err := exportShard(db, tableName, w)
+		if err != nil {
+			return 0, err
+		}
+		exported += n
+	}
+
+	if err := w.flush(); err != nil {
+		return 0, err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return 0, err
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return 0, err
+	}
+	return exported, f.Close()
+}
+
+func exportShard(db *sql.DB, tableName string, w exportWriter) (int64, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT version, sequence, bytes FROM %s ORDER BY version, sequence", tableName))
+	if err != nil {
+		return 0, fmt.Errorf("read %s: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	var exported int64
+	for rows.Next() {
+		var (
+			version, sequence int64
+			bz                []byte
+		)
+		if err := rows.Scan(&version, &sequence, &bz); err != nil {
+			return 0, fmt.Errorf("scan %s: %w", tableName, err)
+		}
+		if err := w.write(exportRecord{Table: tableName, Version: version, Sequence: &sequence, Bytes: hex.EncodeToString(bz)}); err != nil {
+			return 0, err
+		}
+		exported++
+	}
+	return exported, rows.Err()
+}
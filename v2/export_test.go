@@ -0,0 +1,87 @@
+package v2
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func createMigratedTree(t *testing.T, path string) {
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE root (version int, node_version int, node_sequence int, bytes blob, PRIMARY KEY (version DESC)) WITHOUT ROWID;
+		CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool, PRIMARY KEY (version, sequence)) WITHOUT ROWID;
+		CREATE TABLE tree_10 (version int, sequence int, bytes blob, orphaned bool, PRIMARY KEY (version, sequence)) WITHOUT ROWID;
+		CREATE TABLE tree_2 (version int, sequence int, bytes blob, orphaned bool, PRIMARY KEY (version, sequence)) WITHOUT ROWID;
+		INSERT INTO root VALUES (4500001, 4500001, 1, x'ff');
+		INSERT INTO tree_1 VALUES (1, 1, x'01', false);
+		INSERT INTO tree_2 VALUES (500001, 1, x'02', false);
+		INSERT INTO tree_10 VALUES (4500001, 1, x'0a', false);
+	`)
+	require.NoError(t, err)
+}
+
+func TestExportTreeNDJSONGzip(t *testing.T) {
+	tempDir := t.TempDir()
+	treePath := filepath.Join(tempDir, "tree.sqlite")
+	output := filepath.Join(tempDir, "export.ndjson.gz")
+	createMigratedTree(t, treePath)
+
+	rows, err := exportTree(treePath, output, exportFormatNDJSON)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), rows)
+
+	f, err := os.Open(output)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	var records []exportRecord
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var rec exportRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		records = append(records, rec)
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Len(t, records, 4)
+	require.Equal(t, "root", records[0].Table)
+	require.Equal(t, int64(1), *records[0].NodeSequence)
+	require.Equal(t, "ff", records[0].Bytes)
+	// shards are exported in numeric order
+	require.Equal(t, []string{"tree_1", "tree_2", "tree_10"}, []string{records[1].Table, records[2].Table, records[3].Table})
+	require.Equal(t, "0a", records[3].Bytes)
+}
+
+func TestExportTreeCSV(t *testing.T) {
+	tempDir := t.TempDir()
+	treePath := filepath.Join(tempDir, "tree.sqlite")
+	output := filepath.Join(tempDir, "export.csv")
+	createMigratedTree(t, treePath)
+
+	_, err := exportTree(treePath, output, exportFormatCSV)
+	require.NoError(t, err)
+
+	bz, err := os.ReadFile(output)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(bz)), "\n")
+	require.Equal(t, []string{
+		"table,version,sequence,node_version,node_sequence,bytes",
+		"root,4500001,,4500001,1,ff",
+		"tree_1,1,1,,,01",
+		"tree_2,500001,1,,,02",
+		"tree_10,4500001,1,,,0a",
+	}, lines)
+}
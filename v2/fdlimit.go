@@ -0,0 +1,79 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Rough upper bounds on the file descriptors a migration holds open, which checkOpenFiles
+// plans store workers against.
+const (
+	// fdsPerPhase is what one running tree or changelog phase holds: the destination .tmp
+	// database and its rollback journal, the source attached to it, the source on a
+	// connection of its own, and SQLite temp files for sorts and index builds.
+	fdsPerPhase = 6
+	// fdsPerShardWorker is a shard worker's staged shard file, its journal and the source
+	// attached to it.
+	fdsPerShardWorker = 3
+	// fdsReserved covers everything else: stdio, --log-file, the --metrics-addr listener and
+	// its connections, and the runtime's own descriptors.
+	fdsReserved = 64
+)
+
+// openFilesLimit returns how many files the process may have open. Tests replace it.
+var openFilesLimit = processOpenFilesLimit
+
+// errOpenFilesLimitUnsupported is returned by processOpenFilesLimit where it can't be determined.
+var errOpenFilesLimitUnsupported = errors.New("the open file limit can't be determined on this platform")
+
+// estimateOpenFiles estimates the file descriptors storeWorkers stores migrating at once hold.
+// With opts.parallelPhases stores share max(storeWorkers, 2) phase slots, and shard workers
+// are one pool shared by all stores.
+func estimateOpenFiles(storeWorkers int, opts migrateOptions) int64 {
+	phases := storeWorkers
+	if opts.parallelPhases {
+		phases = max(storeWorkers, 2)
+	}
+	n := int64(fdsReserved + phases*fdsPerPhase)
+	if opts.shardWorkers > 1 {
+		n += int64(opts.shardWorkers * fdsPerShardWorker)
+	}
+	return n
+}
+
+// checkOpenFiles returns how many of storeWorkers stores may migrate at once without running
+// out of file descriptors, which otherwise fails stores with "too many open files" hours into
+// a run. The limit is opts.maxOpenFiles, or else RLIMIT_NOFILE. If the requested workers
+// don't fit, fewer are used and a warning is logged; if not even one does, it fails with
+// ErrOpenFilesLimit before any store is touched.
+func checkOpenFiles(storeWorkers int, opts migrateOptions) (int, error) {
+	limit := opts.maxOpenFiles
+	if limit == 0 {
+		var err error
+		limit, err = openFilesLimit()
+		if errors.Is(err, errOpenFilesLimitUnsupported) {
+			opts.logger.Event("open_files_check_skipped", nil, "WARNING: %v, skipping the open file check; pass --max-open-files to plan against one", err)
+			return storeWorkers, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("read open file limit: %w", err)
+		}
+	}
+
+	need := estimateOpenFiles(storeWorkers, opts)
+	if need <= limit {
+		return storeWorkers, nil
+	}
+	fit := storeWorkers - 1
+	for fit > 0 && estimateOpenFiles(fit, opts) > limit {
+		fit--
+	}
+	if fit == 0 {
+		return 0, fmt.Errorf("%w: migrating needs about %d open files but the limit is %d; raise it with ulimit -n or lower --shard-workers",
+			ErrOpenFilesLimit, estimateOpenFiles(1, opts), limit)
+	}
+	opts.logger.Event("open_files", logFields{"limit": limit, "needed": need, "store_workers": fit},
+		"WARNING: %d store workers need about %d open files but the limit is %d; migrating %d stores at once instead. Raise it with ulimit -n to run more",
+		storeWorkers, need, limit, fit)
+	return fit, nil
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package v2
+
+func processOpenFilesLimit() (int64, error) {
+	return 0, errOpenFilesLimitUnsupported
+}
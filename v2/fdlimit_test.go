@@ -0,0 +1,63 @@
+package v2
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setOpenFilesLimit makes openFilesLimit report limit for the rest of the test.
+func setOpenFilesLimit(t *testing.T, limit int64) {
+	orig := openFilesLimit
+	openFilesLimit = func() (int64, error) { return limit, nil }
+	t.Cleanup(func() { openFilesLimit = orig })
+}
+
+func TestEstimateOpenFiles(t *testing.T) {
+	require.Equal(t, int64(fdsReserved+4*fdsPerPhase), estimateOpenFiles(4, migrateOptions{}))
+	// one store still overlaps its two phases
+	require.Equal(t, int64(fdsReserved+2*fdsPerPhase), estimateOpenFiles(1, migrateOptions{parallelPhases: true}))
+	// the shard worker pool is shared, not per store
+	require.Equal(t, int64(fdsReserved+4*fdsPerPhase+8*fdsPerShardWorker), estimateOpenFiles(4, migrateOptions{shardWorkers: 8}))
+}
+
+func TestCheckOpenFiles(t *testing.T) {
+	setOpenFilesLimit(t, fdsReserved+3*fdsPerPhase)
+
+	workers, err := checkOpenFiles(2, migrateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 2, workers)
+
+	buf := captureLog(t)
+	workers, err = checkOpenFiles(8, migrateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 3, workers)
+	require.Contains(t, buf.String(), "migrating 3 stores at once instead")
+
+	// --max-open-files replaces the limit
+	workers, err = checkOpenFiles(8, migrateOptions{maxOpenFiles: 1 << 20})
+	require.NoError(t, err)
+	require.Equal(t, 8, workers)
+
+	_, err = checkOpenFiles(1, migrateOptions{shardWorkers: 64})
+	require.ErrorIs(t, err, ErrOpenFilesLimit)
+	require.ErrorContains(t, err, "ulimit -n")
+}
+
+func TestMigrateChecksOpenFiles(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2)
+	createV2Store(t, filepath.Join(iavl2Path, "staking"), 1, 2)
+
+	err := migrate(iavl2Path, nil, true, migrateOptions{workers: 2, shardWorkers: 4, maxOpenFiles: fdsReserved})
+	require.ErrorIs(t, err, ErrOpenFilesLimit)
+	// failed before the source was moved aside
+	require.DirExists(t, filepath.Join(iavl2Path, "bank"))
+	require.NoDirExists(t, iavl2Path+".bak")
+
+	buf := captureLog(t)
+	require.NoError(t, migrate(iavl2Path, nil, true, migrateOptions{workers: 2, maxOpenFiles: fdsReserved + fdsPerPhase}))
+	require.Contains(t, buf.String(), "max workers 1")
+	require.FileExists(t, filepath.Join(iavl2Path, "staking", "tree.sqlite"))
+}
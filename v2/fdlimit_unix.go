@@ -0,0 +1,22 @@
+//go:build unix
+
+package v2
+
+import (
+	"math"
+	"syscall"
+)
+
+// processOpenFilesLimit returns the soft RLIMIT_NOFILE, which the Go runtime already raises
+// to the hard limit at startup.
+func processOpenFilesLimit() (int64, error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, err
+	}
+	// RLIM_INFINITY doesn't fit an int64
+	if uint64(rl.Cur) > math.MaxInt64 {
+		return math.MaxInt64, nil
+	}
+	return int64(rl.Cur), nil
+}
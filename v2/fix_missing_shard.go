@@ -1,6 +1,7 @@
 package v2
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -13,14 +14,17 @@ import (
 
 func FixMissingShardCommand() *cobra.Command {
 	var (
-		dbPath string
+		dbPath       string
+		sourcePath   string
+		treeFilename string
+		verify       bool
 	)
 
 	cmd := &cobra.Command{
 		Use:   "fix-missing-shard",
 		Short: "fix missing shard tables in migrated database",
-		Run: func(cmd *cobra.Command, args []string) {
-			fixMissingShard(dbPath)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return fixMissingShard(dbPath, sourcePath, treeFilename, verify)
 		},
 	}
 
@@ -28,12 +32,23 @@ func FixMissingShardCommand() *cobra.Command {
 	if err := cmd.MarkFlagRequired("db-path"); err != nil {
 		panic(err)
 	}
+	cmd.Flags().StringVar(&sourcePath, "source-path", "", "Path to the original v2 iavl2/ directory used to backfill recreated shards (e.g. iavl2.bak)")
+	cmd.Flags().StringVar(&treeFilename, "tree-filename", defaultTreeFilename, "Name of the tree databases to fix")
+	cmd.Flags().BoolVar(&verify, "verify", false, "Also compare the row count of every shard that already exists with --source-path and report the under-populated ones")
 
 	return cmd
 }
 
-func fixMissingShard(dbPath string) {
-	// Walk through all tree.sqlite files in the database directory
+// fixMissingShard runs fixMissingShardInFile on every tree database under dbPath. With verify
+// it fails with ErrVerificationFailed if any existing shard holds fewer rows than its source.
+func fixMissingShard(dbPath, sourcePath, treeFilename string, verify bool) error {
+	if verify && sourcePath == "" {
+		log.Printf("WARNING: --verify without --source-path cannot compare row counts; only missing shards are fixed")
+	}
+
+	incomplete := make(map[string][]string)
+	var incompletePaths []string
+	// Walk through all tree databases named treeFilename in the database directory
 	var walkDir func(dir string) error
 	walkDir = func(dir string) error {
 		entries, err := os.ReadDir(dir)
@@ -52,37 +67,79 @@ func fixMissingShard(dbPath string) {
 				continue
 			}
 
-			// Only process tree.sqlite files
-			if entry.Name() != "tree.sqlite" {
+			// Only process tree databases
+			if entry.Name() != treeFilename {
 				continue
 			}
 
-			fmt.Printf("Processing tree.sqlite: %s\n", path)
-			if err := fixMissingShardInFile(path); err != nil {
+			// The source tree sits at the same relative path under the v2 directory
+			var sourceFile string
+			if sourcePath != "" {
+				rel, err := filepath.Rel(dbPath, path)
+				if err != nil {
+					return err
+				}
+				sourceFile = filepath.Join(sourcePath, rel)
+			}
+
+			fmt.Printf("Processing %s: %s\n", treeFilename, path)
+			problems, err := fixMissingShardInFile(path, sourceFile, verify)
+			if err != nil {
 				log.Printf("Error fixing %s: %v", path, err)
 				continue
 			}
+			if len(problems) > 0 {
+				incomplete[path] = problems
+				incompletePaths = append(incompletePaths, path)
+			}
 		}
 		return nil
 	}
 
 	if err := walkDir(dbPath); err != nil {
-		log.Fatal(err)
+		return err
 	}
+
+	if len(incompletePaths) == 0 {
+		return nil
+	}
+	fmt.Println("\nShard tables present but incomplete (a partial migration; re-migrate the store, or drop the tables and re-run with --source-path):")
+	for _, path := range incompletePaths {
+		fmt.Printf("%s:\n", path)
+		for _, problem := range incomplete[path] {
+			fmt.Printf("  %s\n", problem)
+		}
+	}
+	return fmt.Errorf("%w: %d tree databases with incomplete shards %v", ErrVerificationFailed, len(incompletePaths), incompletePaths)
 }
 
-func fixMissingShardInFile(dbPath string) error {
+// fixMissingShardInFile creates the shard tables missing from dbPath. If sourcePath names the
+// original v2 tree.sqlite, each recreated shard is refilled from its tree_1; otherwise it is left empty.
+// With verify and a sourcePath, it also returns the shards that exist but whose row count differs
+// from the source: a table that is present but incomplete, which creating tables cannot fix.
+func fixMissingShardInFile(dbPath, sourcePath string, verify bool) ([]string, error) {
+	if sourcePath != "" {
+		if _, err := os.Stat(sourcePath); err != nil {
+			return nil, fmt.Errorf("source tree %s: %w", sourcePath, err)
+		}
+	}
+
 	// Open the database
 	db, err := sql.Open("sqlite", dbPath)
 	if err != nil {
-		return fmt.Errorf("open db %s: %w", dbPath, err)
+		return nil, fmt.Errorf("open db %s: %w", dbPath, err)
 	}
 	defer db.Close()
 
+	// A truncated destination may be missing the base tables as well
+	if err := createMissingBaseTables(db, dbPath); err != nil {
+		return nil, err
+	}
+
 	// Check what shard tables exist
 	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' AND name LIKE 'tree_%'")
 	if err != nil {
-		return fmt.Errorf("failed to query existing shard tables: %w", err)
+		return nil, fmt.Errorf("failed to query existing shard tables: %w", err)
 	}
 	defer rows.Close()
 
@@ -90,37 +147,38 @@ func fixMissingShardInFile(dbPath string) error {
 	for rows.Next() {
 		var tableName string
 		if err := rows.Scan(&tableName); err != nil {
-			return fmt.Errorf("failed to scan table name: %w", err)
+			return nil, fmt.Errorf("failed to scan table name: %w", err)
 		}
 		existingShards[tableName] = true
 	}
 
 	if err := rows.Err(); err != nil {
-		return fmt.Errorf("error iterating existing shard tables: %w", err)
+		return nil, fmt.Errorf("error iterating existing shard tables: %w", err)
 	}
 
 	// Analyze version range to determine needed shards
 	fmt.Printf("Analyzing version range in %s...\n", dbPath)
 
 	// Get min and max versions from the root table to understand the data range
-	var minVersion, maxVersion int64
+	var minVersion, maxVersion sql.NullInt64
 	err = db.QueryRow("SELECT MIN(version), MAX(version) FROM root").Scan(&minVersion, &maxVersion)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			fmt.Printf("No data found in %s\n", dbPath)
-			return nil
-		}
-		return fmt.Errorf("failed to query version range: %w", err)
+		return nil, fmt.Errorf("failed to query version range: %w", err)
+	}
+	if !minVersion.Valid || !maxVersion.Valid {
+		fmt.Printf("No data found in %s\n", dbPath)
+		return nil, nil
 	}
 
-	fmt.Printf("Found version range: %d to %d\n", minVersion, maxVersion)
+	fmt.Printf("Found version range: %d to %d\n", minVersion.Int64, maxVersion.Int64)
 
 	// Calculate needed shard IDs based on version range
-	neededShards := calculateShardRange(minVersion, maxVersion)
+	neededShards := calculateShardRange(minVersion.Int64, maxVersion.Int64)
 	fmt.Printf("Need shards: %v\n", neededShards)
 
 	// Create missing shard tables
 	createdCount := 0
+	var incomplete []string
 	for _, shardID := range neededShards {
 		tableName := fmt.Sprintf("tree_%d", shardID)
 		if !existingShards[tableName] {
@@ -132,13 +190,34 @@ func fixMissingShardInFile(dbPath string) error {
 			) WITHOUT ROWID;`, tableName)
 
 			if _, err := db.Exec(createStmt); err != nil {
-				return fmt.Errorf("failed to create %s table: %w", tableName, err)
+				return nil, fmt.Errorf("failed to create %s table: %w", tableName, err)
 			}
 
 			fmt.Printf("Successfully created %s table in %s\n", tableName, dbPath)
 			createdCount++
+
+			if sourcePath == "" {
+				log.Printf("WARNING: %s was recreated empty; pass --source-path to backfill it from the v2 tree", tableName)
+				continue
+			}
+			rows, err := backfillShard(db, sourcePath, shardID)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Printf("Backfilled %d rows into %s from %s\n", rows, tableName, sourcePath)
 		} else {
 			fmt.Printf("%s table already exists in %s\n", tableName, dbPath)
+			if !verify || sourcePath == "" {
+				continue
+			}
+			problem, err := verifyShardRowCount(db, sourcePath, shardID)
+			if err != nil {
+				return nil, err
+			}
+			if problem != "" {
+				fmt.Printf("%s in %s\n", problem, dbPath)
+				incomplete = append(incomplete, problem)
+			}
 		}
 	}
 
@@ -148,5 +227,96 @@ func fixMissingShardInFile(dbPath string) error {
 		fmt.Printf("All necessary shard tables already exist in %s\n", dbPath)
 	}
 
+	return incomplete, nil
+}
+
+// createMissingBaseTables creates branch_orphan and root with the same schema migrateTree uses
+// if either is absent.
+func createMissingBaseTables(db *sql.DB, dbPath string) error {
+	baseTables := []struct {
+		name   string
+		create string
+	}{
+		{"branch_orphan", `CREATE TABLE branch_orphan (
+		  version INT, sequence INT, at INT,
+		  PRIMARY KEY (at DESC, version, sequence)
+		) WITHOUT ROWID;`},
+		{"root", `CREATE TABLE root (
+		  version INT, node_version INT, node_sequence INT, bytes BLOB,
+		  PRIMARY KEY (version DESC)
+		) WITHOUT ROWID;`},
+	}
+
+	for _, table := range baseTables {
+		exists, err := tableExists(db, table.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		fmt.Printf("Creating missing %s table in %s\n", table.name, dbPath)
+		if _, err := db.Exec(table.create); err != nil {
+			return fmt.Errorf("failed to create %s table: %w", table.name, err)
+		}
+	}
 	return nil
 }
+
+// backfillShard copies the shard's version range from the v2 tree at sourcePath into tree_<shardID>.
+func backfillShard(db *sql.DB, sourcePath string, shardID int64) (int64, error) {
+	// ATTACH is per connection, so pin one for the attach/insert/detach sequence
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`ATTACH DATABASE '%s' AS old;`, sourcePath)); err != nil {
+		return 0, fmt.Errorf("attach %s: %w", sourcePath, err)
+	}
+	defer conn.ExecContext(ctx, `DETACH DATABASE old;`)
+
+	tableName := fmt.Sprintf("tree_%d", shardID)
+	startVersion, endVersion := shardVersionRange(shardID)
+	res, err := conn.ExecContext(ctx, copyShardStmt(tableName, plainTreeSource, startVersion, endVersion))
+	if err != nil {
+		return 0, fmt.Errorf("backfill %s: %w", tableName, err)
+	}
+	return res.RowsAffected()
+}
+
+// verifyShardRowCount compares the rows of tree_<shardID> in db with the distinct nodes of the
+// shard's version range in the tree_1 of the v2 tree at sourcePath, which backfillShard would
+// copy, and describes the difference; it is empty if the counts agree.
+func verifyShardRowCount(db *sql.DB, sourcePath string, shardID int64) (string, error) {
+	tableName := fmt.Sprintf("tree_%d", shardID)
+	var have int64
+	if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", tableName)).Scan(&have); err != nil {
+		return "", fmt.Errorf("count %s: %w", tableName, err)
+	}
+
+	sourceDB, err := sql.Open("sqlite", readonlyURI(sourcePath))
+	if err != nil {
+		return "", fmt.Errorf("open db %s: %w", sourcePath, err)
+	}
+	defer sourceDB.Close()
+	startVersion, endVersion := shardVersionRange(shardID)
+	var want int64
+	err = sourceDB.QueryRow(`SELECT COUNT(*) FROM (
+	    SELECT 1 FROM tree_1 WHERE version >= ? AND version <= ? GROUP BY version, sequence)`,
+		startVersion, endVersion).Scan(&want)
+	if err != nil {
+		return "", fmt.Errorf("count versions %d-%d of %s: %w", startVersion, endVersion, sourcePath, err)
+	}
+
+	switch {
+	case have < want:
+		return fmt.Sprintf("%s has %d of %d source rows: under-populated by a partial migration", tableName, have, want), nil
+	case have > want:
+		return fmt.Sprintf("%s has %d rows, more than the %d source rows", tableName, have, want), nil
+	}
+	return "", nil
+}
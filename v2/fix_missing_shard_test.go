@@ -0,0 +1,189 @@
+package v2
+
+import (
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+// captureStdout returns everything fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	orig := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = orig }()
+
+	done := make(chan string)
+	go func() {
+		bz, _ := io.ReadAll(r)
+		done <- string(bz)
+	}()
+	fn()
+	require.NoError(t, w.Close())
+	return <-done
+}
+
+func runV2Command(t *testing.T, args ...string) string {
+	return captureStdout(t, func() {
+		cmd := Command()
+		cmd.SetArgs(args)
+		require.NoError(t, cmd.Execute())
+	})
+}
+
+func TestFixMissingShardRecovery(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 500001)
+
+	runV2Command(t, "start", "--iavl2-path", iavl2Path)
+
+	treePath := filepath.Join(iavl2Path, "bank", "tree.sqlite")
+	db, err := sql.Open("sqlite", treePath)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("DROP TABLE tree_2; DROP TABLE branch_orphan;")
+	require.NoError(t, err)
+
+	out := runV2Command(t, "check-shards", "--db-path", iavl2Path)
+	require.Contains(t, out, "Missing shard tables: [tree_2]")
+
+	out = runV2Command(t, "fix-missing-shard", "--db-path", iavl2Path)
+	require.Contains(t, out, "Creating missing branch_orphan table")
+	require.Contains(t, out, "Creating missing tree_2 table")
+
+	out = runV2Command(t, "check-shards", "--db-path", iavl2Path)
+	require.Contains(t, out, "All expected shard tables exist")
+
+	exists, err := tableExists(db, "branch_orphan")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.NoError(t, verifySchema(iavl2Path))
+}
+
+func TestCustomFilenames(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	storeDir := filepath.Join(iavl2Path, "bank")
+	createV2Store(t, storeDir, 1, 500001)
+	require.NoError(t, os.Rename(filepath.Join(storeDir, "tree.sqlite"), filepath.Join(storeDir, "state.db")))
+	require.NoError(t, os.Rename(filepath.Join(storeDir, "changelog.sqlite"), filepath.Join(storeDir, "history.db")))
+
+	runV2Command(t, "start", "--iavl2-path", iavl2Path, "--tree-filename", "state.db", "--changelog-filename", "history.db")
+
+	treePath := filepath.Join(storeDir, "state.db")
+	require.Equal(t, int64(2), countTableRows(t, treePath, "root"))
+	require.Equal(t, int64(2), countTableRows(t, filepath.Join(storeDir, "history.db"), "leaf"))
+	require.NoFileExists(t, filepath.Join(storeDir, "tree.sqlite"))
+	require.NoFileExists(t, filepath.Join(storeDir, "changelog.sqlite"))
+
+	db, err := sql.Open("sqlite", treePath)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("DROP TABLE tree_2")
+	require.NoError(t, err)
+
+	// the default name finds no tree at all
+	out := runV2Command(t, "check-shards", "--db-path", iavl2Path)
+	require.NotContains(t, out, "tree_2")
+	out = runV2Command(t, "check-shards", "--db-path", iavl2Path, "--tree-filename", "state.db")
+	require.Contains(t, out, "Missing shard tables: [tree_2]")
+
+	out = runV2Command(t, "fix-missing-shard", "--db-path", iavl2Path, "--tree-filename", "state.db")
+	require.Contains(t, out, "Creating missing tree_2 table")
+	out = runV2Command(t, "check-shards", "--db-path", iavl2Path, "--tree-filename", "state.db")
+	require.Contains(t, out, "All expected shard tables exist")
+}
+
+func TestCheckStoreFilenames(t *testing.T) {
+	require.NoError(t, checkStoreFilenames("", ""))
+	require.NoError(t, checkStoreFilenames("state.db", ""))
+	require.ErrorContains(t, checkStoreFilenames("sub/state.db", ""), "without a directory")
+	require.ErrorContains(t, checkStoreFilenames("", "tree.sqlite"), "both")
+
+	_, err := Options{TreeFilename: "state.db", Manifest: "manifest.json"}.migrateOptions()
+	require.ErrorContains(t, err, "--manifest")
+}
+
+func TestFixMissingShardCreatesRoot(t *testing.T) {
+	treePath := filepath.Join(t.TempDir(), "tree.sqlite")
+	db, err := sql.Open("sqlite", treePath)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool, PRIMARY KEY (version, sequence)) WITHOUT ROWID;`)
+	require.NoError(t, err)
+
+	_, err = fixMissingShardInFile(treePath, "", false)
+	require.NoError(t, err)
+
+	for _, table := range []string{"root", "branch_orphan"} {
+		exists, err := tableExists(db, table)
+		require.NoError(t, err)
+		require.True(t, exists, table)
+	}
+}
+
+func TestFixMissingShardBackfillFromSource(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 500001, 500002)
+
+	runV2Command(t, "start", "--iavl2-path", iavl2Path)
+
+	db, err := sql.Open("sqlite", filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("DROP TABLE tree_2")
+	require.NoError(t, err)
+
+	out := runV2Command(t, "fix-missing-shard", "--db-path", iavl2Path, "--source-path", iavl2Path+".bak")
+	require.Contains(t, out, "Backfilled 2 rows into tree_2")
+
+	var versions []int64
+	rows, err := db.Query("SELECT version FROM tree_2 ORDER BY version")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var version int64
+		require.NoError(t, rows.Scan(&version))
+		versions = append(versions, version)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []int64{500001, 500002}, versions)
+
+	// the untouched shard is not copied twice
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM tree_1").Scan(&count))
+	require.Equal(t, 1, count)
+}
+
+func TestFixMissingShardVerify(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2, 500001, 500002)
+
+	runV2Command(t, "start", "--iavl2-path", iavl2Path)
+
+	// tree_1 lost a row, tree_2 is gone entirely
+	bankDir := filepath.Join(iavl2Path, "bank")
+	execV2(t, bankDir, "tree.sqlite", "DELETE FROM tree_1 WHERE version = 2")
+	execV2(t, bankDir, "tree.sqlite", "DROP TABLE tree_2")
+
+	var err error
+	out := captureStdout(t, func() {
+		err = fixMissingShard(iavl2Path, iavl2Path+".bak", defaultTreeFilename, true)
+	})
+	require.ErrorIs(t, err, ErrVerificationFailed)
+	require.Contains(t, out, "Backfilled 2 rows into tree_2")
+	require.Contains(t, out, "Shard tables present but incomplete")
+	require.Contains(t, out, "  tree_1 has 1 of 2 source rows: under-populated by a partial migration\n")
+	require.NotContains(t, out, "tree_2 has")
+
+	// without --verify only the missing shard is a problem, and it is fixed
+	execV2(t, bankDir, "tree.sqlite", "DROP TABLE tree_2")
+	out = runV2Command(t, "fix-missing-shard", "--db-path", iavl2Path, "--source-path", iavl2Path+".bak")
+	require.NotContains(t, out, "incomplete")
+	require.Equal(t, int64(2), countTableRows(t, filepath.Join(bankDir, "tree.sqlite"), "tree_2"))
+}
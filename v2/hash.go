@@ -0,0 +1,241 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	nodepool3 "github.com/SaharaLabsAI/iavl/v2/common/pool/node"
+	iavl3 "github.com/SaharaLabsAI/iavl/v2/db/sqlite"
+	inode3 "github.com/SaharaLabsAI/iavl/v2/node"
+	"github.com/spf13/cobra"
+)
+
+func HashCommand() *cobra.Command {
+	var (
+		dbv3       string
+		sk         string
+		expectHash string
+		loadOpts   iavlLoadOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "hash",
+		Short: "print the latest root hash of a migrated store, optionally checking it against a known value",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := loadOpts.validate(); err != nil {
+				return err
+			}
+			path := filepath.Join(dbv3, sk)
+			version, hash, err := loadV3RootHashAt(path, 0, loadOpts)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("store %s version %d root hash %x\n", sk, version, hash)
+			return checkExpectedHash(hash, expectHash)
+		},
+	}
+
+	cmd.Flags().StringVar(&dbv3, "new-iavl2-path", "", "Path to the v3 root directory")
+	cmd.Flags().StringVar(&sk, "store-key", "", "The store to hash")
+	cmd.Flags().StringVar(&expectHash, "expect-hash", "", "Hex root hash the store must have, e.g. from a snapshot manifest")
+	loadOpts.addFlags(cmd)
+	if err := cmd.MarkFlagRequired("new-iavl2-path"); err != nil {
+		panic(err)
+	}
+	if err := cmd.MarkFlagRequired("store-key"); err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+// iavlLoadOptions tunes the SQLite connections the iavl libraries open to load a root, for
+// verifying huge stores on a memory budget. The libraries' node and hash pools are unbounded
+// sync.Pools with nothing to size; the page cache and read pool are what grows with the tree.
+// Zero values keep the libraries' defaults.
+type iavlLoadOptions struct {
+	// cacheSizeMiB is the SQLite page cache of each connection; the default is 1 GiB.
+	cacheSizeMiB int
+	// readPoolSize caps the read connections, each with its own page cache; the default is 1000.
+	readPoolSize int
+}
+
+func (o *iavlLoadOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&o.cacheSizeMiB, "cache-size", 0, "SQLite page cache in MiB of each connection iavl opens to load roots (default: the library's 1024)")
+	cmd.Flags().IntVar(&o.readPoolSize, "read-pool-size", 0, "Most read connections iavl opens to load roots, each with its own page cache (default: the library's 1000)")
+}
+
+func (o iavlLoadOptions) validate() error {
+	if o.cacheSizeMiB < 0 || o.readPoolSize < 0 {
+		return fmt.Errorf("--cache-size and --read-pool-size must not be negative, got %d and %d", o.cacheSizeMiB, o.readPoolSize)
+	}
+	return nil
+}
+
+// sqliteCacheSize converts cacheSizeMiB to a cache_size pragma value, which counts KiB when
+// negative; 0 keeps the default.
+func (o iavlLoadOptions) sqliteCacheSize() int {
+	return -o.cacheSizeMiB * 1024
+}
+
+// loadV3RootHash loads the latest root of the v3 store at path and returns its version and hash.
+// An empty tree, saved as a root without bytes, has a nil hash.
+func loadV3RootHash(path string) (int64, []byte, error) {
+	return loadV3RootHashAt(path, 0, iavlLoadOptions{})
+}
+
+// loadV3RootHashAt is loadV3RootHash for the root of the given version, 0 meaning the latest,
+// opening the store with loadOpts.
+func loadV3RootHashAt(path string, version int64, loadOpts iavlLoadOptions) (int64, []byte, error) {
+	v3sql, err := iavl3.NewDB(iavl3.Options{
+		Path:        path,
+		WalSize:     1024 * 1024 * 1024,
+		CacheSize:   loadOpts.sqliteCacheSize(),
+		MaxPoolSize: loadOpts.readPoolSize,
+	})
+	if err != nil {
+		return 0, nil, fmt.Errorf("open v3 store %s: %w", path, err)
+	}
+	defer v3sql.Close()
+
+	if version == 0 {
+		if version, err = v3sql.LatestVersion(); err != nil {
+			return 0, nil, fmt.Errorf("read latest version of %s: %w", path, err)
+		}
+	}
+	root, err := v3sql.LoadRoot(nodepool3.NewNodePool(), version)
+	if err != nil {
+		return 0, nil, fmt.Errorf("load root %d of %s: %w", version, path, err)
+	}
+	if root == nil {
+		return version, nil, nil
+	}
+	return version, root.Hash(), nil
+}
+
+// validateLatestRoot decodes the latest root of a migrated tree database the way iavl v3's
+// LoadRoot does, so root bytes the v3 codec can't read fail the migration instead of the
+// node's first load. A root without bytes is an empty tree and is accepted.
+func validateLatestRoot(db *sql.DB) error {
+	var (
+		version, nodeVersion int64
+		nodeSequence         int64
+		bz                   []byte
+	)
+	err := db.QueryRow("SELECT version, node_version, node_sequence, bytes FROM root ORDER BY version DESC LIMIT 1").
+		Scan(&version, &nodeVersion, &nodeSequence, &bz)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read latest root: %w", err)
+	}
+	if bz == nil {
+		return nil
+	}
+	nodeKey := inode3.NewNodeKey(nodeVersion, uint32(nodeSequence))
+	if _, err := inode3.Decode(nodepool3.NewNodePool(), nodeKey, bz); err != nil {
+		return fmt.Errorf("%w: root of version %d does not decode as a v3 node, the v2 and v3 node encodings may differ: %v",
+			ErrIncompatibleRoot, version, err)
+	}
+	return nil
+}
+
+// checkExpectedHash compares hash with the hex string expect; an empty expect accepts any hash.
+func checkExpectedHash(hash []byte, expect string) error {
+	if expect == "" {
+		return nil
+	}
+	want, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(expect), "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid --expect-hash %q: %w", expect, err)
+	}
+	if !bytes.Equal(hash, want) {
+		return fmt.Errorf("root %w: got %x, expected %x", ErrHashMismatch, hash, want)
+	}
+	return nil
+}
+
+// checkHashShallow is check-hash --shallow: it reads the latest root row of store sk from the
+// v2 and v3 tree.sqlite files with plain SQL and compares their versions and stored bytes,
+// printing the root hash embedded in them, without opening either tree through iavl. The
+// migration copies root bytes verbatim, so this catches a missing, stale or rewritten root in
+// milliseconds. Unlike the full check it trusts the hash stored in the root node: it neither
+// recomputes it from the children nor confirms the v2 library would load the same root, so a
+// pass is a first-pass signal, not a substitute for check-hash without --shallow.
+func checkHashShallow(w io.Writer, dbv2, dbv3, sk string) error {
+	v2root, err := readLatestRootRow(filepath.Join(dbv2, sk, "tree.sqlite"))
+	if err != nil {
+		return err
+	}
+	v3root, err := readLatestRootRow(filepath.Join(dbv3, sk, "tree.sqlite"))
+	if err != nil {
+		return err
+	}
+	if v2root.version != v3root.version {
+		return fmt.Errorf("%w: version not match, v2 %d, v3 %d", ErrHashMismatch, v2root.version, v3root.version)
+	}
+	if v2root.nodeVersion != v3root.nodeVersion || v2root.nodeSequence != v3root.nodeSequence || !bytes.Equal(v2root.bytes, v3root.bytes) {
+		return fmt.Errorf("%w: root rows of version %d differ: v2 node %d/%d with %d bytes, v3 node %d/%d with %d bytes", ErrHashMismatch,
+			v2root.version, v2root.nodeVersion, v2root.nodeSequence, len(v2root.bytes), v3root.nodeVersion, v3root.nodeSequence, len(v3root.bytes))
+	}
+
+	// a root without bytes is an empty tree, which has no hash
+	var hash []byte
+	if v3root.bytes != nil {
+		nodeKey := inode3.NewNodeKey(v3root.nodeVersion, uint32(v3root.nodeSequence))
+		node, err := inode3.Decode(nodepool3.NewNodePool(), nodeKey, v3root.bytes)
+		if err != nil {
+			return fmt.Errorf("%w: root of version %d does not decode as a v3 node: %v", ErrIncompatibleRoot, v3root.version, err)
+		}
+		hash = node.Hash()
+	}
+	fmt.Fprintf(w, "shallow check finished, latest version %d, stored root hash %x (root rows only; run without --shallow for a full load)\n", v3root.version, hash)
+	return nil
+}
+
+// rootRow is one row of a tree's root table.
+type rootRow struct {
+	version, nodeVersion, nodeSequence int64
+	bytes                              []byte
+}
+
+// readLatestRootRow returns the newest root row of the tree database at path, opened read-only.
+func readLatestRootRow(path string) (rootRow, error) {
+	return readRootRowAt(path, 0)
+}
+
+// readRootRowAt is readLatestRootRow for the root row of the given version, 0 meaning the newest.
+func readRootRowAt(path string, version int64) (rootRow, error) {
+	var row rootRow
+	if !fileExists(path) {
+		return row, fmt.Errorf("tree.sqlite %w: %s", ErrSourceNotFound, path)
+	}
+	db, err := sql.Open("sqlite", readonlyURI(path))
+	if err != nil {
+		return row, fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+	query, args := "SELECT version, node_version, node_sequence, bytes FROM root ORDER BY version DESC LIMIT 1", []any(nil)
+	if version > 0 {
+		query, args = "SELECT version, node_version, node_sequence, bytes FROM root WHERE version = ?", []any{version}
+	}
+	err = db.QueryRow(query, args...).Scan(&row.version, &row.nodeVersion, &row.nodeSequence, &row.bytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		if version > 0 {
+			// pruned, or beyond the latest version
+			return row, fmt.Errorf("root of version %d %w in %s", version, ErrSourceNotFound, path)
+		}
+		return row, fmt.Errorf("no root in %s", path)
+	}
+	if err != nil {
+		return row, fmt.Errorf("read root of %s: %w", path, err)
+	}
+	return row, nil
+}
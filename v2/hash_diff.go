@@ -0,0 +1,272 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	nodepool3 "github.com/SaharaLabsAI/iavl/v2/common/pool/node"
+	inode3 "github.com/SaharaLabsAI/iavl/v2/node"
+	_ "modernc.org/sqlite"
+)
+
+// hashDiffMaxNodes caps the node pairs diagnoseHashMismatch compares, so diagnosing a huge
+// tree still finishes in seconds.
+const hashDiffMaxNodes = 100_000
+
+// hashDiff is what diagnoseHashMismatch finds walking a v2 tree and its migrated v3 tree.
+type hashDiff struct {
+	version int64
+	// rootBytesEqual is set when both root rows hold the same bytes: the trees agree and only
+	// the libraries read them differently.
+	rootBytesEqual bool
+	// compared counts the node pairs compared, differing those whose bytes differ and
+	// missing the children found in only one of the trees.
+	compared, differing, missing int64
+	// origins lists the first sourceCheckMaxErrors differing nodes none of whose children
+	// differ, where a localized difference starts.
+	origins   []string
+	truncated bool
+}
+
+// verdict tells a library incompatibility or systematic encoding difference, which changes
+// every node, from corruption confined to a few subtrees.
+func (d hashDiff) verdict() string {
+	switch {
+	case d.rootBytesEqual:
+		return "both trees store identical root bytes, so the v2 and v3 libraries decode or hash the same node differently: a library version incompatibility, not corruption"
+	case d.differing == d.compared && d.missing == 0:
+		return fmt.Sprintf("all %d compared nodes differ: a systematic encoding difference between the trees, not localized corruption", d.compared)
+	default:
+		return fmt.Sprintf("%d of %d compared nodes differ, from %d subtrees: localized corruption", d.differing, d.compared, len(d.origins))
+	}
+}
+
+// print writes the diagnosis of a mismatch at d.version to w.
+func (d hashDiff) print(w io.Writer) {
+	fmt.Fprintf(w, "compared %d node pairs of version %d: %d differ, %d missing on one side", d.compared, d.version, d.differing, d.missing)
+	if d.truncated {
+		fmt.Fprintf(w, " (stopped after %d)", hashDiffMaxNodes)
+	}
+	fmt.Fprintln(w)
+	for _, origin := range d.origins {
+		fmt.Fprintf(w, "  differs from %s\n", origin)
+	}
+	fmt.Fprintf(w, "diagnosis: %s\n", d.verdict())
+}
+
+// diagnoseHashMismatch compares the trees of the given version in the v2 store directory v2Dir
+// and the migrated v3 store directory v3Dir node by node, starting at the root rows and only
+// descending into pairs whose stored bytes differ. The migration copies node bytes verbatim,
+// so any difference is one the migration or later damage introduced.
+func diagnoseHashMismatch(v2Dir, v3Dir string, version int64) (hashDiff, error) {
+	diff := hashDiff{version: version}
+	v2, err := openNodeReader(v2Dir, false)
+	if err != nil {
+		return diff, err
+	}
+	defer v2.close()
+	v3, err := openNodeReader(v3Dir, true)
+	if err != nil {
+		return diff, err
+	}
+	defer v3.close()
+
+	v2root, err := v2.root(version)
+	if err != nil {
+		return diff, err
+	}
+	v3root, err := v3.root(version)
+	if err != nil {
+		return diff, err
+	}
+	if v2root.nodeVersion == v3root.nodeVersion && v2root.nodeSequence == v3root.nodeSequence && bytes.Equal(v2root.bytes, v3root.bytes) {
+		diff.rootBytesEqual = true
+		return diff, nil
+	}
+
+	pool := nodepool3.NewNodePool()
+	walker := hashDiffWalker{v2: v2, v3: v3, diff: &diff, children: func(nk inode3.NodeKey, bz []byte) ([]inode3.NodeKey, error) {
+		node, err := inode3.Decode(pool, nk, bz)
+		if err != nil || node.IsLeaf() {
+			return nil, err
+		}
+		return []inode3.NodeKey{node.LeftNodeKey(), node.RightNodeKey()}, nil
+	}}
+	_, err = walker.compare(inode3.NewNodeKey(v2root.nodeVersion, uint32(v2root.nodeSequence)), inode3.NewNodeKey(v3root.nodeVersion, uint32(v3root.nodeSequence)),
+		v2root.bytes, v3root.bytes)
+	return diff, err
+}
+
+// hashDiffWalker walks a v2 and a v3 tree in lockstep for diagnoseHashMismatch.
+type hashDiffWalker struct {
+	v2, v3 *nodeReader
+	diff   *hashDiff
+	// children decodes a node with the v3 codec and returns its left and right child, none
+	// for a leaf.
+	children func(nk inode3.NodeKey, bz []byte) ([]inode3.NodeKey, error)
+}
+
+// compare compares the node k2 of the v2 tree, holding bz2, with the node k3 of the v3 tree,
+// holding bz3, and if they differ their children, reporting whether they differ.
+func (w *hashDiffWalker) compare(k2, k3 inode3.NodeKey, bz2, bz3 []byte) (bool, error) {
+	if w.diff.compared >= hashDiffMaxNodes {
+		w.diff.truncated = true
+		return false, nil
+	}
+	w.diff.compared++
+	if bytes.Equal(bz2, bz3) {
+		return false, nil
+	}
+	w.diff.differing++
+
+	children2, err2 := w.children(k2, bz2)
+	children3, err3 := w.children(k3, bz3)
+	switch {
+	case err2 != nil || err3 != nil:
+		w.origin(k3, fmt.Sprintf("undecodable (v2: %v, v3: %v)", err2, err3))
+		return true, nil
+	case children2 == nil || children3 == nil:
+		w.origin(k3, "leaf")
+		return true, nil
+	}
+
+	var childDiffers bool
+	for i := range children3 {
+		child := [2]inode3.NodeKey{children2[i], children3[i]}
+		c2, err := w.v2.node(child[0])
+		if err != nil {
+			return true, err
+		}
+		c3, err := w.v3.node(child[1])
+		if err != nil {
+			return true, err
+		}
+		if c2 == nil || c3 == nil {
+			w.diff.missing++
+			w.origin(child[1], fmt.Sprintf("found in v2: %t, in v3: %t", c2 != nil, c3 != nil))
+			childDiffers = true
+			continue
+		}
+		differs, err := w.compare(child[0], child[1], c2, c3)
+		if err != nil {
+			return true, err
+		}
+		childDiffers = childDiffers || differs
+	}
+	if !childDiffers && !w.diff.truncated {
+		w.origin(k3, "branch whose children match")
+	}
+	return true, nil
+}
+
+func (w *hashDiffWalker) origin(nk inode3.NodeKey, what string) {
+	if len(w.diff.origins) < sourceCheckMaxErrors {
+		w.diff.origins = append(w.diff.origins, fmt.Sprintf("node version %d sequence %d: %s", nk.Version(), nk.Sequence(), what))
+	}
+}
+
+// nodeReader looks nodes up by node key in one store's tree.sqlite, then its changelog.sqlite,
+// where the leaves are.
+type nodeReader struct {
+	tree, changelog *sql.DB
+	// tables returns the tree tables that may hold a node of the given version, in the order
+	// they are searched.
+	tables func(version int64) []string
+}
+
+// openNodeReader opens the databases of the store directory dir read-only, as a v3 store if v3
+// is set and a v2 store otherwise. Without a changelog.sqlite only the tree is searched.
+func openNodeReader(dir string, v3 bool) (*nodeReader, error) {
+	treePath := filepath.Join(dir, "tree.sqlite")
+	if !fileExists(treePath) {
+		return nil, fmt.Errorf("tree.sqlite %w: %s", ErrSourceNotFound, treePath)
+	}
+	r := &nodeReader{}
+	var err error
+	if r.tree, err = sql.Open("sqlite", readonlyURI(treePath)); err != nil {
+		return nil, fmt.Errorf("open db %s: %w", treePath, err)
+	}
+	if changelogPath := filepath.Join(dir, "changelog.sqlite"); fileExists(changelogPath) {
+		if r.changelog, err = sql.Open("sqlite", readonlyURI(changelogPath)); err != nil {
+			r.close()
+			return nil, fmt.Errorf("open db %s: %w", changelogPath, err)
+		}
+	}
+
+	shardIDs, err := listShardIDs(r.tree)
+	if err != nil {
+		r.close()
+		return nil, fmt.Errorf("%s: %w", treePath, err)
+	}
+	if v3 {
+		shards := make(map[int64]bool, len(shardIDs))
+		for _, shardID := range shardIDs {
+			shards[shardID] = true
+		}
+		r.tables = func(version int64) []string {
+			if shardID := ToShardID(version); shards[shardID] {
+				return []string{fmt.Sprintf("tree_%d", shardID)}
+			}
+			return nil
+		}
+	} else {
+		// a v2 source is normally all tree_1, but one built sharded may have any of them
+		tables := make([]string, len(shardIDs))
+		for i, shardID := range shardIDs {
+			tables[i] = fmt.Sprintf("tree_%d", shardID)
+		}
+		r.tables = func(int64) []string { return tables }
+	}
+	return r, nil
+}
+
+func (r *nodeReader) close() {
+	r.tree.Close()
+	if r.changelog != nil {
+		r.changelog.Close()
+	}
+}
+
+// root returns the root row of the given version.
+func (r *nodeReader) root(version int64) (rootRow, error) {
+	row := rootRow{version: version}
+	err := r.tree.QueryRow("SELECT node_version, node_sequence, bytes FROM root WHERE version = ?", version).
+		Scan(&row.nodeVersion, &row.nodeSequence, &row.bytes)
+	if err != nil {
+		return row, fmt.Errorf("read root %d: %w", version, err)
+	}
+	return row, nil
+}
+
+// node returns the bytes of the node nk, nil if neither database holds it.
+func (r *nodeReader) node(nk inode3.NodeKey) ([]byte, error) {
+	version, sequence := nk.Version(), int64(nk.Sequence())
+	for _, table := range r.tables(version) {
+		bz, err := queryNodeBytes(r.tree, table, version, sequence)
+		if bz != nil || err != nil {
+			return bz, err
+		}
+	}
+	if r.changelog == nil {
+		return nil, nil
+	}
+	return queryNodeBytes(r.changelog, "leaf", version, sequence)
+}
+
+// queryNodeBytes returns the bytes of a row of table at version and sequence, nil if there
+// is none.
+func queryNodeBytes(db *sql.DB, table string, version, sequence int64) ([]byte, error) {
+	var bz []byte
+	err := db.QueryRow(fmt.Sprintf("SELECT bytes FROM %s WHERE version = ? AND sequence = ? LIMIT 1", table), version, sequence).Scan(&bz)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read node %d/%d from %s: %w", version, sequence, table, err)
+	}
+	return bz, nil
+}
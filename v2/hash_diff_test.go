@@ -0,0 +1,79 @@
+package v2
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	inode3 "github.com/SaharaLabsAI/iavl/v2/node"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnoseHashMismatch(t *testing.T) {
+	// version 1 is a branch (1, 1) over the leaves (1, 2) and (1, 3)
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir)
+	root := branchNodeBytes(inode3.NewNodeKey(1, 2), inode3.NewNodeKey(1, 3))
+	execV2(t, oldDir, "tree.sqlite", "INSERT INTO root VALUES (1, 1, 1, ?)", root)
+	execV2(t, oldDir, "tree.sqlite", "INSERT INTO tree_1 VALUES (1, 1, ?, false)", root)
+	execV2(t, oldDir, "changelog.sqlite", "INSERT INTO leaf VALUES (1, 2, x'aa', x'000201aa000101', false), (1, 3, x'bb', x'000201bb000101', false)")
+
+	migrated := func(t *testing.T) string {
+		newDir := filepath.Join(t.TempDir(), "bank")
+		require.NoError(t, migrateV2Store(t, oldDir, newDir, migrateOptions{}))
+		return newDir
+	}
+	// rewriteRoot stores the root with another key, as if its hash had changed
+	rewriteRoot := func(t *testing.T, newDir string) {
+		changed := bytes.Clone(root)
+		changed[3] = 0xcc
+		execV2(t, newDir, "tree.sqlite", "UPDATE root SET bytes = ?", changed)
+		execV2(t, newDir, "tree.sqlite", "UPDATE tree_1 SET bytes = ?", changed)
+	}
+
+	t.Run("identical trees", func(t *testing.T) {
+		diff, err := diagnoseHashMismatch(oldDir, migrated(t), 1)
+		require.NoError(t, err)
+		require.True(t, diff.rootBytesEqual)
+		require.Contains(t, diff.verdict(), "library version incompatibility")
+	})
+
+	t.Run("one leaf differs", func(t *testing.T) {
+		newDir := migrated(t)
+		rewriteRoot(t, newDir)
+		execV2(t, newDir, "changelog.sqlite", "UPDATE leaf SET bytes = x'000201bb000102' WHERE sequence = 3")
+
+		diff, err := diagnoseHashMismatch(oldDir, newDir, 1)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), diff.compared)
+		require.Equal(t, int64(2), diff.differing)
+		require.Equal(t, []string{"node version 1 sequence 3: leaf"}, diff.origins)
+		require.Equal(t, "2 of 3 compared nodes differ, from 1 subtrees: localized corruption", diff.verdict())
+
+		var out bytes.Buffer
+		diff.print(&out)
+		require.Contains(t, out.String(), "compared 3 node pairs of version 1: 2 differ, 0 missing on one side\n  differs from node version 1 sequence 3: leaf\n")
+	})
+
+	t.Run("every node differs", func(t *testing.T) {
+		newDir := migrated(t)
+		rewriteRoot(t, newDir)
+		execV2(t, newDir, "changelog.sqlite", "UPDATE leaf SET bytes = bytes || x'00'")
+
+		diff, err := diagnoseHashMismatch(oldDir, newDir, 1)
+		require.NoError(t, err)
+		require.Equal(t, "all 3 compared nodes differ: a systematic encoding difference between the trees, not localized corruption", diff.verdict())
+	})
+
+	t.Run("missing leaf", func(t *testing.T) {
+		newDir := migrated(t)
+		rewriteRoot(t, newDir)
+		execV2(t, newDir, "changelog.sqlite", "DELETE FROM leaf WHERE sequence = 2")
+
+		diff, err := diagnoseHashMismatch(oldDir, newDir, 1)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), diff.missing)
+		require.Contains(t, diff.origins, "node version 1 sequence 2: found in v2: true, in v3: false")
+		require.Contains(t, diff.verdict(), "localized corruption")
+	})
+}
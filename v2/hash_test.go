@@ -0,0 +1,105 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckExpectedHash(t *testing.T) {
+	hash := []byte{0xab, 0xcd, 0xef}
+
+	require.NoError(t, checkExpectedHash(hash, ""))
+	require.NoError(t, checkExpectedHash(hash, "abcdef"))
+	require.NoError(t, checkExpectedHash(hash, " 0xABCDEF\n"))
+	require.ErrorContains(t, checkExpectedHash(hash, "abcd00"), "root hash mismatch: got abcdef, expected abcd00")
+	require.ErrorContains(t, checkExpectedHash(hash, "xyz"), "invalid --expect-hash")
+	// an empty tree only matches an empty expectation
+	require.Error(t, checkExpectedHash(nil, "abcdef"))
+}
+
+func TestIAVLLoadOptions(t *testing.T) {
+	require.Equal(t, 0, iavlLoadOptions{}.sqliteCacheSize())
+	require.Equal(t, -256*1024, iavlLoadOptions{cacheSizeMiB: 256}.sqliteCacheSize())
+	require.NoError(t, iavlLoadOptions{cacheSizeMiB: 256, readPoolSize: 4}.validate())
+	require.ErrorContains(t, iavlLoadOptions{readPoolSize: -1}.validate(), "must not be negative")
+
+	for _, cmd := range []*cobra.Command{CheckHash(), HashCommand()} {
+		require.NoError(t, cmd.ParseFlags([]string{"--cache-size", "256", "--read-pool-size", "4"}), cmd.Name())
+	}
+}
+
+func TestMigrateTreeValidateRoot(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		bytes string
+		err   error
+	}{
+		// height 0, size 1, key aa, empty hash, value 01
+		{"leaf", "000201aa000101", nil},
+		{"empty tree", "", nil},
+		{"garbage", "ff", ErrIncompatibleRoot},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			oldPath := filepath.Join(tempDir, "old_tree.sqlite")
+			newPath := filepath.Join(tempDir, "new_tree.sqlite")
+
+			oldDB, err := sql.Open("sqlite", oldPath)
+			require.NoError(t, err)
+			defer oldDB.Close()
+			_, err = oldDB.Exec(`
+				CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool);
+				CREATE TABLE root (version int, node_version int, node_sequence int, bytes blob, PRIMARY KEY (version));
+				INSERT INTO tree_1 VALUES (1, 1, x'01', false);
+				INSERT INTO root VALUES (1, 1, 1, x'ff');
+			`)
+			require.NoError(t, err)
+			var rootBytes any
+			if tc.bytes != "" {
+				rootBytes, err = hex.DecodeString(tc.bytes)
+				require.NoError(t, err)
+			}
+			// only the latest root is checked
+			_, err = oldDB.Exec("INSERT INTO root VALUES (2, 1, 1, ?)", rootBytes)
+			require.NoError(t, err)
+
+			_, err = migrateTree(oldPath, newPath, migrateOptions{validateRoot: true})
+			if tc.err == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.err)
+			require.ErrorContains(t, err, "root of version 2")
+			require.NoFileExists(t, newPath)
+		})
+	}
+}
+
+func TestCheckHashShallow(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2)
+	runV2Command(t, "start", "--iavl2-path", iavl2Path)
+
+	var buf bytes.Buffer
+	require.NoError(t, checkHashShallow(&buf, iavl2Path+".bak", iavl2Path, "bank"))
+	require.Contains(t, buf.String(), "shallow check finished, latest version 2")
+
+	db, err := sql.Open("sqlite", filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("UPDATE root SET bytes = x'000201bb000101' WHERE version = 2")
+	require.NoError(t, err)
+	err = checkHashShallow(&buf, iavl2Path+".bak", iavl2Path, "bank")
+	require.ErrorIs(t, err, ErrHashMismatch)
+	require.ErrorContains(t, err, "root rows of version 2 differ")
+
+	_, err = db.Exec("DELETE FROM root WHERE version = 2")
+	require.NoError(t, err)
+	require.ErrorContains(t, checkHashShallow(&buf, iavl2Path+".bak", iavl2Path, "bank"), "version not match, v2 2, v3 1")
+}
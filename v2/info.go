@@ -0,0 +1,148 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+func InfoCommand() *cobra.Command {
+	var (
+		path string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "info",
+		Short: "list the stores in a v2 iavl2/ directory with their version range and sizes",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			infos, err := inspectSource(path)
+			if err != nil {
+				return err
+			}
+			printStoreInfo(os.Stdout, infos)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&path, "path", "", "Path to the v2 iavl2/ directory")
+	if err := cmd.MarkFlagRequired("path"); err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+// storeInfo is the inventory of one store directory.
+type storeInfo struct {
+	store                  string
+	hasTree, hasChangelog  bool
+	minVersion, maxVersion sql.NullInt64
+	shards                 int
+	leaves                 int64
+	// bytes is the combined size of tree.sqlite and changelog.sqlite.
+	bytes int64
+}
+
+// inspectSource reads the inventory of every store under path without writing to it.
+func inspectSource(path string) ([]storeInfo, error) {
+	stores, _, err := getStoreKeys(path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]storeInfo, 0, len(stores))
+	for _, store := range stores {
+		info := storeInfo{store: store}
+
+		treePath := filepath.Join(path, store, "tree.sqlite")
+		if fi, err := os.Stat(treePath); err == nil {
+			info.hasTree = true
+			info.bytes += fi.Size()
+			if err := inspectSourceTree(treePath, &info); err != nil {
+				return nil, err
+			}
+		}
+
+		changelogPath := filepath.Join(path, store, "changelog.sqlite")
+		if fi, err := os.Stat(changelogPath); err == nil {
+			info.hasChangelog = true
+			info.bytes += fi.Size()
+			if err := inspectSourceChangelog(changelogPath, &info); err != nil {
+				return nil, err
+			}
+		}
+
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func inspectSourceTree(path string, info *storeInfo) error {
+	db, err := sql.Open("sqlite", readonlyURI(path))
+	if err != nil {
+		return fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+
+	if err := db.QueryRow("SELECT MIN(version), MAX(version) FROM root").Scan(&info.minVersion, &info.maxVersion); err != nil {
+		return fmt.Errorf("read version range from %s: %w", path, err)
+	}
+	shardIDs, err := listShardIDs(db)
+	if err != nil {
+		return fmt.Errorf("list shards of %s: %w", path, err)
+	}
+	info.shards = len(shardIDs)
+	return nil
+}
+
+func inspectSourceChangelog(path string, info *storeInfo) error {
+	db, err := sql.Open("sqlite", readonlyURI(path))
+	if err != nil {
+		return fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM leaf").Scan(&info.leaves); err != nil {
+		return fmt.Errorf("count leaves in %s: %w", path, err)
+	}
+	return nil
+}
+
+func printStoreInfo(w io.Writer, infos []storeInfo) {
+	yesNo := map[bool]string{true: "yes", false: "no"}
+
+	var totalBytes int64
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tTREE\tCHANGELOG\tVERSIONS\tSHARDS\tLEAVES\tSIZE")
+	for _, info := range infos {
+		versions := "-"
+		if info.minVersion.Valid && info.maxVersion.Valid {
+			versions = fmt.Sprintf("%d-%d", info.minVersion.Int64, info.maxVersion.Int64)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", info.store, yesNo[info.hasTree], yesNo[info.hasChangelog],
+			versions, info.shards, info.leaves, formatBytes(info.bytes))
+		totalBytes += info.bytes
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d stores, %s total\n", len(infos), formatBytes(totalBytes))
+}
+
+// formatBytes renders n with a binary unit, e.g. 1.5 GiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
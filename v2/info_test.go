@@ -0,0 +1,45 @@
+package v2
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectSource(t *testing.T) {
+	iavl2Path := t.TempDir()
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2, 500001)
+	require.NoError(t, os.MkdirAll(filepath.Join(iavl2Path, "empty"), 0o755))
+
+	infos, err := inspectSource(iavl2Path)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+
+	bank := infos[0]
+	require.Equal(t, "bank", bank.store)
+	require.True(t, bank.hasTree)
+	require.True(t, bank.hasChangelog)
+	require.Equal(t, int64(1), bank.minVersion.Int64)
+	require.Equal(t, int64(500001), bank.maxVersion.Int64)
+	require.Equal(t, 1, bank.shards)
+	require.Equal(t, int64(3), bank.leaves)
+	require.Positive(t, bank.bytes)
+
+	var buf bytes.Buffer
+	printStoreInfo(&buf, infos)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+	require.Regexp(t, `^bank\s+yes\s+yes\s+1-500001\s+1\s+3\s+\d+\.\d KiB$`, lines[1])
+	require.Regexp(t, `^empty\s+no\s+no\s+-\s+0\s+0\s+0 B$`, lines[2])
+	require.True(t, strings.HasPrefix(lines[3], "2 stores, "))
+}
+
+func TestFormatBytes(t *testing.T) {
+	require.Equal(t, "512 B", formatBytes(512))
+	require.Equal(t, "1.5 KiB", formatBytes(1536))
+	require.Equal(t, "2.0 GiB", formatBytes(2<<30))
+}
@@ -0,0 +1,98 @@
+//go:build integration
+
+// Round-trip tests against the real iavl v2 and v3 libraries. They build trees with the
+// v2 tree API, so they are slower than the SQL-level tests; run them with
+//
+//	go test -tags integration ./v2/...
+package v2
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	iavl2 "github.com/sahara/iavl"
+	"github.com/stretchr/testify/require"
+)
+
+// buildV2Tree writes a store with the iavl v2 tree API under dir: versions of sets, updates
+// and removes, so the migrated tree has several shards' worth of orphans and leaves.
+// It returns the version and root hash of the last saved version.
+func buildV2Tree(t *testing.T, dir string, versions int) (int64, []byte) {
+	pool := iavl2.NewNodePool()
+	sql, err := iavl2.NewSqliteDb(pool, iavl2.DefaultSqliteDbOptions(iavl2.SqliteDbOptions{Path: dir}))
+	require.NoError(t, err)
+	tree := iavl2.NewTree(sql, pool, iavl2.DefaultTreeOptions())
+
+	var (
+		hash    []byte
+		version int64
+	)
+	for v := 1; v <= versions; v++ {
+		for i := 0; i < 50; i++ {
+			key := []byte(fmt.Sprintf("key-%04d", (v*7+i)%200))
+			_, err := tree.Set(key, []byte(fmt.Sprintf("value-%d-%d", v, i)))
+			require.NoError(t, err)
+		}
+		if v%3 == 0 {
+			_, _, err := tree.Remove([]byte(fmt.Sprintf("key-%04d", v%200)))
+			require.NoError(t, err)
+		}
+		hash, version, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+	require.NoError(t, tree.Close())
+	return version, hash
+}
+
+func TestIntegrationRoundTrip(t *testing.T) {
+	oldBase := filepath.Join(t.TempDir(), "iavl2")
+	newBase := filepath.Join(t.TempDir(), "iavl3")
+	version, hash := buildV2Tree(t, filepath.Join(oldBase, "bank"), 20)
+
+	opts := migrateOptions{hashAlgorithm: hashAlgorithmBlake3, validateRoot: true}
+	_, err := migrateTree(filepath.Join(oldBase, "bank", "tree.sqlite"), filepath.Join(newBase, "bank", "tree.sqlite"), opts)
+	require.NoError(t, err)
+	_, err = migrateChangelog(filepath.Join(oldBase, "bank", "changelog.sqlite"), filepath.Join(newBase, "bank", "changelog.sqlite"), opts)
+	require.NoError(t, err)
+
+	v3version, v3hash, err := loadV3RootHash(filepath.Join(newBase, "bank"))
+	require.NoError(t, err)
+	require.Equal(t, version, v3version)
+	require.Equal(t, hash, v3hash)
+
+	// the check-hash command's comparison, which loads both sides through the libraries
+	require.NoError(t, checkHash(oldBase, newBase, "bank", iavlLoadOptions{}))
+	// and check-version's, for every historical version
+	for v := int64(1); v <= version; v++ {
+		require.NoError(t, checkVersion(oldBase, newBase, "bank", v, iavlLoadOptions{}), "version %d", v)
+	}
+}
+
+func TestIntegrationMigrate(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	hashes := make(map[string][]byte)
+	for _, store := range []string{"bank", "staking"} {
+		_, hashes[store] = buildV2Tree(t, filepath.Join(iavl2Path, store), 5)
+	}
+
+	require.NoError(t, migrate(iavl2Path, nil, true, migrateOptions{validateRoot: true}))
+
+	for store, hash := range hashes {
+		_, v3hash, err := loadV3RootHash(filepath.Join(iavl2Path, store))
+		require.NoError(t, err)
+		require.Equal(t, hash, v3hash, "store %s", store)
+		require.NoError(t, checkHash(iavl2Path+".bak", iavl2Path, store, iavlLoadOptions{}))
+	}
+}
+
+func TestIntegrationSelftest(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, selftest(&buf, t.TempDir(), migrateOptions{validateRoot: true}))
+	out := buf.String()
+	for _, check := range []string{"migrate", "shards", "orphans", "root hashes"} {
+		require.Contains(t, out, "PASS "+check+"\n")
+	}
+	require.NotContains(t, out, "FAIL")
+}
@@ -0,0 +1,32 @@
+package v2
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// parseKeyPrefix parses a --key-prefix value, the hex encoding of the raw key prefix with an
+// optional 0x. An empty value keeps every key and returns nil.
+func parseKeyPrefix(spec string) ([]byte, error) {
+	spec = strings.TrimPrefix(strings.TrimSpace(spec), "0x")
+	if spec == "" {
+		return nil, nil
+	}
+	prefix, err := hex.DecodeString(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --key-prefix %q: must be hex: %w", spec, err)
+	}
+	return prefix, nil
+}
+
+// dropUnmatchedLeafOrphans deletes the leaf_orphan rows whose leaf was left out by --key-prefix,
+// so the partial changelog doesn't reference leaves it doesn't hold. It returns the rows deleted.
+func dropUnmatchedLeafOrphans(db sqlExecQuerier) (int64, error) {
+	res, err := db.Exec(`DELETE FROM leaf_orphan WHERE NOT EXISTS (
+		SELECT 1 FROM leaf WHERE leaf.version = leaf_orphan.version AND leaf.sequence = leaf_orphan.sequence)`)
+	if err != nil {
+		return 0, fmt.Errorf("drop leaf orphans outside the key prefix: %w", err)
+	}
+	return res.RowsAffected()
+}
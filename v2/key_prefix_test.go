@@ -0,0 +1,67 @@
+package v2
+
+import (
+	"database/sql"
+	"hash"
+	"path/filepath"
+	"testing"
+
+	hashpool "github.com/SaharaLabsAI/iavl/v2/common/pool/hash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyPrefix(t *testing.T) {
+	prefix, err := parseKeyPrefix("")
+	require.NoError(t, err)
+	require.Nil(t, prefix)
+
+	prefix, err = parseKeyPrefix("0x02ab")
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x02, 0xab}, prefix)
+
+	_, err = parseKeyPrefix("balances")
+	require.ErrorContains(t, err, "must be hex")
+}
+
+func TestMigrateChangelogKeyPrefix(t *testing.T) {
+	tempDir := t.TempDir()
+	oldPath := filepath.Join(tempDir, "old_changelog.sqlite")
+	newPath := filepath.Join(tempDir, "new_changelog.sqlite")
+
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	defer oldDB.Close()
+	_, err = oldDB.Exec(`
+		CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+		CREATE TABLE leaf_orphan (version int, sequence int, at int);
+		INSERT INTO leaf VALUES (1, 1, x'01aa', x'01', false), (1, 2, x'02bb', x'02', false), (2, 1, x'01cc', x'03', false), (2, 2, x'02bb', x'04', false);
+		-- 02bb is rewritten at version 2, orphaning its first leaf
+		INSERT INTO leaf_orphan VALUES (1, 2, 2);
+	`)
+	require.NoError(t, err)
+
+	captureLog(t)
+	rows, err := migrateChangelog(oldPath, newPath, migrateOptions{keyPrefix: []byte{0x01}})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, rows)
+
+	newDB, err := sql.Open("sqlite", newPath)
+	require.NoError(t, err)
+	defer newDB.Close()
+
+	h := hashpool.Blake3Pool.Get().(hash.Hash)
+	defer hashpool.Blake3Pool.Put(h)
+	for _, leaf := range []struct {
+		version, sequence int64
+		key               []byte
+	}{{1, 1, []byte{0x01, 0xaa}}, {2, 1, []byte{0x01, 0xcc}}} {
+		h.Reset()
+		h.Write(leaf.key)
+		var keyHash []byte
+		require.NoError(t, newDB.QueryRow("SELECT key_hash FROM leaf WHERE version = ? AND sequence = ?", leaf.version, leaf.sequence).Scan(&keyHash))
+		require.Equal(t, h.Sum(nil), keyHash)
+	}
+	require.EqualValues(t, 2, countTableRows(t, newPath, "leaf"))
+	// the orphan of the left out 02bb leaf goes with it
+	require.EqualValues(t, 0, countTableRows(t, newPath, "leaf_orphan"))
+}
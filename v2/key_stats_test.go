@@ -0,0 +1,46 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyStats(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2)
+	createV2Store(t, filepath.Join(iavl2Path, "evm"), 1)
+	oldBase := iavl2Path + ".bak"
+
+	// a second key in bank, and a NULL key that is migrated as the empty key
+	source, err := sql.Open("sqlite", filepath.Join(iavl2Path, "bank", "changelog.sqlite"))
+	require.NoError(t, err)
+	defer source.Close()
+	_, err = source.Exec(`INSERT INTO leaf VALUES (2, 2, x'bb', x'02', false), (2, 3, NULL, x'03', false)`)
+	require.NoError(t, err)
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{}))
+
+	var out bytes.Buffer
+	require.NoError(t, keyStats(&out, oldBase, iavl2Path, nil))
+	require.Equal(t, []string{
+		"STORE  SOURCE KEYS  KEY HASHES  DELTA  STATUS",
+		"bank   3            3           +0     ok",
+		"evm    1            1           +0     ok",
+	}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+
+	// a key lost by the migration, or two keys hashing alike, leaves fewer key hashes
+	dest, err := sql.Open("sqlite", filepath.Join(iavl2Path, "bank", "changelog.sqlite"))
+	require.NoError(t, err)
+	defer dest.Close()
+	_, err = dest.Exec(`DELETE FROM leaf WHERE sequence = 3`)
+	require.NoError(t, err)
+
+	out.Reset()
+	err = keyStats(&out, oldBase, iavl2Path, []string{"bank"})
+	require.ErrorIs(t, err, ErrVerificationFailed)
+	require.Contains(t, out.String(), "bank   3            2           -1     DIFFERS")
+}
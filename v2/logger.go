@@ -0,0 +1,191 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// setLogFile points the standard logger, which all log output goes through, at the file
+// logFile, appending to it so reruns keep the earlier runs' logs. With echo the lines also
+// still go to the previous output, stderr by default. restore closes the file and points the
+// logger back.
+func setLogFile(logFile string, echo bool) (restore func(), err error) {
+	f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open log file: %w", err)
+	}
+	prev := log.Writer()
+	if echo {
+		log.SetOutput(io.MultiWriter(f, prev))
+	} else {
+		log.SetOutput(f)
+	}
+	return func() {
+		log.SetOutput(prev)
+		f.Close()
+	}, nil
+}
+
+// stdLogMu serializes the writes migrationLoggers make to log.Writer() directly, flushed
+// groups and JSON events, which bypass the standard logger's own lock. It is shared by every
+// logger, as concurrent stores each derive their own.
+var stdLogMu sync.Mutex
+
+// logFields are the structured attributes attached to a JSON log event.
+type logFields map[string]any
+
+// migrationLogger writes migration progress either as the free-form text lines
+// operators are used to, or as one JSON object per line for automated pipelines.
+// A nil *migrationLogger logs text through the standard logger.
+type migrationLogger struct {
+	json bool
+	// prefix starts every text line, e.g. "[store=bank] ", so concurrent stores can be told apart.
+	prefix string
+	fields logFields
+	// mu guards group's buffer; loggers derived from one grouped logger share it.
+	mu *sync.Mutex
+	// group, if set, holds the output until flush instead of writing it to the standard logger.
+	group *logGroup
+	// quiet drops every event; errors still reach the caller as returned errors.
+	quiet bool
+	// verbose also logs every SQL statement the migration executes, see execSQL.
+	verbose bool
+}
+
+// logGroup buffers one store's log output so it can be written contiguously.
+type logGroup struct {
+	buf    bytes.Buffer
+	logger *log.Logger
+}
+
+func newMigrationLogger(format string) (*migrationLogger, error) {
+	switch format {
+	case "", logFormatText:
+		return &migrationLogger{mu: &sync.Mutex{}}, nil
+	case logFormatJSON:
+		return &migrationLogger{json: true, mu: &sync.Mutex{}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported log format %q (supported: %s, %s)", format, logFormatText, logFormatJSON)
+	}
+}
+
+// with returns a logger that attaches fields to every JSON event it writes.
+// A "store" field also becomes the "[store=<name>] " prefix of text lines.
+func (l *migrationLogger) with(fields logFields) *migrationLogger {
+	if l == nil {
+		l = &migrationLogger{mu: &sync.Mutex{}}
+	}
+	merged := maps.Clone(l.fields)
+	if merged == nil {
+		merged = logFields{}
+	}
+	maps.Copy(merged, fields)
+	prefix := l.prefix
+	if store, ok := fields["store"]; ok {
+		prefix = fmt.Sprintf("[store=%v] ", store)
+	}
+	return &migrationLogger{json: l.json, prefix: prefix, fields: merged, mu: l.mu, group: l.group, quiet: l.quiet, verbose: l.verbose}
+}
+
+// isVerbose reports whether SQL statements are logged; a nil logger never logs them.
+func (l *migrationLogger) isVerbose() bool {
+	return l != nil && l.verbose
+}
+
+// grouped returns a logger, and loggers derived from it with with, whose output is
+// held back until flush.
+func (l *migrationLogger) grouped() *migrationLogger {
+	grouped := l.with(nil)
+	grouped.mu = &sync.Mutex{}
+	grouped.group = &logGroup{}
+	grouped.group.logger = log.New(&grouped.group.buf, log.Prefix(), log.Flags())
+	return grouped
+}
+
+// flush writes the output held back by a grouped logger to the standard logger in one piece.
+func (l *migrationLogger) flush() {
+	if l == nil || l.group == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stdLogMu.Lock()
+	log.Writer().Write(l.group.buf.Bytes())
+	stdLogMu.Unlock()
+	l.group.buf.Reset()
+}
+
+// Printf logs a free-form message, as a "message" event in JSON mode.
+func (l *migrationLogger) Printf(format string, v ...any) {
+	l.Event("message", nil, format, v...)
+}
+
+// Event logs a named event. Text mode prints only the formatted message, and
+// nothing at all when format is empty; JSON mode prints the event, its fields
+// and the message.
+func (l *migrationLogger) Event(event string, fields logFields, format string, v ...any) {
+	if l != nil && l.quiet {
+		return
+	}
+	if l == nil || !l.json {
+		if format == "" {
+			return
+		}
+		if l == nil {
+			log.Printf(format, v...)
+			return
+		}
+		msg := l.prefix + fmt.Sprintf(format, v...)
+		if l.group != nil {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.group.logger.Print(msg)
+			return
+		}
+		log.Print(msg)
+		return
+	}
+
+	entry := logFields{}
+	maps.Copy(entry, l.fields)
+	maps.Copy(entry, fields)
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["event"] = event
+	if format != "" {
+		entry["msg"] = strings.TrimSuffix(fmt.Sprintf(format, v...), "\n")
+	}
+	for k, val := range entry {
+		if err, ok := val.(error); ok {
+			entry[k] = err.Error()
+		}
+	}
+
+	bz, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("marshal log event %s: %v", event, err)
+		return
+	}
+
+	if l.group != nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.group.buf.Write(append(bz, '\n'))
+		return
+	}
+	stdLogMu.Lock()
+	defer stdLogMu.Unlock()
+	log.Writer().Write(append(bz, '\n'))
+}
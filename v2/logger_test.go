@@ -0,0 +1,129 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+func TestMigrationLoggerText(t *testing.T) {
+	buf := captureLog(t)
+
+	lg, err := newMigrationLogger(logFormatText)
+	require.NoError(t, err)
+	lg = lg.with(logFields{"store": "bank"})
+	lg.Event("tree_done", nil, "migrate tree.sqlite successfully, store: %s", "bank")
+	lg.Event("store_done", logFields{"duration_ms": 10}, "")
+
+	require.Equal(t, "[store=bank] migrate tree.sqlite successfully, store: bank\n", buf.String())
+}
+
+func TestMigrationLoggerJSON(t *testing.T) {
+	buf := captureLog(t)
+
+	lg, err := newMigrationLogger(logFormatJSON)
+	require.NoError(t, err)
+	lg = lg.with(logFields{"store": "bank"})
+	lg.Event("store_done", logFields{"duration_ms": 10}, "")
+	lg.Printf("finish migrating tree: %s\n", "a")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var event map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &event))
+	require.Equal(t, "store_done", event["event"])
+	require.Equal(t, "bank", event["store"])
+	require.Equal(t, float64(10), event["duration_ms"])
+	require.NotContains(t, event, "msg")
+
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &event))
+	require.Equal(t, "message", event["event"])
+	require.Equal(t, "finish migrating tree: a", event["msg"])
+}
+
+func TestMigrationLoggerUnsupportedFormat(t *testing.T) {
+	_, err := newMigrationLogger("xml")
+	require.ErrorContains(t, err, "unsupported log format")
+}
+
+func TestMigrationLoggerGrouped(t *testing.T) {
+	buf := captureLog(t)
+
+	lg, err := newMigrationLogger(logFormatText)
+	require.NoError(t, err)
+	bank := lg.grouped().with(logFields{"store": "bank"})
+	evm := lg.grouped().with(logFields{"store": "evm"})
+	bank.Printf("tree")
+	evm.Printf("tree")
+	bank.Printf("changelog")
+	require.Empty(t, buf.String())
+
+	bank.flush()
+	evm.Printf("changelog")
+	evm.flush()
+	require.Equal(t, "[store=bank] tree\n[store=bank] changelog\n[store=evm] tree\n[store=evm] changelog\n", buf.String())
+}
+
+func TestLogFile(t *testing.T) {
+	buf := captureLog(t)
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+	logFile := filepath.Join(t.TempDir(), "migrate.log")
+
+	runV2Command(t, "start", "--iavl2-path", iavl2Path, "--log-file", logFile)
+	bz, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	require.Contains(t, string(bz), "migrate tree.sqlite successfully")
+	require.Empty(t, buf.String())
+
+	// the next run appends, and with --log-stderr also still logs to the previous output
+	otherPath := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(otherPath, "bank"), 1)
+	runV2Command(t, "start", "--iavl2-path", otherPath, "--log-file", logFile, "--log-stderr")
+	log.Print("after the run")
+	after, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(after), string(bz)))
+	require.Greater(t, len(after), len(bz))
+	require.Equal(t, string(after[len(bz):])+"after the run\n", buf.String())
+
+	cmd := Command()
+	cmd.SetArgs([]string{"start", "--iavl2-path", otherPath, "--log-stderr"})
+	require.ErrorContains(t, cmd.Execute(), "--log-stderr only applies together with --log-file")
+	cmd = Command()
+	cmd.SetArgs([]string{"start", "--iavl2-path", otherPath, "--log-file", logFile, "--quiet"})
+	require.ErrorContains(t, cmd.Execute(), "none of the others can be")
+	// cobra checks flag groups after the pre-run hooks; the logger must not have moved yet
+	require.Equal(t, io.Writer(buf), log.Writer())
+
+	// a failed run, which cobra runs no post-run hooks for, still points the logger back
+	missing := filepath.Join(t.TempDir(), "missing")
+	for _, flags := range [][]string{{"--log-file", logFile}, {"--quiet"}} {
+		buf.Reset()
+		cmd = Command()
+		cmd.SetArgs(append([]string{"start", "--iavl2-path", missing}, flags...))
+		require.ErrorContains(t, cmd.Execute(), "not found")
+		log.Print("after the failed run")
+		require.Equal(t, "after the failed run\n", buf.String(), "%v", flags)
+	}
+}
@@ -0,0 +1,243 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Version is the tool version recorded in migration manifests. main sets it from the
+// -X main.Version linker flag; without it the module version from the build info is used.
+var Version string
+
+// toolVersion returns Version, or the main module's version and VCS revision from the
+// build info.
+func toolVersion() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			version += " " + setting.Value
+		}
+	}
+	return version
+}
+
+// iavlVersions returns the versions of the iavl v2 and v3 modules linked into the binary,
+// which decide how nodes are read and written.
+func iavlVersions() (v2, v3 string) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "", ""
+	}
+	for _, dep := range info.Deps {
+		mod := dep
+		if dep.Replace != nil {
+			mod = dep.Replace
+		}
+		switch dep.Path {
+		case "github.com/sahara/iavl":
+			v2 = mod.Path + " " + mod.Version
+		case "github.com/SaharaLabsAI/iavl/v2":
+			v3 = mod.Path + " " + mod.Version
+		}
+	}
+	return v2, v3
+}
+
+// migrationManifest is the JSON record --manifest writes after a migration.
+type migrationManifest struct {
+	ToolVersion string    `json:"tool_version"`
+	IAVLV2      string    `json:"iavl_v2,omitempty"`
+	IAVLV3      string    `json:"iavl_v3,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	DurationMS  int64     `json:"duration_ms"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	// KeyPrefix labels a run whose changelogs only hold leaves under this hex key prefix.
+	KeyPrefix string          `json:"key_prefix,omitempty"`
+	Stores    []manifestStore `json:"stores"`
+}
+
+// manifestStore is one store's entry in a migrationManifest. LatestVersion and RootHash are
+// read back from the destination with the iavl v3 library, only for stores that migrated.
+type manifestStore struct {
+	Store         string `json:"store"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+	TreeRows      int64  `json:"tree_rows"`
+	ChangelogRows int64  `json:"changelog_rows"`
+	Shards        int    `json:"shards"`
+	DurationMS    int64  `json:"duration_ms"`
+	LatestVersion int64  `json:"latest_version,omitempty"`
+	RootHash      string `json:"root_hash,omitempty"`
+}
+
+// writeManifest records the migration of the stores in results from baseOld into baseNew
+// as JSON at path, labeled with the --key-prefix the changelogs were filtered by. The file is written to a temporary name and renamed, so a crash never
+// leaves a truncated manifest behind.
+func writeManifest(path, baseOld, baseNew string, results []storeResult, started time.Time, keyPrefix []byte) error {
+	manifest := migrationManifest{
+		ToolVersion: toolVersion(),
+		StartedAt:   started.UTC(),
+		FinishedAt:  time.Now().UTC(),
+		Source:      absPath(baseOld),
+		Destination: absPath(baseNew),
+		KeyPrefix:   hex.EncodeToString(keyPrefix),
+	}
+	manifest.IAVLV2, manifest.IAVLV3 = iavlVersions()
+	manifest.DurationMS = manifest.FinishedAt.Sub(manifest.StartedAt).Milliseconds()
+
+	sorted := slices.Clone(results)
+	slices.SortFunc(sorted, func(a, b storeResult) int { return strings.Compare(a.store, b.store) })
+	for _, res := range sorted {
+		entry := manifestStore{
+			Store:         res.store,
+			Status:        res.status(),
+			TreeRows:      res.treeRows,
+			ChangelogRows: res.changelogRows,
+			Shards:        res.treeShards,
+			DurationMS:    (res.treeDuration + res.changelogDuration).Milliseconds(),
+		}
+		if res.err != nil {
+			entry.Error = res.err.Error()
+		} else {
+			version, hash, err := loadV3RootHash(filepath.Join(baseNew, res.store))
+			if err != nil {
+				return fmt.Errorf("read root hash of store %s for the manifest: %w", res.store, err)
+			}
+			entry.LatestVersion, entry.RootHash = version, hex.EncodeToString(hash)
+		}
+		manifest.Stores = append(manifest.Stores, entry)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("write manifest %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary name next to path and renames it into place, so
+// a crash never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + tmpSuffix
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+func readManifest(path string) (*migrationManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest migrationManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parse manifest %s: %w", path, err)
+	}
+	return &manifest, nil
+}
+
+func VerifyCommand() *cobra.Command {
+	var (
+		manifestPath string
+		dbPath       string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "re-check the latest versions and root hashes recorded in a migration manifest against the destination",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return verifyManifest(os.Stdout, manifestPath, dbPath)
+		},
+	}
+
+	cmd.Flags().StringVar(&manifestPath, "manifest", "", "Manifest written by start --manifest")
+	if err := cmd.MarkFlagRequired("manifest"); err != nil {
+		panic(err)
+	}
+	cmd.Flags().StringVar(&dbPath, "db-path", "", "Migrated iavl2/ directory to check (default: the manifest's destination), e.g. after copying it elsewhere")
+
+	return cmd
+}
+
+// verifyManifest loads the latest root of every store the manifest recorded as migrated from
+// dbPath, or the manifest's destination, and compares its version and hash with the record.
+func verifyManifest(w io.Writer, manifestPath, dbPath string) error {
+	manifest, err := readManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	if dbPath == "" {
+		dbPath = manifest.Destination
+	}
+
+	var diverged []string
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tVERSION\tROOT HASH\tSTATUS")
+	for _, entry := range manifest.Stores {
+		if entry.Status != "ok" {
+			fmt.Fprintf(tw, "%s\t-\t-\tskipped (%s in the manifest)\n", entry.Store, entry.Status)
+			continue
+		}
+		status := "ok"
+		version, hash, err := loadV3RootHash(filepath.Join(dbPath, entry.Store))
+		switch {
+		case err != nil:
+			status = "ERROR: " + err.Error()
+		case version != entry.LatestVersion:
+			status = fmt.Sprintf("MISMATCH: version %d, manifest %d", version, entry.LatestVersion)
+		default:
+			want, err := hex.DecodeString(entry.RootHash)
+			if err != nil {
+				status = fmt.Sprintf("ERROR: invalid root_hash in manifest: %v", err)
+			} else if !bytes.Equal(hash, want) {
+				status = fmt.Sprintf("MISMATCH: manifest %s", entry.RootHash)
+			}
+		}
+		if status != "ok" {
+			diverged = append(diverged, entry.Store)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%x\t%s\n", entry.Store, version, hash, status)
+	}
+	tw.Flush()
+
+	if len(diverged) > 0 {
+		return fmt.Errorf("%w: %d stores under %s differ from manifest %s: %v", ErrVerificationFailed, len(diverged), dbPath, manifestPath, diverged)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateManifest(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2, 500001)
+	createV2Store(t, filepath.Join(iavl2Path, "staking"), 1)
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{manifest: manifestPath}))
+
+	manifest, err := readManifest(manifestPath)
+	require.NoError(t, err)
+	require.NotEmpty(t, manifest.ToolVersion)
+	require.Equal(t, iavl2Path+".bak", manifest.Source)
+	require.Equal(t, iavl2Path, manifest.Destination)
+	require.False(t, manifest.FinishedAt.Before(manifest.StartedAt))
+	require.Len(t, manifest.Stores, 2)
+	bank := manifest.Stores[0]
+	require.Equal(t, "bank", bank.Store)
+	require.Equal(t, "ok", bank.Status)
+	require.Equal(t, int64(3), bank.TreeRows)
+	require.Equal(t, int64(3), bank.ChangelogRows)
+	require.Equal(t, 2, bank.Shards)
+	require.Equal(t, "staking", manifest.Stores[1].Store)
+	require.NoFileExists(t, manifestPath+tmpSuffix)
+
+	var buf bytes.Buffer
+	require.NoError(t, verifyManifest(&buf, manifestPath, ""))
+	require.Regexp(t, `bank\s+\d+\s+\w*\s+ok`, buf.String())
+
+	// a destination that no longer matches the record fails verification
+	manifest.Stores[1].LatestVersion = 99
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(manifestPath, data, 0o644))
+	buf.Reset()
+	err = verifyManifest(&buf, manifestPath, "")
+	require.ErrorIs(t, err, ErrVerificationFailed)
+	require.ErrorContains(t, err, "[staking]")
+	require.Contains(t, buf.String(), "MISMATCH: version")
+}
+
+func TestMigrateManifestRecordsFailures(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+	// a store without a tree fails
+	require.NoError(t, os.MkdirAll(filepath.Join(iavl2Path, "broken"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(iavl2Path, "broken", "changelog.sqlite"), nil, 0o644))
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+
+	err := migrate(iavl2Path, nil, false, migrateOptions{manifest: manifestPath, continueOnError: true})
+	require.ErrorIs(t, err, ErrPartialFailure)
+
+	manifest, err := readManifest(manifestPath)
+	require.NoError(t, err)
+	require.Len(t, manifest.Stores, 2)
+	require.Equal(t, "ok", manifest.Stores[0].Status)
+	require.Equal(t, "failed", manifest.Stores[1].Status)
+	require.Contains(t, manifest.Stores[1].Error, "tree.sqlite not found")
+
+	var buf bytes.Buffer
+	require.NoError(t, verifyManifest(&buf, manifestPath, ""))
+	require.Contains(t, buf.String(), "skipped (failed in the manifest)")
+}
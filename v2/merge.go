@@ -0,0 +1,214 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+func MergeCommand() *cobra.Command {
+	var (
+		srcs []string
+		dst  string
+		move bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "merge",
+		Short: "combine migrated iavl2/ directories holding different stores, e.g. from hosts that each migrated a subset, into one",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return mergeDestinations(os.Stdout, srcs, dst, move)
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&srcs, "src", nil, "Migrated iavl2/ directory holding some of the stores (repeatable)")
+	cmd.Flags().StringVar(&dst, "dst", "", "Directory to assemble the stores in; stores it already holds are conflicts")
+	cmd.Flags().BoolVar(&move, "move", false, "Move each store directory instead of copying it; falls back to copy and delete across filesystems")
+	for _, name := range []string{"src", "dst"} {
+		if err := cmd.MarkFlagRequired(name); err != nil {
+			panic(err)
+		}
+	}
+
+	return cmd
+}
+
+// mergeDestinations copies (or with move, moves) every store directory of the migrated
+// directories srcs into dst. Nothing is written unless every store is complete and appears
+// exactly once across srcs and dst, so a store is never silently overwritten. Each store is
+// copied to a staging directory in dst and renamed into place, so an interrupted merge leaves
+// no partial store under its real name.
+func mergeDestinations(w io.Writer, srcs []string, dst string, move bool) error {
+	owners := make(map[string][]string)
+	for _, src := range srcs {
+		if sameDir(src, dst) {
+			return fmt.Errorf("--src %s is the same directory as --dst", src)
+		}
+		stores, _, err := getStoreKeys(src, nil, nil)
+		if err != nil {
+			return fmt.Errorf("list stores of %s: %w", src, err)
+		}
+		for _, store := range stores {
+			if err := checkMergeableStore(filepath.Join(src, store)); err != nil {
+				return err
+			}
+			owners[store] = append(owners[store], src)
+		}
+	}
+	if fileExists(dst) {
+		stores, _, err := getStoreKeys(dst, nil, nil)
+		if err != nil {
+			return fmt.Errorf("list stores of %s: %w", dst, err)
+		}
+		for _, store := range stores {
+			if _, ok := owners[store]; ok {
+				owners[store] = append(owners[store], dst)
+			}
+		}
+	}
+
+	stores := make([]string, 0, len(owners))
+	var conflicts []string
+	for store, dirs := range owners {
+		stores = append(stores, store)
+		if len(dirs) > 1 {
+			conflicts = append(conflicts, fmt.Sprintf("%s (in %s)", store, strings.Join(dirs, ", ")))
+		}
+	}
+	slices.Sort(stores)
+	slices.Sort(conflicts)
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%d stores appear more than once, nothing was merged: %s", len(conflicts), strings.Join(conflicts, "; "))
+	}
+	if len(stores) == 0 {
+		return fmt.Errorf("no stores found under %v", srcs)
+	}
+
+	if err := os.MkdirAll(dst, defaultDirMode); err != nil {
+		return fmt.Errorf("create %s: %w", dst, err)
+	}
+	verb := "copied"
+	if move {
+		verb = "moved"
+	}
+	for _, store := range stores {
+		src := owners[store][0]
+		if err := mergeStore(filepath.Join(src, store), filepath.Join(dst, store), move); err != nil {
+			return fmt.Errorf("merge store %s from %s: %w", store, src, err)
+		}
+		fmt.Fprintf(w, "%s %s from %s\n", verb, store, src)
+	}
+	fmt.Fprintf(w, "merged %d stores from %d directories into %s\n", len(stores), len(srcs), dst)
+	return nil
+}
+
+// checkMergeableStore fails unless the store directory dir holds a finished migration: a
+// tree.sqlite or changelog.sqlite and no database still being written.
+func checkMergeableStore(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var hasDB bool
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, tmpSuffix) {
+			return fmt.Errorf("store %s has an unfinished %s; finish or clean up its migration first", dir, name)
+		}
+		if name == "tree.sqlite" || name == "changelog.sqlite" {
+			hasDB = true
+		}
+	}
+	if !hasDB {
+		return fmt.Errorf("store %s holds neither tree.sqlite nor changelog.sqlite", dir)
+	}
+	return nil
+}
+
+// mergeStore puts the store directory src at target, which must not exist. A move is a rename,
+// or a copy and delete across filesystems; a copy goes through a staging directory next to target.
+func mergeStore(src, target string, move bool) error {
+	if fileExists(target) {
+		return fmt.Errorf("%s appeared during the merge", target)
+	}
+	if move {
+		err := os.Rename(src, target)
+		if !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+	}
+
+	staging := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".merging")
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	if err := copyDirFiles(src, staging); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+	if err := os.Rename(staging, target); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+	if move {
+		return os.RemoveAll(src)
+	}
+	return nil
+}
+
+// copyDirFiles copies the regular files of src, such as a store's databases and any WAL,
+// into a new directory dst, syncing each one.
+func copyDirFiles(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(dst, defaultDirMode); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies src to a new file dst and syncs it.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copy %s: %w", src, err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// sameDir reports whether a and b name the same directory.
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
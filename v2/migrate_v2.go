@@ -1,21 +1,30 @@
 package v2
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"hash"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"runtime"
 	"sync"
 
 	hashpool "github.com/SaharaLabsAI/iavl/v2/common/pool/hash"
-	nodepool3 "github.com/SaharaLabsAI/iavl/v2/common/pool/node"
 	iavl3 "github.com/SaharaLabsAI/iavl/v2/db/sqlite"
 	iavl2 "github.com/sahara/iavl"
 	"github.com/spf13/cobra"
@@ -23,21 +32,163 @@ import (
 )
 
 func Command() *cobra.Command {
+	var (
+		quiet     bool
+		logFile   string
+		logStderr bool
+	)
+	// setupLog points the standard logger at the output --quiet or --log-file ask for and
+	// returns the function pointing it back
+	setupLog := func() (func(), error) {
+		if logStderr && logFile == "" {
+			return nil, fmt.Errorf("--log-stderr only applies together with --log-file")
+		}
+		if logFile != "" {
+			return setLogFile(logFile, logStderr)
+		}
+		prev := log.Writer()
+		if quiet {
+			log.SetOutput(io.Discard)
+		}
+		return func() { log.SetOutput(prev) }, nil
+	}
 	cmd := &cobra.Command{
 		Use:   "v2",
 		Short: "migrate iavl2/ from v2 to v3 in sqlite",
 	}
-	cmd.AddCommand(V2toV3Command(), CheckHash())
-	// cmd.AddCommand(V2toV3Command(), CheckHash(), FixMissingShardCommand(), CheckShardsCommand())
+	cmd.PersistentFlags().BoolVar(&quiet, "quiet", false, "Suppress log output and the migration summary; errors are still printed and reported through the exit code")
+	cmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Append log output to this file instead of writing it to stderr")
+	cmd.PersistentFlags().BoolVar(&logStderr, "log-stderr", false, "With --log-file, also still write log output to stderr")
+	cmd.MarkFlagsMutuallyExclusive("quiet", "log-file")
+	cmd.AddCommand(
+		V2toV3Command(),
+		StartFileCommand(),
+		InfoCommand(),
+		EstimateCommand(),
+		CheckHash(),
+		CheckVersionCommand(),
+		HashCommand(),
+		FixMissingShardCommand(),
+		CheckShardsCommand(),
+		CheckRootsCommand(),
+		OrphansCommand(),
+		ExportCommand(),
+		VerifySchemaCommand(),
+		CheckIndexCommand(),
+		VerifyCommand(),
+		VerifyRPCCommand(),
+		ChecksumCommand(),
+		CompareCommand(),
+		MergeCommand(),
+		DowngradeCommand(),
+		VerifyConsistencyCommand(),
+		VerifySampleCommand(),
+		KeyStatsCommand(),
+		VerifyPKCommand(),
+		VerifyPlacementCommand(),
+		VerifyLayoutCommand(),
+		VerifyLeavesCommand(),
+		BenchCommand(),
+		SelftestCommand(),
+	)
+	wrapRun(cmd, setupLog)
 	return cmd
 }
 
+// wrapRun makes the subcommands of cmd call setup before they run and the restore function it
+// returns once they did, failed or not. Persistent pre- and post-run hooks would leave the
+// standard logger redirected both when cobra rejects a command's flags, which it checks after
+// the pre-run hooks, and when RunE fails, which skips the post-run hooks.
+func wrapRun(cmd *cobra.Command, setup func() (restore func(), err error)) {
+	for _, sub := range cmd.Commands() {
+		runE := sub.RunE
+		if run := sub.Run; run != nil && runE == nil {
+			runE = func(cmd *cobra.Command, args []string) error {
+				run(cmd, args)
+				return nil
+			}
+		}
+		if runE != nil {
+			sub.Run = nil
+			sub.RunE = func(cmd *cobra.Command, args []string) error {
+				restore, err := setup()
+				if err != nil {
+					return err
+				}
+				defer restore()
+				return runE(cmd, args)
+			}
+		}
+		wrapRun(sub, setup)
+	}
+}
+
+// quietFlag reports the v2 command's persistent --quiet flag; it is false for a subcommand
+// run without its parent.
+func quietFlag(cmd *cobra.Command) bool {
+	f := cmd.Flag("quiet")
+	return f != nil && f.Value.String() == "true"
+}
+
 func V2toV3Command() *cobra.Command { // 2.0.2 --> 2.2.0
-	// e.g.: ./migrate v2 start --iavl2-path ~/.saharad/data/iavl2 --concurrent true
+	// e.g.: ./migrate v2 start --iavl2-path ~/.saharad/data/iavl2 --store-workers 4 --shard-workers 4
 	var (
-		dbV2         string
-		storeKeysStr string
-		concurrent   bool
+		dbV2          string
+		storeKeysStr  string
+		storeKeysFile string
+		appConfig     string
+		storeRegexes  []string
+		concurrent    bool
+		hashAlgorithm string
+		skipCorrupt   bool
+		ignoreMissing bool
+		lowMemory     bool
+		copyStrategy  string
+		batchSize     int
+		checkpoint    int64
+		workers       int
+		storeWorkers  int
+		shardWorkers  int
+		readonly      bool
+		maxRetries    int
+		checkFormula  bool
+		overwrite     bool
+		assumeYes     bool
+		maxShards     int64
+		force         bool
+		continueOnErr bool
+		copyUnknown   bool
+		groupedLogs   bool
+		noProgressBar bool
+		rebuildOrphan bool
+		metricsAddr   string
+		onlyTree      bool
+		onlyChangelog bool
+		validateRoot  bool
+		stamp         bool
+		fromShards    bool
+		trimOrphans   int64
+		combined      string
+		treeFile      string
+		changelogFile string
+		skipSpace     bool
+		maxOpenFiles  int64
+		busyTimeout   time.Duration
+		storeTimeout  time.Duration
+		shardList     string
+		keyPrefix     string
+		keepRawKey    bool
+		verifySource  bool
+		verifyShards  bool
+		skipEmpty     bool
+		parallel      bool
+		optimize      bool
+		vacuum        bool
+		manifest      string
+		reportFile    string
+		dirMode       string
+		verbose       bool
+		logFormat     string
 	)
 
 	cmd := &cobra.Command{
@@ -48,261 +199,1383 @@ func V2toV3Command() *cobra.Command { // 2.0.2 --> 2.2.0
 			if storeKeysStr != "" {
 				storeKeys = strings.Split(storeKeysStr, ",")
 			}
-			return migrate(dbV2, storeKeys, concurrent)
+			if storeKeysFile != "" {
+				fileKeys, err := readStoreKeysFile(storeKeysFile)
+				if err != nil {
+					return err
+				}
+				for _, key := range fileKeys {
+					if !slices.Contains(storeKeys, key) {
+						storeKeys = append(storeKeys, key)
+					}
+				}
+			}
+			shards, err := parseShardList(shardList)
+			if err != nil {
+				return err
+			}
+			prefix, err := parseKeyPrefix(keyPrefix)
+			if err != nil {
+				return err
+			}
+			mode, err := parseDirMode(dirMode)
+			if err != nil {
+				return err
+			}
+			// the deprecated --concurrent/--workers pair maps onto --store-workers
+			if concurrent && !cmd.Flags().Changed("store-workers") {
+				storeWorkers = workers
+				if storeWorkers == 0 {
+					storeWorkers = runtime.NumCPU()
+				}
+			}
+			if checkFormula {
+				if err := validateShardFormula(10_000); err != nil {
+					return err
+				}
+			}
+			// Ctrl-C / SIGTERM cancel the run; stores stop at the next batch boundary and their partial output is removed
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			// scripts pass --assume-yes; interactive runs confirm each deletion
+			var confirmIn io.Reader
+			if !assumeYes {
+				confirmIn = cmd.InOrStdin()
+			}
+			return Migrate(Options{
+				Context:             ctx,
+				IAVL2Path:           dbV2,
+				StoreKeys:           storeKeys,
+				StoreRegexes:        storeRegexes,
+				IgnoreMissing:       ignoreMissing,
+				AppConfig:           appConfig,
+				StoreWorkers:        storeWorkers,
+				ShardWorkers:        shardWorkers,
+				HashAlgorithm:       hashAlgorithm,
+				SkipCorrupt:         skipCorrupt,
+				LowMemory:           lowMemory,
+				CopyStrategy:        copyStrategy,
+				BatchSize:           batchSize,
+				CheckpointInterval:  checkpoint,
+				SourceReadonly:      readonly,
+				MaxRetries:          maxRetries,
+				Overwrite:           overwrite,
+				ConfirmIn:           confirmIn,
+				MaxShards:           maxShards,
+				Force:               force,
+				ContinueOnError:     continueOnErr,
+				CopyUnknownTables:   copyUnknown,
+				GroupedLogs:         groupedLogs,
+				ProgressBar:         !noProgressBar,
+				RebuildOrphans:      rebuildOrphan,
+				MetricsAddr:         metricsAddr,
+				OnlyTree:            onlyTree,
+				OnlyChangelog:       onlyChangelog,
+				ValidateRoot:        validateRoot,
+				Stamp:               stamp,
+				ShardSizeFromSource: fromShards,
+				TrimOrphans:         trimOrphans,
+				CombinedSource:      combined,
+				TreeFilename:        treeFile,
+				ChangelogFilename:   changelogFile,
+				SkipSpaceCheck:      skipSpace,
+				MaxOpenFiles:        maxOpenFiles,
+				BusyTimeout:         busyTimeout,
+				StoreTimeout:        storeTimeout,
+				Shards:              shards,
+				KeyPrefix:           prefix,
+				KeepRawKey:          keepRawKey,
+				VerifySource:        verifySource,
+				VerifyEachShard:     verifyShards,
+				SkipEmptyShards:     skipEmpty,
+				ParallelPhases:      parallel,
+				Optimize:            optimize,
+				Vacuum:              vacuum,
+				Manifest:            manifest,
+				ReportFile:          reportFile,
+				DirMode:             mode,
+				Quiet:               quietFlag(cmd),
+				Verbose:             verbose,
+				LogFormat:           logFormat,
+			})
 		},
 	}
 	cmd.Flags().StringVar(&dbV2, "iavl2-path", "", "Path to v2 iavl2/ directory")
 	// cmd.Flags().StringVar(&dbV3, "new-iavl2-path", "", "Path to v3 iavl3/ directory")
-	cmd.Flags().StringVar(&storeKeysStr, "store-keys", "", "Comma-separated list of store keys to migrate (default: all)")
+	cmd.Flags().StringVar(&storeKeysStr, "store-keys", "", "Comma-separated list of store keys or globs (e.g. ibc*) to migrate (default: all)")
+	cmd.Flags().StringArrayVar(&storeRegexes, "store-regex", nil, "Also migrate stores whose name matches this regular expression (repeatable)")
+	cmd.Flags().StringVar(&storeKeysFile, "store-keys-file", "", "File listing store keys to migrate, one per line ('#' starts a comment); merged with --store-keys")
+	cmd.Flags().StringVar(&appConfig, "app-config", "", "Node genesis.json whose app_state modules are the stores to migrate, intersected with the directories on disk (default: every directory)")
+	cmd.Flags().BoolVar(&ignoreMissing, "ignore-missing", false, "Skip requested store keys that have no directory under --iavl2-path instead of failing")
+	cmd.Flags().IntVar(&storeWorkers, "store-workers", 1, "Stores migrated at once")
+	cmd.Flags().IntVar(&shardWorkers, "shard-workers", 1, "Tree shards copied at once, shared by all stores being migrated; each stages its shard in a separate file next to the destination")
 	cmd.Flags().BoolVar(&concurrent, "concurrent", false, "Enable concurrent migration of stores (default: false)")
+	cmd.Flags().IntVar(&workers, "workers", 0, "Maximum stores migrated at once with --concurrent (default: number of CPUs)")
+	if err := cmd.Flags().MarkDeprecated("concurrent", "use --store-workers instead"); err != nil {
+		panic(err)
+	}
+	if err := cmd.Flags().MarkDeprecated("workers", "use --store-workers instead"); err != nil {
+		panic(err)
+	}
+	cmd.Flags().StringVar(&hashAlgorithm, "hash-algorithm", hashAlgorithmBlake3, "Hash algorithm used to compute changelog key_hash (blake3, sha256)")
+	cmd.Flags().BoolVar(&skipCorrupt, "skip-corrupt", false, "Skip and report rows that fail to read or insert instead of aborting the store")
+	cmd.Flags().BoolVar(&lowMemory, "low-memory", false, "Copy tree shards and orphan tables with a cursor in bounded batches instead of one INSERT ... SELECT each, trading speed for flat memory/temp usage; duplicate orphan rows are dropped")
+	cmd.Flags().StringVar(&copyStrategy, "copy-strategy", "", "How tree shards and orphan tables are copied: attach (one INSERT ... SELECT each, the default) or stream (bounded batches, same as --low-memory); changelog leaves are always streamed")
+	cmd.Flags().IntVar(&batchSize, "batch-size", lowMemoryBatchSize, "Rows per batch of the --low-memory copies")
+	cmd.Flags().Int64Var(&checkpoint, "checkpoint-interval", 0, "Commit each changelog's leaf copy every this many rows instead of in one transaction, bounding its journal; a killed run then leaves a partly committed .tmp file, which the next run deletes (0: one transaction)")
+	cmd.Flags().BoolVar(&readonly, "source-readonly", true, "Open the v2 source read-only and immutable (no locks); refuses sources with an un-checkpointed -wal file")
+	cmd.Flags().BoolVar(&verifySource, "verify-source", false, "Run SQLite's quick_check on each source tree.sqlite/changelog.sqlite first, check each tree has one root per version, and refuse a store whose source is corrupt")
+	cmd.Flags().BoolVar(&verifyShards, "verify-after-each-shard", false, "Compare each tree shard's row count with its source version range right after writing it and fail the store on the first difference")
+	cmd.MarkFlagsMutuallyExclusive("verify-after-each-shard", "skip-corrupt")
+	cmd.Flags().BoolVar(&skipEmpty, "skip-empty-shards", false, "Don't create tree_N tables whose version range has no source rows; iavl v3 rollback fails on such a gap until fix-missing-shard recreates them")
+	cmd.Flags().BoolVar(&parallel, "parallel-phases", false, "Migrate each store's tree.sqlite and changelog.sqlite at once instead of one after the other; phases of concurrent stores share --store-workers slots")
+	cmd.Flags().IntVar(&maxRetries, "max-retries", 0, "Retry a store this many times, with exponential backoff, when it fails with a transient SQLite error (busy, locked, I/O)")
+	cmd.Flags().DurationVar(&busyTimeout, "busy-timeout", defaultBusyTimeout, "How long each SQLite connection waits on a locked database before failing with SQLITE_BUSY (0 fails at once)")
+	cmd.Flags().DurationVar(&storeTimeout, "store-timeout", 0, "Abort a store whose migration runs longer than this, e.g. 2h, removing its partial output while the other stores carry on (0 never times out)")
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "Replace destination tree.sqlite/changelog.sqlite files that already exist instead of failing")
+	cmd.Flags().BoolVarP(&assumeYes, "assume-yes", "y", false, "Delete files such as destinations replaced by --overwrite without listing them and asking first")
+	cmd.Flags().Int64Var(&maxShards, "max-shards", defaultMaxShards, "Refuse a store whose tree version range spans more shard tables than this, which usually means a corrupt version (see --force)")
+	cmd.Flags().BoolVar(&force, "force", false, "Migrate stores even if their version range exceeds --max-shards")
+	cmd.Flags().BoolVar(&continueOnErr, "continue-on-error", false, "Keep migrating the remaining stores after one fails and report every failure at the end (default: stop at the first failure)")
+	cmd.Flags().BoolVar(&copyUnknown, "copy-unknown-tables", false, "Copy source tables other than tree_1/root/orphan and leaf/leaf_orphan verbatim instead of only logging them")
+	cmd.Flags().BoolVar(&rebuildOrphan, "rebuild-orphans", false, "If a source changelog has no leaf_orphan table, reconstruct it from later writes of the same key (best-effort)")
+	cmd.Flags().Int64Var(&trimOrphans, "trim-orphans", 0, "Skip orphan rows with at below this version to shrink the destination; capped at each store's earliest version with a root (0 keeps all)")
+	cmd.Flags().StringVar(&combined, "combined-source", "", "Read each store's tree and changelog tables from this one file in the store directory (e.g. "+defaultCombinedSource+"); "+defaultCombinedSource+" is picked up automatically when tree.sqlite and changelog.sqlite are both missing")
+	cmd.Flags().StringVar(&treeFile, "tree-filename", defaultTreeFilename, "Name of the tree database in each source and destination store directory")
+	cmd.Flags().StringVar(&changelogFile, "changelog-filename", defaultChangelogFilename, "Name of the changelog database in each source and destination store directory")
+	cmd.Flags().BoolVar(&skipSpace, "skip-space-check", false, "Start even if the destination filesystem has less free space than the migration is estimated to need")
+	cmd.Flags().Int64Var(&maxOpenFiles, "max-open-files", 0, "Open files to plan --store-workers against instead of the process's RLIMIT_NOFILE; fewer stores run at once if the workers would need more (0: the limit)")
+	cmd.Flags().BoolVar(&onlyTree, "only-tree", false, "Migrate only tree.sqlite; an existing destination changelog.sqlite is kept. Runs from an existing iavl2.bak/ if there is one")
+	cmd.Flags().BoolVar(&onlyChangelog, "only-changelog", false, "Migrate only changelog.sqlite; an existing destination tree.sqlite is kept. Runs from an existing iavl2.bak/ if there is one")
+	cmd.MarkFlagsMutuallyExclusive("only-tree", "only-changelog")
+	cmd.Flags().BoolVar(&validateRoot, "validate-root", true, "Check that the latest migrated root decodes as a v3 node before keeping the tree")
+	cmd.Flags().BoolVar(&stamp, "stamp", true, "Record the tool version, source format, shard size and hash algorithm in a migration_meta table of each migrated database, for later checks to read")
+	cmd.Flags().BoolVar(&fromShards, "shard-size-from-source", false, "Read sources already split into tree_N tables, inferring and checking their shard size; the destination still uses iavl v3's shard size")
+	cmd.Flags().BoolVar(&optimize, "optimize", false, "Run ANALYZE on each migrated tree.sqlite/changelog.sqlite so iavl v3's first queries have planner statistics")
+	cmd.Flags().BoolVar(&vacuum, "vacuum", false, "Also VACUUM each migrated database after --optimize (implies it), logging the size before and after; needs free space for a copy of the largest file")
+	cmd.Flags().StringVar(&shardList, "shards", "", "Only create and fill these tree_N shards, e.g. 3,5-7; each must be in every migrated store's shard range. root, orphans and the changelog are still migrated in full")
+	cmd.Flags().StringVar(&keyPrefix, "key-prefix", "", "Only migrate changelog leaves whose raw key starts with this hex prefix, e.g. 02; the result is not a full state and can't back a node")
+	cmd.Flags().BoolVar(&keepRawKey, "keep-raw-key", false, "Also store each leaf's original key in an extra key column of the destination leaf table, for tooling that needs it; iavl v3 ignores the column")
+	cmd.Flags().BoolVar(&checkFormula, "check-shard-formula", false, "Before migrating, verify the shard ID formula matches the linked iavl v3 library")
+	cmd.Flags().StringVar(&manifest, "manifest", "", "After the run, write a JSON manifest of the tool version, paths, timings and each store's result, latest version and root hash to this file (re-check it with verify --manifest)")
+	cmd.Flags().StringVar(&dirMode, "dir-mode", "750", "Octal permissions of the directories created for the migrated stores")
+	cmd.Flags().BoolVar(&verbose, "verbose", false, "Log every SQL statement run on the destinations, including shard CREATE/INSERT and ATTACH, with the rows it affected")
+	cmd.Flags().StringVar(&reportFile, "report-file", "", "After the run, even a failed one, write each store's status, error, tree and changelog rows and duration to this file: JSON if it ends in .json, CSV otherwise")
+	cmd.Flags().StringVar(&logFormat, "log-format", logFormatText, "Log output format (text, json)")
+	cmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address (e.g. :9090) under /metrics while migrating")
+	cmd.Flags().BoolVar(&groupedLogs, "grouped-logs", false, "Hold back each store's log output and print it in one block when the store finishes")
+	cmd.Flags().BoolVar(&noProgressBar, "no-progress-bar", false, "Log as usual instead of redrawing a progress line per store when stdout is a terminal")
 	cmd.MarkFlagRequired("iavl2-path")
 	return cmd
 }
 
-func migrate(iavl2Path string, storeKeys []string, concurrent bool) error {
+// migrateOptions carries the tunables shared by every store migration.
+type migrateOptions struct {
+	// ctx interrupts the migration between stores, shards and row batches; nil never cancels.
+	ctx context.Context
+	// hashAlgorithm selects the hash used for the changelog key_hash column.
+	hashAlgorithm string
+	// skipCorrupt copies rows one at a time, skipping and reporting the ones
+	// that cannot be read or inserted instead of failing the store.
+	skipCorrupt bool
+	// storeRegexes selects additional stores by regular expression, on top of
+	// the store keys passed to migrate.
+	storeRegexes []string
+	// ignoreMissing lets a store key filter name stores that don't exist
+	// in the source; by default that is an error.
+	ignoreMissing bool
+	// appConfig is the node's genesis.json; if set, only the stores it registers are
+	// migrated, instead of every directory or the store key filter.
+	appConfig string
+	// lowMemory streams tree shard rows in bounded batches instead of
+	// materializing a ROW_NUMBER() window over each shard, and orphan rows
+	// instead of copying them with one INSERT ... SELECT, dropping duplicates.
+	// skipCorrupt already copies shard rows one by one and takes precedence.
+	lowMemory bool
+	// batchSize is the rows per batch of the lowMemory copies; 0 means lowMemoryBatchSize.
+	batchSize int
+	// checkpointInterval commits the changelog's leaf copy every so many rows instead of in
+	// the one transaction that holds the whole changelog; 0 never does. Tree shards are
+	// already committed one by one.
+	checkpointInterval int64
+	// workers caps concurrent store migrations; 0 means runtime.NumCPU().
+	workers int
+	// shardWorkers is how many tree shards are staged at once; 1 or less copies them in
+	// order. skipCorrupt always copies in order, since its report is written row by row.
+	shardWorkers int
+	// shardSlots bounds the shard workers of all stores migrating at once, and with them
+	// the SQLite connections they open; nil gives each tree its own shardWorkers slots.
+	shardSlots chan struct{}
+	// parallelPhases runs each store's tree and changelog phases at once instead of one
+	// after the other; --only-tree and --only-changelog run a single phase regardless.
+	parallelPhases bool
+	// phaseSlots bounds the parallel phases of all stores migrating at once, and with them
+	// the SQLite connections they open; nil leaves them unbounded.
+	phaseSlots chan struct{}
+	// sourceReadonly opens the v2 databases with mode=ro&immutable=1.
+	sourceReadonly bool
+	// maxRetries is how often a store failing with a transient SQLite error is retried.
+	maxRetries int
+	// storeTimeout cancels each attempt at migrating a store that runs longer; 0 never does.
+	// Other stores keep going, as if continueOnError were set for that store.
+	storeTimeout time.Duration
+	// busyTimeout is the busy_timeout of every source and destination connection; 0 doesn't wait.
+	busyTimeout time.Duration
+	// verifySource runs quick_check on each source database and checkRootVersions on each
+	// source tree, and refuses a corrupt one.
+	verifySource bool
+	// verifyEachShard compares each tree shard's row count with its source version range as
+	// soon as it is written and fails the tree on the first difference.
+	verifyEachShard bool
+	// manifest is where migrate writes its JSON manifest; empty writes none.
+	manifest string
+	// reportFile is where migrate writes each store's final status, as JSON or CSV by
+	// extension; empty writes none.
+	reportFile string
+	// optimize runs ANALYZE on each migrated database; vacuum also runs VACUUM and implies it.
+	optimize bool
+	vacuum   bool
+	// overwrite replaces existing destination files; otherwise they are an error.
+	overwrite bool
+	// maxShards is the most shard tables a store's version range may need before
+	// it is treated as corrupt; 0 means defaultMaxShards.
+	maxShards int64
+	// force migrates stores that exceed maxShards anyway.
+	force bool
+	// continueOnError migrates every store even after one fails and returns all
+	// failures joined; otherwise the first failure stops the run.
+	continueOnError bool
+	// copyUnknownTables copies source tables the migration doesn't know verbatim;
+	// otherwise they are only logged.
+	copyUnknownTables bool
+	// groupedLogs buffers each store's log output and writes it in one block
+	// when the store finishes.
+	groupedLogs bool
+	// rebuildOrphans reconstructs a missing leaf_orphan table from the changelog.
+	rebuildOrphans bool
+	// onlyTree and onlyChangelog run a single phase of migrateStore and leave the other
+	// phase's destination alone.
+	onlyTree      bool
+	onlyChangelog bool
+	// validateRoot decodes the latest migrated root with the v3 codec and fails
+	// the tree if it can't be read.
+	validateRoot bool
+	// stamp records the tool version, source format, shard size and hash algorithm in a
+	// migration_meta table of each destination database.
+	stamp bool
+	// shardSizeFromSource reads a source already split into tree_N tables, inferring and
+	// checking its shard size, instead of refusing it.
+	shardSizeFromSource bool
+	// trimOrphans drops orphan rows with at below this version, capped at each store's
+	// earliest live version; 0 keeps them all.
+	trimOrphans int64
+	// skipSpaceCheck skips comparing the estimated destination size with the free disk space.
+	skipSpaceCheck bool
+	// maxOpenFiles is the file descriptors store workers are planned against; 0 reads RLIMIT_NOFILE.
+	maxOpenFiles int64
+	// appendMode adds the source versions newer than an existing destination's to it
+	// instead of refusing or replacing the destination.
+	appendMode bool
+	// appendAfter is the destination's latest version while appending; only later
+	// versions are copied. 0 copies everything.
+	appendAfter int64
+	// keepRawKey adds a key column to the destination leaf table holding each leaf's original
+	// key next to its key_hash. iavl v3 ignores the column; it is there for other tooling.
+	keepRawKey bool
+	// keyPrefix keeps only changelog leaves whose raw key starts with it, and their leaf
+	// orphans; the tree is migrated in full. nil keeps every leaf.
+	keyPrefix []byte
+	// shards restricts the tree_N tables created and filled to these shard IDs, which must
+	// lie in the store's shard range; root and orphans are still copied in full. nil means all.
+	shards []int64
+	// skipEmptyShards leaves out the tree_N tables whose version range has no source rows,
+	// e.g. gaps left by pruning, instead of creating them empty.
+	skipEmptyShards bool
+	// quiet leaves out the migration summary; the logger is silenced separately.
+	quiet bool
+	// combinedSource names a file in each store directory holding both the tree and the
+	// changelog tables; without it stores fall back to defaultCombinedSource only when
+	// tree.sqlite and changelog.sqlite are both missing.
+	combinedSource string
+	// combined is set by migrateStore when the store's tree and changelog are read from
+	// one file, so neither phase treats the other's tables as unknown.
+	combined bool
+	// dirMode is the permission of the directories created for the destination; 0 means
+	// defaultDirMode.
+	dirMode os.FileMode
+	// treeFile and changelogFile name the tree and changelog databases in each store
+	// directory, of both the source and the destination; empty means defaultTreeFilename
+	// and defaultChangelogFilename.
+	treeFile, changelogFile string
+	// confirmIn is where the operator confirms deleting existing files, such as destinations
+	// replaced by overwrite; nil, as with --assume-yes or the API, deletes without asking.
+	confirmIn *bufio.Reader
+	// logger receives progress output; nil logs plain text.
+	logger *migrationLogger
+	// metrics counts progress for --metrics-addr; nil records nothing.
+	metrics *migrationMetrics
+	// progressBar has migrate draw a progress line per store instead of logging to the
+	// terminal, if stdout is one.
+	progressBar bool
+	// progress is the display progressBar started, nil if none; storeProgress counts the
+	// rows of the store being migrated on it.
+	progress      *progressTracker
+	storeProgress *storeProgress
+}
+
+// context returns opts.ctx, defaulting to context.Background().
+func (opts migrateOptions) context() context.Context {
+	if opts.ctx == nil {
+		return context.Background()
+	}
+	return opts.ctx
+}
+
+// Default names of the tree and changelog databases in a store directory.
+const (
+	defaultTreeFilename      = "tree.sqlite"
+	defaultChangelogFilename = "changelog.sqlite"
+)
+
+// defaultDirMode keeps the migrated stores away from other users, as a node's data directory should be.
+const defaultDirMode os.FileMode = 0o750
+
+// dirPerm returns opts.dirMode, defaulting to defaultDirMode.
+func (opts migrateOptions) dirPerm() os.FileMode {
+	if opts.dirMode == 0 {
+		return defaultDirMode
+	}
+	return opts.dirMode
+}
+
+// parseDirMode parses an octal permission such as "750" or "0o750" for --dir-mode.
+func parseDirMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(s, "0o"), "0O"), 8, 32)
+	if err != nil || mode == 0 || mode > 0o777 {
+		return 0, fmt.Errorf("invalid directory mode %q: must be octal permission bits such as 750", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// treeFilename returns opts.treeFile, defaulting to defaultTreeFilename.
+func (opts migrateOptions) treeFilename() string {
+	if opts.treeFile == "" {
+		return defaultTreeFilename
+	}
+	return opts.treeFile
+}
+
+// changelogFilename returns opts.changelogFile, defaulting to defaultChangelogFilename.
+func (opts migrateOptions) changelogFilename() string {
+	if opts.changelogFile == "" {
+		return defaultChangelogFilename
+	}
+	return opts.changelogFile
+}
+
+// checkStoreFilenames fails unless the custom tree and changelog filenames, where set, are
+// plain names of distinct files in a store directory.
+func checkStoreFilenames(tree, changelog string) error {
+	for _, name := range []string{tree, changelog} {
+		if name != "" && (name != filepath.Base(name) || name == "." || name == "..") {
+			return fmt.Errorf("invalid database filename %q: must be a file name without a directory", name)
+		}
+	}
+	if tree == "" {
+		tree = defaultTreeFilename
+	}
+	if changelog == "" {
+		changelog = defaultChangelogFilename
+	}
+	if tree == changelog {
+		return fmt.Errorf("the tree and changelog filenames are both %q; use --combined-source for a source holding both", tree)
+	}
+	return nil
+}
+
+// phase names the phases migrateStore runs.
+func (opts migrateOptions) phase() string {
+	switch {
+	case opts.onlyTree:
+		return opts.treeFilename()
+	case opts.onlyChangelog:
+		return opts.changelogFilename()
+	}
+	return opts.treeFilename() + " and " + opts.changelogFilename()
+}
+
+// rowBatchSize returns opts.batchSize, defaulting to lowMemoryBatchSize.
+func (opts migrateOptions) rowBatchSize() int {
+	if opts.batchSize <= 0 {
+		return lowMemoryBatchSize
+	}
+	return opts.batchSize
+}
+
+// shardLimit returns opts.maxShards, defaulting to defaultMaxShards.
+func (opts migrateOptions) shardLimit() int64 {
+	if opts.maxShards <= 0 {
+		return defaultMaxShards
+	}
+	return opts.maxShards
+}
+
+// defaultMaxShards is 5 billion versions at 500k versions per shard, far beyond any real chain.
+const defaultMaxShards = 10_000
+
+// defaultBusyTimeout is the --busy-timeout of the start and start-file commands: long enough
+// to ride out another store's checkpoint or a concurrent reader, short of hiding a real deadlock.
+const defaultBusyTimeout = 5 * time.Second
+
+// cancelCheckInterval is how many rows the row-by-row copies process between context checks.
+const cancelCheckInterval = 10_000
+
+func migrate(iavl2Path string, storeKeys []string, concurrent bool, opts migrateOptions) (err error) {
+	// Reject bad options before touching the source directory
+	if _, err := keyHashPool(opts.hashAlgorithm); err != nil {
+		return err
+	}
+	if opts.workers < 0 {
+		return fmt.Errorf("workers must be positive, got %d", opts.workers)
+	}
+	if opts.shardWorkers < 0 {
+		return fmt.Errorf("shard-workers must be positive, got %d", opts.shardWorkers)
+	}
+	if opts.maxRetries < 0 {
+		return fmt.Errorf("max-retries must not be negative, got %d", opts.maxRetries)
+	}
+	if opts.batchSize < 0 {
+		return fmt.Errorf("batch-size must be positive, got %d", opts.batchSize)
+	}
+	if opts.checkpointInterval < 0 {
+		return fmt.Errorf("checkpoint-interval must not be negative, got %d", opts.checkpointInterval)
+	}
+	if opts.storeTimeout < 0 {
+		return fmt.Errorf("store-timeout must not be negative, got %s", opts.storeTimeout)
+	}
+	if opts.maxOpenFiles < 0 {
+		return fmt.Errorf("max-open-files must not be negative, got %d", opts.maxOpenFiles)
+	}
+	if opts.onlyTree && opts.onlyChangelog {
+		return errors.New("only-tree and only-changelog are mutually exclusive")
+	}
+	if opts.appConfig != "" && (len(storeKeys) > 0 || len(opts.storeRegexes) > 0) {
+		return errors.New("app-config selects the stores itself and can't be combined with store keys or store regexes")
+	}
+	if err := checkNoRunningNode(filepath.Dir(iavl2Path)); err != nil {
+		return err
+	}
 
 	// Prepare directories: move the original directory to backup and create a fresh one
 	baseNew := iavl2Path
 	baseOld := iavl2Path + ".bak"
 
-	// Ensure backup does not already exist
+	// Ensure backup does not already exist; re-running a single phase migrates from the existing backup instead
+	rerun := false
 	if _, err := os.Stat(baseOld); err == nil {
-		return fmt.Errorf("backup path already exists: %s", baseOld)
+		if !opts.onlyTree && !opts.onlyChangelog {
+			return fmt.Errorf("backup path already exists: %s", baseOld)
+		}
+		rerun = true
 	} else if !errors.Is(err, os.ErrNotExist) {
 		return fmt.Errorf("stat backup path %s: %w", baseOld, err)
 	}
+	source := iavl2Path
+	if rerun {
+		source = baseOld
+	}
 
 	// Ensure source exists and rename to backup
-	if _, err := os.Stat(iavl2Path); err != nil {
-		return fmt.Errorf("source path %s not found to backup: %w", iavl2Path, err)
+	if _, err := os.Stat(source); err != nil {
+		return fmt.Errorf("source path %s not found to backup: %w", source, err)
 	}
-	log.Printf("renaming %s to %s", iavl2Path, baseOld)
-	if err := os.Rename(iavl2Path, baseOld); err != nil {
-		return fmt.Errorf("rename %s to %s: %w", iavl2Path, baseOld, err)
+	lg := opts.logger
+
+	// Resolve the store filter before moving anything, so a mistyped key fails cleanly
+	var stores, missing []string
+	if opts.appConfig != "" {
+		stores, err = appConfigStores(source, opts.appConfig, lg)
+	} else {
+		stores, missing, err = getStoreKeys(source, storeKeys, opts.storeRegexes)
+	}
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		if !opts.ignoreMissing {
+			return fmt.Errorf("store keys not found under %s: %v (pass --ignore-missing to skip them)", source, missing)
+		}
+		lg.Event("missing_store_keys", logFields{"stores": missing}, "WARNING: skipping store keys not found under %s: %v", source, missing)
+	}
+
+	// The destination shares the source's filesystem, which keeps the original as the backup
+	var sources []string
+	for _, store := range stores {
+		sources = append(sources, storeSourcePaths(source, store, opts)...)
+	}
+	if err := checkDiskSpace(baseNew, sources, opts); err != nil {
+		return err
+	}
+
+	maxWorkers := 1
+	if concurrent {
+		maxWorkers = opts.workers
+		if maxWorkers == 0 {
+			maxWorkers = runtime.NumCPU()
+		}
+		// No point holding slots for more goroutines than there are stores
+		maxWorkers = max(min(maxWorkers, len(stores)), 1)
+	}
+	if maxWorkers, err = checkOpenFiles(maxWorkers, opts); err != nil {
+		return err
+	}
+
+	// Re-running a phase with --overwrite replaces that phase's earlier output
+	if rerun && opts.overwrite {
+		var replaced []string
+		for _, store := range stores {
+			if !opts.onlyChangelog {
+				replaced = append(replaced, existingFiles(filepath.Join(baseNew, store, opts.treeFilename()))...)
+			}
+			if !opts.onlyTree {
+				replaced = append(replaced, existingFiles(filepath.Join(baseNew, store, opts.changelogFilename()))...)
+			}
+		}
+		if err := confirmDeletion(opts.confirmIn, os.Stdout, replaced); err != nil {
+			return err
+		}
+	}
+
+	if rerun {
+		lg.Event("rerun", logFields{"from": baseOld, "to": baseNew}, "backup %s already exists, re-running %s from it into %s", baseOld, opts.phase(), baseNew)
+	} else {
+		lg.Event("backup", logFields{"from": iavl2Path, "to": baseOld}, "renaming %s to %s", iavl2Path, baseOld)
+		if err := os.Rename(iavl2Path, baseOld); err != nil {
+			return fmt.Errorf("rename %s to %s: %w", iavl2Path, baseOld, err)
+		}
 	}
 
 	// Create new empty target directory
-	if err := os.MkdirAll(baseNew, 0o777); err != nil {
+	if err := os.MkdirAll(baseNew, opts.dirPerm()); err != nil {
 		return fmt.Errorf("create new path %s: %w", baseNew, err)
 	}
-	stores, err := getStoreKeys(baseOld, storeKeys)
-	if err != nil {
-		return err
+	lg.Event("stores", logFields{"stores": stores}, "stores to migrate: %v", stores)
+	opts.metrics.addStores(len(stores))
+	if opts.progressBar && !opts.quiet && (lg == nil || !lg.json) && isTerminal(os.Stdout) {
+		opts.progress = startProgressTracker(os.Stdout, len(stores))
 	}
-	log.Printf("stores to migrate: %v", stores)
+
+	runStart := time.Now()
+	var (
+		results   []storeResult
+		resultsMu sync.Mutex
+	)
+	record := func(res storeResult, err error) {
+		res.err = err
+		resultsMu.Lock()
+		results = append(results, res)
+		resultsMu.Unlock()
+	}
+	defer func() {
+		opts.progress.stopAndFlush()
+		if err == nil {
+			warnDanglingSidecars(baseNew, stores, opts)
+		}
+		if !opts.quiet {
+			printMigrationSummary(os.Stdout, results, time.Since(runStart))
+		}
+		// like the manifest, the report records failed runs too
+		if opts.reportFile != "" {
+			if werr := writeReport(opts.reportFile, stores, results); werr != nil {
+				err = errors.Join(err, werr)
+			} else {
+				lg.Event("report", logFields{"path": opts.reportFile}, "wrote store report %s", opts.reportFile)
+			}
+		}
+		if opts.manifest == "" {
+			return
+		}
+		// written even when stores failed, recording which
+		if werr := writeManifest(opts.manifest, baseOld, baseNew, results, runStart, opts.keyPrefix); werr != nil {
+			err = errors.Join(err, werr)
+			return
+		}
+		lg.Event("manifest", logFields{"path": opts.manifest}, "wrote migration manifest %s", opts.manifest)
+	}()
+
+	if opts.shardWorkers > 1 {
+		// one pool across stores, so store workers × shard workers can't exhaust file descriptors
+		opts.shardSlots = make(chan struct{}, opts.shardWorkers)
+	}
+	ctx := opts.context()
 	if !concurrent {
 		for _, store := range stores {
-			if err := migrateStore(store, baseOld, baseNew); err != nil {
-				return err
+			if ctx.Err() != nil {
+				break
+			}
+			res, err := migrateStoreWithRetry(store, baseOld, baseNew, opts)
+			record(res, err)
+			if err != nil && ctx.Err() == nil && !opts.continueOnError && !errors.Is(err, ErrStoreTimeout) {
+				return partialFailure(results, err)
 			}
 		}
-		return nil
+		if err := cleanupInterrupted(ctx, baseNew, results, opts); err != nil {
+			return err
+		}
+		return joinStoreErrors(results)
+	}
+
+	lg.Event("workers", logFields{"workers": maxWorkers}, "migrate concurrently, max workers %d", maxWorkers)
+	if opts.shardWorkers > 1 {
+		lg.Event("shard_workers", logFields{"shard_workers": opts.shardWorkers},
+			"stores share %d shard workers", opts.shardWorkers)
+	}
+	// Without --continue-on-error the first failing store cancels the others through runCtx
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	storeOpts := opts
+	storeOpts.ctx = runCtx
+	if opts.parallelPhases {
+		// as many phases as store workers, so overlapping phases open no more connections than
+		// --store-workers alone; each store still overlaps its phases once fewer stores remain
+		storeOpts.phaseSlots = make(chan struct{}, max(maxWorkers, 2))
+		lg.Event("parallel_phases", logFields{"phases": cap(storeOpts.phaseSlots)},
+			"stores share %d slots for parallel tree and changelog phases", cap(storeOpts.phaseSlots))
 	}
 
-	maxWorkers := runtime.NumCPU()
-	log.Printf("migrate concurrently, max workers %d", maxWorkers)
 	sem := make(chan struct{}, maxWorkers)
 	var wg sync.WaitGroup
 	var firstErr error
 	var mu sync.Mutex
 	for _, store := range stores {
+		select {
+		case sem <- struct{}{}:
+		case <-runCtx.Done():
+		}
+		if runCtx.Err() != nil {
+			break
+		}
 		wg.Add(1)
-		sem <- struct{}{}
 
 		go func(store string) {
 			defer wg.Done()
-			if err := migrateStore(store, baseOld, baseNew); err != nil {
+			res, err := migrateStoreWithRetry(store, baseOld, baseNew, storeOpts)
+			record(res, err)
+			// a timed out store was already cleaned up and doesn't stop the others
+			if err != nil && runCtx.Err() == nil && !errors.Is(err, ErrStoreTimeout) {
 				mu.Lock()
 				if firstErr == nil {
 					firstErr = err
 				}
 				mu.Unlock()
+				if !opts.continueOnError {
+					cancel(err)
+				}
 			}
 			<-sem
 		}(store)
 	}
 	wg.Wait()
-	return firstErr
+	if err := cleanupInterrupted(ctx, baseNew, results, opts); err != nil {
+		return err
+	}
+	if firstErr == nil || opts.continueOnError {
+		// timed out stores are the only failures when firstErr is nil
+		return joinStoreErrors(results)
+	}
+	// stores cancelled because of firstErr are incomplete
+	removeCancelledStores(baseNew, results, opts)
+	return partialFailure(results, firstErr)
 }
 
-func migrateStore(store, baseOld, baseNew string) error {
-	oldTreePath := filepath.Join(baseOld, store, "tree.sqlite")
-	newTreePath := filepath.Join(baseNew, store, "tree.sqlite")
-	oldChangelogPath := filepath.Join(baseOld, store, "changelog.sqlite")
-	newChangelogPath := filepath.Join(baseNew, store, "changelog.sqlite")
+// joinStoreErrors combines the errors of all failed stores, sorted by store, or returns nil.
+func joinStoreErrors(results []storeResult) error {
+	sorted := slices.Clone(results)
+	slices.SortFunc(sorted, func(a, b storeResult) int { return strings.Compare(a.store, b.store) })
 
-	log.Printf("Processing tree.sqlite:  %s", oldTreePath)
-	if _, err := os.Stat(oldTreePath); err == nil {
-		if err := migrateTree(oldTreePath, newTreePath); err != nil {
-			log.Printf("migrate tree.sqlite failed: %s, store: %s", err.Error(), store)
-			return err
+	var errs []error
+	for _, res := range sorted {
+		if res.err != nil {
+			errs = append(errs, fmt.Errorf("store %s: %w", res.store, res.err))
 		}
-	} else {
-		errMsg := fmt.Sprintf("tree.sqlite not found: %s", oldTreePath)
-		log.Print(errMsg)
-		return errors.New(errMsg)
 	}
-	log.Printf("migrate tree.sqlite successfully, store: %s", store)
+	return partialFailure(results, errors.Join(errs...))
+}
 
-	log.Printf("Processing changelog.sqlite:  %s", oldChangelogPath)
-	if _, err := os.Stat(oldChangelogPath); err == nil {
-		if err := migrateChangelog(oldChangelogPath, newChangelogPath); err != nil {
-			log.Printf("migrate changelog.sqlite failed: %s, store: %s", err.Error(), store)
+// partialFailure wraps a non-nil err with ErrPartialFailure if some stores in results migrated.
+func partialFailure(results []storeResult, err error) error {
+	if err == nil {
+		return nil
+	}
+	var migrated int
+	for _, res := range results {
+		if res.err == nil {
+			migrated++
+		}
+	}
+	if migrated == 0 {
+		return err
+	}
+	return fmt.Errorf("%w (%d of %d stores migrated): %w", ErrPartialFailure, migrated, len(results), err)
+}
+
+// cleanupInterrupted removes the partial output of stores cut short by ctx and reports
+// which stores finished, so they can be left out of the next run. It returns nil if ctx
+// was never cancelled.
+func cleanupInterrupted(ctx context.Context, baseNew string, results []storeResult, opts migrateOptions) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	lg := opts.logger
+
+	removeCancelledStores(baseNew, results, opts)
+	var completed []string
+	for _, res := range results {
+		if res.err == nil {
+			completed = append(completed, res.store)
+		}
+	}
+	slices.Sort(completed)
+	lg.Event("interrupted", logFields{"completed": completed}, "migration interrupted, completed stores: %v", completed)
+	return fmt.Errorf("migration interrupted: %w", ctx.Err())
+}
+
+// removeCancelledStores removes the destination output of every store that stopped
+// because its context was cancelled.
+func removeCancelledStores(baseNew string, results []storeResult, opts migrateOptions) {
+	lg := opts.logger
+	for _, res := range results {
+		if !errors.Is(res.err, context.Canceled) {
+			continue
+		}
+		dir := filepath.Join(baseNew, res.store)
+		lg.Event("cleanup", logFields{"store": res.store, "path": dir}, "removing partially migrated store %s", dir)
+		if err := removeStoreOutput(baseNew, res.store, opts); err != nil {
+			lg.Event("cleanup_failed", logFields{"store": res.store, "error": err}, "remove %s: %v", dir, err)
+		}
+	}
+}
+
+// removeStoreOutput removes what migrateStore writes for store under baseNew: both databases,
+// or only the one of the phase being run with --only-tree/--only-changelog, so the other
+// phase's existing destination is kept. Files it didn't write are never touched, so the store
+// directory itself only goes once nothing else is left in it. Appending merges in one
+// transaction and never leaves partial output, so nothing is removed then.
+func removeStoreOutput(baseNew, store string, opts migrateOptions) error {
+	dir := filepath.Join(baseNew, store)
+	var names []string
+	switch {
+	case opts.appendMode:
+		return nil
+	case opts.onlyTree:
+		names = []string{opts.treeFilename()}
+	case opts.onlyChangelog:
+		names = []string{opts.changelogFilename()}
+	default:
+		names = []string{opts.treeFilename(), opts.changelogFilename()}
+	}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		for _, p := range []string{path, path + tmpSuffix} {
+			if err := removeSQLiteFiles(p); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(path + skippedSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
 			return err
 		}
-	} else {
-		errMsg := fmt.Sprintf("changelog.sqlite not found: %s", oldChangelogPath)
-		log.Print(errMsg)
-		return errors.New(errMsg)
 	}
-	log.Printf("migrate changelog.sqlite successfully, store: %s", store)
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+		return os.Remove(dir)
+	}
+	return nil
+}
+
+func migrateStore(store, baseOld, baseNew string, opts migrateOptions) (storeResult, error) {
+	// the sources may also be shipped compressed as tree.sqlite.zst, changelog.sqlite.gz, ...
+	// or as one combined file that is split into the two destinations
+	oldTreePath, oldChangelogPath, combined := storeSources(filepath.Join(baseOld, store), opts)
+	newTreePath := filepath.Join(baseNew, store, opts.treeFilename())
+	newChangelogPath := filepath.Join(baseNew, store, opts.changelogFilename())
+
+	res := storeResult{store: store}
+	// checked again per store, as a node started mid-run would race it for the databases
+	if err := checkNoRunningNode(filepath.Dir(baseNew)); err != nil {
+		return res, err
+	}
+	start := time.Now()
+	lg := opts.logger.with(logFields{"store": store})
+	opts.logger = lg
+	if combined {
+		opts.combined = true
+		lg.Event("combined_source", logFields{"path": oldTreePath}, "reading tree and changelog of store %s from %s", store, oldTreePath)
+	}
+
+	if opts.parallelPhases && !opts.onlyTree && !opts.onlyChangelog {
+		if err := migrateStorePhases(&res, oldTreePath, newTreePath, oldChangelogPath, newChangelogPath, opts); err != nil {
+			return res, err
+		}
+		lg.Event("store_done", logFields{"rows": res.treeRows + res.changelogRows, "duration_ms": time.Since(start).Milliseconds()}, "")
+		return res, nil
+	}
 
+	if opts.onlyChangelog {
+		lg.Event("tree_skipped", nil, "skipping %s (--only-changelog), store: %s", opts.treeFilename(), store)
+	} else if err := migrateStoreTree(&res, oldTreePath, newTreePath, opts); err != nil {
+		return res, err
+	}
+
+	if err := opts.context().Err(); err != nil {
+		return res, err
+	}
+
+	if opts.onlyTree {
+		lg.Event("changelog_skipped", nil, "skipping %s (--only-tree), store: %s", opts.changelogFilename(), store)
+	} else if err := migrateStoreChangelog(&res, oldChangelogPath, newChangelogPath, opts); err != nil {
+		return res, err
+	}
+	lg.Event("store_done", logFields{"rows": res.treeRows + res.changelogRows, "duration_ms": time.Since(start).Milliseconds()}, "")
+
+	return res, nil
+}
+
+// migrateStoreTree is migrateStore's tree phase, recording its outcome in res.
+func migrateStoreTree(res *storeResult, oldTreePath, newTreePath string, opts migrateOptions) error {
+	store, lg := res.store, opts.logger
+	lg.Event("tree_start", logFields{"path": oldTreePath}, "Processing tree.sqlite:  %s", oldTreePath)
+	if _, err := os.Stat(oldTreePath); err != nil {
+		err := fmt.Errorf("tree.sqlite %w: %s", ErrSourceNotFound, oldTreePath)
+		lg.Event("tree_failed", logFields{"error": err}, "%s", err)
+		return err
+	}
+	treeStart := time.Now()
+	tree, err := migrateTree(oldTreePath, newTreePath, opts)
+	res.treeRows, res.treeShards = tree.Rows(), len(tree.Shards)
+	res.treeDuration = time.Since(treeStart)
+	if err != nil {
+		lg.Event("tree_failed", logFields{"error": err}, "migrate tree.sqlite failed: %s, store: %s", err.Error(), store)
+		return err
+	}
+	lg.Event("tree_done", logFields{"rows": res.treeRows, "shards": res.treeShards, "duration_ms": res.treeDuration.Milliseconds()},
+		"migrate tree.sqlite successfully, store: %s", store)
+	opts.metrics.addRows(res.treeRows)
+	optimizeDestination(newTreePath, opts)
+	return nil
+}
+
+// migrateStoreChangelog is migrateStore's changelog phase, recording its outcome in res.
+func migrateStoreChangelog(res *storeResult, oldChangelogPath, newChangelogPath string, opts migrateOptions) error {
+	store, lg := res.store, opts.logger
+	lg.Event("changelog_start", logFields{"path": oldChangelogPath}, "Processing changelog.sqlite:  %s", oldChangelogPath)
+	if _, err := os.Stat(oldChangelogPath); err != nil {
+		err := fmt.Errorf("changelog.sqlite %w: %s", ErrSourceNotFound, oldChangelogPath)
+		lg.Event("changelog_failed", logFields{"error": err}, "%s", err)
+		return err
+	}
+	changelogStart := time.Now()
+	rows, err := migrateChangelog(oldChangelogPath, newChangelogPath, opts)
+	res.changelogRows = rows
+	res.changelogDuration = time.Since(changelogStart)
+	if err != nil {
+		lg.Event("changelog_failed", logFields{"error": err}, "migrate changelog.sqlite failed: %s, store: %s", err.Error(), store)
+		return err
+	}
+	lg.Event("changelog_done", logFields{"rows": res.changelogRows, "duration_ms": res.changelogDuration.Milliseconds()},
+		"migrate changelog.sqlite successfully, store: %s", store)
+	opts.metrics.addRows(res.changelogRows)
+	optimizeDestination(newChangelogPath, opts)
 	return nil
 }
 
-func migrateTree(oldPath, newPath string) error {
+// migrateStorePhases runs migrateStore's tree and changelog phases at once, each holding one
+// of opts.phaseSlots while it runs, and returns the errors of both once both are done. The
+// phases write separate files and separate fields of res.
+func migrateStorePhases(res *storeResult, oldTreePath, newTreePath, oldChangelogPath, newChangelogPath string, opts migrateOptions) error {
+	ctx := opts.context()
+	var (
+		wg                    sync.WaitGroup
+		treeErr, changelogErr error
+	)
+	run := func(phase func() error, errp *error) {
+		defer wg.Done()
+		if opts.phaseSlots != nil {
+			select {
+			case opts.phaseSlots <- struct{}{}:
+			case <-ctx.Done():
+				*errp = ctx.Err()
+				return
+			}
+			defer func() { <-opts.phaseSlots }()
+		}
+		*errp = phase()
+	}
+	wg.Add(2)
+	go run(func() error { return migrateStoreTree(res, oldTreePath, newTreePath, opts) }, &treeErr)
+	go run(func() error { return migrateStoreChangelog(res, oldChangelogPath, newChangelogPath, opts) }, &changelogErr)
+	wg.Wait()
+	return errors.Join(treeErr, changelogErr)
+}
+
+// TreeMigrationResult describes what migrateTree wrote to the destination tree.sqlite.
+type TreeMigrationResult struct {
+	// Shards are the tree_N shard tables created, in order.
+	Shards []int64
+	// RowsPerShard is the number of tree node rows copied into each shard.
+	RowsPerShard map[int64]int64
+	// RootRows is the number of root rows copied.
+	RootRows int64
+	// TrimmedOrphans is the number of branch orphan rows left out by --trim-orphans.
+	TrimmedOrphans int64
+}
+
+// Rows returns the number of tree node rows copied into all shards.
+func (r TreeMigrationResult) Rows() int64 {
+	var total int64
+	for _, rows := range r.RowsPerShard {
+		total += rows
+	}
+	return total
+}
+
+// migrateTree copies the v2 tree database into the sharded v3 layout and
+// reports the shard tables created and the rows written to them.
+// A .zst or .gz oldPath is first decompressed to a temporary file next to newPath.
+// A destination filesystem filling up fails with ErrDiskFull after the partial output is removed.
+func migrateTree(oldPath, newPath string, opts migrateOptions) (TreeMigrationResult, error) {
+	if opts.appendMode && fileExists(newPath) {
+		result, err := appendTree(oldPath, newPath, opts)
+		return result, diskFullError(err, newPath)
+	}
+	result, err := writeAtomically(newPath, opts.overwrite, opts.dirPerm(), func(tmpPath string) (TreeMigrationResult, error) {
+		srcPath, cleanup, err := decompressSource(oldPath, filepath.Dir(newPath), opts.dirPerm(), opts.logger)
+		if err != nil {
+			return TreeMigrationResult{}, err
+		}
+		defer cleanup()
+		return copyTree(srcPath, newPath, tmpPath, opts)
+	})
+	return result, diskFullError(err, newPath)
+}
+
+// copyTree does the work of migrateTree, writing the database to dbPath.
+func copyTree(oldPath, newPath, dbPath string, opts migrateOptions) (TreeMigrationResult, error) {
 	// Open old db
-	oldDB, err := sql.Open("sqlite", oldPath)
+	oldDB, err := openSource(oldPath, opts.sourceReadonly, opts.busyTimeout)
 	if err != nil {
-		return fmt.Errorf("open old db %s: %w", oldPath, err)
+		return TreeMigrationResult{}, fmt.Errorf("open old db %s: %w", oldPath, err)
 	}
 	defer oldDB.Close()
-
-	// Create target dir
-	os.Remove(newPath)
-	if err := os.MkdirAll(filepath.Dir(newPath), 0o777); err != nil {
-		return err
+	if err := checkSourceFormat(oldDB, oldPath); err != nil {
+		return TreeMigrationResult{}, err
+	}
+	if opts.verifySource {
+		if err := checkSourceIntegrity(oldDB, oldPath, opts.logger); err != nil {
+			return TreeMigrationResult{}, err
+		}
+		if err := checkRootVersions(oldDB, oldPath, opts.logger); err != nil {
+			return TreeMigrationResult{}, err
+		}
 	}
-	newDB, err := sql.Open("sqlite", newPath)
+
+	newDB, err := openDest(dbPath, opts)
 	if err != nil {
-		return fmt.Errorf("open new db %s: %w", newPath, err)
+		return TreeMigrationResult{}, fmt.Errorf("open new db %s: %w", dbPath, err)
 	}
 	defer newDB.Close()
 
-	exec := func(sqlStmt string) {
-		if _, err := newDB.Exec(sqlStmt); err != nil {
-			log.Fatalf("exec [%s]: %v", sqlStmt, err)
+	var result TreeMigrationResult
+	// cancelling the context, e.g. by --store-timeout, interrupts the statement
+	ctx := opts.context()
+	exec := func(sqlStmt string) (int64, error) {
+		res, err := execSQL(ctx, newDB, opts.logger, sqlStmt)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			return 0, fmt.Errorf("exec [%s]: %w", sqlStmt, classifySQLiteError(err))
 		}
+		rows, _ := res.RowsAffected()
+		return rows, nil
 	}
 
-	// Create base tables
-	exec(`CREATE TABLE branch_orphan (
-	  version INT, sequence INT, at INT,
-	  PRIMARY KEY (at DESC, version, sequence)
-	) WITHOUT ROWID;`)
-	exec(`CREATE TABLE root (
-	  version INT, node_version INT, node_sequence INT, bytes BLOB,
-	  PRIMARY KEY (version DESC)
-	) WITHOUT ROWID;`)
+	// Create base tables and ATTACH old db
+	for _, stmt := range []string{
+		`CREATE TABLE branch_orphan (
+		  version INT, sequence INT, at INT,
+		  PRIMARY KEY (at DESC, version, sequence)
+		) WITHOUT ROWID;`,
+		`CREATE TABLE root (
+		  version INT, node_version INT, node_sequence INT, bytes BLOB,
+		  PRIMARY KEY (version DESC)
+		) WITHOUT ROWID;`,
+		attachSourceStmt(oldPath, opts.sourceReadonly),
+	} {
+		if _, err := exec(stmt); err != nil {
+			return TreeMigrationResult{}, err
+		}
+	}
 
-	// ATTACH old db
-	exec(fmt.Sprintf(`ATTACH DATABASE '%s' AS old;`, oldPath))
+	lg := opts.logger
 
 	// Analyze version range in the old database to determine needed shards
-	log.Printf("analyzing version range in old database...")
+	lg.Printf("analyzing version range in old database...")
+
+	if err := requireTables(oldDB, oldPath, "root"); err != nil {
+		return TreeMigrationResult{}, err
+	}
+	src, err := resolveTreeSource(oldDB, oldPath, opts.shardSizeFromSource, lg)
+	if err != nil {
+		return TreeMigrationResult{}, err
+	}
+	if len(src.tables) == 0 {
+		lg.Event("missing_table", logFields{"table": "tree_1"}, "WARNING: old tree %s has no tree_1 or other tree_N table, migrating only root and orphans", oldPath)
+	}
+	if opts.stamp {
+		format := sourceFormatV2Tree
+		if len(src.tables) > 0 && !slices.Equal(src.tables, plainTreeSource.tables) {
+			format = sourceFormatV2TreeShard
+		}
+		if err := stampMigrationMeta(ctx, newDB, lg, newMigrationMeta(format, opts)); err != nil {
+			return TreeMigrationResult{}, err
+		}
+	}
+	known := append(knownSourceTables(knownTreeTables, opts), src.tables...)
+	if err := migrateUnknownTables(oldDB, traceSQL(newDB, lg), oldPath, known, v3TreeTableRe.MatchString, opts.copyUnknownTables, lg); err != nil {
+		return TreeMigrationResult{}, err
+	}
+
+	// --append copies only the versions after the destination's latest
+	var appendWhere, appendAnd string
+	if opts.appendAfter > 0 {
+		appendWhere = fmt.Sprintf(" WHERE version > %d", opts.appendAfter)
+		appendAnd = fmt.Sprintf(" AND version > %d", opts.appendAfter)
+	}
 
 	// First check if there's any data in the tree_1 table
 	var count int64
-	err = oldDB.QueryRow("SELECT COUNT(*) FROM tree_1").Scan(&count)
-	if err != nil {
-		return fmt.Errorf("failed to count rows in tree_1: %w", err)
+	if len(src.tables) > 0 {
+		err = oldDB.QueryRow("SELECT COUNT(*) FROM " + src.from("") + appendWhere).Scan(&count)
+		if err != nil {
+			return TreeMigrationResult{}, fmt.Errorf("failed to count rows in %s: %w", src, err)
+		}
 	}
 
 	// Check if there's any data in the root table
 	var rootCount int64
-	err = oldDB.QueryRow("SELECT COUNT(*) FROM root").Scan(&rootCount)
+	err = oldDB.QueryRow("SELECT COUNT(*) FROM root" + appendWhere).Scan(&rootCount)
 	if err != nil {
-		return fmt.Errorf("failed to count rows in root: %w", err)
+		return TreeMigrationResult{}, fmt.Errorf("failed to count rows in root: %w", err)
 	}
 
 	if count == 0 && rootCount == 0 {
-		log.Printf("no data found in tree_1 or root tables")
-		exec(`DETACH DATABASE old;`)
-		return nil
+		lg.Printf("no data found in tree_1 or root tables")
+		_, err := exec(`DETACH DATABASE old;`)
+		return TreeMigrationResult{}, err
 	}
 
 	// Migrate root table data first (always migrate if it exists)
 	if rootCount > 0 {
-		log.Printf("migrating tree: table root %s → %s\n", oldPath, newPath)
-		exec(`INSERT INTO root(version, node_version, node_sequence, bytes)
-		      SELECT version, node_version, node_sequence, bytes FROM old.root;`)
+		lg.Printf("migrating tree: table root %s → %s\n", oldPath, newPath)
+		result.RootRows, err = exec(`INSERT INTO root(version, node_version, node_sequence, bytes)
+		      SELECT version, node_version, node_sequence, bytes FROM old.root` + appendWhere)
+		if err != nil {
+			return TreeMigrationResult{}, err
+		}
+		if opts.validateRoot {
+			if err := validateLatestRoot(newDB); err != nil {
+				return TreeMigrationResult{}, err
+			}
+		}
 	}
 
 	// Migrate orphan table data if it exists
-	log.Printf("migrating tree: table branch_orphan %s → %s\n", oldPath, newPath)
-	exec(`INSERT INTO branch_orphan(version, sequence, at)
-	      SELECT version, sequence, at FROM old.orphan;`)
+	hasOrphan, err := tableExists(oldDB, "orphan")
+	if err != nil {
+		return TreeMigrationResult{}, err
+	}
+	if hasOrphan {
+		lg.Printf("migrating tree: table branch_orphan %s → %s\n", oldPath, newPath)
+		cutoff, err := orphanTrimCutoff(oldDB, opts.trimOrphans, oldPath, lg)
+		if err != nil {
+			return TreeMigrationResult{}, err
+		}
+		var trimmed int64
+		if opts.lowMemory {
+			var duplicates int64
+			_, trimmed, duplicates, err = copyOrphansStreaming(ctx, oldDB, "orphan", cutoff, opts.appendAfter, opts.rowBatchSize(), func(batch []orphanRow) error {
+				tx, err := newDB.Begin()
+				if err != nil {
+					return err
+				}
+				defer tx.Rollback()
+				if err := insertOrphanBatch(tx, "branch_orphan", batch); err != nil {
+					return err
+				}
+				return tx.Commit()
+			})
+			if duplicates > 0 {
+				lg.Event("orphans_deduplicated", logFields{"table": "branch_orphan", "rows": duplicates},
+					"dropped %d duplicate branch orphan rows: %s", duplicates, oldPath)
+			}
+		} else {
+			_, trimmed, err = copyOrphans(traceSQL(newDB, lg), "orphan", "branch_orphan", cutoff, opts.appendAfter)
+		}
+		if err != nil {
+			return TreeMigrationResult{}, err
+		}
+		result.TrimmedOrphans = trimmed
+		if cutoff > 0 {
+			lg.Event("orphans_trimmed", logFields{"table": "branch_orphan", "rows": trimmed, "cutoff": cutoff},
+				"trimmed %d branch orphans below version %d: %s", trimmed, cutoff, oldPath)
+		}
+	} else {
+		lg.Event("missing_table", logFields{"table": "orphan"}, "WARNING: old tree %s has no orphan table, skipping branch_orphan migration", oldPath)
+		// Rebuilding branch orphans needs every version's tree decoded and diffed, which the migration doesn't do
+		lg.Event("orphans_not_rebuilt", logFields{"table": "branch_orphan"},
+			"WARNING: branch orphans of %s can't be rebuilt; the tree stays readable, but pruning will never reclaim branch nodes orphaned before the migration", oldPath)
+	}
 
 	// Only process tree_1 data if it exists
 	if count > 0 {
 		// Get min and max versions from the old tree_1 table (v2 format), handling NULL values
 		var minVersion, maxVersion sql.NullInt64
-		err = oldDB.QueryRow("SELECT MIN(version), MAX(version) FROM tree_1 WHERE version IS NOT NULL").Scan(&minVersion, &maxVersion)
+		err = oldDB.QueryRow("SELECT MIN(version), MAX(version) FROM "+src.from("")+" WHERE version IS NOT NULL"+appendAnd).Scan(&minVersion, &maxVersion)
 		if err != nil {
 			if err == sql.ErrNoRows {
-				log.Printf("no valid version data found in old database")
-				exec(`DETACH DATABASE old;`)
-				return nil
+				lg.Printf("no valid version data found in old database")
+				_, err := exec(`DETACH DATABASE old;`)
+				return TreeMigrationResult{}, err
 			}
-			return fmt.Errorf("failed to query version range from tree_1: %w", err)
+			return TreeMigrationResult{}, fmt.Errorf("failed to query version range from %s: %w", src, err)
 		}
 
 		// Check if we got valid version data
 		if !minVersion.Valid || !maxVersion.Valid {
-			log.Printf("no valid version data found in tree_1 table")
-			exec(`DETACH DATABASE old;`)
-			return nil
+			lg.Printf("no valid version data found in tree_1 table")
+			_, err := exec(`DETACH DATABASE old;`)
+			return TreeMigrationResult{}, err
+		}
+
+		lg.Event("version_range", logFields{"min_version": minVersion.Int64, "max_version": maxVersion.Int64},
+			"found version range: %d to %d", minVersion.Int64, maxVersion.Int64)
+
+		var report *corruptRowReport
+		if opts.skipCorrupt {
+			report = newCorruptRowReport(newPath, lg)
+			defer report.close()
+			if err := reportNullVersionRows(oldDB, src, report); err != nil {
+				return TreeMigrationResult{}, err
+			}
 		}
 
-		log.Printf("found version range: %d to %d", minVersion.Int64, maxVersion.Int64)
+		if err := checkShardCount(oldDB, src, minVersion.Int64, maxVersion.Int64, opts); err != nil {
+			return TreeMigrationResult{}, err
+		}
 
-		// Calculate needed shard IDs based on version range
-		shardIDs := calculateShardRange(minVersion.Int64, maxVersion.Int64)
-		log.Printf("need to create shards: %v", shardIDs)
+		// Calculate needed shard IDs based on version range, narrowed to --shards
+		shardIDs, err := selectShards(calculateShardRange(minVersion.Int64, maxVersion.Int64), opts.shards)
+		if err != nil {
+			return TreeMigrationResult{}, err
+		}
+		if opts.skipEmptyShards {
+			if shardIDs, err = nonEmptyShards(oldDB, src, shardIDs, opts); err != nil {
+				return TreeMigrationResult{}, err
+			}
+		}
+		lg.Event("shards", logFields{"shards": shardIDs}, "need to create shards: %v", shardIDs)
+		result.Shards = shardIDs
+		result.RowsPerShard = make(map[int64]int64, len(shardIDs))
 
 		// Create all needed shard tables
 		for _, shardID := range shardIDs {
 			tableName := fmt.Sprintf("tree_%d", shardID)
-			log.Printf("creating shard table: %s", tableName)
-			exec(fmt.Sprintf(`CREATE TABLE %s (
+			lg.Printf("creating shard table: %s", tableName)
+			if _, err := exec(fmt.Sprintf(`CREATE TABLE %s (
 			  version INT, sequence INT, bytes BLOB, orphaned BOOL,
 			  PRIMARY KEY (version, sequence)
-			) WITHOUT ROWID;`, tableName))
+			) WITHOUT ROWID;`, tableName)); err != nil {
+				return TreeMigrationResult{}, err
+			}
 		}
 
 		// Migrate tree data to appropriate shards
-		log.Printf("migrating tree data to shards...")
-
-		// For each shard, insert data for versions that belong to that shard
-		for _, shardID := range shardIDs {
-			tableName := fmt.Sprintf("tree_%d", shardID)
-
-			// Calculate version range for this shard
-			startVersion := (shardID-1)*500000 + 1
-			endVersion := shardID * 500000
-
-			log.Printf("migrating shard %d (versions %d-%d) to %s", shardID, startVersion, endVersion, tableName)
+		lg.Printf("migrating tree data to shards...")
 
-			// Insert data for this shard's version range from old.tree_1
-			exec(fmt.Sprintf(`INSERT INTO %s(version, sequence, bytes, orphaned)
-			      SELECT version, sequence, bytes, orphaned FROM (
-			        SELECT version, sequence, bytes, orphaned,
-			               ROW_NUMBER() OVER (PARTITION BY version, sequence ORDER BY rowid) as rn
-			        FROM old.tree_1
-			        WHERE version >= %d AND version <= %d
-			      ) WHERE rn = 1;`, tableName, startVersion, endVersion))
+		var verify func(shardID int64) error
+		if opts.verifyEachShard {
+			verify = func(shardID int64) error { return verifyShardRows(ctx, oldDB, newDB, src, shardID, opts) }
+		}
+		if opts.shardWorkers > 1 && len(shardIDs) > 1 && !opts.skipCorrupt {
+			rows, err := copyShardsParallel(ctx, oldPath, dbPath, newDB, src, shardIDs, verify, opts)
+			if err != nil {
+				return TreeMigrationResult{}, err
+			}
+			result.RowsPerShard = rows
+		} else {
+			// For each shard, insert data for versions that belong to that shard
+			for _, shardID := range shardIDs {
+				if err := ctx.Err(); err != nil {
+					return TreeMigrationResult{}, err
+				}
+				tableName := fmt.Sprintf("tree_%d", shardID)
+
+				// Calculate version range for this shard
+				startVersion, endVersion := shardVersionRange(shardID)
+				startVersion = max(startVersion, opts.appendAfter+1)
+
+				lg.Printf("migrating shard %d (versions %d-%d) to %s", shardID, startVersion, endVersion, tableName)
+
+				var rows int64
+				switch {
+				case opts.skipCorrupt:
+					rows, err = copyShardRowsSkippingCorrupt(oldDB, newDB, src, tableName, startVersion, endVersion, report)
+					if err != nil {
+						return TreeMigrationResult{}, fmt.Errorf("migrate shard %s: %w", tableName, err)
+					}
+				case opts.lowMemory:
+					rows, err = copyShardRowsStreaming(ctx, oldDB, newDB, src, tableName, startVersion, endVersion, opts.rowBatchSize())
+					if err != nil {
+						return TreeMigrationResult{}, fmt.Errorf("migrate shard %s: %w", tableName, err)
+					}
+				default:
+					// Insert data for this shard's version range from old.tree_1 (or every source shard); cancelling ctx interrupts the statement
+					res, err := execSQL(ctx, newDB, lg, copyShardStmt(tableName, src, startVersion, endVersion))
+					if err != nil {
+						if ctx.Err() != nil {
+							return TreeMigrationResult{}, ctx.Err()
+						}
+						return TreeMigrationResult{}, fmt.Errorf("migrate shard %s: %w", tableName, classifySQLiteError(err))
+					}
+					rows, _ = res.RowsAffected()
+				}
+				result.RowsPerShard[shardID] = rows
+				opts.storeProgress.add(rows)
+				// rows skipped as corrupt would always differ, so --skip-corrupt leaves shards unverified
+				if verify != nil && !opts.skipCorrupt {
+					if err := verify(shardID); err != nil {
+						return TreeMigrationResult{}, err
+					}
+				}
+			}
+		}
+		// a report cut short by a failed write must not pass for the full list of skipped rows
+		if err := report.close(); err != nil {
+			return TreeMigrationResult{}, err
 		}
 	} else {
-		log.Printf("tree_1 table is empty, skipping tree data migration")
+		lg.Printf("tree_1 table is empty, skipping tree data migration")
 	}
 
 	// DETACH
-	exec(`DETACH DATABASE old;`)
+	if _, err := exec(`DETACH DATABASE old;`); err != nil {
+		return TreeMigrationResult{}, err
+	}
+
+	lg.Event("tree_finished", logFields{"source": oldPath, "dest": newPath}, "finish migrating tree: %s → %s\n", oldPath, newPath)
+	return result, nil
+}
+
+// shardVersionRange returns the inclusive version range stored in the given shard.
+func shardVersionRange(shardID int64) (int64, int64) {
+	return (shardID-1)*defaultTreeShardSize + 1, shardID * defaultTreeShardSize
+}
+
+// copyShardStmt copies one shard's version range from the attached source tables into tableName,
+// keeping the first row (by rowid) for each duplicated (version, sequence).
+func copyShardStmt(tableName string, src treeSource, startVersion, endVersion int64) string {
+	return fmt.Sprintf(`INSERT INTO %s(version, sequence, bytes, orphaned)
+	      SELECT version, sequence, bytes, orphaned FROM (
+	        SELECT version, sequence, bytes, orphaned,
+	               ROW_NUMBER() OVER (PARTITION BY version, sequence ORDER BY rowid) as rn
+	        FROM %s
+	        WHERE version >= %d AND version <= %d
+	      ) WHERE rn = 1;`, tableName, src.from("old."), startVersion, endVersion)
+}
+
+// tmpSuffix marks a destination database that is still being written.
+const tmpSuffix = ".tmp"
+
+// writeAtomically has write build the database at newPath+tmpSuffix and renames it to newPath
+// only once write succeeds, so a crash never leaves a partial file under the final name.
+// A WAL the database was left with is checkpointed into it first. On failure the temporary
+// file is removed.
+func writeAtomically[T any](newPath string, overwrite bool, dirPerm os.FileMode, write func(tmpPath string) (T, error)) (T, error) {
+	var zero T
+	if err := prepareDestination(newPath, overwrite, dirPerm); err != nil {
+		return zero, err
+	}
+
+	tmpPath := newPath + tmpSuffix
+	result, err := write(tmpPath)
+	if err != nil {
+		removeSQLiteFiles(tmpPath)
+		return zero, err
+	}
+	// only the database file is renamed, so nothing may be left in a -wal beside it
+	if _, err := checkpointWAL(tmpPath); err != nil {
+		removeSQLiteFiles(tmpPath)
+		return zero, err
+	}
+	if err := os.Rename(tmpPath, newPath); err != nil {
+		removeSQLiteFiles(tmpPath)
+		return zero, fmt.Errorf("rename %s to %s: %w", tmpPath, newPath, err)
+	}
+	return result, nil
+}
 
-	log.Printf("finish migrating tree: %s → %s\n", oldPath, newPath)
+// removeSQLiteFiles removes a database file along with any journal, WAL or shared-memory file.
+func removeSQLiteFiles(path string) error {
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
 	return nil
 }
 
+// prepareDestination creates newPath's directory with dirPerm and clears a temporary file left
+// by an interrupted run. An existing newPath is an error unless overwrite is set, in which case
+// it is removed.
+func prepareDestination(newPath string, overwrite bool, dirPerm os.FileMode) error {
+	if err := removeSQLiteFiles(newPath + tmpSuffix); err != nil {
+		return fmt.Errorf("remove stale %s: %w", newPath+tmpSuffix, err)
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		if !overwrite {
+			return fmt.Errorf("destination %s already exists; pass --overwrite or remove it first", newPath)
+		}
+		if err := removeSQLiteFiles(newPath); err != nil {
+			return fmt.Errorf("remove existing destination %s: %w", newPath, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("stat destination %s: %w", newPath, err)
+	}
+	return os.MkdirAll(filepath.Dir(newPath), dirPerm)
+}
+
+// checkShardCount guards against a corrupt version in tree_1 making calculateShardRange
+// create thousands of empty shard tables. Past opts.shardLimit() it logs the suspicious
+// max version and how many rows carry it, and fails unless opts.force is set.
+func checkShardCount(oldDB *sql.DB, src treeSource, minVersion, maxVersion int64, opts migrateOptions) error {
+	shards := ToShardID(maxVersion) - ToShardID(minVersion) + 1
+	if shards <= opts.shardLimit() {
+		return nil
+	}
+
+	var rows int64
+	if err := oldDB.QueryRow("SELECT COUNT(*) FROM "+src.from("")+" WHERE version = ?", maxVersion).Scan(&rows); err != nil {
+		return fmt.Errorf("count rows at version %d in %s: %w", maxVersion, src, err)
+	}
+	opts.logger.Event("suspicious_max_version", logFields{"min_version": minVersion, "max_version": maxVersion, "shards": shards, "rows_at_max_version": rows},
+		"WARNING: version range %d-%d needs %d shard tables (limit %d); %d rows have version %d, which may be corruption",
+		minVersion, maxVersion, shards, opts.shardLimit(), rows, maxVersion)
+	if opts.force {
+		return nil
+	}
+	return fmt.Errorf("version range %d-%d needs %d shard tables, more than --max-shards %d; pass --force to migrate anyway", minVersion, maxVersion, shards, opts.shardLimit())
+}
+
 // calculateShardRange calculates the range of shard IDs needed for a given version range
 func calculateShardRange(minVersion, maxVersion int64) []int64 {
 	if minVersion <= 0 || maxVersion <= 0 {
@@ -320,53 +1593,165 @@ func calculateShardRange(minVersion, maxVersion int64) []int64 {
 	return shards
 }
 
+// listShardIDs returns the IDs of the tree_N shard tables present in a v3 tree database, in ascending order.
+func listShardIDs(db *sql.DB) ([]int64, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' AND name LIKE 'tree_%'")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shard tables: %w", err)
+	}
+	defer rows.Close()
+
+	var shardIDs []int64
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, fmt.Errorf("failed to scan table name: %w", err)
+		}
+		shardID, err := strconv.ParseInt(strings.TrimPrefix(tableName, "tree_"), 10, 64)
+		if err != nil {
+			continue
+		}
+		shardIDs = append(shardIDs, shardID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating shard tables: %w", err)
+	}
+	slices.Sort(shardIDs)
+	return shardIDs, nil
+}
+
+const (
+	defaultStartShardID  = int64(1)
+	defaultTreeShardSize = 500_000
+)
+
 // ToShardID calculates the shard ID for a given version
 func ToShardID(version int64) int64 {
-	const defaultStartShardID = int64(1)
-	const defaultTreeShardSize = 500_000
-
 	if version <= 0 {
 		return defaultStartShardID
 	}
 	return (version-1)/defaultTreeShardSize + defaultStartShardID
 }
 
-func migrateChangelog(oldPath, newPath string) error {
-	log.Printf("migrating changelog: table leaf %s → %s\n", oldPath, newPath)
-	oldDB, err := sql.Open("sqlite", oldPath)
+// validateShardFormula checks ToShardID against iavl v3's own shard function at every shard
+// boundary up to maxShard plus a few far-out versions. The tables written here are named by
+// ToShardID and looked up by iavl v3, so any drift would leave v3 unable to find its nodes.
+func validateShardFormula(maxShard int64) error {
+	versions := []int64{-1, 0, 1, 1 << 32, 1 << 40, 1<<62 + 1}
+	for shard := int64(1); shard <= maxShard; shard++ {
+		end := shard * defaultTreeShardSize
+		versions = append(versions, end-1, end, end+1)
+	}
+	for _, version := range versions {
+		if got, want := ToShardID(version), iavl3.ToShardID(version); got != want {
+			return fmt.Errorf("shard formula diverges from iavl v3 at version %d: migration uses tree_%d, iavl v3 expects tree_%d", version, got, want)
+		}
+	}
+	return nil
+}
+
+const (
+	hashAlgorithmBlake3 = "blake3"
+	hashAlgorithmSha256 = "sha256"
+)
+
+// keyHashPool returns the hasher pool used to compute the leaf key_hash for the given algorithm.
+func keyHashPool(algorithm string) (*sync.Pool, error) {
+	switch algorithm {
+	case "", hashAlgorithmBlake3:
+		return hashpool.Blake3Pool, nil
+	case hashAlgorithmSha256:
+		return hashpool.Sha256Pool, nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q (supported: %s, %s)", algorithm, hashAlgorithmBlake3, hashAlgorithmSha256)
+	}
+}
+
+// migrateChangelog copies the v2 changelog into the v3 layout, replacing each
+// leaf key by its key_hash, and returns the number of leaf rows written.
+// A .zst or .gz oldPath is first decompressed to a temporary file next to newPath.
+// A destination filesystem filling up fails with ErrDiskFull after the partial output is removed.
+func migrateChangelog(oldPath, newPath string, opts migrateOptions) (int64, error) {
+	if opts.appendMode && fileExists(newPath) {
+		rows, err := appendChangelog(oldPath, newPath, opts)
+		return rows, diskFullError(err, newPath)
+	}
+	trimCutoff, err := changelogTrimCutoff(oldPath, opts)
 	if err != nil {
-		return fmt.Errorf("open old changelog db %s: %w", oldPath, err)
+		return 0, err
 	}
-	defer oldDB.Close()
+	rows, err := writeAtomically(newPath, opts.overwrite, opts.dirPerm(), func(tmpPath string) (int64, error) {
+		srcPath, cleanup, err := decompressSource(oldPath, filepath.Dir(newPath), opts.dirPerm(), opts.logger)
+		if err != nil {
+			return 0, err
+		}
+		defer cleanup()
+		return copyChangelog(srcPath, newPath, tmpPath, trimCutoff, opts)
+	})
+	return rows, diskFullError(err, newPath)
+}
 
-	// create target dir
-	os.Remove(newPath)
-	if err := os.MkdirAll(filepath.Dir(newPath), 0o777); err != nil {
-		return err
+// copyChangelog does the work of migrateChangelog, writing the database to dbPath and
+// leaving out leaf orphans with at below trimCutoff.
+func copyChangelog(oldPath, newPath, dbPath string, trimCutoff int64, opts migrateOptions) (int64, error) {
+	hashPool, err := keyHashPool(opts.hashAlgorithm)
+	if err != nil {
+		return 0, err
+	}
+
+	lg := opts.logger
+	lg.Printf("migrating changelog: table leaf %s → %s\n", oldPath, newPath)
+	oldDB, err := openSource(oldPath, opts.sourceReadonly, opts.busyTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("open old changelog db %s: %w", oldPath, err)
+	}
+	defer oldDB.Close()
+	if err := checkSourceFormat(oldDB, oldPath); err != nil {
+		return 0, err
+	}
+	// a combined source was checked with the tree
+	if opts.verifySource && !opts.combined {
+		if err := checkSourceIntegrity(oldDB, oldPath, lg); err != nil {
+			return 0, err
+		}
 	}
 
-	newDB, err := sql.Open("sqlite", newPath)
+	newDB, err := openDest(dbPath, opts)
 	if err != nil {
-		return fmt.Errorf("open new changelog db %s: %w", newPath, err)
+		return 0, fmt.Errorf("open new changelog db %s: %w", dbPath, err)
 	}
 	defer newDB.Close()
 
-	tx, err := newDB.Begin()
+	// ATTACH is per connection, so pin one: the detach must run on the connection the
+	// transaction attached on, whatever the pool hands out in between
+	conn, err := newDB.Conn(context.Background())
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("open new changelog db %s: %w", dbPath, err)
+	}
+	defer conn.Close()
+
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, err
 	}
+	// tx is replaced at each --checkpoint-interval commit
+	defer func() { tx.Rollback() }()
 
 	// create tables
+	rawKeyColumn, insertLeafStmt := "", `INSERT INTO leaf(version, sequence, key_hash, bytes) VALUES (?, ?, ?, ?)`
+	if opts.keepRawKey {
+		rawKeyColumn = "\n\t\t\tkey BLOB,"
+		insertLeafStmt = `INSERT INTO leaf(version, sequence, key_hash, bytes, key) VALUES (?, ?, ?, ?, ?)`
+	}
 	createStmt := []string{
 		`CREATE TABLE leaf (
 			version INT,
 			sequence INT,
 			key_hash BLOB,
 			bytes BLOB,
-			orphaned BOOL,
+			orphaned BOOL,` + rawKeyColumn + `
 			PRIMARY KEY (key_hash, version DESC)
 		);`,
-		`CREATE UNIQUE INDEX IF NOT EXISTS leaf_idx ON leaf (version, sequence);`,
 		`CREATE TABLE leaf_orphan (
 			version INT,
 			sequence INT,
@@ -375,37 +1760,118 @@ func migrateChangelog(oldPath, newPath string) error {
 		) WITHOUT ROWID;`,
 	}
 	for _, stmt := range createStmt {
-		if _, err := tx.Exec(stmt); err != nil {
-			return fmt.Errorf("exec %s: %w", stmt, err)
+		if _, err := execSQL(context.Background(), tx, lg, stmt); err != nil {
+			return 0, fmt.Errorf("exec %s: %w", stmt, err)
+		}
+	}
+	if opts.stamp {
+		if err := stampMigrationMeta(context.Background(), tx, lg, newMigrationMeta(sourceFormatV2, opts)); err != nil {
+			return 0, err
+		}
+	}
+
+	// The unique leaf_idx is built once after the bulk insert, which is much faster than
+	// maintaining it row by row. --skip-corrupt still needs it upfront so that duplicate
+	// (version, sequence) rows are rejected, and reported, one at a time.
+	if opts.skipCorrupt {
+		if _, err := execSQL(context.Background(), tx, lg, createLeafIndexStmt); err != nil {
+			return 0, fmt.Errorf("exec %s: %w", createLeafIndexStmt, err)
 		}
 	}
 
+	if err := requireTables(oldDB, oldPath, "leaf"); err != nil {
+		return 0, err
+	}
+
+	if opts.keyPrefix != nil {
+		lg.Event("partial_changelog", logFields{"key_prefix": hex.EncodeToString(opts.keyPrefix)},
+			"WARNING: --key-prefix %x: %s only keeps leaves whose key starts with the prefix; it is not a full state and must not back a node", opts.keyPrefix, newPath)
+	}
+
 	// read from old table
-	rows, err := oldDB.Query(`SELECT version, sequence, key, bytes FROM leaf`)
+	leafQuery := `SELECT version, sequence, key, bytes FROM leaf`
+	if opts.appendAfter > 0 {
+		leafQuery += fmt.Sprintf(" WHERE version > %d", opts.appendAfter)
+	}
+	rows, err := oldDB.Query(leafQuery)
 
 	if err != nil {
-		return fmt.Errorf("read old leaf: %w", err)
+		return 0, fmt.Errorf("read old leaf: %w", err)
 	}
 	defer rows.Close()
 
-	insertStmt, err := tx.Prepare(`INSERT INTO leaf(version, sequence, key_hash, bytes) VALUES (?, ?, ?, ?)`)
+	insertStmt, err := tx.Prepare(insertLeafStmt)
 
 	if err != nil {
+		return 0, err
+	}
+	defer func() { insertStmt.Close() }()
+
+	// checkpoint commits the leaves copied so far and carries on in a new transaction, so the
+	// journal of a huge changelog stays bounded
+	checkpoint := func() error {
+		insertStmt.Close()
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("checkpoint %s: %w", newPath, classifySQLiteError(err))
+		}
+		if tx, err = conn.BeginTx(context.Background(), nil); err != nil {
+			return err
+		}
+		insertStmt, err = tx.Prepare(insertLeafStmt)
 		return err
 	}
-	defer insertStmt.Close()
 
-	h := hashpool.Blake3Pool.Get().(hash.Hash)
-	defer hashpool.Blake3Pool.Put(h)
+	var leafRows, scanned, coercedRows, filteredRows int64
+	ctx := opts.context()
+	h := hashPool.Get().(hash.Hash)
+	defer hashPool.Put(h)
+
+	var report *corruptRowReport
+	if opts.skipCorrupt {
+		report = newCorruptRowReport(newPath, lg)
+		defer report.close()
+	}
 
 	for rows.Next() {
+		if scanned++; scanned%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
 		var (
-			version, sequence int
+			version, sequence sql.NullInt64
 			key, value        []byte
 			// orphaned          bool
 		)
 		if err := rows.Scan(&version, &sequence, &key, &value); err != nil {
-			return err
+			if report == nil {
+				return 0, err
+			}
+			version, sequence := rescanVersionSequence(rows)
+			if err := report.add("leaf", version, sequence, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		// a leaf without a version can't be placed, unlike the other columns it has no sane default
+		if !version.Valid {
+			if report == nil {
+				return 0, fmt.Errorf("leaf row with NULL version (sequence=%s)", nullInt64String(sequence))
+			}
+			if err := report.add("leaf", version, sequence, errors.New("NULL version")); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if coerced := coerceNullLeafColumns(&sequence, &key, value); len(coerced) > 0 {
+			coercedRows++
+			lg.Event("null_coerced", logFields{"table": "leaf", "version": version.Int64, "sequence": sequence.Int64, "columns": coerced},
+				"leaf row version=%d sequence=%d: NULL %s coerced", version.Int64, sequence.Int64, strings.Join(coerced, ", "))
+		}
+		// the key_hash can't be matched against a prefix, so the raw key is filtered here
+		if opts.keyPrefix != nil && !bytes.HasPrefix(key, opts.keyPrefix) {
+			filteredRows++
+			continue
 		}
 
 		// calculate key_hash
@@ -413,117 +1879,291 @@ func migrateChangelog(oldPath, newPath string) error {
 		h.Write(key)
 		keyHash := h.Sum(nil)
 
-		if _, err := insertStmt.Exec(version, sequence, keyHash[:], value); err != nil {
-			return err
+		args := []any{version.Int64, sequence.Int64, keyHash[:], value}
+		if opts.keepRawKey {
+			args = append(args, key)
+		}
+		if _, err := insertStmt.Exec(args...); err != nil {
+			if report == nil {
+				return 0, classifySQLiteError(err)
+			}
+			if err := report.add("leaf", version, sequence, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		leafRows++
+		opts.storeProgress.add(1)
+		if opts.checkpointInterval > 0 && leafRows%opts.checkpointInterval == 0 {
+			if err := checkpoint(); err != nil {
+				return 0, err
+			}
+			lg.Event("checkpoint", logFields{"table": "leaf", "rows": leafRows}, "committed %d leaf rows of %s", leafRows, dbPath)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("read old leaf: %w", err)
+	}
+	if err := report.close(); err != nil {
+		return 0, err
+	}
+	if lg.isVerbose() {
+		lg.Event("sql", logFields{"sql": insertLeafStmt, "rows": leafRows}, "SQL (prepared, %d rows): %s", leafRows, insertLeafStmt)
+	}
+	if coercedRows > 0 {
+		lg.Event("null_coerced_rows", logFields{"table": "leaf", "rows": coercedRows},
+			"coerced NULL columns in %d leaf rows", coercedRows)
+	}
+	if opts.keyPrefix != nil {
+		lg.Event("key_prefix_filtered", logFields{"table": "leaf", "rows": filteredRows, "kept": leafRows},
+			"left out %d leaf rows outside key prefix %x, kept %d", filteredRows, opts.keyPrefix, leafRows)
+	}
+
+	if !opts.skipCorrupt {
+		if err := createLeafIndex(tx, lg); err != nil {
+			return 0, err
 		}
 	}
 
-	log.Printf("migrating changelog: table leaf_orphan %s → %s\n", oldPath, newPath)
+	lg.Printf("migrating changelog: table leaf_orphan %s → %s\n", oldPath, newPath)
 
 	// ATTACH old db
-	if _, err := tx.Exec(fmt.Sprintf(`ATTACH DATABASE '%s' AS old;`, oldPath)); err != nil {
-		return fmt.Errorf("failed to attach old database: %w", err)
+	if _, err := execSQL(context.Background(), tx, lg, attachSourceStmt(oldPath, opts.sourceReadonly)); err != nil {
+		return 0, fmt.Errorf("failed to attach old database: %w", err)
+	}
+	attached := true
+	detach := func() error {
+		if !attached {
+			return nil
+		}
+		attached = false
+		_, err := execSQL(context.Background(), conn, lg, `DETACH DATABASE old;`)
+		return err
+	}
+	// SQLite refuses to detach inside an open transaction, so an early return rolls back first
+	defer func() {
+		tx.Rollback()
+		detach()
+	}()
+
+	hasLeafOrphan, err := tableExists(oldDB, "leaf_orphan")
+	if err != nil {
+		return 0, err
+	}
+	if hasLeafOrphan {
+		var trimmed int64
+		if opts.lowMemory {
+			// the changelog is written in one transaction, so the batches only bound what is held in memory
+			var duplicates int64
+			_, trimmed, duplicates, err = copyOrphansStreaming(ctx, oldDB, "leaf_orphan", trimCutoff, opts.appendAfter, opts.rowBatchSize(), func(batch []orphanRow) error {
+				return insertOrphanBatch(tx, "leaf_orphan", batch)
+			})
+			if duplicates > 0 {
+				lg.Event("orphans_deduplicated", logFields{"table": "leaf_orphan", "rows": duplicates},
+					"dropped %d duplicate leaf orphan rows: %s", duplicates, oldPath)
+			}
+		} else {
+			_, trimmed, err = copyOrphans(traceSQL(tx, lg), "leaf_orphan", "leaf_orphan", trimCutoff, opts.appendAfter)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if trimCutoff > 0 {
+			lg.Event("orphans_trimmed", logFields{"table": "leaf_orphan", "rows": trimmed, "cutoff": trimCutoff},
+				"trimmed %d leaf orphans below version %d: %s", trimmed, trimCutoff, oldPath)
+		}
+	} else if opts.rebuildOrphans {
+		rows, err := rebuildLeafOrphans(traceSQL(tx, lg), opts.appendAfter)
+		if err != nil {
+			return 0, err
+		}
+		lg.Event("rebuilt_orphans", logFields{"table": "leaf_orphan", "rows": rows},
+			"WARNING: old changelog %s has no leaf_orphan table, rebuilt %d leaf orphans from later writes of the same key (best-effort: leaves orphaned by a delete are missing)", oldPath, rows)
+	} else {
+		lg.Event("missing_table", logFields{"table": "leaf_orphan"},
+			"WARNING: old changelog %s has no leaf_orphan table, skipping leaf_orphan migration; pruning will never reclaim leaves orphaned before the migration (pass --rebuild-orphans to reconstruct them best-effort)", oldPath)
+	}
+
+	if opts.keyPrefix != nil {
+		dropped, err := dropUnmatchedLeafOrphans(traceSQL(tx, lg))
+		if err != nil {
+			return 0, err
+		}
+		lg.Event("key_prefix_filtered", logFields{"table": "leaf_orphan", "rows": dropped},
+			"left out %d leaf orphans of leaves outside key prefix %x", dropped, opts.keyPrefix)
 	}
 
-	if _, err := tx.Exec(`INSERT INTO leaf_orphan(version, sequence, at)
-		SELECT version, sequence, at FROM old.leaf_orphan;`); err != nil {
-		return fmt.Errorf("migrate leaf_orphan: %w", err)
+	if err := migrateUnknownTables(oldDB, traceSQL(tx, lg), oldPath, knownSourceTables(knownChangelogTables, opts), nil, opts.copyUnknownTables, lg); err != nil {
+		return 0, err
 	}
 
 	if err = tx.Commit(); err != nil {
-		return err
+		return 0, err
 	}
 
 	// DETACH
-	if _, err := newDB.Exec(`DETACH DATABASE old;`); err != nil {
-		return fmt.Errorf("failed to detach old database: %w", err)
+	if err := detach(); err != nil {
+		return 0, fmt.Errorf("failed to detach old database: %w", err)
+	}
+	lg.Event("changelog_finished", logFields{"source": oldPath, "dest": newPath}, "finish migrating changelog: %s → %s\n", oldPath, newPath)
+
+	return leafRows, nil
+}
+
+// rebuildLeafOrphans reconstructs leaf_orphan from the attached source's leaf table: a leaf is
+// orphaned at the next version that writes the same key. A key deleted and later written again
+// gets a later "at" than the real one, which only delays pruning; leaves orphaned by a delete
+// that is never followed by a write are not found. Only orphans with at past after are written.
+func rebuildLeafOrphans(tx sqlExecQuerier, after int64) (int64, error) {
+	res, err := tx.Exec(`INSERT INTO leaf_orphan(version, sequence, at)
+		SELECT version, COALESCE(sequence, 0), next_version FROM (
+		  SELECT version, sequence,
+		         LEAD(version) OVER (PARTITION BY key ORDER BY version, sequence) AS next_version
+		  FROM old.leaf
+		  WHERE version IS NOT NULL
+		) WHERE next_version IS NOT NULL AND next_version > version AND next_version > ?;`, after)
+	if err != nil {
+		return 0, fmt.Errorf("rebuild leaf_orphan: %w", err)
 	}
-	log.Printf("finish migrating changelog: %s → %s\n", oldPath, newPath)
+	return res.RowsAffected()
+}
 
+const createLeafIndexStmt = `CREATE UNIQUE INDEX IF NOT EXISTS leaf_idx ON leaf (version, sequence);`
+
+// createLeafIndex builds the unique leaf_idx over the already populated leaf table,
+// naming an offending row if the source holds duplicate (version, sequence) pairs.
+func createLeafIndex(tx *sql.Tx, lg *migrationLogger) error {
+	if _, err := execSQL(context.Background(), tx, lg, createLeafIndexStmt); err != nil {
+		var version, sequence int64
+		dupErr := tx.QueryRow(`SELECT version, sequence FROM leaf
+			GROUP BY version, sequence HAVING COUNT(*) > 1 LIMIT 1`).Scan(&version, &sequence)
+		if dupErr == nil {
+			return fmt.Errorf("create leaf_idx: %w: duplicate leaf (version %d, sequence %d) in source changelog: %w", ErrConstraintViolation, version, sequence, err)
+		}
+		return fmt.Errorf("create leaf_idx: %w", classifySQLiteError(err))
+	}
 	return nil
 }
 
-func getStoreKeys(baseOld string, filter []string) ([]string, error) {
-	entries, err := os.ReadDir(baseOld)
+// tableExists reports whether the database has a table with the given name.
+func tableExists(db *sql.DB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name = ?", table).Scan(&count)
 	if err != nil {
-		return nil, err
+		return false, fmt.Errorf("check table %s exists: %w", table, err)
 	}
-	var stores []string
-	filterSet := make(map[string]bool)
+	return count > 0, nil
+}
+
+// readStoreKeysFile reads one store key per line, skipping blank lines and # comments.
+func readStoreKeysFile(path string) ([]string, error) {
+	bz, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read store keys file: %w", err)
+	}
+
+	var keys []string
+	for _, line := range strings.Split(string(bz), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			keys = append(keys, line)
+		}
+	}
+	return keys, nil
+}
+
+// getStoreKeys lists the store directories under baseOld. When filter or regexes are non-empty,
+// only directories matching at least one of them are kept: filter entries containing glob
+// metacharacters (*, ?, [) are matched with filepath.Match, other entries must equal the name,
+// and regexes must match somewhere in the name. Entries and regexes that matched no directory
+// are returned as missing.
+func getStoreKeys(baseOld string, filter, regexes []string) ([]string, []string, error) {
 	for _, k := range filter {
-		filterSet[k] = true
+		if _, err := filepath.Match(k, ""); err != nil {
+			return nil, nil, fmt.Errorf("invalid store key pattern %q: %w", k, err)
+		}
+	}
+	compiled := make([]*regexp.Regexp, len(regexes))
+	for i, expr := range regexes {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid store regex %q: %w", expr, err)
+		}
+		compiled[i] = re
+	}
+
+	entries, err := os.ReadDir(baseOld)
+	if err != nil {
+		return nil, nil, err
 	}
+	var stores []string
+	matched := make(map[string]bool)
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue
 		}
-		if len(filterSet) > 0 && !filterSet[entry.Name()] {
-			continue
+		name := entry.Name()
+		keep := len(filter) == 0 && len(compiled) == 0
+		for _, k := range filter {
+			if k == name || (strings.ContainsAny(k, "*?[") && matchGlob(k, name)) {
+				matched[k], keep = true, true
+			}
+		}
+		for i, re := range compiled {
+			if re.MatchString(name) {
+				matched[regexes[i]], keep = true, true
+			}
+		}
+		if keep {
+			stores = append(stores, name)
+		}
+	}
+
+	var missing []string
+	for _, k := range append(slices.Clone(filter), regexes...) {
+		if !matched[k] && !slices.Contains(missing, k) {
+			missing = append(missing, k)
 		}
-		stores = append(stores, entry.Name())
 	}
-	return stores, nil
+	return stores, missing, nil
+}
+
+// matchGlob reports whether name matches the already validated glob pattern.
+func matchGlob(pattern, name string) bool {
+	ok, _ := filepath.Match(pattern, name)
+	return ok
 }
 
 func CheckHash() *cobra.Command {
 	var (
-		dbv2 string
-		dbv3 string
-		sk   string
+		dbv2     string
+		dbv3     string
+		sk       string
+		shallow  bool
+		loadOpts iavlLoadOptions
 	)
 
 	cmd := &cobra.Command{
 		Use:   "check-hash",
 		Short: "check tree root hash between old tree and migrated new tree",
-		Run: func(cmd *cobra.Command, args []string) {
-
-			v2sql, err := iavl2.NewSqliteDb(iavl2.NewNodePool(), iavl2.DefaultSqliteDbOptions(iavl2.SqliteDbOptions{Path: fmt.Sprintf("%s/%s", dbv2, sk)}))
-			if err != nil {
-				panic(err)
-			}
-			v2version, err := v2sql.LatestVersion()
-			if err != nil {
-				panic(err)
-			}
-			fmt.Println("v2 path: ", fmt.Sprintf("%s/%s", dbv2, sk), "version: ", v2version)
-			v2root, err := v2sql.LoadRoot(v2version)
-			if err != nil {
-				panic(err)
-			}
-			v2hash := v2root.GetHash()
-			fmt.Printf("v2 root hash: %x \n", v2hash)
-
-			v3sql, err := iavl3.NewDB(iavl3.Options{
-				Path:    fmt.Sprintf("%s/%s", dbv3, sk),
-				WalSize: 1024 * 1024 * 1024,
-			})
-			if err != nil {
-				panic(err)
-			}
-			v3version, err := v3sql.LatestVersion()
-			if err != nil {
-				panic(err)
-			}
-			if v2version != v3version {
-				panic("version not match")
-			}
-
-			v3root, err := v3sql.LoadRoot(nodepool3.NewNodePool(), v3version)
-			if err != nil {
-				panic(err)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if shallow {
+				return checkHashShallow(os.Stdout, dbv2, dbv3, sk)
 			}
-			v3hash := v3root.Hash()
-
-			if !bytes.Equal(v2hash, v3hash) {
-				panic("hash not match")
+			if err := loadOpts.validate(); err != nil {
+				return err
 			}
-			log.Printf("check finished, latest version %d, root hash %x", v2version, v2hash)
+			return checkHash(dbv2, dbv3, sk, loadOpts)
 		},
 	}
 
 	cmd.Flags().StringVar(&dbv2, "old-iavl2-path", "", "Path to the v2 root directory")
 	cmd.Flags().StringVar(&dbv3, "new-iavl2-path", "", "Path to the v3 root directory")
 	cmd.Flags().StringVar(&sk, "store-key", "", "The store which is going to be checked")
+	cmd.Flags().BoolVar(&shallow, "shallow", false, "Quick first pass: compare the latest root rows' stored bytes and embedded hash directly instead of loading both trees through iavl")
+	loadOpts.addFlags(cmd)
 	if err := cmd.MarkFlagRequired("old-iavl2-path"); err != nil {
 		panic(err)
 	}
@@ -536,3 +2176,70 @@ func CheckHash() *cobra.Command {
 
 	return cmd
 }
+
+// checkHash compares the latest root of store sk in the v2 and v3 directories, opening both
+// with loadOpts. A differing version or root hash is ErrHashMismatch; a hash mismatch is
+// diagnosed by comparing both trees node by node, telling a library incompatibility or an
+// encoding difference from localized corruption. A v3 tree stamped with a shard size other
+// than this build's is refused before loading.
+func checkHash(dbv2, dbv3, sk string, loadOpts iavlLoadOptions) error {
+	return checkHashAt(dbv2, dbv3, sk, 0, loadOpts)
+}
+
+// checkHashAt is checkHash for the roots of the given version, 0 meaning the latest.
+func checkHashAt(dbv2, dbv3, sk string, version int64, loadOpts iavlLoadOptions) error {
+	if treePath := filepath.Join(dbv3, sk, "tree.sqlite"); fileExists(treePath) {
+		if err := checkStampedShardSize(treePath); err != nil {
+			return err
+		}
+	}
+	v2sql, err := iavl2.NewSqliteDb(iavl2.NewNodePool(), iavl2.DefaultSqliteDbOptions(iavl2.SqliteDbOptions{
+		Path:        fmt.Sprintf("%s/%s", dbv2, sk),
+		CacheSize:   loadOpts.sqliteCacheSize(),
+		MaxPoolSize: loadOpts.readPoolSize,
+	}))
+	if err != nil {
+		return err
+	}
+	v2version := version
+	if v2version == 0 {
+		if v2version, err = v2sql.LatestVersion(); err != nil {
+			return err
+		}
+	}
+	fmt.Println("v2 path: ", fmt.Sprintf("%s/%s", dbv2, sk), "version: ", v2version)
+	v2root, err := v2sql.LoadRoot(v2version)
+	if err != nil {
+		return err
+	}
+	// an empty tree has no root node and no hash, like loadV3RootHashAt reports it
+	var v2hash []byte
+	if v2root != nil {
+		v2hash = v2root.GetHash()
+	}
+	fmt.Printf("v2 root hash: %x \n", v2hash)
+
+	v3version, v3hash, err := loadV3RootHashAt(fmt.Sprintf("%s/%s", dbv3, sk), version, loadOpts)
+	if err != nil {
+		return err
+	}
+	if v2version != v3version {
+		return fmt.Errorf("%w: version not match, v2 %d, v3 %d", ErrHashMismatch, v2version, v3version)
+	}
+
+	if !bytes.Equal(v2hash, v3hash) {
+		diff, err := diagnoseHashMismatch(filepath.Join(dbv2, sk), filepath.Join(dbv3, sk), v2version)
+		if err != nil {
+			log.Printf("could not compare the trees node by node: %v", err)
+			return fmt.Errorf("%w: v2 %x, v3 %x", ErrHashMismatch, v2hash, v3hash)
+		}
+		diff.print(os.Stdout)
+		return fmt.Errorf("%w: v2 %x, v3 %x: %s", ErrHashMismatch, v2hash, v3hash, diff.verdict())
+	}
+	if version == 0 {
+		log.Printf("check finished, latest version %d, root hash %x", v2version, v2hash)
+	} else {
+		log.Printf("check finished, version %d, root hash %x", v2version, v2hash)
+	}
+	return nil
+}
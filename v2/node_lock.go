@@ -0,0 +1,48 @@
+package v2
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// nodeLockFile is the lock file LevelDB, Pebble and RocksDB keep in each database directory
+// of a node, e.g. data/application.db/LOCK. It outlives the node, so only a lock held on it
+// means the node is running.
+const nodeLockFile = "LOCK"
+
+// checkNoRunningNode fails with ErrNodeRunning if a process holds the lock of a database in
+// dataDir or one of its subdirectories, the layout of a node's data directory. Migrating next
+// to a running node would race it for the stores being replaced.
+func checkNoRunningNode(dataDir string) error {
+	candidates := []string{filepath.Join(dataDir, nodeLockFile)}
+	entries, err := os.ReadDir(dataDir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read %s: %w", dataDir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			candidates = append(candidates, filepath.Join(dataDir, entry.Name(), nodeLockFile))
+		}
+	}
+
+	var held []string
+	for _, path := range candidates {
+		if !fileExists(path) {
+			continue
+		}
+		locked, err := lockHeld(path)
+		if err != nil {
+			return fmt.Errorf("check lock %s: %w", path, err)
+		}
+		if locked {
+			held = append(held, path)
+		}
+	}
+	if len(held) > 0 {
+		return fmt.Errorf("%w: %s is locked by another process; stop the node using %s before migrating",
+			ErrNodeRunning, strings.Join(held, ", "), dataDir)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package v2
+
+// lockHeld can't probe locks here, so a running node is not detected.
+func lockHeld(path string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,36 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package v2
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockHeld reports whether another process holds a lock on the file at path, without
+// keeping one itself.
+func lockHeld(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	// a POSIX record lock, as Pebble and RocksDB take, is reported without being taken
+	lk := syscall.Flock_t{Type: syscall.F_WRLCK}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_GETLK, &lk); err != nil {
+		return false, err
+	}
+	if lk.Type != syscall.F_UNLCK {
+		return true, nil
+	}
+	// a BSD lock, as goleveldb takes, can only be probed by briefly sharing it
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,57 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package v2
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// holdLock takes a BSD lock on path through a file of its own, as goleveldb does, until the test ends.
+func holdLock(t *testing.T, path string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	require.NoError(t, syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB))
+}
+
+func TestCheckNoRunningNode(t *testing.T) {
+	dataDir := t.TempDir()
+	// a LOCK left behind by a stopped node is not held
+	require.NoError(t, os.MkdirAll(filepath.Join(dataDir, "blockstore.db"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "blockstore.db", nodeLockFile), nil, 0o644))
+	require.NoError(t, checkNoRunningNode(dataDir))
+	require.NoError(t, checkNoRunningNode(filepath.Join(dataDir, "missing")))
+
+	lockPath := filepath.Join(dataDir, "application.db", nodeLockFile)
+	holdLock(t, lockPath)
+	held, err := lockHeld(lockPath)
+	require.NoError(t, err)
+	require.True(t, held)
+	err = checkNoRunningNode(dataDir)
+	require.ErrorIs(t, err, ErrNodeRunning)
+	require.ErrorContains(t, err, lockPath)
+	require.Equal(t, ExitNodeRunning, ExitCode(err))
+}
+
+func TestMigrateRefusesRunningNode(t *testing.T) {
+	dataDir := t.TempDir()
+	iavl2Path := filepath.Join(dataDir, "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+	holdLock(t, filepath.Join(dataDir, "application.db", nodeLockFile))
+
+	err := migrate(iavl2Path, nil, false, migrateOptions{})
+	require.ErrorIs(t, err, ErrNodeRunning)
+	// nothing was moved aside
+	require.NoDirExists(t, iavl2Path+".bak")
+	require.FileExists(t, filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+
+	err = migrateFiles(filepath.Join(iavl2Path, "bank", "tree.sqlite"), filepath.Join(dataDir, "iavl3", "bank", "tree.sqlite"), "", "", migrateOptions{})
+	require.ErrorIs(t, err, ErrNodeRunning)
+	require.NoDirExists(t, filepath.Join(dataDir, "iavl3"))
+}
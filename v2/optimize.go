@@ -0,0 +1,53 @@
+package v2
+
+import (
+	"fmt"
+	"os"
+)
+
+// optimizeDestination runs ANALYZE on the migrated database at path for --optimize, so the
+// first queries iavl v3 runs have planner statistics, and with --vacuum also VACUUM to undo
+// the fragmentation of the bulk load, logging the file size before and after. It runs after
+// the migration's transactions have committed. A failure is only logged: the migrated data
+// is complete either way, and the step can be repeated by hand.
+func optimizeDestination(path string, opts migrateOptions) {
+	if !opts.optimize && !opts.vacuum {
+		return
+	}
+	lg := opts.logger
+	if err := analyzeAndVacuum(path, opts); err != nil {
+		lg.Event("optimize_failed", logFields{"path": path, "error": err}, "WARNING: optimizing %s failed, the migrated data is unaffected: %v", path, err)
+	}
+}
+
+func analyzeAndVacuum(path string, opts migrateOptions) error {
+	lg := opts.logger
+	db, err := openDest(path, opts)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("ANALYZE"); err != nil {
+		return fmt.Errorf("analyze: %w", err)
+	}
+	lg.Event("analyzed", logFields{"path": path}, "analyzed %s", path)
+	if !opts.vacuum {
+		return nil
+	}
+
+	before, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	lg.Event("vacuumed", logFields{"path": path, "bytes_before": before.Size(), "bytes_after": after.Size()},
+		"vacuumed %s: %s → %s", path, formatBytes(before.Size()), formatBytes(after.Size()))
+	return nil
+}
@@ -0,0 +1,53 @@
+package v2
+
+import (
+	"database/sql"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateOptimize(t *testing.T) {
+	buf := captureLog(t)
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2, 500001)
+
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{vacuum: true}))
+
+	for _, name := range []string{"tree.sqlite", "changelog.sqlite"} {
+		path := filepath.Join(iavl2Path, "bank", name)
+		// ANALYZE may add sqlite_stat4 too, depending on how SQLite was compiled
+		tables := []string{"branch_orphan", "root", "sqlite_stat1", "tree_1", "tree_2"}
+		if name == "changelog.sqlite" {
+			tables = []string{"leaf", "leaf_orphan", "sqlite_stat1"}
+		}
+		requireTablesExist(t, path, tables...)
+		require.Contains(t, buf.String(), "analyzed "+path)
+		require.Regexp(t, "vacuumed "+regexp.QuoteMeta(path)+`: \d+(\.\d)? \w+ → \d+(\.\d)? \w+`, buf.String())
+	}
+}
+
+func TestOptimizeDestinationFailureIsLogged(t *testing.T) {
+	buf := captureLog(t)
+	optimizeDestination(filepath.Join(t.TempDir(), "missing", "tree.sqlite"), migrateOptions{optimize: true})
+	require.Contains(t, buf.String(), "WARNING: optimizing")
+	require.Contains(t, buf.String(), "the migrated data is unaffected")
+
+	// without --optimize nothing runs
+	buf.Reset()
+	optimizeDestination(filepath.Join(t.TempDir(), "missing", "tree.sqlite"), migrateOptions{})
+	require.Empty(t, buf.String())
+}
+
+func requireTablesExist(t *testing.T, path string, want ...string) {
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+	for _, table := range want {
+		exists, err := tableExists(db, table)
+		require.NoError(t, err)
+		require.True(t, exists, "%s has no table %s", path, table)
+	}
+}
@@ -0,0 +1,210 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+func OrphansCommand() *cobra.Command {
+	var (
+		dbPath   string
+		storeKey string
+		bucket   int64
+	)
+
+	cmd := &cobra.Command{
+		Use:   "orphans",
+		Short: "print a histogram of branch and leaf orphans per orphaned-at version in a migrated v3 directory",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if bucket <= 0 {
+				return fmt.Errorf("bucket must be positive, got %d", bucket)
+			}
+			dir := dbPath
+			if storeKey != "" {
+				dir = filepath.Join(dbPath, storeKey)
+			}
+			return printOrphans(os.Stdout, dbPath, dir, bucket)
+		},
+	}
+
+	cmd.Flags().StringVar(&dbPath, "db-path", "", "Path to the migrated iavl2/ directory")
+	if err := cmd.MarkFlagRequired("db-path"); err != nil {
+		panic(err)
+	}
+	cmd.Flags().StringVar(&storeKey, "store-key", "", "Only report this store (default: every store under --db-path)")
+	cmd.Flags().Int64Var(&bucket, "bucket", 1, "Group orphans into ranges of this many versions")
+
+	return cmd
+}
+
+// orphanBucket counts the orphans whose at version is in [from, to].
+type orphanBucket struct {
+	from, to     int64
+	branch, leaf int64
+}
+
+// orphanHistogram is the orphan distribution of one store.
+type orphanHistogram struct {
+	store   string
+	buckets []orphanBucket
+	// missing names the databases or tables that weren't found.
+	missing []string
+}
+
+// printOrphans prints the histogram of every store directory under dir, walking it like
+// check-shards does: a directory holding a tree.sqlite or changelog.sqlite is a store.
+func printOrphans(w io.Writer, dbPath, dir string, bucket int64) error {
+	var walkDir func(dir string) error
+	walkDir = func(dir string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		isStore := false
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if entry.IsDir() {
+				if err := walkDir(path); err != nil {
+					return err
+				}
+				continue
+			}
+			if entry.Name() == "tree.sqlite" || entry.Name() == "changelog.sqlite" {
+				isStore = true
+			}
+		}
+		if !isStore {
+			return nil
+		}
+
+		store, err := filepath.Rel(dbPath, dir)
+		if err != nil {
+			store = dir
+		}
+		hist, err := countOrphans(dir, store, bucket)
+		if err != nil {
+			log.Printf("Error counting orphans in %s: %v", dir, err)
+			return nil
+		}
+		printOrphanHistogram(w, hist)
+		return nil
+	}
+	return walkDir(dir)
+}
+
+// countOrphans groups the branch_orphan rows of dir/tree.sqlite and the leaf_orphan rows of
+// dir/changelog.sqlite by at version, in buckets of bucket versions. Both are opened read-only.
+func countOrphans(dir, store string, bucket int64) (*orphanHistogram, error) {
+	hist := &orphanHistogram{store: store}
+	buckets := make(map[int64]*orphanBucket)
+	for _, src := range []struct {
+		db, table string
+		count     func(*orphanBucket, int64)
+	}{
+		{"tree.sqlite", "branch_orphan", func(b *orphanBucket, n int64) { b.branch += n }},
+		{"changelog.sqlite", "leaf_orphan", func(b *orphanBucket, n int64) { b.leaf += n }},
+	} {
+		path := filepath.Join(dir, src.db)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			hist.missing = append(hist.missing, src.db)
+			continue
+		}
+		db, err := sql.Open("sqlite", readonlyURI(path))
+		if err != nil {
+			return nil, fmt.Errorf("open db %s: %w", path, err)
+		}
+		counts, err := countOrphansByBucket(db, src.table, bucket)
+		db.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if counts == nil {
+			hist.missing = append(hist.missing, src.db+":"+src.table)
+			continue
+		}
+		for from, n := range counts {
+			b, ok := buckets[from]
+			if !ok {
+				b = &orphanBucket{from: from, to: from + bucket - 1}
+				buckets[from] = b
+			}
+			src.count(b, n)
+		}
+	}
+
+	for _, b := range buckets {
+		hist.buckets = append(hist.buckets, *b)
+	}
+	sort.Slice(hist.buckets, func(i, j int) bool { return hist.buckets[i].from < hist.buckets[j].from })
+	return hist, nil
+}
+
+// countOrphansByBucket returns the number of rows of table per bucket, keyed by the first
+// version of the bucket, or nil if the table doesn't exist.
+func countOrphansByBucket(db *sql.DB, table string, bucket int64) (map[int64]int64, error) {
+	ok, err := tableExists(db, table)
+	if err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(fmt.Sprintf(`SELECT (at - 1) / %d * %d + 1 AS bucket, COUNT(*) FROM %s
+		WHERE at IS NOT NULL GROUP BY bucket`, bucket, bucket, table))
+	if err != nil {
+		return nil, fmt.Errorf("count %s: %w", table, err)
+	}
+	defer rows.Close()
+	counts := make(map[int64]int64)
+	for rows.Next() {
+		var from, n int64
+		if err := rows.Scan(&from, &n); err != nil {
+			return nil, fmt.Errorf("count %s: %w", table, err)
+		}
+		counts[from] = n
+	}
+	return counts, rows.Err()
+}
+
+// orphanBarWidth is the length of the longest histogram bar.
+const orphanBarWidth = 40
+
+// printOrphanHistogram prints one row per bucket. CUMULATIVE is how many orphans a prune up to
+// the end of the bucket deletes, since pruning version v removes every orphan with at <= v.
+func printOrphanHistogram(w io.Writer, hist *orphanHistogram) {
+	fmt.Fprintf(w, "\n=== Orphans: %s ===\n", hist.store)
+	for _, name := range hist.missing {
+		fmt.Fprintf(w, "No %s\n", name)
+	}
+	if len(hist.buckets) == 0 {
+		fmt.Fprintf(w, "No orphans\n")
+		return
+	}
+
+	var maxTotal, cumulative int64
+	for _, b := range hist.buckets {
+		maxTotal = max(maxTotal, b.branch+b.leaf)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "AT\tBRANCH\tLEAF\tTOTAL\tCUMULATIVE\t")
+	for _, b := range hist.buckets {
+		total := b.branch + b.leaf
+		cumulative += total
+		at := fmt.Sprint(b.from)
+		if b.to != b.from {
+			at = fmt.Sprintf("%d-%d", b.from, b.to)
+		}
+		bar := strings.Repeat("#", int((total*orphanBarWidth+maxTotal-1)/maxTotal))
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\n", at, b.branch, b.leaf, total, cumulative, bar)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d orphans in %d buckets\n", cumulative, len(hist.buckets))
+}
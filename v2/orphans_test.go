@@ -0,0 +1,49 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintOrphans(t *testing.T) {
+	dbPath := t.TempDir()
+	createMigratedStore(t, dbPath, "bank")
+	for db, stmt := range map[string]string{
+		"tree.sqlite":      "INSERT INTO branch_orphan VALUES (1, 1, 3), (1, 2, 3), (1, 3, 12)",
+		"changelog.sqlite": "INSERT INTO leaf_orphan VALUES (1, 1, 3), (1, 2, 5)",
+	} {
+		sqlDB, err := sql.Open("sqlite", filepath.Join(dbPath, "bank", db))
+		require.NoError(t, err)
+		_, err = sqlDB.Exec(stmt)
+		require.NoError(t, err)
+		require.NoError(t, sqlDB.Close())
+	}
+	// a store whose changelog wasn't migrated
+	createMigratedStore(t, dbPath, "staking")
+	require.NoError(t, os.Remove(filepath.Join(dbPath, "staking", "changelog.sqlite")))
+
+	var buf bytes.Buffer
+	require.NoError(t, printOrphans(&buf, dbPath, dbPath, 1))
+	out := buf.String()
+	require.Contains(t, out, "=== Orphans: bank ===")
+	require.Regexp(t, `\n3\s+2\s+1\s+3\s+3\s+#{40}\n`, out)
+	require.Regexp(t, `\n5\s+0\s+1\s+1\s+4\s+#{14}\n`, out)
+	require.Regexp(t, `\n12\s+1\s+0\s+1\s+5\s+#{14}\n`, out)
+	require.Contains(t, out, "5 orphans in 3 buckets")
+	require.Contains(t, out, "=== Orphans: staking ===\nNo changelog.sqlite\nNo orphans\n")
+
+	// buckets of 10 versions, one store only
+	buf.Reset()
+	require.NoError(t, printOrphans(&buf, dbPath, filepath.Join(dbPath, "bank"), 10))
+	out = buf.String()
+	require.NotContains(t, out, "staking")
+	require.Regexp(t, `\n1-10\s+2\s+2\s+4\s+4\s+#{40}\n`, out)
+	require.Regexp(t, `\n11-20\s+1\s+0\s+1\s+5\s+#{10}\n`, out)
+	require.Equal(t, 1, strings.Count(out, "=== Orphans"))
+}
@@ -0,0 +1,225 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// progressRedrawInterval is how often the progress display is redrawn.
+const progressRedrawInterval = 200 * time.Millisecond
+
+// progressTracker draws one line per store being migrated, with its share of rows copied,
+// and a total line, redrawing them in place on a terminal. While it runs, log output bound
+// for stderr is held back and written once it stops, so log lines don't tear up the display;
+// a --log-file keeps receiving it. A nil *progressTracker draws nothing.
+type progressTracker struct {
+	out   io.Writer
+	stop  chan struct{}
+	done  chan struct{}
+	start time.Time
+
+	mu     sync.Mutex
+	stores []*storeProgress
+	// finished and total count stores; finishedRows the rows of the finished ones.
+	finished, total int
+	finishedRows    int64
+	// lines is how many lines the last draw wrote, to move back over.
+	lines int
+
+	// prevLog is the log output held back in held, nil if it isn't.
+	held     lockedBuffer
+	prevLog  io.Writer
+	stopOnce sync.Once
+}
+
+// storeProgress counts the rows copied for one store. A nil *storeProgress counts nothing,
+// so the copy loops can report to it unconditionally.
+type storeProgress struct {
+	store string
+	// total is the estimated rows of the store's sources; 0 if unknown.
+	total int64
+	done  atomic.Int64
+}
+
+func (s *storeProgress) add(n int64) {
+	if s != nil {
+		s.done.Add(n)
+	}
+}
+
+func (s *storeProgress) reset() {
+	if s != nil {
+		s.done.Store(0)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer that log.Print and JSON log events, which hold different
+// locks, can write to at once.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// isTerminal reports whether f is a character device, such as an interactive terminal rather
+// than a pipe or file.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// startProgressTracker starts redrawing the progress of stores stores to out and holds back
+// log output until stopAndFlush.
+func startProgressTracker(out io.Writer, stores int) *progressTracker {
+	p := &progressTracker{out: out, total: stores, stop: make(chan struct{}), done: make(chan struct{}), start: time.Now()}
+	if log.Writer() == os.Stderr {
+		p.prevLog = log.Writer()
+		log.SetOutput(&p.held)
+	}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(progressRedrawInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.draw()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// startStore adds a line for store, whose sources hold about total rows.
+func (p *progressTracker) startStore(store string, total int64) *storeProgress {
+	if p == nil {
+		return nil
+	}
+	s := &storeProgress{store: store, total: total}
+	p.mu.Lock()
+	p.stores = append(p.stores, s)
+	p.mu.Unlock()
+	return s
+}
+
+// finishStore drops the line of s, counting its store as done.
+func (p *progressTracker) finishStore(s *storeProgress) {
+	if p == nil || s == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if i := slices.Index(p.stores, s); i >= 0 {
+		p.stores = slices.Delete(p.stores, i, i+1)
+		p.finished++
+		p.finishedRows += s.done.Load()
+	}
+}
+
+// stopAndFlush stops redrawing, clears the display and writes the held back log output.
+func (p *progressTracker) stopAndFlush() {
+	if p == nil {
+		return
+	}
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		<-p.done
+		p.mu.Lock()
+		p.clear()
+		p.mu.Unlock()
+		if p.prevLog == nil {
+			return
+		}
+		log.SetOutput(p.prevLog)
+		p.held.mu.Lock()
+		p.prevLog.Write(p.held.buf.Bytes())
+		p.held.buf.Reset()
+		p.held.mu.Unlock()
+	})
+}
+
+// clear moves the cursor back over the last draw and erases it; p.mu must be held.
+func (p *progressTracker) clear() {
+	if p.lines > 0 {
+		fmt.Fprintf(p.out, "\x1b[%dA\x1b[J", p.lines)
+		p.lines = 0
+	}
+}
+
+// draw redraws a line per active store and the total line in place of the last draw.
+func (p *progressTracker) draw() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var buf bytes.Buffer
+	rows := p.finishedRows
+	for _, s := range p.stores {
+		n := s.done.Load()
+		rows += n
+		fmt.Fprintf(&buf, "  %-20s %s\n", s.store, progressText(n, s.total, "rows"))
+	}
+	fmt.Fprintf(&buf, "  %-20s %s, %d rows copied, %s elapsed\n", "total",
+		progressText(int64(p.finished), int64(p.total), "stores"), rows, time.Since(p.start).Round(time.Second))
+	p.clear()
+	p.out.Write(buf.Bytes())
+	p.lines = bytes.Count(buf.Bytes(), []byte("\n"))
+}
+
+// progressText renders done of total units as a bar and percentage, or only done when the
+// total is unknown. An estimated total may fall short, so the bar stops at 100%.
+func progressText(done, total int64, unit string) string {
+	if total <= 0 {
+		return fmt.Sprintf("%d %s", done, unit)
+	}
+	const width = 30
+	frac := min(float64(done)/float64(total), 1)
+	filled := int(frac * width)
+	return fmt.Sprintf("[%s%s] %5.1f%% %d/%d %s", bytes.Repeat([]byte("#"), filled), bytes.Repeat([]byte("-"), width-filled),
+		frac*100, done, total, unit)
+}
+
+// estimateSourceRows estimates the rows the v2 sources at paths hold from the largest rowid of
+// their tree_N and leaf tables, which costs one index lookup each unlike COUNT(*). Sources
+// that can't be read, such as compressed ones, count as 0.
+func estimateSourceRows(paths ...string) int64 {
+	var total int64
+	for _, path := range paths {
+		if !fileExists(path) {
+			continue
+		}
+		db, err := sql.Open("sqlite", readonlyURI(path))
+		if err != nil {
+			continue
+		}
+		tables := []string{"leaf"}
+		if shardIDs, err := listShardIDs(db); err == nil {
+			for _, shardID := range shardIDs {
+				tables = append(tables, fmt.Sprintf("tree_%d", shardID))
+			}
+		}
+		for _, table := range tables {
+			var rows sql.NullInt64
+			if db.QueryRow(fmt.Sprintf("SELECT MAX(rowid) FROM %s", table)).Scan(&rows) == nil {
+				total += rows.Int64
+			}
+		}
+		db.Close()
+	}
+	return total
+}
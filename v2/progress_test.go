@@ -0,0 +1,56 @@
+package v2
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressTracker(t *testing.T) {
+	var out bytes.Buffer
+	p := startProgressTracker(&out, 2)
+	// draw by hand instead of waiting for the ticker
+	p.stopAndFlush()
+	out.Reset()
+
+	bank := p.startStore("bank", 200)
+	evm := p.startStore("evm", 0)
+	bank.add(50)
+	evm.add(7)
+	p.draw()
+	require.Equal(t, "  bank                 [#######-----------------------]  25.0% 50/200 rows\n"+
+		"  evm                  7 rows\n"+
+		"  total                [------------------------------]   0.0% 0/2 stores, 57 rows copied, 0s elapsed\n", out.String())
+
+	// the next draw replaces the three lines, without the finished store
+	out.Reset()
+	bank.add(250)
+	p.finishStore(bank)
+	p.draw()
+	require.True(t, strings.HasPrefix(out.String(), "\x1b[3A\x1b[J  evm "))
+	require.Contains(t, out.String(), "[###############---------------]  50.0% 1/2 stores, 307 rows copied")
+
+	out.Reset()
+	p.mu.Lock()
+	p.clear()
+	p.mu.Unlock()
+	require.Equal(t, "\x1b[2A\x1b[J", out.String())
+}
+
+func TestMigrateStoreProgress(t *testing.T) {
+	baseOld, baseNew := t.TempDir(), t.TempDir()
+	createV2Store(t, filepath.Join(baseOld, "bank"), 1, 2, 500001)
+	require.Equal(t, int64(6), estimateSourceRows(filepath.Join(baseOld, "bank", "tree.sqlite"), filepath.Join(baseOld, "bank", "changelog.sqlite")))
+
+	var out bytes.Buffer
+	p := startProgressTracker(&out, 1)
+	p.stopAndFlush()
+	res, err := migrateStoreWithRetry("bank", baseOld, baseNew, migrateOptions{progress: p})
+	require.NoError(t, err)
+	require.Equal(t, 1, p.finished)
+	require.Equal(t, res.treeRows+res.changelogRows, p.finishedRows)
+	require.Equal(t, int64(6), p.finishedRows)
+}
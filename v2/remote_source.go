@@ -0,0 +1,171 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// downloadProgressInterval is how often a running source download logs its progress.
+const downloadProgressInterval = 10 * time.Second
+
+// isRemoteSource reports whether source is an http(s):// or s3:// URL rather than a local path.
+func isRemoteSource(source string) bool {
+	for _, scheme := range []string{"http://", "https://", "s3://"} {
+		if strings.HasPrefix(source, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteSourceURL returns the URL source is downloaded from. An s3://bucket/key source maps to
+// the bucket's virtual-hosted HTTPS endpoint and is fetched unsigned, so the object must be
+// public; pass a presigned https:// URL for a private one.
+func remoteSourceURL(source string) (string, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("invalid source URL %q: %w", source, err)
+	}
+	if u.Scheme != "s3" {
+		return source, nil
+	}
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return "", fmt.Errorf("invalid source URL %q: want s3://bucket/key", source)
+	}
+	return (&url.URL{Scheme: "https", Host: u.Host + ".s3.amazonaws.com", Path: u.Path}).String(), nil
+}
+
+// downloadSource downloads the remote source into a temporary file in dir and returns its path,
+// which keeps the source's name so a .zst or .gz download is still decompressed, and a cleanup
+// that removes it. SQLite needs random access, so the whole file is fetched before migrating;
+// its size is logged upfront and checked against the free space of dir.
+func downloadSource(ctx context.Context, client *http.Client, source, dir string, opts migrateOptions) (string, func(), error) {
+	lg := opts.logger
+	srcURL, err := remoteSourceURL(source)
+	if err != nil {
+		return "", nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srcURL, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("download %s: %w", source, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", nil, fmt.Errorf("source %s %w", source, ErrSourceNotFound)
+	case resp.StatusCode != http.StatusOK:
+		return "", nil, fmt.Errorf("download %s: %s", source, resp.Status)
+	}
+
+	if err := os.MkdirAll(dir, opts.dirPerm()); err != nil {
+		return "", nil, err
+	}
+	size := resp.ContentLength
+	if size >= 0 && !opts.skipSpaceCheck {
+		avail, err := freeSpace(existingAncestor(dir))
+		if err != nil && !errors.Is(err, errFreeSpaceUnsupported) {
+			return "", nil, fmt.Errorf("check free space of %s: %w", dir, err)
+		}
+		if err == nil && size > avail {
+			return "", nil, fmt.Errorf("%w: downloading %s into %s needs %s but only %s is free; free up space or pass --skip-space-check",
+				ErrInsufficientSpace, source, dir, formatBytes(size), formatBytes(avail))
+		}
+	}
+
+	out, err := os.CreateTemp(dir, "download.*."+path.Base(req.URL.Path))
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { removeSQLiteFiles(out.Name()) }
+
+	sizeText := "unknown size"
+	if size >= 0 {
+		sizeText = formatBytes(size)
+	}
+	lg.Event("download", logFields{"source": source, "path": out.Name(), "bytes": size}, "downloading %s (%s) to %s", source, sizeText, out.Name())
+	start := time.Now()
+	n, err := io.Copy(out, &progressReader{r: resp.Body, last: start, report: func(read int64) {
+		lg.Event("download_progress", logFields{"source": source, "bytes": read, "total": size}, "downloaded %s of %s", formatBytes(read), sizeText)
+	}})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("got %d of %d bytes", n, size)
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("download %s: %w", source, err)
+	}
+	lg.Event("downloaded", logFields{"source": source, "bytes": n, "duration_ms": time.Since(start).Milliseconds()},
+		"downloaded %s (%s) in %s", source, formatBytes(n), time.Since(start).Round(time.Second))
+	return out.Name(), cleanup, nil
+}
+
+// progressReader passes r through, calling report with the bytes read so far at most every
+// downloadProgressInterval.
+type progressReader struct {
+	r      io.Reader
+	read   int64
+	last   time.Time
+	report func(read int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if now := time.Now(); now.Sub(p.last) >= downloadProgressInterval {
+		p.last = now
+		p.report(p.read)
+	}
+	return n, err
+}
+
+// fetchRemoteSources downloads the sources of migrateFiles that are URLs next to their
+// destinations and returns the local paths to migrate from, with a cleanup removing the
+// downloads. A combined source named as both tree and changelog is downloaded once.
+func fetchRemoteSources(oldTree, newTree, oldChangelog, newChangelog string, opts migrateOptions) (string, string, func(), error) {
+	var cleanups []func()
+	cleanup := func() {
+		for _, c := range cleanups {
+			c()
+		}
+	}
+	fetch := func(source, dest string) (string, error) {
+		if !isRemoteSource(source) {
+			return source, nil
+		}
+		local, done, err := downloadSource(opts.context(), http.DefaultClient, source, filepath.Dir(dest), opts)
+		if err != nil {
+			return "", err
+		}
+		cleanups = append(cleanups, done)
+		return local, nil
+	}
+
+	localTree, err := fetch(oldTree, newTree)
+	if err != nil {
+		return "", "", nil, err
+	}
+	localChangelog := localTree
+	if oldChangelog != oldTree {
+		if localChangelog, err = fetch(oldChangelog, newChangelog); err != nil {
+			cleanup()
+			return "", "", nil, err
+		}
+	}
+	return localTree, localChangelog, cleanup, nil
+}
@@ -0,0 +1,50 @@
+package v2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteSourceURL(t *testing.T) {
+	require.True(t, isRemoteSource("https://example.com/bank/tree.sqlite"))
+	require.True(t, isRemoteSource("s3://snapshots/bank/tree.sqlite"))
+	require.False(t, isRemoteSource("/data/iavl2/bank/tree.sqlite"))
+
+	u, err := remoteSourceURL("s3://snapshots/bank/tree.sqlite.zst")
+	require.NoError(t, err)
+	require.Equal(t, "https://snapshots.s3.amazonaws.com/bank/tree.sqlite.zst", u)
+	_, err = remoteSourceURL("s3://snapshots")
+	require.ErrorContains(t, err, "want s3://bucket/key")
+}
+
+func TestMigrateFilesFromURL(t *testing.T) {
+	srcDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, srcDir, 1, 2)
+	srv := httptest.NewServer(http.FileServer(http.Dir(srcDir)))
+	defer srv.Close()
+
+	destDir := t.TempDir()
+	newTree := filepath.Join(destDir, "tree.sqlite")
+	newChangelog := filepath.Join(destDir, "changelog.sqlite")
+	captureLog(t)
+	require.NoError(t, migrateFiles(srv.URL+"/tree.sqlite", newTree, srv.URL+"/changelog.sqlite", newChangelog, migrateOptions{}))
+	require.EqualValues(t, 2, countTableRows(t, newTree, "root"))
+	require.EqualValues(t, 2, countTableRows(t, newChangelog, "leaf"))
+
+	// the downloads are removed once migrated
+	entries, err := os.ReadDir(destDir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	require.ElementsMatch(t, []string{"tree.sqlite", "changelog.sqlite"}, names)
+
+	err = migrateFiles(srv.URL+"/missing.sqlite", filepath.Join(t.TempDir(), "tree.sqlite"), "", "", migrateOptions{})
+	require.ErrorIs(t, err, ErrSourceNotFound)
+}
@@ -0,0 +1,79 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// storeReport is one store's row in the --report-file written after a migration.
+type storeReport struct {
+	Store         string `json:"store"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+	TreeRows      int64  `json:"tree_rows"`
+	ChangelogRows int64  `json:"changelog_rows"`
+	DurationMS    int64  `json:"duration_ms"`
+}
+
+// reportNotStarted is the status of a selected store the run stopped before reaching, e.g.
+// after another store failed without --continue-on-error.
+const reportNotStarted = "not_started"
+
+// writeReport writes the final status of every store in stores to path, as JSON if path ends
+// in .json and as CSV otherwise. Stores without a result never started.
+func writeReport(path string, stores []string, results []storeResult) error {
+	byStore := make(map[string]storeResult, len(results))
+	for _, res := range results {
+		byStore[res.store] = res
+	}
+	reports := make([]storeReport, 0, len(stores))
+	for _, store := range stores {
+		res, ok := byStore[store]
+		if !ok {
+			reports = append(reports, storeReport{Store: store, Status: reportNotStarted})
+			continue
+		}
+		report := storeReport{
+			Store:         store,
+			Status:        res.status(),
+			TreeRows:      res.treeRows,
+			ChangelogRows: res.changelogRows,
+			DurationMS:    (res.treeDuration + res.changelogDuration).Milliseconds(),
+		}
+		if res.err != nil {
+			report.Error = res.err.Error()
+		}
+		reports = append(reports, report)
+	}
+
+	var data []byte
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		bz, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(bz, '\n')
+	} else {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"store", "status", "error", "tree_rows", "changelog_rows", "duration_ms"})
+		for _, r := range reports {
+			w.Write([]string{r.Store, r.Status, r.Error, strconv.FormatInt(r.TreeRows, 10),
+				strconv.FormatInt(r.ChangelogRows, 10), strconv.FormatInt(r.DurationMS, 10)})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("write report %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package v2
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateReportFile(t *testing.T) {
+	newSource := func(t *testing.T) string {
+		iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+		createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2)
+		// a store without a tree fails
+		require.NoError(t, os.MkdirAll(filepath.Join(iavl2Path, "broken"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(iavl2Path, "broken", "changelog.sqlite"), nil, 0o644))
+		return iavl2Path
+	}
+
+	t.Run("json", func(t *testing.T) {
+		reportPath := filepath.Join(t.TempDir(), "report.json")
+		err := migrate(newSource(t), nil, false, migrateOptions{reportFile: reportPath, continueOnError: true})
+		require.ErrorIs(t, err, ErrPartialFailure)
+
+		data, err := os.ReadFile(reportPath)
+		require.NoError(t, err)
+		var reports []storeReport
+		require.NoError(t, json.Unmarshal(data, &reports))
+		require.Len(t, reports, 2)
+		require.Equal(t, "bank", reports[0].Store)
+		require.Equal(t, "ok", reports[0].Status)
+		require.Empty(t, reports[0].Error)
+		require.EqualValues(t, 2, reports[0].TreeRows)
+		require.EqualValues(t, 2, reports[0].ChangelogRows)
+		require.Equal(t, "broken", reports[1].Store)
+		require.Equal(t, "failed", reports[1].Status)
+		require.Contains(t, reports[1].Error, "tree.sqlite not found")
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		reportPath := filepath.Join(t.TempDir(), "report.csv")
+		// without --continue-on-error the run stops at the broken store and still reports
+		err := migrate(newSource(t), []string{"broken", "bank"}, false, migrateOptions{reportFile: reportPath})
+		require.Error(t, err)
+
+		f, err := os.Open(reportPath)
+		require.NoError(t, err)
+		defer f.Close()
+		records, err := csv.NewReader(f).ReadAll()
+		require.NoError(t, err)
+		require.Equal(t, []string{"store", "status", "error", "tree_rows", "changelog_rows", "duration_ms"}, records[0])
+		require.Len(t, records, 3)
+		statuses := map[string]string{records[1][0]: records[1][1], records[2][0]: records[2][1]}
+		require.Equal(t, "failed", statuses["broken"])
+		require.Contains(t, []string{reportNotStarted, "ok", "interrupted"}, statuses["bank"])
+	})
+}
@@ -0,0 +1,105 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// retryBackoff is the wait before the first retry of a store; it doubles on every further attempt
+// up to maxRetryBackoff.
+var (
+	retryBackoff    = time.Second
+	maxRetryBackoff = 30 * time.Second
+)
+
+// Primary SQLite result codes that are worth retrying.
+const (
+	sqliteBusy     = 5
+	sqliteLocked   = 6
+	sqliteIOErr    = 10
+	sqliteProtocol = 15
+)
+
+// isTransientSQLiteError reports whether err carries a SQLite result code that may clear up on
+// its own, such as a lock held by another connection. Extended codes are reduced to their primary code.
+// A full disk, even when reported as an I/O error, only clears up once space is freed.
+func isTransientSQLiteError(err error) bool {
+	var coded interface{ Code() int }
+	if !errors.As(err, &coded) || isDiskFull(err) {
+		return false
+	}
+	switch coded.Code() & 0xff {
+	case sqliteBusy, sqliteLocked, sqliteIOErr, sqliteProtocol:
+		return true
+	}
+	return false
+}
+
+// migrateStoreWithRetry runs migrateStore, retrying up to opts.maxRetries times with exponential
+// backoff when it fails with a transient SQLite error. The store's partial destination is removed
+// before each retry. Other errors fail immediately.
+func migrateStoreWithRetry(store, baseOld, baseNew string, opts migrateOptions) (res storeResult, err error) {
+	defer func() { opts.metrics.storeFinished(err) }()
+	if opts.progress != nil {
+		opts.storeProgress = opts.progress.startStore(store, estimateSourceRows(storeSourcePaths(baseOld, store, opts)...))
+		defer opts.progress.finishStore(opts.storeProgress)
+	}
+	if opts.groupedLogs {
+		opts.logger = opts.logger.grouped()
+		defer opts.logger.flush()
+	}
+	ctx := opts.context()
+	lg := opts.logger.with(logFields{"store": store})
+	backoff := retryBackoff
+
+	for attempt := 1; ; attempt++ {
+		res, err = migrateStoreWithTimeout(store, baseOld, baseNew, opts)
+		if err == nil || attempt > opts.maxRetries || !isTransientSQLiteError(err) || ctx.Err() != nil {
+			return res, err
+		}
+
+		lg.Event("retry", logFields{"attempt": attempt, "max_retries": opts.maxRetries, "backoff_ms": backoff.Milliseconds(), "error": err},
+			"store %s failed with transient error, retry %d/%d in %s: %v", store, attempt, opts.maxRetries, backoff, err)
+		if err := removeStoreOutput(baseNew, store, opts); err != nil {
+			return res, err
+		}
+		// the retry copies everything again
+		opts.storeProgress.reset()
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return res, ctx.Err()
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+// migrateStoreWithTimeout runs migrateStore, cancelling it once it has run for opts.storeTimeout
+// if that is set. A store cancelled that way has its partial output removed and fails with
+// ErrStoreTimeout; cancelling the whole run is still reported as such.
+func migrateStoreWithTimeout(store, baseOld, baseNew string, opts migrateOptions) (storeResult, error) {
+	if opts.storeTimeout <= 0 {
+		return migrateStore(store, baseOld, baseNew, opts)
+	}
+	parent := opts.context()
+	ctx, cancel := context.WithTimeout(parent, opts.storeTimeout)
+	defer cancel()
+	opts.ctx = ctx
+
+	res, err := migrateStore(store, baseOld, baseNew, opts)
+	if err == nil || parent.Err() != nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return res, err
+	}
+	lg := opts.logger.with(logFields{"store": store})
+	dir := filepath.Join(baseNew, store)
+	lg.Event("store_timeout", logFields{"timeout_ms": opts.storeTimeout.Milliseconds(), "path": dir},
+		"store %s ran longer than %s, removing partially migrated store %s", store, opts.storeTimeout, dir)
+	if rerr := removeStoreOutput(baseNew, store, opts); rerr != nil {
+		lg.Event("cleanup_failed", logFields{"error": rerr}, "remove %s: %v", dir, rerr)
+	}
+	return res, fmt.Errorf("%w: store %s did not finish within %s: %w", ErrStoreTimeout, store, opts.storeTimeout, err)
+}
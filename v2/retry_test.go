@@ -0,0 +1,59 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type codedError struct{ code int }
+
+func (e codedError) Error() string { return fmt.Sprintf("sqlite error %d", e.code) }
+func (e codedError) Code() int     { return e.code }
+
+func TestIsTransientSQLiteError(t *testing.T) {
+	require.True(t, isTransientSQLiteError(codedError{sqliteBusy}))
+	require.True(t, isTransientSQLiteError(fmt.Errorf("migrate shard tree_1: %w", codedError{sqliteLocked})))
+	// SQLITE_IOERR_SHORT_READ
+	require.True(t, isTransientSQLiteError(codedError{sqliteIOErr | 2<<8}))
+	// SQLITE_CORRUPT, SQLITE_CONSTRAINT
+	require.False(t, isTransientSQLiteError(codedError{11}))
+	require.False(t, isTransientSQLiteError(codedError{19}))
+	require.False(t, isTransientSQLiteError(errors.New("tree.sqlite not found")))
+}
+
+func TestMigrateStoreWithRetryFailsFast(t *testing.T) {
+	baseOld, baseNew := t.TempDir(), t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(baseOld, "bank"), 0o755))
+
+	buf := captureLog(t)
+	_, err := migrateStoreWithRetry("bank", baseOld, baseNew, migrateOptions{maxRetries: 3})
+	require.ErrorContains(t, err, "tree.sqlite not found")
+	require.NotContains(t, buf.String(), "retry")
+}
+
+func TestMigrateStoreTimeout(t *testing.T) {
+	baseOld, baseNew := t.TempDir(), t.TempDir()
+	createV2Store(t, filepath.Join(baseOld, "bank"), 1, 500001)
+
+	// no shard worker ever gets a slot, standing in for a store that never finishes
+	opts := migrateOptions{shardWorkers: 2, shardSlots: make(chan struct{}), storeTimeout: 100 * time.Millisecond}
+	buf := captureLog(t)
+	_, err := migrateStoreWithRetry("bank", baseOld, baseNew, opts)
+	require.ErrorIs(t, err, ErrStoreTimeout)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, "timed_out", storeResult{store: "bank", err: err}.status())
+	require.NoDirExists(t, filepath.Join(baseNew, "bank"))
+	require.Contains(t, buf.String(), "removing partially migrated store")
+
+	// without the timeout the same store migrates
+	opts.storeTimeout, opts.shardSlots = 0, nil
+	_, err = migrateStoreWithRetry("bank", baseOld, baseNew, opts)
+	require.NoError(t, err)
+}
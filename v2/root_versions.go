@@ -0,0 +1,199 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func CheckRootsCommand() *cobra.Command {
+	var path string
+
+	cmd := &cobra.Command{
+		Use:   "check-roots",
+		Short: "check that every version between the first and last root of each v2 store has exactly one root row",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return checkSourceRoots(os.Stdout, path)
+		},
+	}
+
+	cmd.Flags().StringVar(&path, "path", "", "Path to the v2 iavl2/ directory")
+	if err := cmd.MarkFlagRequired("path"); err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+// rootVersionReport is what inspectRootVersions finds in a tree's root table.
+type rootVersionReport struct {
+	// minVersion and maxVersion are NULL when the root table is empty.
+	minVersion, maxVersion sql.NullInt64
+	// gaps are the inclusive version ranges between minVersion and maxVersion without a root,
+	// at most sourceCheckMaxErrors of them; missing counts every missing version.
+	gaps    [][2]int64
+	missing int64
+	// duplicates maps versions with more than one root row to their row count, for at most
+	// sourceCheckMaxErrors versions.
+	duplicates map[int64]int64
+}
+
+func (r rootVersionReport) ok() bool {
+	return r.missing == 0 && len(r.duplicates) == 0
+}
+
+// problems describes the report's offending versions, e.g. "versions 5-9 have no root".
+func (r rootVersionReport) problems() []string {
+	var problems []string
+	for _, gap := range r.gaps {
+		if gap[0] == gap[1] {
+			problems = append(problems, fmt.Sprintf("version %d has no root", gap[0]))
+		} else {
+			problems = append(problems, fmt.Sprintf("versions %d-%d have no root", gap[0], gap[1]))
+		}
+	}
+	for _, version := range slices.Sorted(maps.Keys(r.duplicates)) {
+		problems = append(problems, fmt.Sprintf("version %d has %d roots", version, r.duplicates[version]))
+	}
+	return problems
+}
+
+// inspectRootVersions finds the versions between the first and last root of db that have no
+// root row or more than one. iavl v3 navigates versions through their roots, so either breaks
+// the migrated tree. Both queries read only the root table, which has a row per version.
+func inspectRootVersions(db *sql.DB) (rootVersionReport, error) {
+	var report rootVersionReport
+	if err := db.QueryRow("SELECT MIN(version), MAX(version) FROM root").Scan(&report.minVersion, &report.maxVersion); err != nil {
+		return report, fmt.Errorf("read root version range: %w", err)
+	}
+	if !report.minVersion.Valid {
+		return report, nil
+	}
+
+	rows, err := db.Query(`SELECT version + 1, next - 1 FROM (
+		SELECT version, LEAD(version) OVER (ORDER BY version) AS next
+		FROM (SELECT DISTINCT version FROM root WHERE version IS NOT NULL)
+	) WHERE next > version + 1 ORDER BY version`)
+	if err != nil {
+		return report, fmt.Errorf("find root gaps: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var gap [2]int64
+		if err := rows.Scan(&gap[0], &gap[1]); err != nil {
+			return report, fmt.Errorf("find root gaps: %w", err)
+		}
+		report.missing += gap[1] - gap[0] + 1
+		if len(report.gaps) < sourceCheckMaxErrors {
+			report.gaps = append(report.gaps, gap)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return report, fmt.Errorf("find root gaps: %w", err)
+	}
+
+	dups, err := db.Query(fmt.Sprintf("SELECT version, COUNT(*) FROM root GROUP BY version HAVING COUNT(*) > 1 ORDER BY version LIMIT %d", sourceCheckMaxErrors))
+	if err != nil {
+		return report, fmt.Errorf("find duplicate roots: %w", err)
+	}
+	defer dups.Close()
+	for dups.Next() {
+		var version, count int64
+		if err := dups.Scan(&version, &count); err != nil {
+			return report, fmt.Errorf("find duplicate roots: %w", err)
+		}
+		if report.duplicates == nil {
+			report.duplicates = make(map[int64]int64)
+		}
+		report.duplicates[version] = count
+	}
+	return report, dups.Err()
+}
+
+// checkRootVersions is --verify-source's root check of the source tree db opened from path: it
+// fails with ErrCorruptSource, naming the offending versions, unless every version between
+// the first and last root has exactly one root row.
+func checkRootVersions(db *sql.DB, path string, lg *migrationLogger) error {
+	lg.Event("verify_roots", logFields{"source": path}, "checking root versions of %s", path)
+	report, err := inspectRootVersions(db)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if !report.ok() {
+		return fmt.Errorf("%w: %s has %d versions without a root and %d with several between versions %d and %d: %s",
+			ErrCorruptSource, path, report.missing, len(report.duplicates), report.minVersion.Int64, report.maxVersion.Int64,
+			strings.Join(report.problems(), "; "))
+	}
+	return nil
+}
+
+// checkSourceRoots runs inspectRootVersions on the tree.sqlite of every store under path,
+// printing a row per store and the offending versions below, and fails with ErrCorruptSource
+// if any store has missing or duplicate roots.
+func checkSourceRoots(w io.Writer, path string) error {
+	stores, _, err := getStoreKeys(path, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	var bad []string
+	details := make(map[string][]string)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tVERSIONS\tMISSING\tDUPLICATED\tSTATUS")
+	for _, store := range stores {
+		treePath := filepath.Join(path, store, "tree.sqlite")
+		if !fileExists(treePath) {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\tno tree.sqlite\n", store)
+			continue
+		}
+		report, err := inspectTreeRoots(treePath)
+		if err != nil {
+			tw.Flush()
+			return err
+		}
+		versions, status := "-", "ok"
+		if report.minVersion.Valid {
+			versions = fmt.Sprintf("%d-%d", report.minVersion.Int64, report.maxVersion.Int64)
+		}
+		if !report.ok() {
+			status = "BAD"
+			bad = append(bad, store)
+			details[store] = report.problems()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", store, versions, report.missing, len(report.duplicates), status)
+	}
+	tw.Flush()
+
+	for _, store := range bad {
+		fmt.Fprintf(w, "\n%s:\n", store)
+		for _, problem := range details[store] {
+			fmt.Fprintf(w, "  %s\n", problem)
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%w: %d stores under %s have missing or duplicate roots: %v", ErrCorruptSource, len(bad), path, bad)
+	}
+	return nil
+}
+
+// inspectTreeRoots opens the tree.sqlite at path read-only and runs inspectRootVersions on it.
+func inspectTreeRoots(path string) (rootVersionReport, error) {
+	db, err := sql.Open("sqlite", readonlyURI(path))
+	if err != nil {
+		return rootVersionReport{}, fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+	report, err := inspectRootVersions(db)
+	if err != nil {
+		return report, fmt.Errorf("%s: %w", path, err)
+	}
+	return report, nil
+}
@@ -0,0 +1,53 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectRootVersions(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "tree.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE root (version int, node_version int, node_sequence int, bytes blob);
+		INSERT INTO root VALUES (3, 3, 1, x'01'), (4, 4, 1, x'01'), (4, 4, 1, x'01'), (6, 6, 1, x'01'), (10, 10, 1, x'01');
+	`)
+	require.NoError(t, err)
+
+	report, err := inspectRootVersions(db)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, report.minVersion.Int64)
+	require.EqualValues(t, 10, report.maxVersion.Int64)
+	require.EqualValues(t, 4, report.missing)
+	require.False(t, report.ok())
+	require.Equal(t, []string{"version 5 has no root", "versions 7-9 have no root", "version 4 has 2 roots"}, report.problems())
+}
+
+func TestCheckRootVersions(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2, 3)
+	createV2Store(t, filepath.Join(iavl2Path, "staking"), 1, 2, 5)
+
+	var buf bytes.Buffer
+	err := checkSourceRoots(&buf, iavl2Path)
+	require.ErrorIs(t, err, ErrCorruptSource)
+	require.ErrorContains(t, err, "[staking]")
+	require.Regexp(t, `bank\s+1-3\s+0\s+0\s+ok`, buf.String())
+	require.Regexp(t, `staking\s+1-5\s+2\s+0\s+BAD`, buf.String())
+	require.Contains(t, buf.String(), "staking:\n  versions 3-4 have no root\n")
+
+	// --verify-source refuses the store before copying anything
+	captureLog(t)
+	newPath := filepath.Join(t.TempDir(), "tree.sqlite")
+	_, err = migrateTree(filepath.Join(iavl2Path, "staking", "tree.sqlite"), newPath, migrateOptions{verifySource: true})
+	require.ErrorIs(t, err, ErrCorruptSource)
+	require.ErrorContains(t, err, "versions 3-4 have no root")
+	_, err = os.Stat(newPath)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,222 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	nodepool3 "github.com/SaharaLabsAI/iavl/v2/common/pool/node"
+	iavl3 "github.com/SaharaLabsAI/iavl/v2/db/sqlite"
+	iavl2 "github.com/sahara/iavl"
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+func SelftestCommand() *cobra.Command {
+	var dir string
+
+	cmd := &cobra.Command{
+		Use:   "selftest",
+		Short: "migrate a small generated v2 tree and check every root hash, to confirm this build works",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if dir == "" {
+				tmp, err := os.MkdirTemp("", "iavl-migration-selftest")
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(tmp)
+				dir = tmp
+			}
+			return selftest(os.Stdout, dir, migrateOptions{ctx: cmd.Context(), validateRoot: true})
+		},
+	}
+
+	cmd.Flags().StringVar(&dir, "dir", "", "Directory for the generated and migrated databases; must be empty or not exist yet (default: a temporary directory, removed afterwards)")
+
+	return cmd
+}
+
+const (
+	// selftestVersions is how many versions the self-test tree saves. They start
+	// selftestVersions/2 versions before the end of the first shard, so the migrated
+	// tree spans two shards.
+	selftestVersions = 20
+	selftestStore    = "selftest"
+)
+
+// selftestCheck is one line of the self-test report.
+type selftestCheck struct {
+	name string
+	err  error
+}
+
+// selftest builds a v2 store with the iavl v2 tree API under dir, which must be empty or not
+// exist yet, migrates it and checks the result through the iavl v3 library: the shards and
+// orphan tables exist, and every version's root hash matches the one v2 saved. It prints a
+// PASS or FAIL line per check and returns ErrVerificationFailed if any check failed.
+func selftest(w io.Writer, dir string, opts migrateOptions) error {
+	// the generated stores go into fixed subdirectories, so leftovers would be migrated or clobbered
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("self-test directory %s is not empty", dir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read self-test directory %s: %w", dir, err)
+	}
+	start := time.Now()
+	oldDir := filepath.Join(dir, "v2", selftestStore)
+	newDir := filepath.Join(dir, "v3", selftestStore)
+	firstVersion := int64(defaultTreeShardSize - selftestVersions/2 + 1)
+	hashes, err := buildSelftestTree(oldDir, firstVersion)
+	if err != nil {
+		return fmt.Errorf("generate v2 tree: %w", err)
+	}
+
+	var checks []selftestCheck
+	tree, err := migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite"), opts)
+	if err == nil {
+		_, err = migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(newDir, "changelog.sqlite"), opts)
+	}
+	checks = append(checks, selftestCheck{"migrate", err})
+	if err == nil {
+		checks = append(checks,
+			selftestCheck{"shards", checkSelftestShards(tree, firstVersion)},
+			selftestCheck{"orphans", checkSelftestOrphans(newDir)},
+			selftestCheck{"root hashes", checkSelftestHashes(newDir, firstVersion, hashes)},
+		)
+	}
+
+	failed := 0
+	for _, c := range checks {
+		if c.err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL %s: %v\n", c.name, c.err)
+			continue
+		}
+		fmt.Fprintf(w, "PASS %s\n", c.name)
+	}
+	if failed > 0 {
+		fmt.Fprintf(w, "FAIL (%d of %d checks) in %s\n", failed, len(checks), time.Since(start).Round(time.Millisecond))
+		return fmt.Errorf("%w: self-test failed %d of %d checks", ErrVerificationFailed, failed, len(checks))
+	}
+	fmt.Fprintf(w, "PASS in %s\n", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// buildSelftestTree saves selftestVersions versions of sets, updates and removes with the
+// iavl v2 tree API, starting at firstVersion, and returns the root hash of each version.
+func buildSelftestTree(dir string, firstVersion int64) ([][]byte, error) {
+	if err := os.MkdirAll(dir, defaultDirMode); err != nil {
+		return nil, err
+	}
+	pool := iavl2.NewNodePool()
+	db, err := iavl2.NewSqliteDb(pool, iavl2.DefaultSqliteDbOptions(iavl2.SqliteDbOptions{Path: dir}))
+	if err != nil {
+		return nil, err
+	}
+	tree := iavl2.NewTree(db, pool, iavl2.DefaultTreeOptions())
+	if err := tree.SetInitialVersion(firstVersion); err != nil {
+		tree.Close()
+		return nil, err
+	}
+
+	var hashes [][]byte
+	for v := 0; v < selftestVersions; v++ {
+		for i := 0; i < 20; i++ {
+			key := []byte(fmt.Sprintf("key-%03d", (v*7+i)%64))
+			if _, err := tree.Set(key, []byte(fmt.Sprintf("value-%d-%d", v, i))); err != nil {
+				tree.Close()
+				return nil, err
+			}
+		}
+		// removes orphan leaves as well as the branches above them
+		if v%3 == 2 {
+			if _, _, err := tree.Remove([]byte(fmt.Sprintf("key-%03d", v%64))); err != nil {
+				tree.Close()
+				return nil, err
+			}
+		}
+		hash, _, err := tree.SaveVersion()
+		if err != nil {
+			tree.Close()
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, tree.Close()
+}
+
+// checkSelftestShards checks that the migrated versions were split across two shards.
+func checkSelftestShards(tree TreeMigrationResult, firstVersion int64) error {
+	want := []int64{ToShardID(firstVersion), ToShardID(firstVersion + selftestVersions - 1)}
+	if len(tree.Shards) != 2 || tree.Shards[0] != want[0] || tree.Shards[1] != want[1] {
+		return fmt.Errorf("expected shards %v, got %v", want, tree.Shards)
+	}
+	for id, rows := range tree.RowsPerShard {
+		if rows == 0 {
+			return fmt.Errorf("shard tree_%d is empty", id)
+		}
+	}
+	return nil
+}
+
+// checkSelftestOrphans checks that the migrated branch_orphan and leaf_orphan tables have rows.
+func checkSelftestOrphans(dir string) error {
+	for _, src := range []struct{ db, table string }{
+		{"tree.sqlite", "branch_orphan"},
+		{"changelog.sqlite", "leaf_orphan"},
+	} {
+		path := filepath.Join(dir, src.db)
+		db, err := sql.Open("sqlite", readonlyURI(path))
+		if err != nil {
+			return fmt.Errorf("open db %s: %w", path, err)
+		}
+		var rows int64
+		err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", src.table)).Scan(&rows)
+		db.Close()
+		if err != nil {
+			return fmt.Errorf("count %s in %s: %w", src.table, path, err)
+		}
+		if rows == 0 {
+			return fmt.Errorf("%s in %s is empty", src.table, path)
+		}
+	}
+	return nil
+}
+
+// checkSelftestHashes loads the root of every version from the migrated store with the
+// iavl v3 library and compares its hash with the one v2 saved, like check-hash does for
+// the latest version.
+func checkSelftestHashes(dir string, firstVersion int64, hashes [][]byte) error {
+	v3sql, err := iavl3.NewDB(iavl3.Options{Path: dir, WalSize: 1024 * 1024})
+	if err != nil {
+		return fmt.Errorf("open v3 store %s: %w", dir, err)
+	}
+	defer v3sql.Close()
+
+	latest, err := v3sql.LatestVersion()
+	if err != nil {
+		return fmt.Errorf("read latest version of %s: %w", dir, err)
+	}
+	if want := firstVersion + int64(len(hashes)) - 1; latest != want {
+		return fmt.Errorf("%w: latest version %d, expected %d", ErrHashMismatch, latest, want)
+	}
+	pool := nodepool3.NewNodePool()
+	for i, want := range hashes {
+		version := firstVersion + int64(i)
+		root, err := v3sql.LoadRoot(pool, version)
+		if err != nil {
+			return fmt.Errorf("load root %d of %s: %w", version, dir, err)
+		}
+		var got []byte
+		if root != nil {
+			got = root.Hash()
+		}
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("%w: version %d, v2 %x, v3 %x", ErrHashMismatch, version, want, got)
+		}
+	}
+	return nil
+}
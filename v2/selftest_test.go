@@ -0,0 +1,21 @@
+package v2
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelftestRefusesNonEmptyDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "keep.txt"), []byte("x"), 0o644))
+
+	var out bytes.Buffer
+	err := selftest(&out, dir, migrateOptions{})
+	require.ErrorContains(t, err, "is not empty")
+	require.Empty(t, out.String())
+	require.NoDirExists(t, filepath.Join(dir, "v2"))
+}
@@ -0,0 +1,80 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parseShardList parses a --shards value such as "3,5-7" into the sorted, de-duplicated
+// shard IDs it names. An empty value selects every shard and returns nil.
+func parseShardList(spec string) ([]int64, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	var shards []int64
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := strconv.ParseInt(strings.TrimSpace(from), 10, 64)
+		if err != nil || first < defaultStartShardID {
+			return nil, fmt.Errorf("invalid shard %q in %q: shard IDs are integers from %d", part, spec, defaultStartShardID)
+		}
+		last := first
+		if isRange {
+			last, err = strconv.ParseInt(strings.TrimSpace(to), 10, 64)
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid shard range %q in %q", part, spec)
+			}
+		}
+		for id := first; id <= last; id++ {
+			shards = append(shards, id)
+		}
+	}
+	slices.Sort(shards)
+	return slices.Compact(shards), nil
+}
+
+// selectShards intersects the shards a store's version range needs with the requested ones.
+// A requested shard outside that range is an error rather than an empty table, since it
+// most likely means the wrong store or a typo.
+func selectShards(needed, requested []int64) ([]int64, error) {
+	if requested == nil {
+		return needed, nil
+	}
+	for _, id := range requested {
+		if !slices.Contains(needed, id) {
+			return nil, fmt.Errorf("requested shard %d is outside the store's shard range %d-%d", id, needed[0], needed[len(needed)-1])
+		}
+	}
+	return requested, nil
+}
+
+// nonEmptyShards returns the shards of shardIDs whose version range holds at least one row of
+// src, logging the ones left out. iavl v3 reads and prunes a missing shard like an empty one,
+// but its rollback deletes from every tree_N table between two versions and fails on a gap, so
+// skipping is opt-in; fix-missing-shard recreates the tables if a rollback is needed.
+func nonEmptyShards(oldDB *sql.DB, src treeSource, shardIDs []int64, opts migrateOptions) ([]int64, error) {
+	var kept, skipped []int64
+	for _, shardID := range shardIDs {
+		startVersion, endVersion := shardVersionRange(shardID)
+		startVersion = max(startVersion, opts.appendAfter+1)
+		var hasRows bool
+		err := oldDB.QueryRow(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE version >= %d AND version <= %d)",
+			src.from(""), startVersion, endVersion)).Scan(&hasRows)
+		if err != nil {
+			return nil, fmt.Errorf("check rows of shard %d in %s: %w", shardID, src, err)
+		}
+		if hasRows {
+			kept = append(kept, shardID)
+		} else {
+			skipped = append(skipped, shardID)
+		}
+	}
+	if len(skipped) > 0 {
+		opts.logger.Event("empty_shards_skipped", logFields{"shards": skipped}, "skipping shards without source rows: %v", skipped)
+	}
+	return kept, nil
+}
@@ -0,0 +1,61 @@
+package v2
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseShardList(t *testing.T) {
+	shards, err := parseShardList("")
+	require.NoError(t, err)
+	require.Nil(t, shards)
+
+	shards, err = parseShardList("3, 5-7,4,6")
+	require.NoError(t, err)
+	require.Equal(t, []int64{3, 4, 5, 6, 7}, shards)
+
+	for _, spec := range []string{"0", "a", "2,", "7-5", "1-x", "-3"} {
+		_, err := parseShardList(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestMigrateShardSubset(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	// one version in each of shards 1 to 4
+	createV2Store(t, oldDir, 1, 500001, 1000001, 1500001)
+	execV2(t, oldDir, "tree.sqlite", "INSERT INTO orphan VALUES (1, 1, 500001)")
+	newPath := filepath.Join(t.TempDir(), "tree.sqlite")
+
+	tree, err := migrateTree(filepath.Join(oldDir, "tree.sqlite"), newPath, migrateOptions{shards: []int64{2, 4}})
+	require.NoError(t, err)
+	require.Equal(t, []int64{2, 4}, tree.Shards)
+	require.Equal(t, map[int64]int64{2: 1, 4: 1}, tree.RowsPerShard)
+	requireTableNames(t, newPath, "branch_orphan", "root", "tree_2", "tree_4")
+	require.Equal(t, int64(4), countTableRows(t, newPath, "root"))
+	require.Equal(t, int64(1), countTableRows(t, newPath, "branch_orphan"))
+
+	_, err = migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(t.TempDir(), "tree.sqlite"), migrateOptions{shards: []int64{4, 5}})
+	require.ErrorContains(t, err, "requested shard 5 is outside the store's shard range 1-4")
+}
+
+func TestMigrateTreeSkipEmptyShards(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bank")
+	// pruning left nothing in shard 2
+	createV2Store(t, dir, 1, 1000001)
+	newPath := filepath.Join(t.TempDir(), "tree.sqlite")
+
+	buf := captureLog(t)
+	result, err := migrateTree(filepath.Join(dir, "tree.sqlite"), newPath, migrateOptions{skipEmptyShards: true})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 3}, result.Shards)
+	require.Contains(t, buf.String(), "skipping shards without source rows: [2]")
+
+	report, err := inspectShards(newPath)
+	require.NoError(t, err)
+	require.Equal(t, []string{"tree_1", "tree_3"}, report.existing)
+	require.Empty(t, report.missing)
+	require.Equal(t, []string{"tree_2"}, report.gaps)
+}
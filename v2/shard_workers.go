@@ -0,0 +1,150 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// stagedShard is a shard copied into its own staging database by a shard worker.
+type stagedShard struct {
+	shardID int64
+	path    string
+	err     error
+}
+
+// copyShardsParallel copies the given shards of src into the tree database newDB, which is
+// being written at dbPath, with up to opts.shardWorkers shards in flight. SQLite allows one
+// writer per file, so each worker reads its shard's version range from the source and writes
+// it into a staging database next to dbPath; the caller's connection then appends each staged
+// shard to its tree_N table as it completes and removes the staging file. Workers take a slot
+// from opts.shardSlots, shared by every store migrating at once, for as long as they hold their
+// connections. A non-nil verify checks each shard right after it is merged. The first failure
+// cancels the remaining workers.
+func copyShardsParallel(ctx context.Context, oldPath, dbPath string, newDB *sql.DB, src treeSource, shardIDs []int64, verify func(shardID int64) error, opts migrateOptions) (map[int64]int64, error) {
+	slots := opts.shardSlots
+	if slots == nil {
+		slots = make(chan struct{}, opts.shardWorkers)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	staged := make(chan stagedShard, len(shardIDs))
+	for _, shardID := range shardIDs {
+		go func() {
+			path := fmt.Sprintf("%s.shard%d", dbPath, shardID)
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				staged <- stagedShard{shardID: shardID, path: path, err: context.Cause(ctx)}
+				return
+			}
+			defer func() { <-slots }()
+			staged <- stagedShard{shardID: shardID, path: path, err: stageShard(ctx, oldPath, path, src, shardID, opts)}
+		}()
+	}
+
+	rowsPerShard := make(map[int64]int64, len(shardIDs))
+	var firstErr error
+	for range shardIDs {
+		shard := <-staged
+		err := shard.err
+		if err == nil && firstErr == nil {
+			var rows int64
+			rows, err = mergeStagedShard(ctx, newDB, shard, opts.logger)
+			rowsPerShard[shard.shardID] = rows
+			if err == nil {
+				opts.storeProgress.add(rows)
+				opts.logger.Event("shard_merged", logFields{"shard": shard.shardID, "rows": rows},
+					"merged staged shard %d (%d rows) into %s", shard.shardID, rows, dbPath)
+				if verify != nil {
+					err = verify(shard.shardID)
+				}
+			}
+		}
+		removeSQLiteFiles(shard.path)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("migrate shard tree_%d: %w", shard.shardID, err)
+			cancel(firstErr)
+		}
+	}
+	if firstErr != nil {
+		if err := opts.context().Err(); err != nil {
+			return nil, err
+		}
+		return nil, firstErr
+	}
+	return rowsPerShard, nil
+}
+
+// stageShard copies the rows of one shard's version range from the source at oldPath into a
+// tree_N table of a fresh database at path, the same way the sequential copy would.
+func stageShard(ctx context.Context, oldPath, path string, src treeSource, shardID int64, opts migrateOptions) error {
+	if err := removeSQLiteFiles(path); err != nil {
+		return fmt.Errorf("remove stale %s: %w", path, err)
+	}
+	db, err := openDest(path, opts)
+	if err != nil {
+		return fmt.Errorf("open staging db %s: %w", path, err)
+	}
+	defer db.Close()
+
+	tableName := fmt.Sprintf("tree_%d", shardID)
+	if _, err := execSQL(ctx, db, opts.logger, fmt.Sprintf(`CREATE TABLE %s (
+	  version INT, sequence INT, bytes BLOB, orphaned BOOL,
+	  PRIMARY KEY (version, sequence)
+	) WITHOUT ROWID;`, tableName)); err != nil {
+		return fmt.Errorf("create %s in %s: %w", tableName, path, err)
+	}
+	startVersion, endVersion := shardVersionRange(shardID)
+	startVersion = max(startVersion, opts.appendAfter+1)
+	opts.logger.Printf("staging shard %d (versions %d-%d) in %s", shardID, startVersion, endVersion, path)
+
+	if opts.lowMemory {
+		oldDB, err := openSource(oldPath, opts.sourceReadonly, opts.busyTimeout)
+		if err != nil {
+			return fmt.Errorf("open old db %s: %w", oldPath, err)
+		}
+		defer oldDB.Close()
+		_, err = copyShardRowsStreaming(ctx, oldDB, db, src, tableName, startVersion, endVersion, opts.rowBatchSize())
+		return err
+	}
+
+	// ATTACH is per connection, so pin one for the attach and the copy
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := execSQL(ctx, conn, opts.logger, attachSourceStmt(oldPath, opts.sourceReadonly)); err != nil {
+		return fmt.Errorf("attach %s: %w", oldPath, err)
+	}
+	if _, err := execSQL(ctx, conn, opts.logger, copyShardStmt(tableName, src, startVersion, endVersion)); err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		return classifySQLiteError(err)
+	}
+	return nil
+}
+
+// mergeStagedShard appends the staged shard's rows to its tree_N table in newDB.
+func mergeStagedShard(ctx context.Context, newDB *sql.DB, shard stagedShard, lg *migrationLogger) (int64, error) {
+	conn, err := newDB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if _, err := execSQL(ctx, conn, lg, fmt.Sprintf(`ATTACH DATABASE '%s' AS stage;`, shard.path)); err != nil {
+		return 0, fmt.Errorf("attach %s: %w", shard.path, err)
+	}
+	defer execSQL(context.Background(), conn, lg, `DETACH DATABASE stage;`)
+
+	tableName := fmt.Sprintf("tree_%d", shard.shardID)
+	res, err := execSQL(ctx, conn, lg, fmt.Sprintf(`INSERT INTO main.%s(version, sequence, bytes, orphaned)
+	      SELECT version, sequence, bytes, orphaned FROM stage.%s;`, tableName, tableName))
+	if err != nil {
+		return 0, classifySQLiteError(err)
+	}
+	return res.RowsAffected()
+}
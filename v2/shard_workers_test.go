@@ -0,0 +1,104 @@
+package v2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyShardsParallel(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, dir, 1, 2, 500001, 1000001, 1500001, 1500002)
+	oldPath := filepath.Join(dir, "tree.sqlite")
+
+	for _, lowMemory := range []bool{false, true} {
+		outDir := t.TempDir()
+		sequential, err := migrateTree(oldPath, filepath.Join(outDir, "sequential.sqlite"), migrateOptions{lowMemory: lowMemory})
+		require.NoError(t, err)
+		parallel, err := migrateTree(oldPath, filepath.Join(outDir, "parallel.sqlite"), migrateOptions{lowMemory: lowMemory, shardWorkers: 3})
+		require.NoError(t, err)
+
+		require.Equal(t, sequential.Shards, parallel.Shards)
+		require.Equal(t, map[int64]int64{1: 2, 2: 1, 3: 1, 4: 2}, parallel.RowsPerShard)
+		require.Equal(t, sequential.RowsPerShard, parallel.RowsPerShard)
+		for _, table := range []string{"tree_1", "tree_2", "tree_3", "tree_4"} {
+			require.Equal(t, countTableRows(t, filepath.Join(outDir, "sequential.sqlite"), table),
+				countTableRows(t, filepath.Join(outDir, "parallel.sqlite"), table), table)
+		}
+
+		// staging databases are removed once merged
+		entries, err := os.ReadDir(outDir)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+	}
+}
+
+func TestMigrateShardWorkersShared(t *testing.T) {
+	require.ErrorContains(t, migrate(filepath.Join(t.TempDir(), "iavl2"), nil, false, migrateOptions{shardWorkers: -1}), "shard-workers must be positive")
+
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 500001)
+	createV2Store(t, filepath.Join(iavl2Path, "staking"), 1, 500001, 1000001)
+
+	buf := captureLog(t)
+	var err error
+	captureStdout(t, func() {
+		err = migrate(iavl2Path, nil, true, migrateOptions{workers: 2, shardWorkers: 2})
+	})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "stores share 2 shard workers")
+	require.EqualValues(t, 1, countTableRows(t, filepath.Join(iavl2Path, "staking", "tree.sqlite"), "tree_3"))
+}
+
+func TestMigrateParallelPhases(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2, 500001)
+	createV2Store(t, filepath.Join(iavl2Path, "staking"), 1, 500001, 1000001)
+	createV2Store(t, filepath.Join(iavl2Path, "evm"), 1)
+
+	buf := captureLog(t)
+	var err error
+	captureStdout(t, func() {
+		err = migrate(iavl2Path, nil, true, migrateOptions{workers: 2, parallelPhases: true})
+	})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "stores share 2 slots for parallel tree and changelog phases")
+	for store, versions := range map[string]int64{"bank": 3, "staking": 3, "evm": 1} {
+		treePath := filepath.Join(iavl2Path, store, "tree.sqlite")
+		require.Equal(t, versions, countTableRows(t, treePath, "root"), store)
+		require.Equal(t, versions, countTableRows(t, filepath.Join(iavl2Path, store, "changelog.sqlite"), "leaf"), store)
+	}
+	require.EqualValues(t, 1, countTableRows(t, filepath.Join(iavl2Path, "staking", "tree.sqlite"), "tree_3"))
+	require.NoError(t, verifySchema(iavl2Path))
+
+	// a failing phase fails the store after the other phase finished
+	iavl2Path = filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+	require.NoError(t, os.Remove(filepath.Join(iavl2Path, "bank", "changelog.sqlite")))
+	captureStdout(t, func() {
+		err = migrate(iavl2Path, nil, false, migrateOptions{parallelPhases: true})
+	})
+	require.ErrorIs(t, err, ErrSourceNotFound)
+	require.Contains(t, buf.String(), "migrate tree.sqlite successfully, store: bank")
+}
+
+func TestOptionsStoreWorkers(t *testing.T) {
+	tests := []struct {
+		opts       Options
+		concurrent bool
+		workers    int
+	}{
+		{Options{}, false, 0},
+		{Options{Concurrent: true}, true, 0},
+		{Options{Concurrent: true, Workers: 3}, true, 3},
+		{Options{StoreWorkers: 4}, true, 4},
+		// StoreWorkers takes precedence over the deprecated pair
+		{Options{StoreWorkers: 1, Concurrent: true, Workers: 3}, false, 1},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.concurrent, tt.opts.concurrent(), "%+v", tt.opts)
+		require.Equal(t, tt.workers, tt.opts.storeWorkers(), "%+v", tt.opts)
+	}
+}
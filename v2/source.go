@@ -0,0 +1,115 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// sourceURI returns the name used to open or ATTACH a v2 source database. In read-only
+// mode it is a URI with mode=ro&immutable=1, so SQLite takes no locks and never writes,
+// which lets the source live on read-only media or stay open in another process.
+func sourceURI(path string, readonly bool) string {
+	if !readonly {
+		return path
+	}
+	return readonlyURI(path) + "&immutable=1"
+}
+
+// readonlyURI returns a URI opening path with mode=ro. Unlike sourceURI it still takes
+// shared locks and reads the -wal file, so it sees the latest committed data.
+func readonlyURI(path string) string {
+	u := url.URL{Scheme: "file", Opaque: (&url.URL{Path: path}).EscapedPath(), RawQuery: "mode=ro"}
+	return u.String()
+}
+
+// checkSourceReadonly rejects sources with a non-empty write-ahead log: immutable=1 makes
+// SQLite ignore the -wal file, so those sources would silently lose their latest commits.
+func checkSourceReadonly(path string) error {
+	info, err := os.Stat(path + "-wal")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stat %s-wal: %w", path, err)
+	}
+	if info.Size() > 0 {
+		return fmt.Errorf("source %s has an un-checkpointed write-ahead log; checkpoint it or pass --source-readonly=false", path)
+	}
+	return nil
+}
+
+// withBusyTimeout adds a busy_timeout pragma to the database name or URI dsn, which the
+// driver runs on every connection it opens, so a locked database is retried for up to
+// timeout instead of failing with SQLITE_BUSY at once. It covers databases ATTACHed to
+// those connections too. A timeout of 0 leaves SQLite's default of not waiting.
+func withBusyTimeout(dsn string, timeout time.Duration) string {
+	if timeout <= 0 {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s_pragma=busy_timeout(%d)", dsn, sep, timeout.Milliseconds())
+}
+
+// openDest opens a destination database with opts.busyTimeout.
+func openDest(path string, opts migrateOptions) (*sql.DB, error) {
+	return sql.Open("sqlite", withBusyTimeout(path, opts.busyTimeout))
+}
+
+// openSource opens a v2 source database, read-only if requested, waiting up to busyTimeout
+// for locks. A missing path is ErrSourceNotFound rather than a new, empty database.
+func openSource(path string, readonly bool, busyTimeout time.Duration) (*sql.DB, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("source %s %w", path, ErrSourceNotFound)
+	}
+	if readonly {
+		if err := checkSourceReadonly(path); err != nil {
+			return nil, err
+		}
+	}
+	return sql.Open("sqlite", withBusyTimeout(sourceURI(path, readonly), busyTimeout))
+}
+
+// attachSourceStmt attaches the v2 source at path to the destination connection as "old".
+func attachSourceStmt(path string, readonly bool) string {
+	return fmt.Sprintf(`ATTACH DATABASE '%s' AS old;`, sourceURI(path, readonly))
+}
+
+// sourceCheckMaxErrors caps the problems quick_check reports for one source.
+const sourceCheckMaxErrors = 20
+
+// checkSourceIntegrity runs PRAGMA quick_check on the source db opened from path and fails
+// with ErrCorruptSource, listing what SQLite found, unless it reports ok. quick_check reads
+// every page and row but skips matching index entries to their rows, so it stays linear in
+// the database size; a corrupt page would otherwise be copied faithfully into the destination.
+func checkSourceIntegrity(db *sql.DB, path string, lg *migrationLogger) error {
+	lg.Event("verify_source", logFields{"source": path}, "checking integrity of %s", path)
+	rows, err := db.Query(fmt.Sprintf("PRAGMA quick_check(%d)", sourceCheckMaxErrors))
+	if err != nil {
+		return fmt.Errorf("%w: quick_check %s: %w", ErrCorruptSource, path, err)
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return fmt.Errorf("quick_check %s: %w", path, err)
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: quick_check %s: %w", ErrCorruptSource, path, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s failed quick_check: %s", ErrCorruptSource, path, strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// checkSourceFormat refuses the source db opened from path if it is laid out like iavl v3
+// rather than v2, e.g. an iavl2/ directory that was already migrated, before anything is
+// written. Tree and changelog tables are checked alike, so a combined source is covered too.
+// v3 markers are a branch_orphan table without v2's orphan table, and a leaf table keyed by
+// key_hash without v2's raw key column.
+func checkSourceFormat(db *sql.DB, path string) error {
+	hasBranchOrphan, err := tableExists(db, "branch_orphan")
+	if err != nil {
+		return err
+	}
+	hasOrphan, err := tableExists(db, "orphan")
+	if err != nil {
+		return err
+	}
+	if hasBranchOrphan && !hasOrphan {
+		return fmt.Errorf("%w: %s appears to be iavl v3, not v2: it has a branch_orphan table and no orphan table; was it already migrated?",
+			ErrSourceIsV3, path)
+	}
+
+	hasKeyHash, err := columnExists(db, "leaf", "key_hash")
+	if err != nil {
+		return err
+	}
+	hasKey, err := columnExists(db, "leaf", "key")
+	if err != nil {
+		return err
+	}
+	if hasKeyHash && !hasKey {
+		return fmt.Errorf("%w: %s appears to be iavl v3, not v2: its leaf table has key_hash instead of key; was it already migrated?",
+			ErrSourceIsV3, path)
+	}
+	return nil
+}
+
+// columnExists reports whether table has a column named column; a missing table has none.
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	var ok bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE name = ?)", table, column).Scan(&ok)
+	if err != nil {
+		return false, fmt.Errorf("read columns of %s: %w", table, err)
+	}
+	return ok, nil
+}
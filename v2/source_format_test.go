@@ -0,0 +1,25 @@
+package v2
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateRefusesV3Source(t *testing.T) {
+	// a directory that was already migrated
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createMigratedStore(t, iavl2Path, "bank")
+
+	err := migrate(iavl2Path, nil, false, migrateOptions{})
+	require.ErrorIs(t, err, ErrSourceIsV3)
+	require.ErrorContains(t, err, "appears to be iavl v3, not v2")
+	require.Equal(t, ExitBadSource, ExitCode(err))
+	require.NoFileExists(t, filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+
+	// the changelog alone is recognized by its key_hash column
+	_, err = migrateChangelog(filepath.Join(iavl2Path+".bak", "bank", "changelog.sqlite"), filepath.Join(t.TempDir(), "changelog.sqlite"), migrateOptions{})
+	require.ErrorIs(t, err, ErrSourceIsV3)
+	require.ErrorContains(t, err, "key_hash instead of key")
+}
@@ -0,0 +1,148 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// treeSource is the set of source tables holding tree nodes: just tree_1 for a plain v2
+// database, or every tree_N of a source that was already sharded.
+type treeSource struct {
+	tables []string
+}
+
+// plainTreeSource is the v2 layout with all nodes in tree_1.
+var plainTreeSource = treeSource{tables: []string{"tree_1"}}
+
+// sourceRowidStride spaces the rowids of consecutive source tables apart in from, so ordering
+// by rowid still prefers tree_1 over tree_2 and so on when rows are duplicated across tables.
+const sourceRowidStride = int64(1) << 40
+
+// from returns a FROM clause reading the version, sequence, bytes, orphaned and rowid columns
+// of every source table as one, with tables qualified by schema (e.g. "old.") if it is set.
+func (s treeSource) from(schema string) string {
+	if len(s.tables) == 1 {
+		return schema + s.tables[0]
+	}
+	selects := make([]string, len(s.tables))
+	for i, table := range s.tables {
+		selects[i] = fmt.Sprintf("SELECT version, sequence, bytes, orphaned, %d + rowid AS rowid FROM %s%s",
+			int64(i)*sourceRowidStride, schema, table)
+	}
+	return "(" + strings.Join(selects, " UNION ALL ") + ")"
+}
+
+// tableOf names the source table holding the row with the given rowid, as read through from;
+// an unknown rowid names all of them.
+func (s treeSource) tableOf(rowid sql.NullInt64) string {
+	if len(s.tables) == 1 {
+		return s.tables[0]
+	}
+	if i := rowid.Int64 / sourceRowidStride; rowid.Valid && i >= 0 && i < int64(len(s.tables)) {
+		return s.tables[i]
+	}
+	return s.String()
+}
+
+// String names the source tables for log and error messages.
+func (s treeSource) String() string {
+	return strings.Join(s.tables, "+")
+}
+
+// sourceShardBounds is the version range found in one source shard table.
+type sourceShardBounds struct {
+	shardID                int64
+	minVersion, maxVersion int64
+}
+
+// resolveTreeSource decides which source tables the tree migration reads, none if the source has
+// no tree_N table at all. A source with non-empty tree_N tables besides tree_1 was built sharded;
+// without fromShards that is an error, since only tree_1 would be migrated. With fromShards the
+// source shard size is inferred from each table's version range and checked for consistency, and
+// all tables are read. The destination always uses
+// iavl v3's fixed shard size, as v3 computes the shard of a version itself.
+func resolveTreeSource(oldDB *sql.DB, oldPath string, fromShards bool, lg *migrationLogger) (treeSource, error) {
+	shardIDs, err := listShardIDs(oldDB)
+	if err != nil {
+		return treeSource{}, err
+	}
+	if len(shardIDs) == 0 {
+		// a tree-less store, e.g. one only holding metadata roots; there are no nodes to read
+		return treeSource{}, nil
+	}
+	if shardIDs[0] != defaultStartShardID && !fromShards {
+		return treeSource{}, fmt.Errorf("%w: %s has tree_%d but no tree_1; pass --shard-size-from-source to read its shard tables",
+			ErrSchemaMismatch, oldPath, shardIDs[0])
+	}
+	if len(shardIDs) == 1 && shardIDs[0] == defaultStartShardID {
+		if fromShards {
+			lg.Printf("%s has only tree_1, using the default shard size %d", oldPath, defaultTreeShardSize)
+		}
+		return plainTreeSource, nil
+	}
+
+	var tables []string
+	for _, id := range shardIDs {
+		tables = append(tables, fmt.Sprintf("tree_%d", id))
+	}
+	if !fromShards {
+		// empty extra shards lose nothing; they're reported like any other unknown table
+		for _, table := range tables[1:] {
+			var hasRows bool
+			if err := oldDB.QueryRow("SELECT EXISTS (SELECT 1 FROM " + table + ")").Scan(&hasRows); err != nil {
+				return treeSource{}, fmt.Errorf("read %s: %w", table, err)
+			}
+			if hasRows {
+				return treeSource{}, fmt.Errorf("%w: %s is already sharded into %v but only tree_1 is migrated; pass --shard-size-from-source to read every shard",
+					ErrSchemaMismatch, oldPath, tables)
+			}
+		}
+		return plainTreeSource, nil
+	}
+
+	var bounds []sourceShardBounds
+	for _, id := range shardIDs {
+		var minVersion, maxVersion sql.NullInt64
+		err := oldDB.QueryRow(fmt.Sprintf("SELECT MIN(version), MAX(version) FROM tree_%d WHERE version > 0", id)).Scan(&minVersion, &maxVersion)
+		if err != nil {
+			return treeSource{}, fmt.Errorf("read version range of tree_%d: %w", id, err)
+		}
+		if minVersion.Valid {
+			bounds = append(bounds, sourceShardBounds{shardID: id, minVersion: minVersion.Int64, maxVersion: maxVersion.Int64})
+		}
+	}
+	lower, upper, err := inferShardSize(bounds)
+	if err != nil {
+		return treeSource{}, fmt.Errorf("%s: %w", oldPath, err)
+	}
+	if upper == math.MaxInt64 {
+		lg.Event("source_shards", logFields{"tables": tables, "min_shard_size": lower},
+			"%s is sharded into %v, shard size at least %d; re-sharding into iavl v3's shard size %d", oldPath, tables, lower, defaultTreeShardSize)
+	} else {
+		lg.Event("source_shards", logFields{"tables": tables, "min_shard_size": lower, "max_shard_size": upper},
+			"%s is sharded into %v, shard size between %d and %d; re-sharding into iavl v3's shard size %d", oldPath, tables, lower, upper, defaultTreeShardSize)
+	}
+	return treeSource{tables: tables}, nil
+}
+
+// inferShardSize returns the range of shard sizes s under which every shard N holds only
+// versions (N-1)*s+1 through N*s, or an error if no size fits. Without a non-empty shard
+// past tree_1 the upper bound is math.MaxInt64.
+func inferShardSize(bounds []sourceShardBounds) (lower, upper int64, err error) {
+	lower, upper = 1, math.MaxInt64
+	for _, b := range bounds {
+		// the largest version must fit: N*s >= max
+		lower = max(lower, (b.maxVersion+b.shardID-1)/b.shardID)
+		// the smallest version must be past the previous shard: (N-1)*s < min
+		if b.shardID > 1 {
+			upper = min(upper, (b.minVersion-1)/(b.shardID-1))
+		}
+	}
+	if lower > upper {
+		return 0, 0, fmt.Errorf("%w: source shard version ranges are inconsistent with any shard size (needs at least %d, at most %d)",
+			ErrSchemaMismatch, lower, upper)
+	}
+	return lower, upper, nil
+}
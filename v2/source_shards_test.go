@@ -0,0 +1,108 @@
+package v2
+
+import (
+	"database/sql"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInferShardSize(t *testing.T) {
+	lower, upper, err := inferShardSize([]sourceShardBounds{{1, 1, 100}, {2, 101, 200}, {3, 201, 250}})
+	require.NoError(t, err)
+	require.Equal(t, [2]int64{100, 100}, [2]int64{lower, upper})
+
+	// sparse shards only narrow the size down
+	lower, upper, err = inferShardSize([]sourceShardBounds{{1, 1, 90}, {2, 150, 180}})
+	require.NoError(t, err)
+	require.Equal(t, [2]int64{90, 149}, [2]int64{lower, upper})
+
+	lower, upper, err = inferShardSize([]sourceShardBounds{{1, 1, 90}})
+	require.NoError(t, err)
+	require.Equal(t, [2]int64{90, math.MaxInt64}, [2]int64{lower, upper})
+
+	// tree_2 starts before tree_1 ends
+	_, _, err = inferShardSize([]sourceShardBounds{{1, 1, 100}, {2, 50, 150}})
+	require.ErrorIs(t, err, ErrSchemaMismatch)
+}
+
+func TestMigrateTreeShardedSource(t *testing.T) {
+	tempDir := t.TempDir()
+	oldPath := filepath.Join(tempDir, "old_tree.sqlite")
+
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	defer oldDB.Close()
+	// built with a shard size of 100
+	_, err = oldDB.Exec(`
+		CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool);
+		CREATE TABLE tree_2 (version int, sequence int, bytes blob, orphaned bool);
+		CREATE TABLE orphan (version int, sequence int, at int);
+		CREATE TABLE root (version int, node_version int, node_sequence int, bytes blob, PRIMARY KEY (version));
+		INSERT INTO tree_1 VALUES (1, 1, x'01', false);
+		INSERT INTO tree_1 VALUES (100, 1, x'02', false);
+		INSERT INTO tree_2 VALUES (101, 1, x'03', false);
+		INSERT INTO tree_2 VALUES (150, 1, x'04', false);
+		INSERT INTO root VALUES (150, 150, 1, x'04');
+	`)
+	require.NoError(t, err)
+
+	_, err = migrateTree(oldPath, filepath.Join(tempDir, "refused.sqlite"), migrateOptions{})
+	require.ErrorIs(t, err, ErrSchemaMismatch)
+	require.ErrorContains(t, err, "--shard-size-from-source")
+
+	for _, opts := range []migrateOptions{{shardSizeFromSource: true}, {shardSizeFromSource: true, lowMemory: true}, {shardSizeFromSource: true, skipCorrupt: true}} {
+		newPath := filepath.Join(t.TempDir(), "tree.sqlite")
+		buf := captureLog(t)
+		result, err := migrateTree(oldPath, newPath, opts)
+		require.NoError(t, err)
+		require.Contains(t, buf.String(), "shard size between 100 and 100")
+		// all versions fall into iavl v3's first shard
+		require.Equal(t, []int64{1}, result.Shards)
+		require.Equal(t, int64(4), result.Rows())
+		newDB, err := sql.Open("sqlite", newPath)
+		require.NoError(t, err)
+		require.Equal(t, []int64{1}, shardIDsOf(t, newDB))
+		require.NoError(t, newDB.Close())
+	}
+
+	_, err = oldDB.Exec("INSERT INTO tree_2 VALUES (50, 2, x'05', false)")
+	require.NoError(t, err)
+	_, err = migrateTree(oldPath, filepath.Join(t.TempDir(), "tree.sqlite"), migrateOptions{shardSizeFromSource: true})
+	require.ErrorIs(t, err, ErrSchemaMismatch)
+	require.ErrorContains(t, err, "inconsistent")
+}
+
+func TestMigrateTreeShardedSourceSkipCorrupt(t *testing.T) {
+	tempDir := t.TempDir()
+	oldPath := filepath.Join(tempDir, "old_tree.sqlite")
+
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	defer oldDB.Close()
+	_, err = oldDB.Exec(`
+		CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool);
+		CREATE TABLE tree_2 (version int, sequence int, bytes blob, orphaned bool);
+		CREATE TABLE root (version int, node_version int, node_sequence int, bytes blob, PRIMARY KEY (version));
+		INSERT INTO tree_1 VALUES (1, 1, x'01', false);
+		INSERT INTO tree_2 VALUES (101, 1, NULL, false);
+		INSERT INTO tree_2 VALUES (NULL, 2, x'02', false);
+		INSERT INTO tree_2 VALUES (150, 1, x'04', false);
+		INSERT INTO root VALUES (150, 150, 1, x'04');
+	`)
+	require.NoError(t, err)
+
+	newPath := filepath.Join(tempDir, "tree.sqlite")
+	_, err = migrateTree(oldPath, newPath, migrateOptions{shardSizeFromSource: true, skipCorrupt: true})
+	require.NoError(t, err)
+
+	// the skipped rows are reported under the source shard they were read from
+	report, err := os.ReadFile(newPath + ".skipped")
+	require.NoError(t, err)
+	require.Equal(t, "table,version,sequence,error\n"+
+		"tree_2,NULL,2,NULL version\n"+
+		"tree_2,101,1,NULL bytes\n", string(report))
+}
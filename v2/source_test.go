@@ -0,0 +1,90 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceURI(t *testing.T) {
+	require.Equal(t, "/data/iavl2/bank/tree.sqlite", sourceURI("/data/iavl2/bank/tree.sqlite", false))
+	require.Equal(t, "file:/data/iavl%202/bank/tree.sqlite?mode=ro&immutable=1", sourceURI("/data/iavl 2/bank/tree.sqlite", true))
+}
+
+func TestMigrateReadonlySource(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 1, 500001)
+	for _, name := range []string{"tree.sqlite", "changelog.sqlite"} {
+		require.NoError(t, os.Chmod(filepath.Join(oldDir, name), 0o444))
+	}
+	require.NoError(t, os.Chmod(oldDir, 0o555))
+	t.Cleanup(func() { os.Chmod(oldDir, 0o755) })
+
+	newDir := t.TempDir()
+	opts := migrateOptions{sourceReadonly: true}
+	treeRows, err := migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite"), opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), treeRows.Rows())
+	leafRows, err := migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(newDir, "changelog.sqlite"), opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), leafRows)
+
+	// nothing was created next to the source
+	entries, err := os.ReadDir(oldDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func TestMigrateReadonlySourceWithWAL(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 1)
+	require.NoError(t, os.WriteFile(filepath.Join(oldDir, "tree.sqlite-wal"), []byte("pending"), 0o644))
+
+	_, err := migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(t.TempDir(), "tree.sqlite"), migrateOptions{sourceReadonly: true})
+	require.ErrorContains(t, err, "un-checkpointed write-ahead log")
+}
+
+func TestWithBusyTimeout(t *testing.T) {
+	require.Equal(t, "/data/tree.sqlite", withBusyTimeout("/data/tree.sqlite", 0))
+	require.Equal(t, "/data/tree.sqlite?_pragma=busy_timeout(1500)", withBusyTimeout("/data/tree.sqlite", 1500*time.Millisecond))
+	require.Equal(t, "file:/data/tree.sqlite?mode=ro&_pragma=busy_timeout(5000)", withBusyTimeout(readonlyURI("/data/tree.sqlite"), 5*time.Second))
+
+	path := filepath.Join(t.TempDir(), "tree.sqlite")
+	db, err := openDest(path, migrateOptions{busyTimeout: 1500 * time.Millisecond})
+	require.NoError(t, err)
+	defer db.Close()
+	var timeout int64
+	require.NoError(t, db.QueryRow("PRAGMA busy_timeout").Scan(&timeout))
+	require.Equal(t, int64(1500), timeout)
+}
+
+func TestMigrateWaitsForLockedSource(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 1, 2)
+
+	// another process writing the source holds it exclusively for a moment
+	lockDB, err := sql.Open("sqlite", filepath.Join(oldDir, "tree.sqlite"))
+	require.NoError(t, err)
+	defer lockDB.Close()
+	conn, err := lockDB.Conn(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.ExecContext(context.Background(), "BEGIN EXCLUSIVE")
+	require.NoError(t, err)
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		time.Sleep(200 * time.Millisecond)
+		conn.ExecContext(context.Background(), "COMMIT")
+	}()
+
+	tree, err := migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(t.TempDir(), "tree.sqlite"), migrateOptions{busyTimeout: 5 * time.Second})
+	<-released
+	require.NoError(t, err)
+	require.Equal(t, int64(2), tree.Rows())
+}
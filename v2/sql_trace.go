@@ -0,0 +1,67 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqlExecer is satisfied by *sql.DB, *sql.Conn and *sql.Tx.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// execSQL runs query on db, logging it with the rows it affected if lg is verbose.
+func execSQL(ctx context.Context, db sqlExecer, lg *migrationLogger, query string, args ...any) (sql.Result, error) {
+	if !lg.isVerbose() {
+		return db.ExecContext(ctx, query, args...)
+	}
+	start := time.Now()
+	res, err := db.ExecContext(ctx, query, args...)
+	logSQL(lg, query, args, res, err, time.Since(start))
+	return res, err
+}
+
+// sqlTracer logs every statement executed through it, for helpers taking a sqlExecQuerier.
+type sqlTracer struct {
+	sqlExecQuerier
+	lg *migrationLogger
+}
+
+// traceSQL returns db wrapped in a sqlTracer if lg is verbose, and db itself otherwise.
+func traceSQL(db sqlExecQuerier, lg *migrationLogger) sqlExecQuerier {
+	if !lg.isVerbose() {
+		return db
+	}
+	return sqlTracer{sqlExecQuerier: db, lg: lg}
+}
+
+func (t sqlTracer) Exec(query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	res, err := t.sqlExecQuerier.Exec(query, args...)
+	logSQL(t.lg, query, args, res, err, time.Since(start))
+	return res, err
+}
+
+// logSQL logs a statement, its arguments and the rows it affected or the error it failed with,
+// so the statement can be rerun by hand in the sqlite3 shell.
+func logSQL(lg *migrationLogger, query string, args []any, res sql.Result, err error, elapsed time.Duration) {
+	fields := logFields{"sql": query, "duration_ms": elapsed.Milliseconds()}
+	var withArgs string
+	if len(args) > 0 {
+		fields["args"] = args
+		withArgs = fmt.Sprintf(" with args %v", args)
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+		lg.Event("sql", fields, "SQL failed after %s: %s%s: %v", elapsed.Round(time.Millisecond), query, withArgs, err)
+		return
+	}
+	var rows int64
+	if res != nil {
+		rows, _ = res.RowsAffected()
+	}
+	fields["rows"] = rows
+	lg.Event("sql", fields, "SQL (%d rows, %s): %s%s", rows, elapsed.Round(time.Millisecond), query, withArgs)
+}
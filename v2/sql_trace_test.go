@@ -0,0 +1,57 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateVerboseLogsSQL(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 1, 2, 500001)
+	newDir := filepath.Join(t.TempDir(), "bank")
+
+	buf := captureLog(t)
+	lg, err := newMigrationLogger(logFormatText)
+	require.NoError(t, err)
+	lg.verbose = true
+	_, err = migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite"), migrateOptions{logger: lg})
+	require.NoError(t, err)
+	_, err = migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(newDir, "changelog.sqlite"), migrateOptions{logger: lg})
+	require.NoError(t, err)
+
+	out := buf.String()
+	require.Contains(t, out, "SQL (0 rows")
+	require.Contains(t, out, "ATTACH DATABASE")
+	require.Contains(t, out, "CREATE TABLE tree_2 (")
+	require.Regexp(t, `SQL \(2 rows, \w+\): INSERT INTO tree_1\(`, out)
+	require.Regexp(t, `SQL \(3 rows, \w+\): INSERT INTO root\(`, out)
+	require.Contains(t, out, "SQL (prepared, 3 rows): INSERT INTO leaf(version, sequence, key_hash, bytes)")
+	require.Contains(t, out, "CREATE UNIQUE INDEX IF NOT EXISTS leaf_idx")
+
+	// quiet by default
+	buf.Reset()
+	_, err = migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(t.TempDir(), "tree.sqlite"), migrateOptions{})
+	require.NoError(t, err)
+	require.NotContains(t, buf.String(), "SQL (")
+}
+
+func TestExecSQLLogsFailedStatement(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	buf := captureLog(t)
+	lg := &migrationLogger{verbose: true}
+	_, err = execSQL(context.Background(), db, lg, "INSERT INTO missing VALUES (?)", 7)
+	require.Error(t, err)
+	require.Regexp(t, `SQL failed after \w+: INSERT INTO missing VALUES \(\?\) with args \[7\]: .*no such table`, buf.String())
+
+	buf.Reset()
+	_, err = traceSQL(db, lg).Exec("CREATE TABLE t (a INT)")
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "SQL (0 rows")
+}
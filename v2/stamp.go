@@ -0,0 +1,152 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// migrationMetaTable is the table the migration stamps into each destination database,
+// recording how it was written so later checks can use the same parameters instead of
+// relying on the operator to pass them again. iavl v3 ignores it.
+const migrationMetaTable = "migration_meta"
+
+// migrationMeta is what a migration_meta table records, one key/value row per field.
+type migrationMeta struct {
+	toolVersion string
+	// sourceFormat describes the v2 source, e.g. "iavl v2 tree_1".
+	sourceFormat string
+	// shardSize is the versions per destination tree_N shard.
+	shardSize int64
+	// hashAlgorithm is the hash of the changelog key_hash column.
+	hashAlgorithm string
+}
+
+// Source formats a stamp records.
+const (
+	sourceFormatV2          = "iavl v2"
+	sourceFormatV2Tree      = "iavl v2 tree_1"
+	sourceFormatV2TreeShard = "iavl v2 tree_N shards"
+)
+
+// newMigrationMeta is the stamp of a migration run with opts from a source of sourceFormat.
+func newMigrationMeta(sourceFormat string, opts migrateOptions) migrationMeta {
+	return migrationMeta{
+		toolVersion:   toolVersion(),
+		sourceFormat:  sourceFormat,
+		shardSize:     defaultTreeShardSize,
+		hashAlgorithm: hashAlgorithmName(opts.hashAlgorithm),
+	}
+}
+
+// hashAlgorithmName names the algorithm keyHashPool picks for algorithm, including the default.
+func hashAlgorithmName(algorithm string) string {
+	if algorithm == "" {
+		return hashAlgorithmBlake3
+	}
+	return algorithm
+}
+
+// stampMigrationMeta writes meta into the migration_meta table of db, creating it if needed
+// and replacing an earlier stamp, e.g. when --append adds to a destination.
+func stampMigrationMeta(ctx context.Context, db sqlExecer, lg *migrationLogger, meta migrationMeta) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (key TEXT PRIMARY KEY, value TEXT)`, migrationMetaTable)
+	if _, err := execSQL(ctx, db, lg, stmt); err != nil {
+		return fmt.Errorf("create %s: %w", migrationMetaTable, err)
+	}
+	insert := fmt.Sprintf(`INSERT OR REPLACE INTO %s(key, value) VALUES (?, ?)`, migrationMetaTable)
+	for _, kv := range [][2]string{
+		{"tool_version", meta.toolVersion},
+		{"source_format", meta.sourceFormat},
+		{"shard_size", strconv.FormatInt(meta.shardSize, 10)},
+		{"hash_algorithm", meta.hashAlgorithm},
+	} {
+		if _, err := execSQL(ctx, db, lg, insert, kv[0], kv[1]); err != nil {
+			return fmt.Errorf("stamp %s: %w", migrationMetaTable, err)
+		}
+	}
+	return nil
+}
+
+// readMigrationMeta reads the stamp of the destination database at path; ok is false if the
+// database has no migration_meta table, e.g. it was migrated with --stamp=false or by an
+// older version of this tool.
+func readMigrationMeta(path string) (meta migrationMeta, ok bool, err error) {
+	db, err := sql.Open("sqlite", readonlyURI(path))
+	if err != nil {
+		return meta, false, fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+	if ok, err := tableExists(db, migrationMetaTable); err != nil || !ok {
+		return meta, false, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf("SELECT key, value FROM %s", migrationMetaTable))
+	if err != nil {
+		return meta, false, fmt.Errorf("read %s of %s: %w", migrationMetaTable, path, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return meta, false, fmt.Errorf("read %s of %s: %w", migrationMetaTable, path, err)
+		}
+		switch key {
+		case "tool_version":
+			meta.toolVersion = value
+		case "source_format":
+			meta.sourceFormat = value
+		case "shard_size":
+			if meta.shardSize, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return meta, false, fmt.Errorf("%s of %s: bad shard_size %q", migrationMetaTable, path, value)
+			}
+		case "hash_algorithm":
+			meta.hashAlgorithm = value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return meta, false, fmt.Errorf("read %s of %s: %w", migrationMetaTable, path, err)
+	}
+	return meta, true, nil
+}
+
+// checkStampedShardSize fails if the destination database at path is stamped with a shard
+// size other than the one this build splits versions by, which every shard lookup and check
+// would then get wrong. An unstamped database passes.
+func checkStampedShardSize(path string) error {
+	meta, ok, err := readMigrationMeta(path)
+	if err != nil || !ok || meta.shardSize == 0 {
+		return err
+	}
+	if meta.shardSize != defaultTreeShardSize {
+		return fmt.Errorf("%s was migrated with shard size %d (tool %s), but this build uses %d; check it with a matching build",
+			path, meta.shardSize, meta.toolVersion, defaultTreeShardSize)
+	}
+	return nil
+}
+
+// checkHashAlgorithm picks the key hash algorithm to check the destination changelog at path
+// with: the one it is stamped with when algorithm is empty, else algorithm, which must then
+// agree with the stamp; checking with another would report every leaf as missing. Without a
+// file or a stamp it is algorithm, or the default.
+func checkHashAlgorithm(path, algorithm string) (string, error) {
+	var stamped string
+	if fileExists(path) {
+		meta, ok, err := readMigrationMeta(path)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			stamped = meta.hashAlgorithm
+		}
+	}
+	switch {
+	case algorithm == "" && stamped != "":
+		return stamped, nil
+	case stamped != "" && stamped != hashAlgorithmName(algorithm):
+		return "", fmt.Errorf("%s was migrated with hash algorithm %s, not %s; drop --hash-algorithm to use the stamped one",
+			path, stamped, hashAlgorithmName(algorithm))
+	}
+	return hashAlgorithmName(algorithm), nil
+}
@@ -0,0 +1,63 @@
+package v2
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationMetaStamp(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2)
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{stamp: true, hashAlgorithm: hashAlgorithmSha256}))
+
+	treePath := filepath.Join(iavl2Path, "bank", "tree.sqlite")
+	changelogPath := filepath.Join(iavl2Path, "bank", "changelog.sqlite")
+	requireTableNames(t, treePath, "branch_orphan", migrationMetaTable, "root", "tree_1")
+	meta, ok, err := readMigrationMeta(treePath)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, migrationMeta{toolVersion: toolVersion(), sourceFormat: sourceFormatV2Tree, shardSize: defaultTreeShardSize, hashAlgorithm: hashAlgorithmSha256}, meta)
+	meta, ok, err = readMigrationMeta(changelogPath)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, sourceFormatV2, meta.sourceFormat)
+	require.NoError(t, checkStampedShardSize(treePath))
+
+	// without a stamp nothing is recorded
+	unstamped := t.TempDir()
+	require.NoError(t, migrateV2Store(t, filepath.Join(iavl2Path+".bak", "bank"), unstamped, migrateOptions{}))
+	_, ok, err = readMigrationMeta(filepath.Join(unstamped, "tree.sqlite"))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// verify-sample picks up the stamped sha256 by itself and refuses a contradicting flag
+	var out bytes.Buffer
+	require.NoError(t, verifySample(&out, iavl2Path+".bak", iavl2Path, nil, 100, 1, ""))
+	require.Contains(t, out.String(), "store bank: tree 2/2, changelog 2/2 sampled rows match")
+	err = verifySample(&out, iavl2Path+".bak", iavl2Path, nil, 100, 1, hashAlgorithmBlake3)
+	require.ErrorContains(t, err, "migrated with hash algorithm sha256, not blake3")
+
+	// a stamp from a build with another shard size is refused
+	execV2(t, filepath.Join(iavl2Path, "bank"), "tree.sqlite", "UPDATE migration_meta SET value = '1000' WHERE key = 'shard_size'")
+	require.ErrorContains(t, checkStampedShardSize(treePath), "migrated with shard size 1000")
+}
+
+func TestAppendRefusesOtherHashAlgorithm(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+	createV2Store(t, oldDir, 1)
+	require.NoError(t, migrateV2Store(t, oldDir, newDir, migrateOptions{stamp: true}))
+	addV2Versions(t, oldDir, 2)
+
+	_, err := migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(newDir, "changelog.sqlite"),
+		migrateOptions{appendMode: true, stamp: true, hashAlgorithm: hashAlgorithmSha256})
+	require.ErrorIs(t, err, ErrAppendConflict)
+
+	rows, err := migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(newDir, "changelog.sqlite"),
+		migrateOptions{appendMode: true, stamp: true})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), rows)
+	require.Equal(t, int64(4), countTableRows(t, filepath.Join(newDir, "changelog.sqlite"), migrationMetaTable))
+}
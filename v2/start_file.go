@@ -0,0 +1,272 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func StartFileCommand() *cobra.Command {
+	// e.g.: ./migrate v2 start-file --old-tree bank/tree.sqlite --new-tree /tmp/bank/tree.sqlite
+	var (
+		oldTree, oldChangelog string
+		newTree, newChangelog string
+		hashAlgorithm         string
+		skipCorrupt           bool
+		lowMemory             bool
+		copyStrategy          string
+		batchSize             int
+		checkpoint            int64
+		shardWorkers          int
+		readonly              bool
+		overwrite             bool
+		assumeYes             bool
+		maxShards             int64
+		force                 bool
+		copyUnknown           bool
+		rebuildOrphans        bool
+		validateRoot          bool
+		stamp                 bool
+		fromShards            bool
+		trimOrphans           int64
+		skipSpace             bool
+		maxOpenFiles          int64
+		appendMode            bool
+		busyTimeout           time.Duration
+		shardList             string
+		keyPrefix             string
+		keepRawKey            bool
+		verifySource          bool
+		verifyShards          bool
+		skipEmpty             bool
+		optimize              bool
+		vacuum                bool
+		dirMode               string
+		verbose               bool
+		logFormat             string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "start-file",
+		Short: "migrate a single tree.sqlite and/or changelog.sqlite given by path",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger, err := newMigrationLogger(logFormat)
+			if err != nil {
+				return err
+			}
+			logger.verbose = verbose
+			shards, err := parseShardList(shardList)
+			if err != nil {
+				return err
+			}
+			prefix, err := parseKeyPrefix(keyPrefix)
+			if err != nil {
+				return err
+			}
+			mode, err := parseDirMode(dirMode)
+			if err != nil {
+				return err
+			}
+			lowMemory, err := resolveCopyStrategy(copyStrategy, lowMemory)
+			if err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			opts := migrateOptions{
+				confirmIn:           confirmReader(cmd.InOrStdin()),
+				ctx:                 ctx,
+				hashAlgorithm:       hashAlgorithm,
+				skipCorrupt:         skipCorrupt,
+				lowMemory:           lowMemory,
+				batchSize:           batchSize,
+				checkpointInterval:  checkpoint,
+				shardWorkers:        shardWorkers,
+				sourceReadonly:      readonly,
+				overwrite:           overwrite,
+				maxShards:           maxShards,
+				force:               force,
+				copyUnknownTables:   copyUnknown,
+				rebuildOrphans:      rebuildOrphans,
+				validateRoot:        validateRoot,
+				stamp:               stamp,
+				shardSizeFromSource: fromShards,
+				trimOrphans:         trimOrphans,
+				skipSpaceCheck:      skipSpace,
+				maxOpenFiles:        maxOpenFiles,
+				appendMode:          appendMode,
+				busyTimeout:         busyTimeout,
+				shards:              shards,
+				keyPrefix:           prefix,
+				keepRawKey:          keepRawKey,
+				verifySource:        verifySource,
+				verifyEachShard:     verifyShards,
+				skipEmptyShards:     skipEmpty,
+				optimize:            optimize,
+				vacuum:              vacuum,
+				dirMode:             mode,
+				logger:              logger,
+			}
+			if assumeYes {
+				opts.confirmIn = nil
+			}
+			return migrateFiles(oldTree, newTree, oldChangelog, newChangelog, opts)
+		},
+	}
+	cmd.Flags().StringVar(&oldTree, "old-tree", "", "Path to the v2 tree.sqlite, or an http(s):// or s3:// URL downloaded next to --new-tree first")
+	cmd.Flags().StringVar(&newTree, "new-tree", "", "Destination path for the migrated tree.sqlite")
+	cmd.Flags().StringVar(&oldChangelog, "old-changelog", "", "Path or http(s)/s3 URL of the v2 changelog.sqlite; may be the same as --old-tree for a source holding both")
+	cmd.Flags().StringVar(&newChangelog, "new-changelog", "", "Destination path for the migrated changelog.sqlite")
+	cmd.MarkFlagsRequiredTogether("old-tree", "new-tree")
+	cmd.MarkFlagsRequiredTogether("old-changelog", "new-changelog")
+	cmd.MarkFlagsOneRequired("old-tree", "old-changelog")
+	cmd.Flags().StringVar(&hashAlgorithm, "hash-algorithm", hashAlgorithmBlake3, "Hash algorithm used to compute changelog key_hash (blake3, sha256)")
+	cmd.Flags().BoolVar(&skipCorrupt, "skip-corrupt", false, "Skip and report rows that fail to read or insert instead of aborting")
+	cmd.Flags().BoolVar(&lowMemory, "low-memory", false, "Copy tree shards and orphan tables with a cursor in bounded batches instead of one INSERT ... SELECT each; duplicate orphan rows are dropped")
+	cmd.Flags().StringVar(&copyStrategy, "copy-strategy", "", "How tree shards and orphan tables are copied: attach (one INSERT ... SELECT each, the default) or stream (bounded batches, same as --low-memory); changelog leaves are always streamed")
+	cmd.Flags().IntVar(&batchSize, "batch-size", lowMemoryBatchSize, "Rows per batch of the --low-memory copies")
+	cmd.Flags().Int64Var(&checkpoint, "checkpoint-interval", 0, "Commit the changelog's leaf copy every this many rows instead of in one transaction, bounding its journal (0: one transaction)")
+	cmd.Flags().IntVar(&shardWorkers, "shard-workers", 1, "Tree shards copied at once; each stages its shard in a separate file next to the destination")
+	cmd.Flags().BoolVar(&readonly, "source-readonly", true, "Open the v2 source read-only and immutable (no locks)")
+	cmd.Flags().BoolVar(&verifySource, "verify-source", false, "Run SQLite's quick_check on each source first, check the tree has one root per version, and refuse a corrupt one")
+	cmd.Flags().BoolVar(&verifyShards, "verify-after-each-shard", false, "Compare each tree shard's row count with its source version range right after writing it and fail on the first difference")
+	cmd.MarkFlagsMutuallyExclusive("verify-after-each-shard", "skip-corrupt")
+	cmd.Flags().BoolVar(&skipEmpty, "skip-empty-shards", false, "Don't create tree_N tables whose version range has no source rows; iavl v3 rollback fails on such a gap until fix-missing-shard recreates them")
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "Replace destination files that already exist instead of failing")
+	cmd.Flags().BoolVarP(&assumeYes, "assume-yes", "y", false, "Replace destinations with --overwrite without listing them and asking first")
+	cmd.Flags().DurationVar(&busyTimeout, "busy-timeout", defaultBusyTimeout, "How long each SQLite connection waits on a locked database before failing with SQLITE_BUSY (0 fails at once)")
+	cmd.Flags().Int64Var(&maxShards, "max-shards", defaultMaxShards, "Refuse a tree whose version range spans more shard tables than this (see --force)")
+	cmd.Flags().BoolVar(&force, "force", false, "Migrate the tree even if its version range exceeds --max-shards")
+	cmd.Flags().BoolVar(&copyUnknown, "copy-unknown-tables", false, "Copy source tables the migration doesn't know about verbatim instead of only logging them")
+	cmd.Flags().BoolVar(&rebuildOrphans, "rebuild-orphans", false, "If the source changelog has no leaf_orphan table, reconstruct it from later writes of the same key (best-effort)")
+	cmd.Flags().Int64Var(&trimOrphans, "trim-orphans", 0, "Skip orphan rows with at below this version, capped at the tree's earliest version with a root (0 keeps all); the changelog reads the tree.sqlite next to it")
+	cmd.Flags().BoolVar(&appendMode, "append", false, "If a destination exists, add only the source versions newer than its latest version to it (the source must continue it without gaps)")
+	cmd.MarkFlagsMutuallyExclusive("append", "overwrite")
+	cmd.Flags().BoolVar(&skipSpace, "skip-space-check", false, "Start even if the destination filesystem has less free space than the migration is estimated to need")
+	cmd.Flags().Int64Var(&maxOpenFiles, "max-open-files", 0, "Open files to check --shard-workers against instead of the process's RLIMIT_NOFILE (0: the limit)")
+	cmd.Flags().BoolVar(&validateRoot, "validate-root", true, "Check that the latest migrated root decodes as a v3 node before keeping the tree")
+	cmd.Flags().BoolVar(&stamp, "stamp", true, "Record the tool version, source format, shard size and hash algorithm in a migration_meta table of each migrated database")
+	cmd.Flags().BoolVar(&fromShards, "shard-size-from-source", false, "Read a source already split into tree_N tables, inferring and checking its shard size")
+	cmd.Flags().BoolVar(&optimize, "optimize", false, "Run ANALYZE on each migrated database")
+	cmd.Flags().BoolVar(&vacuum, "vacuum", false, "Also VACUUM each migrated database after --optimize (implies it), logging the size before and after")
+	cmd.Flags().StringVar(&shardList, "shards", "", "Only create and fill these tree_N shards, e.g. 3,5-7; each must be in the tree's shard range. root, orphans and the changelog are still migrated in full")
+	cmd.Flags().StringVar(&keyPrefix, "key-prefix", "", "Only migrate changelog leaves whose raw key starts with this hex prefix; the result is not a full state and can't back a node")
+	cmd.Flags().BoolVar(&keepRawKey, "keep-raw-key", false, "Also store each leaf's original key in an extra key column of the destination leaf table; iavl v3 ignores the column")
+	cmd.Flags().StringVar(&dirMode, "dir-mode", "750", "Octal permissions of the directories created for the destinations")
+	cmd.Flags().BoolVar(&verbose, "verbose", false, "Log every SQL statement run on the destinations, including shard CREATE/INSERT and ATTACH, with the rows it affected")
+	cmd.Flags().StringVar(&logFormat, "log-format", logFormatText, "Log output format (text, json)")
+	return cmd
+}
+
+// migrateFiles runs migrateTree and/or migrateChangelog on explicit paths; an empty old path skips that half.
+// Unlike migrate it does not move the source aside, and it refuses to overwrite a source in place.
+// A source may also be an http(s):// or s3:// URL, downloaded to a temporary file first.
+func migrateFiles(oldTree, newTree, oldChangelog, newChangelog string, opts migrateOptions) error {
+	if opts.shardWorkers < 0 {
+		return fmt.Errorf("shard-workers must be positive, got %d", opts.shardWorkers)
+	}
+	if opts.batchSize < 0 {
+		return fmt.Errorf("batch-size must be positive, got %d", opts.batchSize)
+	}
+	if opts.checkpointInterval < 0 {
+		return fmt.Errorf("checkpoint-interval must not be negative, got %d", opts.checkpointInterval)
+	}
+	if opts.maxOpenFiles < 0 {
+		return fmt.Errorf("max-open-files must not be negative, got %d", opts.maxOpenFiles)
+	}
+	for _, pair := range [][2]string{{oldTree, newTree}, {oldChangelog, newChangelog}} {
+		if pair[0] == "" || isRemoteSource(pair[0]) {
+			continue
+		}
+		if _, err := os.Stat(pair[0]); os.IsNotExist(err) {
+			return fmt.Errorf("source %s %w", pair[0], ErrSourceNotFound)
+		} else if err != nil {
+			return fmt.Errorf("source %s: %w", pair[0], err)
+		}
+		oldAbs, err := filepath.Abs(pair[0])
+		if err != nil {
+			return err
+		}
+		newAbs, err := filepath.Abs(pair[1])
+		if err != nil {
+			return err
+		}
+		if oldAbs == newAbs {
+			return errors.New("destination must differ from source: " + pair[0])
+		}
+	}
+	// a destination laid out as <data>/iavl2/<store>/tree.sqlite sits in the data directory of a node
+	for _, newPath := range []string{newTree, newChangelog} {
+		if newPath == "" {
+			continue
+		}
+		newAbs, err := filepath.Abs(newPath)
+		if err != nil {
+			return err
+		}
+		if err := checkNoRunningNode(filepath.Dir(filepath.Dir(filepath.Dir(newAbs)))); err != nil {
+			return err
+		}
+	}
+
+	// one file holding both halves is split into the two destinations
+	if oldTree != "" && oldTree == oldChangelog {
+		opts.combined = true
+	}
+	if opts.overwrite {
+		var replaced []string
+		if oldTree != "" {
+			replaced = append(replaced, existingFiles(newTree)...)
+		}
+		if oldChangelog != "" {
+			replaced = append(replaced, existingFiles(newChangelog)...)
+		}
+		if err := confirmDeletion(opts.confirmIn, os.Stdout, replaced); err != nil {
+			return err
+		}
+	}
+	// URL sources are downloaded next to their destinations and migrated from the local copy
+	oldTree, oldChangelog, cleanup, err := fetchRemoteSources(oldTree, newTree, oldChangelog, newChangelog, opts)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	for i, pair := range [][2]string{{oldTree, newTree}, {oldChangelog, newChangelog}} {
+		// a combined source is split into both destinations, its size is counted once
+		if pair[0] == "" || (opts.combined && i == 1) {
+			continue
+		}
+		if err := checkDiskSpace(filepath.Dir(pair[1]), []string{pair[0]}, opts); err != nil {
+			return err
+		}
+	}
+	if _, err := checkOpenFiles(1, opts); err != nil {
+		return err
+	}
+
+	lg := opts.logger
+	if oldTree != "" {
+		tree, err := migrateTree(oldTree, newTree, opts)
+		if err != nil {
+			return fmt.Errorf("migrate tree %s: %w", oldTree, err)
+		}
+		lg.Event("tree_done", logFields{"rows": tree.Rows(), "shards": tree.Shards}, "migrated %d tree rows into shards %v: %s → %s", tree.Rows(), tree.Shards, oldTree, newTree)
+		optimizeDestination(newTree, opts)
+	}
+	if oldChangelog != "" {
+		rows, err := migrateChangelog(oldChangelog, newChangelog, opts)
+		if err != nil {
+			return fmt.Errorf("migrate changelog %s: %w", oldChangelog, err)
+		}
+		lg.Event("changelog_done", logFields{"rows": rows}, "migrated %d changelog rows: %s → %s", rows, oldChangelog, newChangelog)
+		optimizeDestination(newChangelog, opts)
+	}
+	return nil
+}
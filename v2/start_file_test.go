@@ -0,0 +1,65 @@
+package v2
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func TestStartFile(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 1, 500001)
+	newDir := t.TempDir()
+	newTree := filepath.Join(newDir, "out", "tree.sqlite")
+	newChangelog := filepath.Join(newDir, "out", "changelog.sqlite")
+
+	buf := captureLog(t)
+	runV2Command(t, "start-file",
+		"--old-tree", filepath.Join(oldDir, "tree.sqlite"), "--new-tree", newTree,
+		"--old-changelog", filepath.Join(oldDir, "changelog.sqlite"), "--new-changelog", newChangelog)
+	require.Contains(t, buf.String(), "migrated 2 tree rows")
+	require.Contains(t, buf.String(), "migrated 2 changelog rows")
+
+	db, err := sql.Open("sqlite", newTree)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, []int64{1, 2}, shardIDsOf(t, db))
+}
+
+func TestMigrateFilesRejectsInPlace(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 1)
+	treePath := filepath.Join(oldDir, "tree.sqlite")
+
+	err := migrateFiles(treePath, treePath, "", "", migrateOptions{})
+	require.ErrorContains(t, err, "destination must differ from source")
+}
+
+func TestStartFileOverwriteConfirmation(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 1, 2)
+	oldTree := filepath.Join(oldDir, "tree.sqlite")
+	newTree := filepath.Join(t.TempDir(), "tree.sqlite")
+	captureLog(t)
+	require.NoError(t, migrateFiles(oldTree, newTree, "", "", migrateOptions{}))
+
+	// declining keeps the earlier destination and lists what would have gone
+	var err error
+	out := captureStdout(t, func() {
+		err = migrateFiles(oldTree, newTree, "", "", migrateOptions{overwrite: true, confirmIn: confirmReader(strings.NewReader("n\n"))})
+	})
+	require.ErrorIs(t, err, errNotConfirmed)
+	require.Contains(t, out, "The following 1 files will be deleted:\n  "+newTree+" (")
+	require.FileExists(t, newTree)
+
+	out = captureStdout(t, func() {
+		err = migrateFiles(oldTree, newTree, "", "", migrateOptions{overwrite: true, confirmIn: confirmReader(strings.NewReader("y\n"))})
+	})
+	require.NoError(t, err)
+	require.Contains(t, out, "Delete ")
+	require.EqualValues(t, 2, countTableRows(t, newTree, "root"))
+}
@@ -0,0 +1,214 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Strategies --copy-strategy picks between for the tree shards and the tree and changelog
+// orphan tables alike: one INSERT ... SELECT per table from the source ATTACHed to the
+// destination connection, fast but holding a whole shard in SQLite's temp space, or a cursor
+// on the source read in bounded batches. Leaves are streamed under either, as their key_hash
+// is computed in Go; the small root and unknown tables are always copied through ATTACH.
+const (
+	copyStrategyAttach = "attach"
+	copyStrategyStream = "stream"
+)
+
+// resolveCopyStrategy reports whether strategy streams rows. --low-memory is the stream
+// strategy under its older name, so an empty strategy follows it and attach contradicts it.
+func resolveCopyStrategy(strategy string, lowMemory bool) (bool, error) {
+	switch strategy {
+	case "":
+		return lowMemory, nil
+	case copyStrategyStream:
+		return true, nil
+	case copyStrategyAttach:
+		if lowMemory {
+			return false, fmt.Errorf("--low-memory streams rows and can't be combined with --copy-strategy %s", copyStrategyAttach)
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("unsupported copy strategy %q (supported: %s, %s)", strategy, copyStrategyAttach, copyStrategyStream)
+	}
+}
+
+// lowMemoryBatchSize is the default --batch-size: the number of rows inserted per transaction
+// by the --low-memory copies.
+const lowMemoryBatchSize = 10_000
+
+// copyShardRowsStreaming copies one shard's version range from src with a cursor instead of
+// the ROW_NUMBER() window, committing every batchSize rows so neither side holds the whole shard.
+// Rows are read in (version, sequence, rowid) order and only the first of each (version, sequence)
+// is kept, matching the window-function copy, including its treatment of NULL sequences as one
+// partition. Values are passed through unconverted. Cancelling ctx stops the copy at the next
+// batch boundary. It returns the number of rows copied.
+func copyShardRowsStreaming(ctx context.Context, oldDB, newDB *sql.DB, src treeSource, tableName string, startVersion, endVersion int64, batchSize int) (int64, error) {
+	rows, err := oldDB.Query(`SELECT version, sequence, bytes, orphaned FROM `+src.from("")+`
+		WHERE version >= ? AND version <= ? ORDER BY version, sequence, rowid`, startVersion, endVersion)
+	if err != nil {
+		return 0, fmt.Errorf("read old tree_1: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		tx         *sql.Tx
+		insertStmt *sql.Stmt
+		pending    int
+	)
+	begin := func() error {
+		if tx, err = newDB.Begin(); err != nil {
+			return err
+		}
+		insertStmt, err = tx.Prepare(fmt.Sprintf(`INSERT INTO %s(version, sequence, bytes, orphaned) VALUES (?, ?, ?, ?)`, tableName))
+		return err
+	}
+	commit := func() error {
+		insertStmt.Close()
+		pending = 0
+		return tx.Commit()
+	}
+	if err := begin(); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var (
+		lastVersion, lastSequence sql.NullInt64
+		hasLast                   bool
+		copied                    int64
+	)
+	for rows.Next() {
+		var (
+			version, sequence sql.NullInt64
+			bz, orphaned      any
+		)
+		if err := rows.Scan(&version, &sequence, &bz, &orphaned); err != nil {
+			return 0, fmt.Errorf("read old tree_1: %w", err)
+		}
+		if hasLast && version == lastVersion && sequence == lastSequence {
+			continue
+		}
+		lastVersion, lastSequence, hasLast = version, sequence, true
+
+		if _, err := insertStmt.Exec(version, sequence, bz, orphaned); err != nil {
+			return 0, fmt.Errorf("insert version %s sequence %s into %s: %w",
+				nullInt64String(version), nullInt64String(sequence), tableName, err)
+		}
+		copied++
+
+		if pending++; pending >= batchSize {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+			if err := commit(); err != nil {
+				return 0, err
+			}
+			if err := begin(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("read old tree_1: %w", err)
+	}
+
+	if err := commit(); err != nil {
+		return 0, err
+	}
+	tx = nil
+	return copied, nil
+}
+
+// orphanRow is one row of an orphan table; NULLs are passed through unconverted.
+type orphanRow struct {
+	version, sequence, at sql.NullInt64
+}
+
+// copyOrphansStreaming is the --low-memory form of copyOrphans: it reads the source orphan table
+// of oldDB with a cursor in the destination's (at DESC, version, sequence) primary key order,
+// drops rows repeating the previous key, which would fail the primary key, and hands write
+// batches of at most batchSize rows, so neither side holds the whole orphan history. Rows with
+// at below cutoff, or up to after for --append, are left out the same way. Cancelling ctx stops
+// the copy at the next batch boundary. It returns the rows copied, trimmed and dropped as
+// duplicates.
+func copyOrphansStreaming(ctx context.Context, oldDB *sql.DB, source string, cutoff, after int64, batchSize int,
+	write func(batch []orphanRow) error) (copied, trimmed, duplicates int64, err error) {
+	var where string
+	if after > 0 {
+		where = fmt.Sprintf(" WHERE at > %d", after)
+	}
+	rows, err := oldDB.QueryContext(ctx, fmt.Sprintf(`SELECT version, sequence, at FROM %s%s ORDER BY at DESC, version, sequence`, source, where))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("read old %s: %w", source, err)
+	}
+	defer rows.Close()
+
+	batch := make([]orphanRow, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := write(batch); err != nil {
+			return err
+		}
+		copied += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+	var (
+		last    orphanRow
+		hasLast bool
+	)
+	for rows.Next() {
+		var row orphanRow
+		if err := rows.Scan(&row.version, &row.sequence, &row.at); err != nil {
+			return 0, 0, 0, fmt.Errorf("read old %s: %w", source, err)
+		}
+		if hasLast && row == last {
+			duplicates++
+			continue
+		}
+		last, hasLast = row, true
+		if cutoff > 0 && row.at.Int64 < cutoff {
+			trimmed++
+			continue
+		}
+		if batch = append(batch, row); len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return 0, 0, 0, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, 0, fmt.Errorf("read old %s: %w", source, err)
+	}
+	if err := flush(); err != nil {
+		return 0, 0, 0, err
+	}
+	return copied, trimmed, duplicates, nil
+}
+
+// insertOrphanBatch inserts a batch of copyOrphansStreaming into the table dest within tx.
+func insertOrphanBatch(tx *sql.Tx, dest string, batch []orphanRow) error {
+	insertStmt, err := tx.Prepare(fmt.Sprintf(`INSERT INTO %s(version, sequence, at) VALUES (?, ?, ?)`, dest))
+	if err != nil {
+		return err
+	}
+	defer insertStmt.Close()
+	for _, row := range batch {
+		if _, err := insertStmt.Exec(row.version, row.sequence, row.at); err != nil {
+			return fmt.Errorf("insert orphan version %s sequence %s at %s into %s: %w",
+				nullInt64String(row.version), nullInt64String(row.sequence), nullInt64String(row.at), dest, classifySQLiteError(err))
+		}
+	}
+	return nil
+}
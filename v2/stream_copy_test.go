@@ -0,0 +1,223 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func dumpShards(t *testing.T, path string) []string {
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	shardIDs, err := listShardIDs(db)
+	require.NoError(t, err)
+
+	var dump []string
+	for _, shardID := range shardIDs {
+		rows, err := db.Query(fmt.Sprintf("SELECT version, sequence, hex(bytes), quote(orphaned) FROM tree_%d ORDER BY version, sequence", shardID))
+		require.NoError(t, err)
+		for rows.Next() {
+			var (
+				version, sequence int64
+				bz, orphaned      string
+			)
+			require.NoError(t, rows.Scan(&version, &sequence, &bz, &orphaned))
+			dump = append(dump, fmt.Sprintf("tree_%d %d/%d %s %s", shardID, version, sequence, bz, orphaned))
+		}
+		require.NoError(t, rows.Err())
+		rows.Close()
+	}
+	return dump
+}
+
+func TestMigrateTreeLowMemoryMatchesWindowCopy(t *testing.T) {
+	tempDir := t.TempDir()
+	oldPath := filepath.Join(tempDir, "old_tree.sqlite")
+
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	defer oldDB.Close()
+	// duplicates are inserted out of (version, sequence) order so rowid decides which copy wins
+	_, err = oldDB.Exec(`
+		CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool);
+		CREATE TABLE orphan (version int, sequence int, at int);
+		CREATE TABLE root (version int, node_version int, node_sequence int, bytes blob, PRIMARY KEY (version));
+		INSERT INTO tree_1 VALUES (500001, 2, x'b1', 1);
+		INSERT INTO tree_1 VALUES (2, 1, x'a1', NULL);
+		INSERT INTO tree_1 VALUES (1, 1, x'01', 0);
+		INSERT INTO tree_1 VALUES (2, 1, x'a2', 1);
+		INSERT INTO tree_1 VALUES (500001, 1, x'c1', 0);
+		INSERT INTO tree_1 VALUES (500001, 2, x'b2', 0);
+		INSERT INTO tree_1 VALUES (2, 2, x'a3', 0);
+		INSERT INTO tree_1 VALUES (2, 1, x'a4', 0);
+		INSERT INTO root VALUES (500001, 500001, 1, x'ff');
+	`)
+	require.NoError(t, err)
+
+	windowPath := filepath.Join(tempDir, "window.sqlite")
+	windowRows, err := migrateTree(oldPath, windowPath, migrateOptions{})
+	require.NoError(t, err)
+
+	streamPath := filepath.Join(tempDir, "stream.sqlite")
+	streamRows, err := migrateTree(oldPath, streamPath, migrateOptions{lowMemory: true})
+	require.NoError(t, err)
+
+	require.Equal(t, int64(5), windowRows.Rows())
+	require.Equal(t, windowRows, streamRows)
+	require.Equal(t, dumpShards(t, windowPath), dumpShards(t, streamPath))
+	require.Contains(t, dumpShards(t, streamPath), "tree_1 2/1 A1 NULL")
+}
+
+func TestCopyShardRowsStreamingBatches(t *testing.T) {
+	tempDir := t.TempDir()
+	oldDB, err := sql.Open("sqlite", filepath.Join(tempDir, "old.sqlite"))
+	require.NoError(t, err)
+	defer oldDB.Close()
+	newDB, err := sql.Open("sqlite", filepath.Join(tempDir, "new.sqlite"))
+	require.NoError(t, err)
+	defer newDB.Close()
+
+	_, err = oldDB.Exec(`CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool)`)
+	require.NoError(t, err)
+	for i := 1; i <= 7; i++ {
+		_, err = oldDB.Exec("INSERT INTO tree_1 VALUES (?, 1, x'01', false)", i)
+		require.NoError(t, err)
+	}
+	_, err = newDB.Exec(`CREATE TABLE tree_1 (version INT, sequence INT, bytes BLOB, orphaned BOOL, PRIMARY KEY (version, sequence)) WITHOUT ROWID`)
+	require.NoError(t, err)
+
+	copied, err := copyShardRowsStreaming(context.Background(), oldDB, newDB, plainTreeSource, "tree_1", 1, 500000, 3)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), copied)
+
+	var count int64
+	require.NoError(t, newDB.QueryRow("SELECT COUNT(*) FROM tree_1").Scan(&count))
+	require.Equal(t, int64(7), count)
+}
+
+// insertOrphanRows fills the orphan table of the database at path with rows distinct
+// (version, sequence, at) rows, then repeats every dupEvery-th of them.
+func insertOrphanRows(t *testing.T, path, table string, rows, dupEvery int) {
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (version, sequence, at) VALUES (?, ?, ?)", table))
+	require.NoError(t, err)
+	for pass := 0; pass < 2; pass++ {
+		for i := 0; i < rows; i++ {
+			if pass == 1 && i%dupEvery != 0 {
+				continue
+			}
+			_, err := stmt.Exec(i%500+1, i, i%300+1)
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, stmt.Close())
+	require.NoError(t, tx.Commit())
+}
+
+func TestMigrateLowMemoryOrphansDropDuplicates(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 100, 200)
+	oldTree, oldChangelog := filepath.Join(oldDir, "tree.sqlite"), filepath.Join(oldDir, "changelog.sqlite")
+	const rows, dupEvery = 25_000, 5
+	insertOrphanRows(t, oldTree, "orphan", rows, dupEvery)
+	insertOrphanRows(t, oldChangelog, "leaf_orphan", rows, dupEvery)
+
+	// the single INSERT ... SELECT trips over the duplicates
+	_, err := migrateTree(oldTree, filepath.Join(t.TempDir(), "tree.sqlite"), migrateOptions{})
+	require.ErrorContains(t, err, "UNIQUE constraint failed")
+
+	buf := captureLog(t)
+	newDir := t.TempDir()
+	opts := migrateOptions{lowMemory: true, batchSize: 1000}
+	_, err = migrateTree(oldTree, filepath.Join(newDir, "tree.sqlite"), opts)
+	require.NoError(t, err)
+	_, err = migrateChangelog(oldChangelog, filepath.Join(newDir, "changelog.sqlite"), opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(rows), countTableRows(t, filepath.Join(newDir, "tree.sqlite"), "branch_orphan"))
+	require.Equal(t, int64(rows), countTableRows(t, filepath.Join(newDir, "changelog.sqlite"), "leaf_orphan"))
+	require.Contains(t, buf.String(), fmt.Sprintf("dropped %d duplicate branch orphan rows", rows/dupEvery))
+	require.Contains(t, buf.String(), fmt.Sprintf("dropped %d duplicate leaf orphan rows", rows/dupEvery))
+
+	// trimming drops the same rows as the INSERT ... SELECT copy does
+	opts.trimOrphans = 50
+	result, err := migrateTree(oldTree, filepath.Join(t.TempDir(), "tree.sqlite"), opts)
+	require.NoError(t, err)
+	var below int64
+	for i := 0; i < rows; i++ {
+		if i%300+1 < 50 {
+			below++
+		}
+	}
+	require.Equal(t, below, result.TrimmedOrphans)
+}
+
+func TestCopyOrphansStreamingBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.sqlite")
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE orphan (version int, sequence int, at int)")
+	require.NoError(t, err)
+	insertOrphanRows(t, path, "orphan", 10, 3)
+
+	var batches [][]orphanRow
+	copied, trimmed, duplicates, err := copyOrphansStreaming(context.Background(), db, "orphan", 0, 2, 4, func(batch []orphanRow) error {
+		batches = append(batches, append([]orphanRow(nil), batch...))
+		return nil
+	})
+	require.NoError(t, err)
+	// at > 2 leaves 8 of the 10 rows, and their 3 repeats
+	require.Equal(t, int64(8), copied)
+	require.Zero(t, trimmed)
+	require.Equal(t, int64(3), duplicates)
+	require.Len(t, batches, 2)
+	// in primary key order: at descending, then version and sequence
+	require.Equal(t, int64(10), batches[0][0].at.Int64)
+	require.Equal(t, int64(3), batches[1][3].at.Int64)
+}
+
+func TestResolveCopyStrategy(t *testing.T) {
+	for _, tc := range []struct {
+		strategy  string
+		lowMemory bool
+		want      bool
+		err       string
+	}{
+		{strategy: "", want: false},
+		{strategy: "", lowMemory: true, want: true},
+		{strategy: copyStrategyAttach, want: false},
+		{strategy: copyStrategyStream, want: true},
+		{strategy: copyStrategyStream, lowMemory: true, want: true},
+		{strategy: copyStrategyAttach, lowMemory: true, err: "can't be combined"},
+		{strategy: "mmap", err: `unsupported copy strategy "mmap"`},
+	} {
+		got, err := resolveCopyStrategy(tc.strategy, tc.lowMemory)
+		if tc.err != "" {
+			require.ErrorContains(t, err, tc.err, tc.strategy)
+			continue
+		}
+		require.NoError(t, err, tc.strategy)
+		require.Equal(t, tc.want, got, tc.strategy)
+	}
+}
+
+func TestMigrateCopyStrategyStream(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2)
+	// duplicate orphan rows, which only the streaming copy drops
+	insertOrphanRows(t, filepath.Join(iavl2Path, "bank", "tree.sqlite"), "orphan", 10, 5)
+
+	runV2Command(t, "start", "--iavl2-path", iavl2Path, "--copy-strategy", "stream")
+	require.Equal(t, int64(10), countTableRows(t, filepath.Join(iavl2Path, "bank", "tree.sqlite"), "branch_orphan"))
+}
@@ -0,0 +1,66 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// storeResult summarizes the migration of a single store.
+type storeResult struct {
+	store             string
+	treeRows          int64
+	treeShards        int
+	changelogRows     int64
+	treeDuration      time.Duration
+	changelogDuration time.Duration
+	err               error
+}
+
+// status is "ok", "failed", "timed_out" for a store that hit --store-timeout, or "interrupted"
+// for a store cancelled by Ctrl-C or another store's failure.
+func (res storeResult) status() string {
+	switch {
+	case errors.Is(res.err, context.Canceled):
+		return "interrupted"
+	case errors.Is(res.err, ErrStoreTimeout):
+		return "timed_out"
+	case res.err != nil:
+		return "failed"
+	}
+	return "ok"
+}
+
+// printMigrationSummary prints one line per store, sorted by name, followed by the total wall-clock time.
+func printMigrationSummary(w io.Writer, results []storeResult, total time.Duration) {
+	sorted := make([]storeResult, len(results))
+	copy(sorted, results)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].store < sorted[j].store })
+
+	failed := 0
+	fmt.Fprintf(w, "\nMigration summary:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tSTATUS\tTREE ROWS\tCHANGELOG ROWS\tTREE TIME\tCHANGELOG TIME\tSHARDS")
+	for _, res := range sorted {
+		status := res.status()
+		if res.err != nil {
+			status = strings.ToUpper(status)
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%d\n", res.store, status, res.treeRows, res.changelogRows,
+			res.treeDuration.Round(time.Millisecond), res.changelogDuration.Round(time.Millisecond), res.treeShards)
+	}
+	tw.Flush()
+
+	for _, res := range sorted {
+		if res.err != nil {
+			fmt.Fprintf(w, "store %s failed: %v\n", res.store, res.err)
+		}
+	}
+	fmt.Fprintf(w, "%d stores migrated, %d failed, total time %s\n", len(sorted)-failed, failed, total.Round(time.Millisecond))
+}
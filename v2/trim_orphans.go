@@ -0,0 +1,94 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// earliestLiveVersion returns the smallest version that still has a root in the tree
+// database db, and false if there are no roots.
+func earliestLiveVersion(db *sql.DB) (int64, bool, error) {
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MIN(version) FROM root").Scan(&version); err != nil {
+		return 0, false, fmt.Errorf("read earliest root version: %w", err)
+	}
+	return version.Int64, version.Valid, nil
+}
+
+// orphanTrimCutoff returns the version below which orphan rows are dropped for --trim-orphans,
+// or 0 to keep them all. An orphan with at <= v is deleted when version v is pruned, so rows
+// with at below the earliest version that still has a root only describe nodes no kept version
+// reaches; the requested cutoff is capped there so pruning a live version never misses a node.
+func orphanTrimCutoff(treeDB *sql.DB, requested int64, treePath string, lg *migrationLogger) (int64, error) {
+	if requested <= 0 {
+		return 0, nil
+	}
+	earliest, ok, err := earliestLiveVersion(treeDB)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		lg.Event("trim_orphans_skipped", logFields{"source": treePath},
+			"WARNING: %s has no roots, keeping every orphan row despite --trim-orphans", treePath)
+		return 0, nil
+	}
+	if requested > earliest {
+		lg.Event("trim_orphans_capped", logFields{"source": treePath, "requested": requested, "cutoff": earliest},
+			"--trim-orphans=%d is past the earliest live version %d of %s, trimming orphans below %d instead", requested, earliest, treePath, earliest)
+		return earliest, nil
+	}
+	return requested, nil
+}
+
+// changelogTrimCutoff is orphanTrimCutoff for a changelog, whose live versions are those of the
+// tree database next to it, or of the changelog file itself for a combined source. A missing or
+// compressed tree can't be read, which fails the changelog rather than guessing.
+func changelogTrimCutoff(changelogPath string, opts migrateOptions) (int64, error) {
+	if opts.trimOrphans <= 0 {
+		return 0, nil
+	}
+	treePath := resolveSource(filepath.Join(filepath.Dir(changelogPath), opts.treeFilename()))
+	if opts.combined {
+		treePath = changelogPath
+	}
+	if strings.HasSuffix(treePath, zstdSuffix) || strings.HasSuffix(treePath, gzipSuffix) {
+		return 0, fmt.Errorf("--trim-orphans needs an uncompressed %s next to %s to find the earliest live version, found %s", opts.treeFilename(), changelogPath, treePath)
+	}
+	treeDB, err := openSource(treePath, opts.sourceReadonly, opts.busyTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("--trim-orphans needs the tree next to %s: %w", changelogPath, err)
+	}
+	defer treeDB.Close()
+	return orphanTrimCutoff(treeDB, opts.trimOrphans, treePath, opts.logger)
+}
+
+// copyOrphans copies the attached source's orphan table into the destination table dest,
+// leaving out rows with at below cutoff (none if cutoff is 0) and, for --append, rows with
+// at up to after, which the destination already has. It returns the rows copied and trimmed.
+func copyOrphans(db sqlExecQuerier, source, dest string, cutoff, after int64) (copied, trimmed int64, err error) {
+	var appended string
+	if after > 0 {
+		appended = fmt.Sprintf(" AND at > %d", after)
+	}
+	stmt := fmt.Sprintf(`INSERT INTO %s(version, sequence, at)
+		SELECT version, sequence, at FROM old.%s`, dest, source)
+	switch {
+	case cutoff > 0:
+		stmt += fmt.Sprintf(" WHERE at >= %d", cutoff) + appended
+	case after > 0:
+		stmt += fmt.Sprintf(" WHERE at > %d", after)
+	}
+	res, err := db.Exec(stmt)
+	if err != nil {
+		return 0, 0, fmt.Errorf("migrate %s: %w", dest, err)
+	}
+	copied, _ = res.RowsAffected()
+	if cutoff > 0 {
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM old.%s WHERE at < %d", source, cutoff) + appended).Scan(&trimmed); err != nil {
+			return 0, 0, fmt.Errorf("count trimmed %s rows: %w", source, err)
+		}
+	}
+	return copied, trimmed, nil
+}
@@ -0,0 +1,100 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Source tables the tree and changelog migrations read; anything else is "unknown".
+var (
+	knownTreeTables      = []string{"tree_1", "root", "orphan"}
+	knownChangelogTables = []string{"leaf", "leaf_orphan"}
+)
+
+// v3TreeTableRe matches the tables copyTree creates itself, so an unknown source table with
+// one of these names can't be copied across.
+var v3TreeTableRe = regexp.MustCompile(`^(tree_\d+|branch_orphan|root)$`)
+
+// sqlExecQuerier is satisfied by both *sql.DB and *sql.Tx.
+type sqlExecQuerier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// unknownTables lists the tables of db that are not in known, skipping SQLite's internal tables.
+func unknownTables(db *sql.DB, known []string) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("list tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(known, name) {
+			tables = append(tables, name)
+		}
+	}
+	return tables, rows.Err()
+}
+
+// migrateUnknownTables handles the source tables neither migration knows about, e.g. a metadata
+// table. By default they are only logged as unmigrated; with copyTables each one is recreated
+// from its original CREATE statement in newDB and filled from the source, which must be
+// attached to newDB as "old". Tables whose name reserved reports as part of the v3 layout are
+// never copied.
+func migrateUnknownTables(oldDB *sql.DB, newDB sqlExecQuerier, oldPath string, known []string, reserved func(string) bool, copyTables bool, lg *migrationLogger) error {
+	tables, err := unknownTables(oldDB, known)
+	if err != nil {
+		return fmt.Errorf("%s: %w", oldPath, err)
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	var unmigrated []string
+	for _, table := range tables {
+		if !copyTables {
+			unmigrated = append(unmigrated, table)
+			continue
+		}
+		if reserved != nil && reserved(table) {
+			lg.Event("unmigrated_table", logFields{"table": table, "source": oldPath},
+				"WARNING: not copying table %s from %s, its name is taken by the v3 layout", table, oldPath)
+			continue
+		}
+
+		var createStmt string
+		if err := oldDB.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name = ?`, table).Scan(&createStmt); err != nil {
+			return fmt.Errorf("read schema of %s: %w", table, err)
+		}
+		if _, err := newDB.Exec(createStmt); err != nil {
+			return fmt.Errorf("create table %s: %w", table, err)
+		}
+		res, err := newDB.Exec(fmt.Sprintf(`INSERT INTO %s SELECT * FROM old.%s`, quoteIdent(table), quoteIdent(table)))
+		if err != nil {
+			return fmt.Errorf("copy table %s: %w", table, err)
+		}
+		rows, _ := res.RowsAffected()
+		lg.Event("copied_table", logFields{"table": table, "rows": rows, "source": oldPath},
+			"copied unknown table %s (%d rows) from %s", table, rows, oldPath)
+	}
+
+	if len(unmigrated) > 0 {
+		lg.Event("unmigrated_tables", logFields{"tables": unmigrated, "source": oldPath},
+			"WARNING: %s has tables that are not migrated: %v (pass --copy-unknown-tables to copy them)", oldPath, unmigrated)
+	}
+	return nil
+}
+
+// quoteIdent quotes a SQLite identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,61 @@
+package v2
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateUnknownTables(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, oldDir, 1)
+	for _, name := range []string{"tree.sqlite", "changelog.sqlite"} {
+		db, err := sql.Open("sqlite", filepath.Join(oldDir, name))
+		require.NoError(t, err)
+		_, err = db.Exec(`
+			CREATE TABLE metadata (key text PRIMARY KEY, value text);
+			INSERT INTO metadata VALUES ('format', '2');
+		`)
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+	}
+	treeDB, err := sql.Open("sqlite", filepath.Join(oldDir, "tree.sqlite"))
+	require.NoError(t, err)
+	_, err = treeDB.Exec(`CREATE TABLE tree_2 (version int, sequence int, bytes blob, orphaned bool)`)
+	require.NoError(t, err)
+	require.NoError(t, treeDB.Close())
+
+	// by default unknown tables are only reported
+	buf := captureLog(t)
+	newDir := t.TempDir()
+	_, err = migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite"), migrateOptions{})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "has tables that are not migrated: [metadata tree_2]")
+	newTree, err := sql.Open("sqlite", filepath.Join(newDir, "tree.sqlite"))
+	require.NoError(t, err)
+	defer newTree.Close()
+	exists, err := tableExists(newTree, "metadata")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// --copy-unknown-tables carries them across, except names taken by the v3 layout
+	buf.Reset()
+	newDir = t.TempDir()
+	opts := migrateOptions{copyUnknownTables: true}
+	_, err = migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite"), opts)
+	require.NoError(t, err)
+	_, err = migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(newDir, "changelog.sqlite"), opts)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "not copying table tree_2")
+
+	for _, name := range []string{"tree.sqlite", "changelog.sqlite"} {
+		db, err := sql.Open("sqlite", filepath.Join(newDir, name))
+		require.NoError(t, err)
+		var value string
+		require.NoError(t, db.QueryRow("SELECT value FROM metadata WHERE key = 'format'").Scan(&value))
+		require.Equal(t, "2", value)
+		require.NoError(t, db.Close())
+	}
+}
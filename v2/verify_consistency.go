@@ -0,0 +1,98 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+func VerifyConsistencyCommand() *cobra.Command {
+	var (
+		dbPath string
+		maxGap int64
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify-consistency",
+		Short: "check that each store's latest tree root and changelog leaf versions agree",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if maxGap < 0 {
+				return fmt.Errorf("max-gap must not be negative, got %d", maxGap)
+			}
+			return verifyConsistency(dbPath, maxGap)
+		},
+	}
+
+	cmd.Flags().StringVar(&dbPath, "db-path", "", "Path to the migrated iavl2/ directory")
+	if err := cmd.MarkFlagRequired("db-path"); err != nil {
+		panic(err)
+	}
+	// The changelog only gains rows at versions that wrote leaves, so its max version may trail the root
+	cmd.Flags().Int64Var(&maxGap, "max-gap", 0, "Largest allowed difference between the latest root and leaf versions")
+
+	return cmd
+}
+
+// maxVersion returns MAX(version) of table in the database at path; it is NULL for an empty table.
+func maxVersion(path, table string) (sql.NullInt64, error) {
+	var version sql.NullInt64
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return version, fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+
+	if err := db.QueryRow(fmt.Sprintf("SELECT MAX(version) FROM %s", table)).Scan(&version); err != nil {
+		return version, fmt.Errorf("read latest %s version from %s: %w", table, path, err)
+	}
+	return version, nil
+}
+
+func verifyConsistency(dbPath string, maxGap int64) error {
+	stores, _, err := getStoreKeys(dbPath, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	var inconsistent []string
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tROOT VERSION\tLEAF VERSION\tDELTA\tSTATUS")
+	for _, store := range stores {
+		rootVersion, err := maxVersion(filepath.Join(dbPath, store, "tree.sqlite"), "root")
+		if err != nil {
+			return err
+		}
+		leafVersion, err := maxVersion(filepath.Join(dbPath, store, "changelog.sqlite"), "leaf")
+		if err != nil {
+			return err
+		}
+
+		delta, status := "-", "ok"
+		switch {
+		case rootVersion.Valid && leafVersion.Valid:
+			d := rootVersion.Int64 - leafVersion.Int64
+			delta = fmt.Sprint(d)
+			if d > maxGap || -d > maxGap {
+				status = "INCONSISTENT"
+			}
+		case rootVersion.Valid != leafVersion.Valid:
+			// one side has data and the other is empty
+			status = "INCONSISTENT"
+		}
+		if status != "ok" {
+			inconsistent = append(inconsistent, store)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", store, nullInt64String(rootVersion), nullInt64String(leafVersion), delta, status)
+	}
+	tw.Flush()
+
+	if len(inconsistent) > 0 {
+		return fmt.Errorf("%w: %d stores have root and changelog versions more than %d apart: %v", ErrVerificationFailed, len(inconsistent), maxGap, inconsistent)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package v2
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func TestVerifyConsistency(t *testing.T) {
+	dbPath := t.TempDir()
+	createMigratedStore(t, dbPath, "bank")
+	createMigratedStore(t, dbPath, "staking")
+
+	out := captureStdout(t, func() {
+		require.NoError(t, verifyConsistency(dbPath, 0))
+	})
+	require.Regexp(t, `bank\s+1\s+1\s+0\s+ok`, out)
+
+	// the tree moves on three versions while the changelog stays behind
+	db, err := sql.Open("sqlite", filepath.Join(dbPath, "staking", "tree.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("INSERT INTO root VALUES (4, 4, 1, x'01')")
+	require.NoError(t, err)
+
+	var verifyErr error
+	out = captureStdout(t, func() {
+		verifyErr = verifyConsistency(dbPath, 2)
+	})
+	require.ErrorContains(t, verifyErr, "1 stores have root and changelog versions more than 2 apart: [staking]")
+	require.Regexp(t, `staking\s+4\s+1\s+3\s+INCONSISTENT`, out)
+
+	captureStdout(t, func() {
+		require.NoError(t, verifyConsistency(dbPath, 3))
+	})
+}
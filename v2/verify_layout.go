@@ -0,0 +1,153 @@
+package v2
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func VerifyLayoutCommand() *cobra.Command {
+	var (
+		dbPath        string
+		dirName       string
+		treeFile      string
+		changelogFile string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify-layout",
+		Short: "check that every migrated store directory holds exactly the tree and changelog databases the node opens, with no leftovers",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return verifyLayout(os.Stdout, dbPath, dirName, treeFile, changelogFile)
+		},
+	}
+
+	cmd.Flags().StringVar(&dbPath, "db-path", "", "Path to the migrated iavl2/ directory")
+	if err := cmd.MarkFlagRequired("db-path"); err != nil {
+		panic(err)
+	}
+	cmd.Flags().StringVar(&dirName, "dir-name", "", "Name the node expects the directory itself to have, e.g. iavl2 (default: not checked)")
+	cmd.Flags().StringVar(&treeFile, "tree-filename", defaultTreeFilename, "Name of the tree database the node opens in each store directory")
+	cmd.Flags().StringVar(&changelogFile, "changelog-filename", defaultChangelogFilename, "Name of the changelog database the node opens in each store directory")
+
+	return cmd
+}
+
+// inspectStoreLayout lists what is wrong with the layout of the migrated store directory dir:
+// a missing tree or changelog database, and anything besides them, such as the .tmp, .append
+// or .skipped files of a migration, SQLite's -wal, -shm and -journal files, which an intact
+// migration leaves none of, or a subdirectory. The node would start on an empty store for a
+// missing database and ignore everything else.
+func inspectStoreLayout(dir, treeFile, changelogFile string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var problems []string
+	for _, name := range []string{treeFile, changelogFile} {
+		i := slices.IndexFunc(entries, func(e os.DirEntry) bool { return e.Name() == name })
+		switch {
+		case i < 0:
+			problems = append(problems, fmt.Sprintf("%s is missing", name))
+		case !entries[i].Type().IsRegular():
+			problems = append(problems, fmt.Sprintf("%s is not a regular file", name))
+		}
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == treeFile || name == changelogFile {
+			continue
+		}
+		problems = append(problems, describeStrayFile(dir, entry))
+	}
+	return problems, nil
+}
+
+// describeStrayFile says what a file or directory besides the databases in a store directory
+// most likely is.
+func describeStrayFile(dir string, entry os.DirEntry) string {
+	name := entry.Name()
+	switch {
+	case entry.IsDir():
+		return fmt.Sprintf("unexpected directory %s", name)
+	case strings.HasSuffix(name, tmpSuffix):
+		return fmt.Sprintf("%s is an unfinished migration; re-run it or remove the file", name)
+	case strings.HasSuffix(name, appendSuffix):
+		return fmt.Sprintf("%s is an unfinished --append; re-run it or remove the file", name)
+	case strings.HasSuffix(name, skippedSuffix):
+		return fmt.Sprintf("%s lists rows skipped as corrupt; the store is incomplete", name)
+	case strings.HasSuffix(name, "-wal"):
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.Size() > 0 {
+			return fmt.Sprintf("%s holds %d bytes of un-checkpointed writes", name, info.Size())
+		}
+		return fmt.Sprintf("leftover %s", name)
+	case strings.HasSuffix(name, "-shm"), strings.HasSuffix(name, "-journal"):
+		return fmt.Sprintf("leftover %s", name)
+	default:
+		return fmt.Sprintf("unexpected file %s", name)
+	}
+}
+
+// verifyLayout runs inspectStoreLayout on every store directory under dbPath, printing a row per
+// store and the problems below, and fails with ErrVerificationFailed if any store has one. A
+// non-empty dirName must be the name of dbPath itself, the directory the node config points at.
+func verifyLayout(w io.Writer, dbPath, dirName, treeFile, changelogFile string) error {
+	var layoutErrs []string
+	if dirName != "" {
+		abs, err := filepath.Abs(dbPath)
+		if err != nil {
+			return err
+		}
+		if base := filepath.Base(abs); base != dirName {
+			layoutErrs = append(layoutErrs, fmt.Sprintf("%s is named %s, the node expects %s", dbPath, base, dirName))
+		}
+	}
+	stores, _, err := getStoreKeys(dbPath, nil, nil)
+	if err != nil {
+		return err
+	}
+	if len(stores) == 0 {
+		layoutErrs = append(layoutErrs, fmt.Sprintf("%s holds no store directories", dbPath))
+	}
+
+	var bad []string
+	details := make(map[string][]string)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tPROBLEMS\tSTATUS")
+	for _, store := range stores {
+		problems, err := inspectStoreLayout(filepath.Join(dbPath, store), treeFile, changelogFile)
+		if err != nil {
+			tw.Flush()
+			return err
+		}
+		status := "ok"
+		if len(problems) > 0 {
+			status = "BAD"
+			bad = append(bad, store)
+			details[store] = problems
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", store, len(problems), status)
+	}
+	tw.Flush()
+
+	for _, store := range bad {
+		fmt.Fprintf(w, "\n%s:\n", store)
+		for _, problem := range details[store] {
+			fmt.Fprintf(w, "  %s\n", problem)
+		}
+	}
+	for _, problem := range layoutErrs {
+		fmt.Fprintf(w, "\n%s\n", problem)
+	}
+	if len(bad) > 0 || len(layoutErrs) > 0 {
+		return fmt.Errorf("%w: layout of %s: %d stores with problems %v, %d directory problems",
+			ErrVerificationFailed, dbPath, len(bad), bad, len(layoutErrs))
+	}
+	return nil
+}
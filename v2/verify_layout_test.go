@@ -0,0 +1,34 @@
+package v2
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyLayout(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "iavl2")
+	createMigratedStore(t, dbPath, "bank")
+	createMigratedStore(t, dbPath, "evm")
+
+	var out bytes.Buffer
+	require.NoError(t, verifyLayout(&out, dbPath, "iavl2", defaultTreeFilename, defaultChangelogFilename))
+	require.Contains(t, out.String(), "bank   0         ok")
+
+	bank := filepath.Join(dbPath, "bank")
+	require.NoError(t, os.Remove(filepath.Join(bank, "changelog.sqlite")))
+	require.NoError(t, os.WriteFile(filepath.Join(bank, "changelog.sqlite.tmp"), nil, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(bank, "tree.sqlite-wal"), []byte("wal"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(bank, "notes.txt"), nil, 0o644))
+
+	out.Reset()
+	err := verifyLayout(&out, dbPath, "iavl3", defaultTreeFilename, defaultChangelogFilename)
+	require.ErrorIs(t, err, ErrVerificationFailed)
+	require.Contains(t, out.String(), "bank   4         BAD")
+	require.Contains(t, out.String(), "evm    0         ok")
+	require.Contains(t, out.String(), "bank:\n  changelog.sqlite is missing\n  changelog.sqlite.tmp is an unfinished migration; re-run it or remove the file\n  unexpected file notes.txt\n  tree.sqlite-wal holds 3 bytes of un-checkpointed writes\n")
+	require.Contains(t, out.String(), "is named iavl2, the node expects iavl3")
+}
@@ -0,0 +1,215 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+func VerifyLeavesCommand() *cobra.Command {
+	var (
+		oldPath       string
+		newPath       string
+		storeKeysStr  string
+		hashAlgorithm string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify-leaves",
+		Short: "check that no changelog leaf row was lost to the (key_hash, version) primary key of the migrated leaf table",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var storeKeys []string
+			if storeKeysStr != "" {
+				storeKeys = strings.Split(storeKeysStr, ",")
+			}
+			return verifyLeaves(os.Stdout, oldPath, newPath, storeKeys, hashAlgorithm)
+		},
+	}
+
+	cmd.Flags().StringVar(&oldPath, "old-iavl2-path", "", "Path to the v2 source directory (the iavl2.bak/ left by start)")
+	cmd.Flags().StringVar(&newPath, "new-iavl2-path", "", "Path to the migrated iavl2/ directory")
+	cmd.Flags().StringVar(&storeKeysStr, "store-keys", "", "Comma-separated list of store keys or globs to check (default: all)")
+	cmd.Flags().StringVar(&hashAlgorithm, "hash-algorithm", "", "Hash algorithm the changelog key_hash was computed with (blake3, sha256; default: the one stamped in each migrated changelog, else blake3)")
+	if err := cmd.MarkFlagRequired("old-iavl2-path"); err != nil {
+		panic(err)
+	}
+	if err := cmd.MarkFlagRequired("new-iavl2-path"); err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+// leafLoss is what compareLeafCounts finds for one store.
+type leafLoss struct {
+	sourceRows, migratedRows int64
+	// samples describes up to sourceCheckMaxErrors source rows the destination lacks, and the
+	// destination row each collapsed into, if any.
+	samples []string
+}
+
+// verifyLeaves compares the leaf row count of every store's v2 changelog under oldBase with
+// its migrated changelog under newBase. The destination leaf table is keyed by (key_hash,
+// version) while the source is not, so two source rows of one version whose keys are equal,
+// or whose hashes collide, can only be kept as one. A destination with fewer rows fails with
+// ErrVerificationFailed, listing the missing rows and the key_hash each collapsed into.
+// Rows a migration leaves out on purpose, with --key-prefix or --skip-corrupt, count as lost.
+func verifyLeaves(w io.Writer, oldBase, newBase string, storeKeys []string, hashAlgorithm string) error {
+	if _, err := keyHashPool(hashAlgorithm); err != nil {
+		return err
+	}
+	stores, missing, err := getStoreKeys(oldBase, storeKeys, nil)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("store keys not found under %s: %v", oldBase, missing)
+	}
+
+	var lost []string
+	losses := make(map[string]leafLoss)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tSOURCE LEAVES\tMIGRATED LEAVES\tDELTA\tSTATUS")
+	for _, store := range stores {
+		newChangelog := filepath.Join(newBase, store, "changelog.sqlite")
+		algorithm, err := checkHashAlgorithm(newChangelog, hashAlgorithm)
+		if err != nil {
+			tw.Flush()
+			return fmt.Errorf("store %s: %w", store, err)
+		}
+		hashPool, err := keyHashPool(algorithm)
+		if err != nil {
+			tw.Flush()
+			return fmt.Errorf("store %s: %w", store, err)
+		}
+		loss, err := compareLeafCounts(filepath.Join(oldBase, store, "changelog.sqlite"), newChangelog, hashPool)
+		if err != nil {
+			tw.Flush()
+			return fmt.Errorf("store %s: %w", store, err)
+		}
+
+		delta := loss.migratedRows - loss.sourceRows
+		status := "ok"
+		switch {
+		case delta < 0:
+			status = "LOST"
+			lost = append(lost, store)
+			losses[store] = loss
+		case delta > 0:
+			// more rows than the source, e.g. appended from a newer source; nothing was lost
+			status = "extra"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%+d\t%s\n", store, loss.sourceRows, loss.migratedRows, delta, status)
+	}
+	tw.Flush()
+
+	for _, store := range lost {
+		loss := losses[store]
+		fmt.Fprintf(w, "\n%s: %d leaf rows lost\n", store, loss.sourceRows-loss.migratedRows)
+		for _, sample := range loss.samples {
+			fmt.Fprintf(w, "  %s\n", sample)
+		}
+	}
+	if len(lost) > 0 {
+		return fmt.Errorf("%w: leaf rows lost in %d stores %v", ErrVerificationFailed, len(lost), lost)
+	}
+	return nil
+}
+
+// compareLeafCounts counts the leaf rows of the v2 changelog at oldPath and the migrated
+// changelog at newPath and, if the destination has fewer, samples the source rows it lacks
+// by (version, sequence), hashing each key with hashPool to find the row it collapsed into.
+func compareLeafCounts(oldPath, newPath string, hashPool *sync.Pool) (leafLoss, error) {
+	var loss leafLoss
+	if !fileExists(oldPath) {
+		return loss, fmt.Errorf("changelog %w: %s", ErrSourceNotFound, oldPath)
+	}
+	if !fileExists(newPath) {
+		return loss, fmt.Errorf("migrated changelog %w: %s", ErrSourceNotFound, newPath)
+	}
+	db, err := sql.Open("sqlite", readonlyURI(newPath))
+	if err != nil {
+		return loss, fmt.Errorf("open db %s: %w", newPath, err)
+	}
+	defer db.Close()
+
+	// ATTACH is per connection, so pin one for the queries reading both
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return loss, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, attachSourceStmt(oldPath, true)); err != nil {
+		return loss, fmt.Errorf("attach %s: %w", oldPath, err)
+	}
+	defer conn.ExecContext(ctx, `DETACH DATABASE old;`)
+
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM old.leaf").Scan(&loss.sourceRows); err != nil {
+		return loss, fmt.Errorf("count leaves in %s: %w", oldPath, err)
+	}
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM main.leaf").Scan(&loss.migratedRows); err != nil {
+		return loss, fmt.Errorf("count leaves in %s: %w", newPath, err)
+	}
+	if loss.migratedRows >= loss.sourceRows {
+		return loss, nil
+	}
+
+	type missingRow struct {
+		version, sequence int64
+		key               []byte
+	}
+	var missingRows []missingRow
+	rows, err := conn.QueryContext(ctx, `SELECT o.version, o.sequence, o.key FROM old.leaf o
+	    WHERE o.version IS NOT NULL AND o.sequence IS NOT NULL AND NOT EXISTS (
+	      SELECT 1 FROM main.leaf n WHERE n.version = o.version AND n.sequence = o.sequence)
+	    LIMIT ?`, sourceCheckMaxErrors)
+	if err != nil {
+		return loss, fmt.Errorf("find missing leaves: %w", err)
+	}
+	for rows.Next() {
+		var r missingRow
+		if err := rows.Scan(&r.version, &r.sequence, &r.key); err != nil {
+			rows.Close()
+			return loss, fmt.Errorf("find missing leaves: %w", err)
+		}
+		missingRows = append(missingRows, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return loss, fmt.Errorf("find missing leaves: %w", err)
+	}
+
+	h := hashPool.Get().(hash.Hash)
+	defer hashPool.Put(h)
+	for _, r := range missingRows {
+		h.Reset()
+		h.Write(r.key)
+		keyHash := h.Sum(nil)
+
+		sample := fmt.Sprintf("version %d sequence %d key %x: ", r.version, r.sequence, r.key)
+		var sequence int64
+		err := conn.QueryRowContext(ctx, "SELECT sequence FROM main.leaf WHERE key_hash = ? AND version = ? LIMIT 1", keyHash, r.version).Scan(&sequence)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			sample += fmt.Sprintf("missing, no row with its key_hash %x at this version", keyHash)
+		case err != nil:
+			return loss, fmt.Errorf("read leaf with key_hash %x: %w", keyHash, err)
+		default:
+			sample += fmt.Sprintf("collapsed into sequence %d under key_hash %x", sequence, keyHash)
+		}
+		loss.samples = append(loss.samples, sample)
+	}
+	return loss, nil
+}
@@ -0,0 +1,38 @@
+package v2
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyLeaves(t *testing.T) {
+	oldBase, newBase := t.TempDir(), t.TempDir()
+	oldDir, newDir := filepath.Join(oldBase, "bank"), filepath.Join(newBase, "bank")
+	createV2Store(t, oldDir, 1, 2)
+	require.NoError(t, migrateV2Store(t, oldDir, newDir, migrateOptions{}))
+
+	var out bytes.Buffer
+	require.NoError(t, verifyLeaves(&out, oldBase, newBase, nil, ""))
+	require.Contains(t, out.String(), "bank   2              2                +0     ok\n")
+
+	// a second write of key aa at version 1 can only be kept once under (key_hash, version)
+	execV2(t, oldDir, "changelog.sqlite", "INSERT INTO leaf VALUES (1, 2, x'aa', x'02', false)")
+	execV2(t, newDir, "changelog.sqlite", "DELETE FROM leaf WHERE version = 2")
+
+	out.Reset()
+	err := verifyLeaves(&out, oldBase, newBase, nil, "")
+	require.ErrorIs(t, err, ErrVerificationFailed)
+	require.Contains(t, out.String(), "bank   3              1                -2     LOST\n")
+	require.Contains(t, out.String(), "\nbank: 2 leaf rows lost\n")
+	require.Regexp(t, `  version 1 sequence 2 key aa: collapsed into sequence 1 under key_hash [0-9a-f]{64}\n`, out.String())
+	require.Regexp(t, `  version 2 sequence 1 key aa: missing, no row with its key_hash [0-9a-f]{64} at this version\n`, out.String())
+
+	// more rows than the source lose nothing
+	execV2(t, oldDir, "changelog.sqlite", "DELETE FROM leaf")
+	out.Reset()
+	require.NoError(t, verifyLeaves(&out, oldBase, newBase, nil, ""))
+	require.Contains(t, out.String(), "extra")
+}
@@ -0,0 +1,168 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+func VerifyPKCommand() *cobra.Command {
+	var (
+		dbPath       string
+		treeFilename string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify-pk",
+		Short: "check that each migrated tree_N table has unique (version, sequence) keys, all within the shard's version range",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return verifyPrimaryKeys(os.Stdout, dbPath, treeFilename)
+		},
+	}
+
+	cmd.Flags().StringVar(&dbPath, "db-path", "", "Path to the migrated iavl2/ directory")
+	if err := cmd.MarkFlagRequired("db-path"); err != nil {
+		panic(err)
+	}
+	cmd.Flags().StringVar(&treeFilename, "tree-filename", defaultTreeFilename, "Name of the tree databases to check")
+
+	return cmd
+}
+
+// shardKeyReport is what inspectShardKeys finds in one tree_N table.
+type shardKeyReport struct {
+	shardID int64
+	rows    int64
+	// duplicates counts the (version, sequence) pairs held by more than one row.
+	duplicates int64
+	// misplaced counts the rows whose version lies outside the shard's range, and
+	// minMisplaced and maxMisplaced are the lowest and highest of those versions.
+	misplaced                  int64
+	minMisplaced, maxMisplaced sql.NullInt64
+}
+
+func (r shardKeyReport) ok() bool {
+	return r.duplicates == 0 && r.misplaced == 0
+}
+
+// problems describes what is wrong with the shard, e.g. "tree_2: 3 rows at versions 5-9 outside 500001-1000000".
+func (r shardKeyReport) problems() []string {
+	var problems []string
+	tableName := fmt.Sprintf("tree_%d", r.shardID)
+	if r.duplicates > 0 {
+		problems = append(problems, fmt.Sprintf("%s: %d (version, sequence) keys are held by more than one row", tableName, r.duplicates))
+	}
+	if r.misplaced > 0 {
+		startVersion, endVersion := shardVersionRange(r.shardID)
+		problems = append(problems, fmt.Sprintf("%s: %d rows at versions %d-%d outside %d-%d",
+			tableName, r.misplaced, r.minMisplaced.Int64, r.maxMisplaced.Int64, startVersion, endVersion))
+	}
+	return problems
+}
+
+// inspectShardKeys checks every tree_N table of db. A migrated shard's table is WITHOUT ROWID
+// with a (version, sequence) primary key, so duplicates only turn up in tables created some
+// other way; a row at a version outside its shard's range, which iavl v3 never looks for
+// there, is what a bug in the shard split or overlapping version ranges would leave.
+func inspectShardKeys(db *sql.DB) ([]shardKeyReport, error) {
+	shardIDs, err := listShardIDs(db)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]shardKeyReport, 0, len(shardIDs))
+	for _, shardID := range shardIDs {
+		tableName := fmt.Sprintf("tree_%d", shardID)
+		startVersion, endVersion := shardVersionRange(shardID)
+		report := shardKeyReport{shardID: shardID}
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", tableName)).Scan(&report.rows); err != nil {
+			return nil, fmt.Errorf("count rows of %s: %w", tableName, err)
+		}
+		err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM (SELECT 1 FROM %s GROUP BY version, sequence HAVING COUNT(*) > 1)",
+			tableName)).Scan(&report.duplicates)
+		if err != nil {
+			return nil, fmt.Errorf("find duplicate keys in %s: %w", tableName, err)
+		}
+		err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*), MIN(version), MAX(version) FROM %s WHERE version < %d OR version > %d",
+			tableName, startVersion, endVersion)).Scan(&report.misplaced, &report.minMisplaced, &report.maxMisplaced)
+		if err != nil {
+			return nil, fmt.Errorf("find misplaced rows in %s: %w", tableName, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// verifyPrimaryKeys runs inspectShardKeys on the tree database named treeFilename of every
+// store under dbPath, printing a row per store and the offending shards below, and fails with
+// ErrVerificationFailed if any shard has duplicate keys or misplaced rows.
+func verifyPrimaryKeys(w io.Writer, dbPath, treeFilename string) error {
+	stores, _, err := getStoreKeys(dbPath, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	var bad []string
+	details := make(map[string][]string)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tSHARDS\tROWS\tDUPLICATE KEYS\tMISPLACED\tSTATUS")
+	for _, store := range stores {
+		treePath := filepath.Join(dbPath, store, treeFilename)
+		if !fileExists(treePath) {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\tno %s\n", store, treeFilename)
+			continue
+		}
+		reports, err := inspectTreeKeys(treePath)
+		if err != nil {
+			tw.Flush()
+			return err
+		}
+		var rows, duplicates, misplaced int64
+		status := "ok"
+		for _, report := range reports {
+			rows += report.rows
+			duplicates += report.duplicates
+			misplaced += report.misplaced
+			if !report.ok() {
+				status = "BAD"
+				details[store] = append(details[store], report.problems()...)
+			}
+		}
+		if status != "ok" {
+			bad = append(bad, store)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\n", store, len(reports), rows, duplicates, misplaced, status)
+	}
+	tw.Flush()
+
+	for _, store := range bad {
+		fmt.Fprintf(w, "\n%s:\n", store)
+		for _, problem := range details[store] {
+			fmt.Fprintf(w, "  %s\n", problem)
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%w: %d stores under %s have duplicate keys or rows outside their shard: %v",
+			ErrVerificationFailed, len(bad), dbPath, bad)
+	}
+	return nil
+}
+
+// inspectTreeKeys opens the tree database at path read-only and runs inspectShardKeys on it.
+func inspectTreeKeys(path string) ([]shardKeyReport, error) {
+	db, err := sql.Open("sqlite", readonlyURI(path))
+	if err != nil {
+		return nil, fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+	reports, err := inspectShardKeys(db)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return reports, nil
+}
@@ -0,0 +1,43 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyPrimaryKeys(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 500001)
+	createV2Store(t, filepath.Join(iavl2Path, "evm"), 1)
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{}))
+
+	var out bytes.Buffer
+	require.NoError(t, verifyPrimaryKeys(&out, iavl2Path, defaultTreeFilename))
+	require.Equal(t, []string{
+		"STORE  SHARDS  ROWS  DUPLICATE KEYS  MISPLACED  STATUS",
+		"bank   2       2     0               0          ok",
+		"evm    1       1     0               0          ok",
+	}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+
+	db, err := sql.Open("sqlite", filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	// a row in the wrong shard, and a shard table without the primary key holding a key twice
+	_, err = db.Exec(`INSERT INTO tree_2 VALUES (7, 1, x'01', false);
+		CREATE TABLE tree_3 (version int, sequence int, bytes blob, orphaned bool);
+		INSERT INTO tree_3 VALUES (1000001, 1, x'01', false), (1000001, 1, x'02', false);`)
+	require.NoError(t, err)
+
+	out.Reset()
+	err = verifyPrimaryKeys(&out, iavl2Path, defaultTreeFilename)
+	require.ErrorIs(t, err, ErrVerificationFailed)
+	require.ErrorContains(t, err, "[bank]")
+	require.Contains(t, out.String(), "bank   3       5     1               1          BAD")
+	require.Contains(t, out.String(), "tree_2: 1 rows at versions 7-7 outside 500001-1000000")
+	require.Contains(t, out.String(), "tree_3: 1 (version, sequence) keys are held by more than one row")
+}
@@ -0,0 +1,162 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+func VerifyPlacementCommand() *cobra.Command {
+	var (
+		dbPath       string
+		treeFilename string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify-placement",
+		Short: "check that every row of each migrated tree_N table has a version ToShardID maps to that shard",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return verifyPlacement(os.Stdout, dbPath, treeFilename)
+		},
+	}
+
+	cmd.Flags().StringVar(&dbPath, "db-path", "", "Path to the migrated iavl2/ directory")
+	if err := cmd.MarkFlagRequired("db-path"); err != nil {
+		panic(err)
+	}
+	cmd.Flags().StringVar(&treeFilename, "tree-filename", defaultTreeFilename, "Name of the tree databases to check")
+
+	return cmd
+}
+
+// misplacedVersion is a version whose rows sit in a shard table ToShardID doesn't map it to.
+type misplacedVersion struct {
+	version, rows int64
+	shardID       int64
+}
+
+func (m misplacedVersion) String() string {
+	return fmt.Sprintf("version %d (%d rows) is in tree_%d but belongs in tree_%d", m.version, m.rows, m.shardID, ToShardID(m.version))
+}
+
+// placementReport is what inspectPlacement finds in one tree database.
+type placementReport struct {
+	shards, versions int64
+	// misplacedRows counts every row in the wrong shard; misplaced lists the first
+	// sourceCheckMaxErrors offending versions.
+	misplacedRows int64
+	misplaced     []misplacedVersion
+}
+
+// inspectPlacement maps the version of every row of every tree_N table of db through ToShardID,
+// the function iavl v3's shard lookup agrees with, instead of trusting the version range the
+// copy's WHERE clause derived from shardVersionRange. It reads a row per distinct version.
+func inspectPlacement(db *sql.DB) (placementReport, error) {
+	var report placementReport
+	shardIDs, err := listShardIDs(db)
+	if err != nil {
+		return report, err
+	}
+	for _, shardID := range shardIDs {
+		if err := inspectShardPlacement(db, shardID, &report); err != nil {
+			return report, err
+		}
+		report.shards++
+	}
+	return report, nil
+}
+
+// inspectShardPlacement adds the versions of tree_<shardID> to report.
+func inspectShardPlacement(db *sql.DB, shardID int64, report *placementReport) error {
+	tableName := fmt.Sprintf("tree_%d", shardID)
+	rows, err := db.Query(fmt.Sprintf("SELECT version, COUNT(*) FROM %s GROUP BY version ORDER BY version", tableName))
+	if err != nil {
+		return fmt.Errorf("read versions of %s: %w", tableName, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m misplacedVersion
+		if err := rows.Scan(&m.version, &m.rows); err != nil {
+			return fmt.Errorf("read versions of %s: %w", tableName, err)
+		}
+		report.versions++
+		if ToShardID(m.version) == shardID {
+			continue
+		}
+		report.misplacedRows += m.rows
+		if len(report.misplaced) < sourceCheckMaxErrors {
+			m.shardID = shardID
+			report.misplaced = append(report.misplaced, m)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read versions of %s: %w", tableName, err)
+	}
+	return nil
+}
+
+// verifyPlacement runs inspectPlacement on the tree database named treeFilename of every store
+// under dbPath, printing a row per store and the misplaced versions below, and fails with
+// ErrVerificationFailed if any row sits in the wrong shard, where iavl v3 would never find it.
+func verifyPlacement(w io.Writer, dbPath, treeFilename string) error {
+	stores, _, err := getStoreKeys(dbPath, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	var bad []string
+	details := make(map[string][]misplacedVersion)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tSHARDS\tVERSIONS\tMISPLACED ROWS\tSTATUS")
+	for _, store := range stores {
+		treePath := filepath.Join(dbPath, store, treeFilename)
+		if !fileExists(treePath) {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\tno %s\n", store, treeFilename)
+			continue
+		}
+		report, err := inspectTreePlacement(treePath)
+		if err != nil {
+			tw.Flush()
+			return err
+		}
+		status := "ok"
+		if report.misplacedRows > 0 {
+			status = "BAD"
+			bad = append(bad, store)
+			details[store] = report.misplaced
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", store, report.shards, report.versions, report.misplacedRows, status)
+	}
+	tw.Flush()
+
+	for _, store := range bad {
+		fmt.Fprintf(w, "\n%s:\n", store)
+		for _, m := range details[store] {
+			fmt.Fprintf(w, "  %s\n", m)
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%w: %d stores under %s have rows in the wrong shard: %v", ErrVerificationFailed, len(bad), dbPath, bad)
+	}
+	return nil
+}
+
+// inspectTreePlacement opens the tree database at path read-only and runs inspectPlacement on it.
+func inspectTreePlacement(path string) (placementReport, error) {
+	db, err := sql.Open("sqlite", readonlyURI(path))
+	if err != nil {
+		return placementReport{}, fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+	report, err := inspectPlacement(db)
+	if err != nil {
+		return report, fmt.Errorf("%s: %w", path, err)
+	}
+	return report, nil
+}
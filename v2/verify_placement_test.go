@@ -0,0 +1,38 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyPlacement(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2, 500001)
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{}))
+
+	var out bytes.Buffer
+	require.NoError(t, verifyPlacement(&out, iavl2Path, defaultTreeFilename))
+	require.Equal(t, []string{
+		"STORE  SHARDS  VERSIONS  MISPLACED ROWS  STATUS",
+		"bank   2       3         0               ok",
+	}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+
+	db, err := sql.Open("sqlite", filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`INSERT INTO tree_2 VALUES (500000, 1, x'01', false), (500000, 2, x'01', false);
+		INSERT INTO tree_1 VALUES (500002, 1, x'01', false);`)
+	require.NoError(t, err)
+
+	out.Reset()
+	err = verifyPlacement(&out, iavl2Path, defaultTreeFilename)
+	require.ErrorIs(t, err, ErrVerificationFailed)
+	require.Contains(t, out.String(), "bank   2       5         3               BAD")
+	require.Contains(t, out.String(), "version 500002 (1 rows) is in tree_1 but belongs in tree_2")
+	require.Contains(t, out.String(), "version 500000 (2 rows) is in tree_2 but belongs in tree_1")
+}
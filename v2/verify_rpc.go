@@ -0,0 +1,233 @@
+package v2
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func VerifyRPCCommand() *cobra.Command {
+	var (
+		rpcURL   string
+		dbPath   string
+		storeKey string
+		height   int64
+		probeKey string
+		timeout  time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify-rpc",
+		Short: "compare the root hashes of migrated stores with the ones a live node proves over CometBFT RPC at the same height",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := hex.DecodeString(strings.TrimPrefix(probeKey, "0x"))
+			if err != nil || len(key) == 0 {
+				return fmt.Errorf("invalid --probe-key %q: must be non-empty hex", probeKey)
+			}
+			client := &http.Client{Timeout: timeout}
+			return verifyRPC(cmd.Context(), os.Stdout, client, rpcURL, dbPath, storeKey, height, key)
+		},
+	}
+
+	cmd.Flags().StringVar(&rpcURL, "rpc-url", "http://localhost:26657", "CometBFT RPC address of a node still running the v2 store layout")
+	cmd.Flags().StringVar(&dbPath, "new-iavl2-path", "", "Path to the migrated iavl2/ directory")
+	if err := cmd.MarkFlagRequired("new-iavl2-path"); err != nil {
+		panic(err)
+	}
+	cmd.Flags().StringVar(&storeKey, "store-key", "", "Only check this store (default: every store under --new-iavl2-path)")
+	cmd.Flags().Int64Var(&height, "height", 0, "Height to compare; the node must not have pruned it (default: each store's latest migrated version)")
+	cmd.Flags().StringVar(&probeKey, "probe-key", "00", "Hex key queried with a proof; only the store hash in the proof is used, so it need not exist")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "Timeout of each RPC request")
+
+	return cmd
+}
+
+// verifyRPC compares, for every store under dbPath or only storeKey, the root hash of the
+// migrated v3 store at height (or its latest version) with the store hash the node at rpcURL
+// proves for the same height. Any difference fails with ErrHashMismatch.
+func verifyRPC(ctx context.Context, w io.Writer, client *http.Client, rpcURL, dbPath, storeKey string, height int64, probeKey []byte) error {
+	stores := []string{storeKey}
+	if storeKey == "" {
+		var err error
+		if stores, _, err = getStoreKeys(dbPath, nil, nil); err != nil {
+			return err
+		}
+	}
+
+	var diverged []string
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tHEIGHT\tMIGRATED\tNODE\tSTATUS")
+	for _, store := range stores {
+		version, local, err := loadV3RootHashAt(filepath.Join(dbPath, store), height, iavlLoadOptions{})
+		if err != nil {
+			tw.Flush()
+			return err
+		}
+		remote, err := queryStoreHash(ctx, client, rpcURL, store, version, probeKey)
+		if err != nil {
+			tw.Flush()
+			return fmt.Errorf("store %s: %w", store, err)
+		}
+		status := "ok"
+		if !bytes.Equal(local, remote) {
+			status = "MISMATCH"
+			diverged = append(diverged, store)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%x\t%x\t%s\n", store, version, local, remote, status)
+	}
+	tw.Flush()
+
+	if len(diverged) > 0 {
+		return fmt.Errorf("%w: %d stores under %s differ from the node at %s: %v", ErrHashMismatch, len(diverged), dbPath, rpcURL, diverged)
+	}
+	return nil
+}
+
+// abciQueryResponse is the part of CometBFT's /abci_query result verifyRPC reads.
+type abciQueryResponse struct {
+	Result struct {
+		Response struct {
+			Code     uint32 `json:"code"`
+			Log      string `json:"log"`
+			Height   string `json:"height"`
+			ProofOps *struct {
+				Ops []struct {
+					Type string `json:"type"`
+					Key  []byte `json:"key"`
+					Data []byte `json:"data"`
+				} `json:"ops"`
+			} `json:"proofOps"`
+		} `json:"response"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+		Data    string `json:"data"`
+	} `json:"error"`
+}
+
+// simpleMerkleProofType is the proof op the multistore adds to a proven query, proving the
+// queried store's root hash against the app hash.
+const simpleMerkleProofType = "ics23:simple"
+
+// queryStoreHash asks the node at rpcURL for a proof of probeKey in store at height and
+// returns the store root hash that proof commits to.
+func queryStoreHash(ctx context.Context, client *http.Client, rpcURL, store string, height int64, probeKey []byte) ([]byte, error) {
+	query := url.Values{}
+	query.Set("path", strconv.Quote("/store/"+store+"/key"))
+	query.Set("data", "0x"+hex.EncodeToString(probeKey))
+	query.Set("height", strconv.FormatInt(height, 10))
+	query.Set("prove", "true")
+	endpoint := strings.TrimSuffix(rpcURL, "/") + "/abci_query?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %w", rpcURL, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response of %s: %w", rpcURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("query %s: %s: %s", rpcURL, resp.Status, bytes.TrimSpace(body))
+	}
+
+	var parsed abciQueryResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("parse response of %s: %w", rpcURL, err)
+	}
+	if parsed.Error != nil {
+		return nil, fmt.Errorf("query %s: %s %s", rpcURL, parsed.Error.Message, parsed.Error.Data)
+	}
+	res := parsed.Result.Response
+	if res.Code != 0 {
+		return nil, fmt.Errorf("query %s at height %d failed with code %d: %s", rpcURL, height, res.Code, res.Log)
+	}
+	if res.Height != "" && res.Height != strconv.FormatInt(height, 10) {
+		return nil, fmt.Errorf("node at %s answered for height %s instead of %d", rpcURL, res.Height, height)
+	}
+	if res.ProofOps == nil {
+		return nil, fmt.Errorf("node at %s returned no proof for store %s", rpcURL, store)
+	}
+	for _, op := range res.ProofOps.Ops {
+		if op.Type == simpleMerkleProofType && string(op.Key) == store {
+			return existenceProofValue(op.Data)
+		}
+	}
+	return nil, fmt.Errorf("node at %s returned no %s proof for store %s", rpcURL, simpleMerkleProofType, store)
+}
+
+// existenceProofValue returns the value of the ics23 CommitmentProof in data, which must hold
+// an ExistenceProof: for the multistore's simple merkle proof that is the store's root hash.
+// Only the two fields needed are decoded, which avoids depending on the ics23 types.
+func existenceProofValue(data []byte) ([]byte, error) {
+	// CommitmentProof.exist is field 1, ExistenceProof.value field 2
+	exist, err := protoBytesField(data, 1)
+	if err != nil {
+		return nil, fmt.Errorf("decode commitment proof: %w", err)
+	}
+	value, err := protoBytesField(exist, 2)
+	if err != nil {
+		return nil, fmt.Errorf("decode existence proof: %w", err)
+	}
+	return value, nil
+}
+
+// protoBytesField returns the first length-delimited field number field of the protobuf
+// message msg, skipping the other fields.
+func protoBytesField(msg []byte, field uint64) ([]byte, error) {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, errors.New("malformed tag")
+		}
+		msg = msg[n:]
+		switch wireType := tag & 7; wireType {
+		case 0:
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				return nil, errors.New("malformed varint")
+			}
+			msg = msg[n:]
+		case 1, 5:
+			size := 8
+			if wireType == 5 {
+				size = 4
+			}
+			if len(msg) < size {
+				return nil, errors.New("truncated fixed-size field")
+			}
+			msg = msg[size:]
+		case 2:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < size {
+				return nil, errors.New("truncated length-delimited field")
+			}
+			if tag>>3 == field {
+				return msg[n : n+int(size)], nil
+			}
+			msg = msg[n+int(size):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", wireType)
+		}
+	}
+	return nil, fmt.Errorf("field %d not found", field)
+}
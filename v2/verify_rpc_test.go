@@ -0,0 +1,78 @@
+package v2
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// protoBytes encodes a length-delimited protobuf field.
+func protoBytes(field uint64, value []byte) []byte {
+	bz := binary.AppendUvarint(nil, field<<3|2)
+	bz = binary.AppendUvarint(bz, uint64(len(value)))
+	return append(bz, value...)
+}
+
+// simpleProof builds the ics23 CommitmentProof of the multistore's simple merkle proof op,
+// whose ExistenceProof carries the store name as key and its root hash as value.
+func simpleProof(store string, hash []byte) []byte {
+	// ExistenceProof.leaf (field 3) holds a varint hash op, which the decoder must skip
+	leaf := protoBytes(3, []byte{0x08, 0x01})
+	exist := append(protoBytes(1, []byte(store)), protoBytes(2, hash)...)
+	return protoBytes(1, append(exist, leaf...))
+}
+
+// abciQueryServer answers /abci_query with a proof that store has hash at the queried height.
+func abciQueryServer(t *testing.T, store string, hash []byte) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/abci_query", r.URL.Path)
+		require.Equal(t, `"/store/`+store+`/key"`, r.URL.Query().Get("path"))
+		require.Equal(t, "true", r.URL.Query().Get("prove"))
+		ops := []map[string]any{
+			{"type": "ics23:iavl", "key": []byte{0}, "data": []byte{}},
+			{"type": simpleMerkleProofType, "key": []byte(store), "data": simpleProof(store, hash)},
+		}
+		json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "result": map[string]any{
+			"response": map[string]any{"code": 0, "height": r.URL.Query().Get("height"), "proofOps": map[string]any{"ops": ops}},
+		}})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVerifyRPC(t *testing.T) {
+	dbPath := t.TempDir()
+	createMigratedStore(t, dbPath, "bank")
+	_, hash, err := loadV3RootHash(filepath.Join(dbPath, "bank"))
+	require.NoError(t, err)
+
+	srv := abciQueryServer(t, "bank", hash)
+	var buf bytes.Buffer
+	require.NoError(t, verifyRPC(context.Background(), &buf, srv.Client(), srv.URL, dbPath, "", 0, []byte{0}))
+	require.Regexp(t, `bank\s+\d+\s+\w*\s+\w*\s+ok`, buf.String())
+
+	srv = abciQueryServer(t, "bank", []byte("other"))
+	buf.Reset()
+	err = verifyRPC(context.Background(), &buf, srv.Client(), srv.URL, dbPath, "bank", 0, []byte{0})
+	require.ErrorIs(t, err, ErrHashMismatch)
+	require.Contains(t, buf.String(), "MISMATCH")
+}
+
+func TestQueryStoreHashErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":{"response":{"code":26,"log":"height 5 is not available"}}}`))
+	}))
+	defer srv.Close()
+	_, err := queryStoreHash(context.Background(), srv.Client(), srv.URL, "bank", 5, []byte{0})
+	require.ErrorContains(t, err, "height 5 is not available")
+
+	_, err = existenceProofValue([]byte{0x0a, 0x05, 0x01})
+	require.ErrorContains(t, err, "truncated")
+}
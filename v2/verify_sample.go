@@ -0,0 +1,287 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+func VerifySampleCommand() *cobra.Command {
+	var (
+		oldPath       string
+		newPath       string
+		storeKeysStr  string
+		samples       int
+		seed          int64
+		hashAlgorithm string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify-sample",
+		Short: "spot-check random tree and changelog rows of the v2 source against the migrated stores",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if samples <= 0 {
+				return fmt.Errorf("samples must be positive, got %d", samples)
+			}
+			var storeKeys []string
+			if storeKeysStr != "" {
+				storeKeys = strings.Split(storeKeysStr, ",")
+			}
+			if !cmd.Flags().Changed("seed") {
+				seed = time.Now().UnixNano()
+			}
+			return verifySample(os.Stdout, oldPath, newPath, storeKeys, samples, seed, hashAlgorithm)
+		},
+	}
+
+	cmd.Flags().StringVar(&oldPath, "old-iavl2-path", "", "Path to the v2 source directory (the iavl2.bak/ left by start)")
+	cmd.Flags().StringVar(&newPath, "new-iavl2-path", "", "Path to the migrated iavl2/ directory")
+	cmd.Flags().StringVar(&storeKeysStr, "store-keys", "", "Comma-separated list of store keys or globs to check (default: all)")
+	cmd.Flags().IntVar(&samples, "samples", 1000, "Rows sampled per store from each of tree_1 and leaf")
+	cmd.Flags().Int64Var(&seed, "seed", 0, "Seed for picking rows, to repeat a run (default: random)")
+	cmd.Flags().StringVar(&hashAlgorithm, "hash-algorithm", "", "Hash algorithm the changelog key_hash was computed with (blake3, sha256; default: the one stamped in each migrated changelog, else blake3)")
+	if err := cmd.MarkFlagRequired("old-iavl2-path"); err != nil {
+		panic(err)
+	}
+	if err := cmd.MarkFlagRequired("new-iavl2-path"); err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+// sampleMismatch is a sampled source row that the destination doesn't reproduce.
+type sampleMismatch struct {
+	store             string
+	table             string
+	version, sequence int64
+	// key is the leaf key; nil for tree rows.
+	key    []byte
+	reason string
+}
+
+func (m sampleMismatch) String() string {
+	if m.key != nil {
+		return fmt.Sprintf("store %s %s version %d sequence %d key %x: %s", m.store, m.table, m.version, m.sequence, m.key, m.reason)
+	}
+	return fmt.Sprintf("store %s %s version %d sequence %d: %s", m.store, m.table, m.version, m.sequence, m.reason)
+}
+
+// verifySample compares up to samples random tree_1 and leaf rows of every selected store
+// under oldBase with the rows migrated to newBase and fails if any differ. Leaf keys are hashed
+// with hashAlgorithm, or if it is empty with the algorithm each changelog is stamped with.
+func verifySample(w io.Writer, oldBase, newBase string, storeKeys []string, samples int, seed int64, hashAlgorithm string) error {
+	if _, err := keyHashPool(hashAlgorithm); err != nil {
+		return err
+	}
+	stores, missing, err := getStoreKeys(oldBase, storeKeys, nil)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("store keys not found under %s: %v", oldBase, missing)
+	}
+
+	fmt.Fprintf(w, "sampling up to %d rows per table, seed %d\n", samples, seed)
+	rng := rand.New(rand.NewSource(seed))
+	var mismatches []sampleMismatch
+	for _, store := range stores {
+		treeChecked, treeMismatches, err := sampleTree(store, filepath.Join(oldBase, store, "tree.sqlite"), filepath.Join(newBase, store, "tree.sqlite"), rng, samples)
+		if err != nil {
+			return fmt.Errorf("store %s: %w", store, err)
+		}
+		newChangelog := filepath.Join(newBase, store, "changelog.sqlite")
+		algorithm, err := checkHashAlgorithm(newChangelog, hashAlgorithm)
+		if err != nil {
+			return fmt.Errorf("store %s: %w", store, err)
+		}
+		hashPool, err := keyHashPool(algorithm)
+		if err != nil {
+			return fmt.Errorf("store %s: %w", store, err)
+		}
+		leafChecked, leafMismatches, err := sampleChangelog(store, filepath.Join(oldBase, store, "changelog.sqlite"), newChangelog, rng, samples, hashPool)
+		if err != nil {
+			return fmt.Errorf("store %s: %w", store, err)
+		}
+		fmt.Fprintf(w, "store %s: tree %d/%d, changelog %d/%d sampled rows match\n", store,
+			treeChecked-len(treeMismatches), treeChecked, leafChecked-len(leafMismatches), leafChecked)
+		mismatches = append(mismatches, treeMismatches...)
+		mismatches = append(mismatches, leafMismatches...)
+	}
+
+	for _, m := range mismatches {
+		fmt.Fprintf(w, "MISMATCH %s\n", m)
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%w: %d sampled rows differ between %s and %s", ErrVerificationFailed, len(mismatches), oldBase, newBase)
+	}
+	return nil
+}
+
+// sampleRowids picks up to n distinct existing rowids of table at random.
+func sampleRowids(db *sql.DB, table string, rng *rand.Rand, n int) ([]int64, error) {
+	var minRowid, maxRowid sql.NullInt64
+	if err := db.QueryRow(fmt.Sprintf("SELECT MIN(rowid), MAX(rowid) FROM %s", table)).Scan(&minRowid, &maxRowid); err != nil {
+		return nil, fmt.Errorf("read rowid range of %s: %w", table, err)
+	}
+	if !minRowid.Valid {
+		return nil, nil
+	}
+
+	seen := make(map[int64]bool)
+	var rowids []int64
+	span := maxRowid.Int64 - minRowid.Int64 + 1
+	for attempt := 0; attempt < 2*n && len(rowids) < n; attempt++ {
+		var rowid int64
+		// rowids may have gaps, take the first existing one at or after the pick
+		err := db.QueryRow(fmt.Sprintf("SELECT rowid FROM %s WHERE rowid >= ? ORDER BY rowid LIMIT 1", table),
+			minRowid.Int64+rng.Int63n(span)).Scan(&rowid)
+		if err != nil {
+			return nil, fmt.Errorf("sample %s: %w", table, err)
+		}
+		if !seen[rowid] {
+			seen[rowid] = true
+			rowids = append(rowids, rowid)
+		}
+	}
+	return rowids, nil
+}
+
+func sampleTree(store, oldPath, newPath string, rng *rand.Rand, n int) (int, []sampleMismatch, error) {
+	oldDB, err := openSource(oldPath, true, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer oldDB.Close()
+	newDB, err := sql.Open("sqlite", readonlyURI(newPath))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer newDB.Close()
+
+	rowids, err := sampleRowids(oldDB, "tree_1", rng, n)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var (
+		checked    int
+		mismatches []sampleMismatch
+	)
+	for _, rowid := range rowids {
+		var (
+			version, sequence sql.NullInt64
+			bz                []byte
+		)
+		if err := oldDB.QueryRow("SELECT version, sequence, bytes FROM tree_1 WHERE rowid = ?", rowid).Scan(&version, &sequence, &bz); err != nil {
+			return 0, nil, fmt.Errorf("read tree_1 rowid %d: %w", rowid, err)
+		}
+		// rows the migration can't place are not expected in the destination
+		if !version.Valid || !sequence.Valid {
+			continue
+		}
+		checked++
+
+		table := fmt.Sprintf("tree_%d", ToShardID(version.Int64))
+		var got []byte
+		err := newDB.QueryRow(fmt.Sprintf("SELECT bytes FROM %s WHERE version = ? AND sequence = ?", table), version.Int64, sequence.Int64).Scan(&got)
+		reason := ""
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			reason = "missing from destination"
+		case err != nil && strings.Contains(err.Error(), "no such table"):
+			reason = "destination has no " + table
+		case err != nil:
+			return 0, nil, fmt.Errorf("read %s: %w", table, err)
+		case !bytes.Equal(got, bz):
+			// of duplicated (version, sequence) rows the migration keeps the first by rowid
+			var first []byte
+			if err := oldDB.QueryRow("SELECT bytes FROM tree_1 WHERE version = ? AND sequence = ? ORDER BY rowid LIMIT 1",
+				version.Int64, sequence.Int64).Scan(&first); err != nil {
+				return 0, nil, fmt.Errorf("read tree_1: %w", err)
+			}
+			if !bytes.Equal(got, first) {
+				reason = "bytes differ"
+			}
+		}
+		if reason != "" {
+			mismatches = append(mismatches, sampleMismatch{store: store, table: table, version: version.Int64, sequence: sequence.Int64, reason: reason})
+		}
+	}
+	return checked, mismatches, nil
+}
+
+func sampleChangelog(store, oldPath, newPath string, rng *rand.Rand, n int, hashPool *sync.Pool) (int, []sampleMismatch, error) {
+	oldDB, err := openSource(oldPath, true, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer oldDB.Close()
+	newDB, err := sql.Open("sqlite", readonlyURI(newPath))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer newDB.Close()
+
+	rowids, err := sampleRowids(oldDB, "leaf", rng, n)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	h := hashPool.Get().(hash.Hash)
+	defer hashPool.Put(h)
+
+	var (
+		checked    int
+		mismatches []sampleMismatch
+	)
+	for _, rowid := range rowids {
+		var (
+			version, sequence sql.NullInt64
+			key, value        []byte
+		)
+		if err := oldDB.QueryRow("SELECT version, sequence, key, bytes FROM leaf WHERE rowid = ?", rowid).Scan(&version, &sequence, &key, &value); err != nil {
+			return 0, nil, fmt.Errorf("read leaf rowid %d: %w", rowid, err)
+		}
+		if !version.Valid {
+			continue
+		}
+		// same defaults as the migration
+		coerceNullLeafColumns(&sequence, &key, value)
+		checked++
+
+		h.Reset()
+		h.Write(key)
+		keyHash := h.Sum(nil)
+
+		var gotHash, got []byte
+		err := newDB.QueryRow("SELECT key_hash, bytes FROM leaf WHERE version = ? AND sequence = ?", version.Int64, sequence.Int64).Scan(&gotHash, &got)
+		reason := ""
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			reason = "missing from destination"
+		case err != nil:
+			return 0, nil, fmt.Errorf("read leaf: %w", err)
+		case !bytes.Equal(gotHash, keyHash):
+			reason = fmt.Sprintf("key_hash differs: got %x, expected %x", gotHash, keyHash)
+		case !bytes.Equal(got, value):
+			reason = "bytes differ"
+		}
+		if reason != "" {
+			mismatches = append(mismatches, sampleMismatch{store: store, table: "leaf", version: version.Int64, sequence: sequence.Int64, key: key, reason: reason})
+		}
+	}
+	return checked, mismatches, nil
+}
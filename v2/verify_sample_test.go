@@ -0,0 +1,47 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifySample(t *testing.T) {
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1, 2, 3, 500001)
+	createV2Store(t, filepath.Join(iavl2Path, "evm"), 1)
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{}))
+	oldBase := iavl2Path + ".bak"
+
+	var out bytes.Buffer
+	require.NoError(t, verifySample(&out, oldBase, iavl2Path, nil, 100, 1, hashAlgorithmBlake3))
+	require.Contains(t, out.String(), "store bank: tree 4/4, changelog 4/4 sampled rows match")
+	require.Contains(t, out.String(), "store evm: tree 1/1, changelog 1/1 sampled rows match")
+
+	changelog, err := sql.Open("sqlite", filepath.Join(iavl2Path, "evm", "changelog.sqlite"))
+	require.NoError(t, err)
+	defer changelog.Close()
+	_, err = changelog.Exec(`UPDATE leaf SET key_hash = x'00'`)
+	require.NoError(t, err)
+
+	out.Reset()
+	err = verifySample(&out, oldBase, iavl2Path, []string{"evm"}, 100, 1, hashAlgorithmBlake3)
+	require.ErrorContains(t, err, "1 sampled rows differ")
+	require.Contains(t, out.String(), "MISMATCH store evm leaf version 1 sequence 1 key aa: key_hash differs: got 00")
+
+	// corrupt a migrated tree row and drop the second shard
+	db, err := sql.Open("sqlite", filepath.Join(iavl2Path, "bank", "tree.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`UPDATE tree_1 SET bytes = x'ff' WHERE version = 2; DROP TABLE tree_2;`)
+	require.NoError(t, err)
+
+	out.Reset()
+	err = verifySample(&out, oldBase, iavl2Path, []string{"bank"}, 100, 1, hashAlgorithmBlake3)
+	require.ErrorContains(t, err, "2 sampled rows differ")
+	require.Contains(t, out.String(), "MISMATCH store bank tree_1 version 2 sequence 1: bytes differ")
+	require.Contains(t, out.String(), "MISMATCH store bank tree_2 version 500001 sequence 1: destination has no tree_2")
+}
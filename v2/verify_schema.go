@@ -0,0 +1,290 @@
+package v2
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+type columnSchema struct {
+	name string
+	typ  string
+}
+
+// tableSchema describes a table as iavl v3 creates it. primaryKey is compared
+// against the CREATE TABLE text with whitespace removed and lowercased, so it
+// also pins the DESC ordering that PRAGMA table_info doesn't expose.
+type tableSchema struct {
+	columns      []columnSchema
+	primaryKey   string
+	withoutRowid bool
+}
+
+var (
+	rootSchema = tableSchema{
+		columns:      []columnSchema{{"version", "int"}, {"node_version", "int"}, {"node_sequence", "int"}, {"bytes", "blob"}},
+		primaryKey:   "primarykey(versiondesc)",
+		withoutRowid: true,
+	}
+	branchOrphanSchema = tableSchema{
+		columns:      []columnSchema{{"version", "int"}, {"sequence", "int"}, {"at", "int"}},
+		primaryKey:   "primarykey(atdesc,version,sequence)",
+		withoutRowid: true,
+	}
+	shardSchema = tableSchema{
+		columns:      []columnSchema{{"version", "int"}, {"sequence", "int"}, {"bytes", "blob"}, {"orphaned", "bool"}},
+		primaryKey:   "primarykey(version,sequence)",
+		withoutRowid: true,
+	}
+	leafSchema = tableSchema{
+		columns:    []columnSchema{{"version", "int"}, {"sequence", "int"}, {"key_hash", "blob"}, {"bytes", "blob"}, {"orphaned", "bool"}},
+		primaryKey: "primarykey(key_hash,versiondesc)",
+	}
+	leafOrphanSchema = branchOrphanSchema
+)
+
+func VerifySchemaCommand() *cobra.Command {
+	var (
+		dbPath string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify-schema",
+		Short: "verify that migrated tree and changelog tables match the iavl v3 schema",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return verifySchema(dbPath)
+		},
+	}
+
+	cmd.Flags().StringVar(&dbPath, "db-path", "", "Path to the migrated iavl2/ directory")
+	if err := cmd.MarkFlagRequired("db-path"); err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+func verifySchema(dbPath string) error {
+	stores, _, err := getStoreKeys(dbPath, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	var mismatched []string
+	for _, store := range stores {
+		var problems []string
+		for _, check := range []struct {
+			file   string
+			verify func(db *sql.DB) ([]string, error)
+		}{
+			{"tree.sqlite", verifyTreeSchema},
+			{"changelog.sqlite", verifyChangelogSchema},
+		} {
+			path := filepath.Join(dbPath, store, check.file)
+			if _, err := os.Stat(path); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: missing", check.file))
+				continue
+			}
+			db, err := sql.Open("sqlite", path)
+			if err != nil {
+				return fmt.Errorf("open db %s: %w", path, err)
+			}
+			drift, err := check.verify(db)
+			db.Close()
+			if err != nil {
+				return fmt.Errorf("verify %s: %w", path, err)
+			}
+			for _, d := range drift {
+				problems = append(problems, fmt.Sprintf("%s: %s", check.file, d))
+			}
+		}
+
+		if len(problems) == 0 {
+			fmt.Printf("%s: OK\n", store)
+			continue
+		}
+		mismatched = append(mismatched, store)
+		fmt.Printf("%s: schema mismatch\n", store)
+		for _, p := range problems {
+			fmt.Printf("  - %s\n", p)
+		}
+	}
+
+	if len(mismatched) > 0 {
+		return fmt.Errorf("%w: schema mismatch in %d stores: %v", ErrVerificationFailed, len(mismatched), mismatched)
+	}
+	return nil
+}
+
+func verifyTreeSchema(db *sql.DB) ([]string, error) {
+	var drift []string
+	for _, t := range []struct {
+		name   string
+		schema tableSchema
+	}{
+		{"root", rootSchema},
+		{"branch_orphan", branchOrphanSchema},
+	} {
+		d, err := verifyTable(db, t.name, t.schema)
+		if err != nil {
+			return nil, err
+		}
+		drift = append(drift, d...)
+	}
+
+	shardIDs, err := listShardIDs(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, shardID := range shardIDs {
+		d, err := verifyTable(db, fmt.Sprintf("tree_%d", shardID), shardSchema)
+		if err != nil {
+			return nil, err
+		}
+		drift = append(drift, d...)
+	}
+	return drift, nil
+}
+
+func verifyChangelogSchema(db *sql.DB) ([]string, error) {
+	var drift []string
+	for _, t := range []struct {
+		name   string
+		schema tableSchema
+	}{
+		{"leaf", leafSchema},
+		{"leaf_orphan", leafOrphanSchema},
+	} {
+		d, err := verifyTable(db, t.name, t.schema)
+		if err != nil {
+			return nil, err
+		}
+		drift = append(drift, d...)
+	}
+
+	d, err := verifyLeafIndex(db)
+	if err != nil {
+		return nil, err
+	}
+	return append(drift, d...), nil
+}
+
+// verifyTable compares a table's columns, primary key and rowid-ness against the expected schema.
+func verifyTable(db *sql.DB, name string, want tableSchema) ([]string, error) {
+	var createSQL string
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type='table' AND name = ?", name).Scan(&createSQL)
+	if err == sql.ErrNoRows {
+		return []string{fmt.Sprintf("table %s is missing", name)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read schema of %s: %w", name, err)
+	}
+
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", name))
+	if err != nil {
+		return nil, fmt.Errorf("read table_info of %s: %w", name, err)
+	}
+	defer rows.Close()
+
+	var got []columnSchema
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			colName, colType string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &colName, &colType, &notNull, &dflt, &pk); err != nil {
+			return nil, fmt.Errorf("scan table_info of %s: %w", name, err)
+		}
+		got = append(got, columnSchema{name: colName, typ: strings.ToLower(colType)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read table_info of %s: %w", name, err)
+	}
+
+	var drift []string
+	if fmt.Sprint(got) != fmt.Sprint(want.columns) {
+		drift = append(drift, fmt.Sprintf("table %s has columns %s, want %s", name, formatColumns(got), formatColumns(want.columns)))
+	}
+	normalized := normalizeSQL(createSQL)
+	if !strings.Contains(normalized, want.primaryKey) {
+		drift = append(drift, fmt.Sprintf("table %s does not declare %s", name, want.primaryKey))
+	}
+	if hasRowid := !strings.HasSuffix(normalized, "withoutrowid"); hasRowid == want.withoutRowid {
+		if want.withoutRowid {
+			drift = append(drift, fmt.Sprintf("table %s is not WITHOUT ROWID", name))
+		} else {
+			drift = append(drift, fmt.Sprintf("table %s is unexpectedly WITHOUT ROWID", name))
+		}
+	}
+	return drift, nil
+}
+
+// verifyLeafIndex checks that leaf_idx is a unique index on leaf (version, sequence).
+func verifyLeafIndex(db *sql.DB) ([]string, error) {
+	var tblName string
+	err := db.QueryRow("SELECT tbl_name FROM sqlite_master WHERE type='index' AND name = 'leaf_idx'").Scan(&tblName)
+	if err == sql.ErrNoRows {
+		return []string{"index leaf_idx is missing"}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read schema of leaf_idx: %w", err)
+	}
+	if tblName != "leaf" {
+		return []string{fmt.Sprintf("index leaf_idx is on table %s, want leaf", tblName)}, nil
+	}
+
+	var drift []string
+	var unique bool
+	err = db.QueryRow("SELECT \"unique\" FROM pragma_index_list('leaf') WHERE name = 'leaf_idx'").Scan(&unique)
+	if err != nil {
+		return nil, fmt.Errorf("read index_list of leaf: %w", err)
+	}
+	if !unique {
+		drift = append(drift, "index leaf_idx is not UNIQUE")
+	}
+
+	rows, err := db.Query("SELECT name FROM pragma_index_info('leaf_idx') ORDER BY seqno")
+	if err != nil {
+		return nil, fmt.Errorf("read index_info of leaf_idx: %w", err)
+	}
+	defer rows.Close()
+	var cols []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, fmt.Errorf("scan index_info of leaf_idx: %w", err)
+		}
+		cols = append(cols, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read index_info of leaf_idx: %w", err)
+	}
+	if strings.Join(cols, ",") != "version,sequence" {
+		drift = append(drift, fmt.Sprintf("index leaf_idx covers (%s), want (version, sequence)", strings.Join(cols, ", ")))
+	}
+	return drift, nil
+}
+
+func normalizeSQL(stmt string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, strings.TrimRight(strings.TrimSpace(stmt), ";"))
+}
+
+func formatColumns(cols []columnSchema) string {
+	parts := make([]string, len(cols))
+	for i, c := range cols {
+		parts[i] = c.name + " " + c.typ
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
@@ -0,0 +1,96 @@
+package v2
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+// createV2Store writes a v2 tree.sqlite and changelog.sqlite into dir with one node, root and leaf per version.
+func createV2Store(t *testing.T, dir string, versions ...int64) {
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+
+	treeDB, err := sql.Open("sqlite", filepath.Join(dir, "tree.sqlite"))
+	require.NoError(t, err)
+	defer treeDB.Close()
+	_, err = treeDB.Exec(`
+		CREATE TABLE tree_1 (version int, sequence int, bytes blob, orphaned bool);
+		CREATE TABLE orphan (version int, sequence int, at int);
+		CREATE TABLE root (version int, node_version int, node_sequence int, bytes blob, PRIMARY KEY (version));
+	`)
+	require.NoError(t, err)
+
+	changelogDB, err := sql.Open("sqlite", filepath.Join(dir, "changelog.sqlite"))
+	require.NoError(t, err)
+	defer changelogDB.Close()
+	_, err = changelogDB.Exec(`
+		CREATE TABLE leaf (version int, sequence int, key blob, bytes blob, orphaned bool);
+		CREATE TABLE leaf_orphan (version int, sequence int, at int);
+	`)
+	require.NoError(t, err)
+
+	for _, version := range versions {
+		_, err = treeDB.Exec("INSERT INTO tree_1 VALUES (?, 1, x'01', false)", version)
+		require.NoError(t, err)
+		// a leaf root the v3 codec can decode: height 0, size 1, key aa, empty hash, value 01
+		_, err = treeDB.Exec("INSERT INTO root VALUES (?, ?, 1, x'000201aa000101')", version, version)
+		require.NoError(t, err)
+		_, err = changelogDB.Exec("INSERT INTO leaf VALUES (?, 1, x'aa', x'01', false)", version)
+		require.NoError(t, err)
+	}
+}
+
+// createMigratedStore runs the tree and changelog migrations for a small v2 store under dbPath/store.
+func createMigratedStore(t *testing.T, dbPath, store string) {
+	oldDir := filepath.Join(t.TempDir(), store)
+	newDir := filepath.Join(dbPath, store)
+	createV2Store(t, oldDir, 1)
+	require.NoError(t, os.MkdirAll(newDir, 0o755))
+
+	_, err := migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(newDir, "tree.sqlite"), migrateOptions{})
+	require.NoError(t, err)
+	_, err = migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(newDir, "changelog.sqlite"), migrateOptions{})
+	require.NoError(t, err)
+}
+
+func TestVerifySchema(t *testing.T) {
+	dbPath := t.TempDir()
+	createMigratedStore(t, dbPath, "bank")
+	require.NoError(t, verifySchema(dbPath))
+}
+
+func TestVerifySchemaDrift(t *testing.T) {
+	dbPath := t.TempDir()
+	createMigratedStore(t, dbPath, "bank")
+	createMigratedStore(t, dbPath, "staking")
+
+	treeDB, err := sql.Open("sqlite", filepath.Join(dbPath, "staking", "tree.sqlite"))
+	require.NoError(t, err)
+	defer treeDB.Close()
+	_, err = treeDB.Exec(`CREATE TABLE tree_2 (version int, sequence int, bytes blob, orphaned bool, PRIMARY KEY (version, sequence));`)
+	require.NoError(t, err)
+
+	changelogDB, err := sql.Open("sqlite", filepath.Join(dbPath, "staking", "changelog.sqlite"))
+	require.NoError(t, err)
+	defer changelogDB.Close()
+	_, err = changelogDB.Exec(`
+		DROP INDEX leaf_idx;
+		CREATE INDEX leaf_idx ON leaf (version, sequence);
+	`)
+	require.NoError(t, err)
+
+	tree, err := verifyTreeSchema(treeDB)
+	require.NoError(t, err)
+	require.Equal(t, []string{"table tree_2 is not WITHOUT ROWID"}, tree)
+
+	changelog, err := verifyChangelogSchema(changelogDB)
+	require.NoError(t, err)
+	require.Equal(t, []string{"index leaf_idx is not UNIQUE"}, changelog)
+
+	err = verifySchema(dbPath)
+	require.ErrorContains(t, err, "schema mismatch in 1 stores: [staking]")
+}
@@ -0,0 +1,54 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateTreeVerifyEachShard(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, dir, 1, 2, 500001, 1000001)
+	oldPath := filepath.Join(dir, "tree.sqlite")
+
+	for _, shardWorkers := range []int{1, 2} {
+		buf := captureLog(t)
+		result, err := migrateTree(oldPath, filepath.Join(t.TempDir(), "tree.sqlite"), migrateOptions{verifyEachShard: true, shardWorkers: shardWorkers})
+		require.NoError(t, err)
+		require.Equal(t, []int64{1, 2, 3}, result.Shards)
+		require.Equal(t, 3, strings.Count(buf.String(), "verified shard tree_"), buf.String())
+	}
+}
+
+func TestVerifyShardRows(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bank")
+	createV2Store(t, dir, 1, 2, 500001)
+	oldPath := filepath.Join(dir, "tree.sqlite")
+	newPath := filepath.Join(t.TempDir(), "tree.sqlite")
+	captureLog(t)
+	_, err := migrateTree(oldPath, newPath, migrateOptions{})
+	require.NoError(t, err)
+
+	oldDB, err := sql.Open("sqlite", oldPath)
+	require.NoError(t, err)
+	defer oldDB.Close()
+	newDB, err := sql.Open("sqlite", newPath)
+	require.NoError(t, err)
+	defer newDB.Close()
+	src := plainTreeSource
+
+	ctx := context.Background()
+	require.NoError(t, verifyShardRows(ctx, oldDB, newDB, src, 1, migrateOptions{}))
+
+	// a row lost from the shard is reported with the shard and both counts
+	_, err = newDB.Exec("DELETE FROM tree_1 WHERE version = 2")
+	require.NoError(t, err)
+	err = verifyShardRows(ctx, oldDB, newDB, src, 1, migrateOptions{})
+	require.ErrorIs(t, err, ErrVerificationFailed)
+	require.ErrorContains(t, err, "shard tree_1 (versions 1-500000) has 1 rows, expected 2")
+	require.NoError(t, verifyShardRows(ctx, oldDB, newDB, src, 2, migrateOptions{}))
+}
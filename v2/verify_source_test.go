@@ -0,0 +1,44 @@
+package v2
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// corruptPage overwrites the b-tree page header of page number page of the database at path.
+func corruptPage(t *testing.T, path string, page int) {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	const pageSize = 4096
+	require.Greater(t, len(data), page*pageSize)
+	copy(data[(page-1)*pageSize:], bytes.Repeat([]byte{0xff}, 8))
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+}
+
+func TestMigrateVerifySource(t *testing.T) {
+	oldDir := filepath.Join(t.TempDir(), "bank")
+	versions := make([]int64, 500)
+	for i := range versions {
+		versions[i] = int64(i + 1)
+	}
+	createV2Store(t, oldDir, versions...)
+
+	// a sound source passes
+	opts := migrateOptions{verifySource: true}
+	_, err := migrateTree(filepath.Join(oldDir, "tree.sqlite"), filepath.Join(t.TempDir(), "tree.sqlite"), opts)
+	require.NoError(t, err)
+	_, err = migrateChangelog(filepath.Join(oldDir, "changelog.sqlite"), filepath.Join(t.TempDir(), "changelog.sqlite"), opts)
+	require.NoError(t, err)
+
+	corruptPage(t, filepath.Join(oldDir, "tree.sqlite"), 3)
+	newPath := filepath.Join(t.TempDir(), "tree.sqlite")
+	_, err = migrateTree(filepath.Join(oldDir, "tree.sqlite"), newPath, opts)
+	require.ErrorIs(t, err, ErrCorruptSource)
+	require.ErrorContains(t, err, "quick_check")
+	require.Equal(t, ExitBadSource, ExitCode(err))
+	require.NoFileExists(t, newPath)
+}
@@ -0,0 +1,99 @@
+package v2
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSidecarSuffixes are the files SQLite keeps next to a database: the rollback journal,
+// the WAL and the WAL's shared-memory index.
+var sqliteSidecarSuffixes = []string{"-journal", "-wal", "-shm"}
+
+// checkpointWAL folds the -wal file of the database at path into the database file and
+// switches it to a rollback journal, so the file can be copied on its own: a node opening a
+// copy shipped without its -wal sees the state before those writes. It returns the size of the
+// -wal folded in, 0 for a database without a -wal or -shm file, which is not opened at all.
+// A WAL another connection keeps from being emptied is an error.
+func checkpointWAL(path string) (int64, error) {
+	if !fileExists(path+"-wal") && !fileExists(path+"-shm") {
+		return 0, nil
+	}
+	var walBytes int64
+	if fi, err := os.Stat(path + "-wal"); err == nil {
+		walBytes = fi.Size()
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return 0, fmt.Errorf("open db %s: %w", path, err)
+	}
+	defer db.Close()
+	// journal_mode can only leave WAL on the connection that checkpointed
+	db.SetMaxOpenConns(1)
+
+	// TRUNCATE reports no frames once it emptied the WAL, so they aren't read
+	var busy, frames, checkpointed int64
+	if err := db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &frames, &checkpointed); err != nil {
+		return 0, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	if busy != 0 {
+		return 0, fmt.Errorf("checkpoint %s: another connection is using its WAL; stop it and retry", path)
+	}
+	var mode string
+	if err := db.QueryRow("PRAGMA journal_mode=DELETE").Scan(&mode); err != nil {
+		return 0, fmt.Errorf("leave WAL mode on %s: %w", path, err)
+	}
+	if err := db.Close(); err != nil {
+		return 0, fmt.Errorf("close db %s: %w", path, err)
+	}
+
+	// the -shm, and a -wal emptied by the checkpoint, hold nothing once the database is closed
+	if fi, err := os.Stat(path + "-wal"); err == nil && fi.Size() > 0 {
+		return 0, fmt.Errorf("%s-wal still holds %d bytes after the checkpoint", path, fi.Size())
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+	return walBytes, nil
+}
+
+// checkpointDestination is checkpointWAL for a destination database written in place, such
+// as one --append added to, logging the checkpoint if there was a WAL to fold in.
+func checkpointDestination(path string, lg *migrationLogger) error {
+	walBytes, err := checkpointWAL(path)
+	if err != nil {
+		return err
+	}
+	if walBytes > 0 {
+		lg.Event("wal_checkpoint", logFields{"path": path, "bytes": walBytes}, "checkpointed %s of WAL into %s", formatBytes(walBytes), path)
+	}
+	return nil
+}
+
+// warnDanglingSidecars logs a warning for every journal, WAL or shared-memory file next to the
+// tree and changelog databases of stores under baseNew once a migration finishes. The
+// migration checkpoints what it writes, so one showing up means something else opened the
+// database meanwhile; copying the database without it may lose writes. verify-layout fails
+// on the same files.
+func warnDanglingSidecars(baseNew string, stores []string, opts migrateOptions) {
+	for _, store := range stores {
+		for _, name := range []string{opts.treeFilename(), opts.changelogFilename()} {
+			path := filepath.Join(baseNew, store, name)
+			for _, suffix := range sqliteSidecarSuffixes {
+				fi, err := os.Stat(path + suffix)
+				if err != nil {
+					continue
+				}
+				opts.logger.Event("dangling_sidecar", logFields{"store": store, "path": path + suffix, "bytes": fi.Size()},
+					"WARNING: %s (%d bytes) is left next to %s; checkpoint it or ship it with the database",
+					path+suffix, fi.Size(), name)
+			}
+		}
+	}
+}
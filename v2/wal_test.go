@@ -0,0 +1,51 @@
+package v2
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointWAL(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tree.sqlite")
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("PRAGMA journal_mode=WAL; CREATE TABLE root (version int); INSERT INTO root VALUES (1), (2)")
+	require.NoError(t, err)
+
+	// a copy of the database and its WAL taken while the writer still had it open
+	copied := filepath.Join(t.TempDir(), "tree.sqlite")
+	require.NoError(t, copyFile(path, copied))
+	require.NoError(t, copyFile(path+"-wal", copied+"-wal"))
+	require.NoError(t, db.Close())
+
+	walBytes, err := checkpointWAL(copied)
+	require.NoError(t, err)
+	require.Positive(t, walBytes)
+	require.NoFileExists(t, copied+"-wal")
+	require.NoFileExists(t, copied+"-shm")
+	require.Equal(t, int64(2), countTableRows(t, copied, "root"))
+
+	// nothing to do for a database without sidecars
+	walBytes, err = checkpointWAL(copied)
+	require.NoError(t, err)
+	require.Zero(t, walBytes)
+}
+
+func TestWarnDanglingSidecars(t *testing.T) {
+	buf := captureLog(t)
+	iavl2Path := filepath.Join(t.TempDir(), "iavl2")
+	createV2Store(t, filepath.Join(iavl2Path, "bank"), 1)
+	require.NoError(t, migrate(iavl2Path, nil, false, migrateOptions{}))
+	require.NotContains(t, buf.String(), "is left next to")
+
+	shm := filepath.Join(iavl2Path, "bank", "changelog.sqlite-shm")
+	require.NoError(t, os.WriteFile(shm, make([]byte, 8), 0o644))
+	warnDanglingSidecars(iavl2Path, []string{"bank"}, migrateOptions{})
+	require.Contains(t, buf.String(), "WARNING: "+shm+" (8 bytes) is left next to changelog.sqlite")
+}